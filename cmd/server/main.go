@@ -11,11 +11,17 @@ import (
 	"github.com/talx-hub/malerter/internal/constants"
 	l "github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/cache"
 	"github.com/talx-hub/malerter/internal/repository/db"
+	"github.com/talx-hub/malerter/internal/repository/disk"
+	"github.com/talx-hub/malerter/internal/repository/failover"
+	"github.com/talx-hub/malerter/internal/repository/gated"
 	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/internal/repository/writethrough"
 	"github.com/talx-hub/malerter/internal/service/server"
 	"github.com/talx-hub/malerter/internal/service/server/backup"
 	"github.com/talx-hub/malerter/internal/service/server/buildinfo"
+	"github.com/talx-hub/malerter/internal/service/server/retention"
 	"github.com/talx-hub/malerter/pkg/queue"
 	"github.com/talx-hub/malerter/pkg/shutdown"
 )
@@ -24,19 +30,24 @@ func main() {
 	cfg := loadConfig()
 	logger := initLogger(&cfg)
 
-	buffer := queue.New[model.Metric]()
+	buffer := queue.New[model.Change]()
 	defer buffer.Close()
 
 	storage := initStorage(&cfg, logger, &buffer)
-	defer closeDatabase(storage)
+	defer closeStorage(storage, logger)
 
 	ctxBackup, cancelBackup := context.WithCancel(context.Background())
 	defer cancelBackup()
-	startBackupService(ctxBackup, &cfg, &buffer, storage, logger)
+	cached := startCache(ctxBackup, &cfg, storage, logger)
+	backups := startBackupService(ctxBackup, &cfg, &buffer, storage, cached, logger)
+	startRetentionService(ctxBackup, &cfg, storage, logger)
+	if f, ok := storage.(*failover.Failover); ok {
+		go f.Run(ctxBackup)
+	}
 
 	printStartupInfo(&cfg, logger)
 
-	srv := server.Init(&cfg, storage, logger)
+	srv := server.Init(&cfg, writeThrough(&cfg, gate(cached, backups), backups), backups, logger)
 	if srv == nil {
 		logger.Fatal().Msg("Unable to start server. Exit")
 		return
@@ -76,36 +87,214 @@ func initLogger(cfg *serverCfg.Builder) *l.ZeroLogger {
 func initStorage(
 	cfg *serverCfg.Builder,
 	logger *l.ZeroLogger,
-	buffer *queue.Queue[model.Metric],
+	buffer *queue.Queue[model.Change],
 ) handlers.Storage {
-	dbStorage, err := metricDB(context.Background(), cfg.DatabaseDSN, logger, buffer)
-	if err != nil {
+	overflow := model.OverflowPolicy(cfg.CounterOverflow)
+	switch cfg.Storage {
+	case serverCfg.StorageMemory:
+		return memoryStorage(logger, buffer, overflow)
+	case serverCfg.StorageDisk:
+		diskStorage, err := disk.New(logger, cfg.StoragePath, overflow)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to open disk storage")
+		}
+		return diskStorage
+	}
+
+	if err := checkDSN(cfg.DatabaseDSN); err != nil {
+		if cfg.Storage == serverCfg.StoragePostgres {
+			logger.Fatal().Err(err).Msg("unable to connect to postgres storage")
+		}
 		logger.Warn().Err(err).Msg("store metrics in memory")
-		return memory.New(logger, buffer)
+		return memoryStorage(logger, buffer, overflow)
+	}
+	for _, dsn := range cfg.ReplicaDSNs() {
+		if err := db.ValidateDSN(dsn); err != nil {
+			logger.Fatal().Err(err).Msg("invalid read replica DSN")
+		}
+	}
+	f := failoverStorage(cfg, logger, buffer, overflow)
+	if !f.Connected() {
+		if cfg.FailFast {
+			closeStorage(f, logger)
+			logger.Fatal().Msg("database is unreachable")
+		}
+		logger.Warn().Msg("database is unreachable, journal writes until it recovers")
+	}
+	return f
+}
+
+func checkDSN(dsn string) error {
+	if len(dsn) == 0 {
+		return errors.New("DB DSN is empty")
+	}
+	if err := db.ValidateDSN(dsn); err != nil {
+		return fmt.Errorf("invalid DB DSN: %w", err)
+	}
+	return nil
+}
+
+// failoverStorage подключается к Postgres, а пока база недоступна,
+// сохраняет записи в журнал и воспроизводит его после восстановления.
+// Журнал в памяти ограничен, заполненный журнал отклоняет запись.
+func failoverStorage(
+	cfg *serverCfg.Builder,
+	logger *l.ZeroLogger,
+	buffer *queue.Queue[model.Change],
+	overflow model.OverflowPolicy,
+) *failover.Failover {
+	var journal failover.Journal = failover.NewMemoryJournal(failover.MemoryJournalSizeDefault)
+	if cfg.FailoverJournal != constants.EmptyPath {
+		fileJournal, err := failover.NewFileJournal(cfg.FailoverJournal)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to open failover journal")
+		}
+		journal = fileJournal
+	}
+
+	connect := func(ctx context.Context) (failover.Primary, error) {
+		dbStorage, err := metricDB(ctx, cfg.DatabaseDSN, logger, buffer)
+		if err != nil {
+			return nil, err
+		}
+		dbStorage.SetOverflowPolicy(overflow)
+		err = dbStorage.ConnectReplicas(ctx, cfg.ReplicaDSNs(), db.ReplicaCheckInterval)
+		if err != nil {
+			dbStorage.Close()
+			return nil, fmt.Errorf("unable to connect read replicas: %w", err)
+		}
+		return dbStorage, nil
 	}
-	return dbStorage
+	return failover.New(context.Background(), logger, connect, journal, failover.DefaultRecoverInterval)
+}
+
+func memoryStorage(
+	logger *l.ZeroLogger,
+	buffer *queue.Queue[model.Change],
+	overflow model.OverflowPolicy,
+) *memory.Memory {
+	mem := memory.New(logger, buffer)
+	mem.SetOverflowPolicy(overflow)
+	return mem
 }
 
-func closeDatabase(storage handlers.Storage) {
-	if dbStorage, ok := storage.(*db.DB); ok {
-		dbStorage.Close()
+func closeStorage(storage handlers.Storage, logger *l.ZeroLogger) {
+	switch s := storage.(type) {
+	case *db.DB:
+		s.Close()
+	case *disk.Disk:
+		if err := s.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close disk storage")
+		}
+	case *failover.Failover:
+		if err := s.Close(); err != nil {
+			logger.Error().Err(err).Msg("failed to close failover storage")
+		}
 	}
 }
 
+// startBackupService запускает резервное копирование хранилища storage.
+// Восстановление пишет через cached, чтобы кеш не отдавал прежние значения.
 func startBackupService(
 	ctx context.Context,
 	cfg *serverCfg.Builder,
-	buffer *queue.Queue[model.Metric],
+	buffer *queue.Queue[model.Change],
 	storage handlers.Storage,
+	cached handlers.Storage,
 	logger *l.ZeroLogger,
-) {
-	bk := backup.New(cfg, buffer, storage, logger)
-	if bk != nil {
-		go bk.Run(ctx)
-	} else {
+) handlers.Backups {
+	if _, ok := storage.(*disk.Disk); ok {
+		logger.Info().Msg("disk storage persists metrics itself, backup service is disabled")
+		buffer.Close()
+		return nil
+	}
+	restorable, ok := cached.(backup.Storage)
+	if !ok {
+		logger.Warn().Msg("storage cannot be restored, backup service is disabled")
+		buffer.Close()
+		return nil
+	}
+	bk := backup.New(backupConfig(cfg, storage, logger), buffer, restorable, logger)
+	if bk == nil {
 		logger.Warn().Msg("unable to load backup service")
 		buffer.Close()
+		return nil
+	}
+	go bk.Run(ctx)
+	return bk
+}
+
+// backupConfig отключает восстановление при запуске для хранилища,
+// которое сохраняет метрики само: его состояние новее резервной копии,
+// и замена откатила бы последние записи. Откат на момент
+// (-restore-until) выполняется для любого хранилища.
+func backupConfig(
+	cfg *serverCfg.Builder,
+	storage handlers.Storage,
+	logger *l.ZeroLogger,
+) *serverCfg.Builder {
+	if _, inMemory := storage.(*memory.Memory); inMemory || !cfg.Restore {
+		return cfg
+	}
+	logger.Info().Msg("storage persists metrics itself, startup restore is skipped")
+	bkCfg := *cfg
+	bkCfg.Restore = false
+	return &bkCfg
+}
+
+// gate задерживает запись в хранилище, пока восстановление резервной
+// копии заменяет его состояние.
+func gate(storage handlers.Storage, backups handlers.Backups) handlers.Storage {
+	g, ok := backups.(gated.Gate)
+	if !ok {
+		return storage
+	}
+	return gated.New(storage, g.Writes())
+}
+
+// writeThrough заставляет запись в хранилище дожидаться резервной копии,
+// если копия сохраняется синхронно, без интервала.
+func writeThrough(
+	cfg *serverCfg.Builder,
+	storage handlers.Storage,
+	backups handlers.Backups,
+) handlers.Storage {
+	syncer, ok := backups.(writethrough.Syncer)
+	if cfg.StoreInterval != 0 || !ok {
+		return storage
+	}
+	return writethrough.New(storage, syncer)
+}
+
+func startRetentionService(
+	ctx context.Context,
+	cfg *serverCfg.Builder,
+	storage handlers.Storage,
+	logger *l.ZeroLogger,
+) {
+	compactable, ok := storage.(retention.Storage)
+	if !ok {
+		logger.Warn().Msg("storage does not support history compaction")
+		return
+	}
+	if rm := retention.New(cfg, compactable, logger); rm != nil {
+		go rm.Run(ctx)
+	}
+}
+
+// startCache оборачивает хранилище кешем, если он включён.
+func startCache(
+	ctx context.Context,
+	cfg *serverCfg.Builder,
+	storage handlers.Storage,
+	logger *l.ZeroLogger,
+) handlers.Storage {
+	if cfg.CacheSize == 0 {
+		return storage
 	}
+	c := cache.New(storage, logger, cfg.CacheSize, cfg.CacheTTL)
+	go c.Run(ctx)
+	return c
 }
 
 func printStartupInfo(cfg *serverCfg.Builder, logger *l.ZeroLogger) {
@@ -113,10 +302,31 @@ func printStartupInfo(cfg *serverCfg.Builder, logger *l.ZeroLogger) {
 		Str("address", cfg.RootAddress).
 		Str("trusted subnet", cfg.TrustedSubnet).
 		Dur("backup interval", cfg.StoreInterval).
+		Dur("snapshot interval", cfg.SnapshotPeriod).
 		Bool("restore backup", cfg.Restore).
+		Str("restore until", cfg.RestoreUntil).
+		Str("storage", cfg.Storage).
+		Str("storage path", cfg.StoragePath).
+		Str("counter overflow", cfg.CounterOverflow).
+		Dur("raw retention", cfg.RetentionRaw).
+		Dur("1m rollup retention", cfg.RetentionMinute).
+		Dur("1h rollup retention", cfg.RetentionHour).
 		Str("backup path", cfg.FileStoragePath).
+		Str("backup dir", cfg.BackupDir).
+		Int("backup max files", cfg.BackupMaxFiles).
+		Dur("backup max age", cfg.BackupMaxAge).
+		Str("backup bucket", cfg.BackupBucket).
+		Str("backup endpoint", cfg.BackupEndpoint).
+		Str("backup prefix", cfg.BackupPrefix).
+		Str("backup compression", cfg.BackupCompress).
+		Bool("backup encryption", cfg.BackupKeyPath != constants.EmptyPath).
 		Bool("signature check", cfg.Secret != constants.NoSecret).
+		Bool("admin API", cfg.AdminKey != constants.NoSecret).
 		Str("dsn", cfg.DatabaseDSN).
+		Strs("replica dsn", cfg.ReplicaDSNs()).
+		Str("failover journal", cfg.FailoverJournal).
+		Int("cache size", cfg.CacheSize).
+		Dur("cache ttl", cfg.CacheTTL).
 		Str("buildVersion", buildinfo.Version).
 		Str("buildCommit", buildinfo.Commit).
 		Str("buildDate", buildinfo.Date).
@@ -127,7 +337,7 @@ func metricDB(
 	ctx context.Context,
 	dsn string,
 	logger *l.ZeroLogger,
-	buffer *queue.Queue[model.Metric],
+	buffer *queue.Queue[model.Change],
 ) (*db.DB, error) {
 	if len(dsn) == 0 {
 		return nil, errors.New("DB DSN is empty")
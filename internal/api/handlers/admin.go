@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/service/server/backup"
+)
+
+// Backups управляет резервными копиями хранилища.
+type Backups interface {
+	// Backup сохраняет накопленные записи и делает снимок.
+	Backup(ctx context.Context) (backup.Info, error)
+	// List возвращает резервные копии от новых к старым.
+	List(ctx context.Context) ([]backup.Info, error)
+	// Restore пересобирает хранилище из копии name на момент until.
+	// Пустое name — самая новая годная копия, нулевой until — момент
+	// последней записи.
+	Restore(ctx context.Context, name string, until time.Time) (backup.Report, error)
+	// Download возвращает tar-архив с файлами копии name.
+	// Ошибки чтения копии возвращаются до записи архива.
+	Download(ctx context.Context, name string) (io.WriterTo, error)
+}
+
+type backupsResponse struct {
+	Backups []backup.Info `json:"backups"`
+}
+
+// SetBackups задаёт резервные копии для административных запросов.
+func (h *HTTPHandler) SetBackups(b Backups) {
+	h.backups = b
+}
+
+// TriggerBackup делает резервную копию, не дожидаясь её периода,
+// и возвращает её описание в формате JSON.
+//
+// Пример запроса: POST /admin/backup.
+func (h *HTTPHandler) TriggerBackup(w http.ResponseWriter, r *http.Request) {
+	if !h.backupsEnabled(w) {
+		return
+	}
+	info, err := h.backups.Backup(r.Context())
+	if err != nil {
+		h.backupError(w, r, err)
+		return
+	}
+	writeJSON(w, &info)
+}
+
+// ListBackups возвращает резервные копии от новых к старым
+// с размером и числом записей в формате JSON.
+//
+// Пример запроса: GET /admin/backups.
+func (h *HTTPHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	if !h.backupsEnabled(w) {
+		return
+	}
+	infos, err := h.backups.List(r.Context())
+	if err != nil {
+		h.backupError(w, r, err)
+		return
+	}
+	writeJSON(w, &backupsResponse{Backups: infos})
+}
+
+// DownloadBackup возвращает tar-архив со снимком и журналом резервной копии.
+//
+// Пример запроса: GET /admin/backups/20250301T120000.000000000Z.bk.
+func (h *HTTPHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	if !h.backupsEnabled(w) {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	archive, err := h.backups.Download(r.Context(), name)
+	if err != nil {
+		h.backupError(w, r, err)
+		return
+	}
+	w.Header().Set(constants.KeyContentType, constants.ContentTypeTar)
+	w.Header().Set(constants.KeyDisposition, fmt.Sprintf("attachment; filename=%q", name+".tar"))
+	if _, err = archive.WriteTo(w); err != nil {
+		h.log.Error().Err(err).Msg("failed to write backup archive")
+	}
+}
+
+// RestoreBackup пересобирает хранилище из резервных копий и возвращает
+// отчёт в формате JSON. Параметры запроса:
+//   - backup — имя копии, по умолчанию самая новая годная;
+//   - until — момент восстановления в RFC3339, записи, сделанные позже,
+//     отбрасываются.
+//
+// Пример запроса: POST /admin/restore?until=2025-03-01T12:00:00Z.
+func (h *HTTPHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if !h.backupsEnabled(w) {
+		return
+	}
+	var until time.Time
+	if u := r.URL.Query().Get("until"); u != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, u); err != nil {
+			http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := h.backups.Restore(r.Context(), r.URL.Query().Get("backup"), until)
+	if err != nil {
+		h.backupError(w, r, err)
+		return
+	}
+	writeJSON(w, &report)
+}
+
+func (h *HTTPHandler) backupsEnabled(w http.ResponseWriter) bool {
+	if h.backups == nil {
+		http.Error(w, "backups are disabled", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func (h *HTTPHandler) backupError(w http.ResponseWriter, r *http.Request, err error) {
+	st := http.StatusInternalServerError
+	if errors.Is(err, backup.ErrNotFound) || errors.Is(err, backup.ErrNoBackups) {
+		st = http.StatusNotFound
+	}
+	h.log.Error().Err(err).Str("path", r.URL.Path).Msg("backup request failed")
+	http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set(constants.KeyContentType, constants.ContentTypeJSON)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
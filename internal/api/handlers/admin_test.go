@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/internal/service/server/backup"
+)
+
+type fakeBackups struct {
+	err   error
+	name  string
+	until time.Time
+}
+
+func (f *fakeBackups) Backup(_ context.Context) (backup.Info, error) {
+	return backup.Info{Name: "b.bk", Size: 10, Snapshot: 3}, f.err
+}
+
+func (f *fakeBackups) List(_ context.Context) ([]backup.Info, error) {
+	return []backup.Info{{Name: "b.bk"}, {Name: "a.bk", Error: "snapshot a.bk is truncated"}}, f.err
+}
+
+func (f *fakeBackups) Restore(_ context.Context, name string, until time.Time) (backup.Report, error) {
+	f.name, f.until = name, until
+	return backup.Report{Until: until, Backup: "a.bk", Log: 2, Ignored: 1}, f.err
+}
+
+func (f *fakeBackups) Download(_ context.Context, name string) (io.WriterTo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return strings.NewReader("archive of " + name), nil
+}
+
+func TestHTTPHandler_RestoreBackup(t *testing.T) {
+	lg := logger.NewNopLogger()
+	handler := NewHTTPHandler(memory.New(lg, nil), lg)
+	restore := func(query string) (int, string) {
+		resp, got := testRequest(t, handler.RestoreBackup, http.MethodPost, "/admin/restore?"+query, "", nil)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode, got
+	}
+
+	status, _ := restore("")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	backups := &fakeBackups{}
+	handler.SetBackups(backups)
+	status, got := restore("until=2025-03-01T12:00:00Z")
+	require.Equal(t, http.StatusOK, status, got)
+	assert.Equal(t, time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC), backups.until)
+	var report backup.Report
+	require.NoError(t, json.Unmarshal([]byte(got), &report))
+	assert.Equal(t, 2, report.Log)
+	assert.Equal(t, 1, report.Ignored)
+
+	status, _ = restore("backup=a.bk")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "a.bk", backups.name)
+	assert.True(t, backups.until.IsZero())
+
+	status, _ = restore("until=yesterday")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	backups.err = fmt.Errorf("%w made before ...", backup.ErrNoBackups)
+	status, _ = restore("until=2025-03-01T12:00:00Z")
+	assert.Equal(t, http.StatusNotFound, status)
+	backups.err = errors.New("disk is full")
+	status, _ = restore("")
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestHTTPHandler_Backups(t *testing.T) {
+	lg := logger.NewNopLogger()
+	handler := NewHTTPHandler(memory.New(lg, nil), lg)
+	backups := &fakeBackups{}
+	handler.SetBackups(backups)
+
+	resp, got := testRequest(t, handler.TriggerBackup, http.MethodPost, "/admin/backup", "", nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, got)
+	var info backup.Info
+	require.NoError(t, json.Unmarshal([]byte(got), &info))
+	assert.Equal(t, "b.bk", info.Name)
+	assert.Equal(t, 3, info.Snapshot)
+
+	resp, got = testRequest(t, handler.ListBackups, http.MethodGet, "/admin/backups", "", nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode, got)
+	var list backupsResponse
+	require.NoError(t, json.Unmarshal([]byte(got), &list))
+	require.Len(t, list.Backups, 2)
+	assert.NotEmpty(t, list.Backups[1].Error)
+
+	download := func(name string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/admin/backups/"+name, http.NoBody)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("name", name)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		w := httptest.NewRecorder()
+		handler.DownloadBackup(w, r)
+		return w
+	}
+	w := download("a.bk")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, constants.ContentTypeTar, w.Header().Get(constants.KeyContentType))
+	assert.Equal(t, "archive of a.bk", w.Body.String())
+
+	backups.err = fmt.Errorf("%w: c.bk", backup.ErrNotFound)
+	assert.Equal(t, http.StatusNotFound, download("c.bk").Code)
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"time"
 
 	"github.com/talx-hub/malerter/internal/api/handlers"
 	"github.com/talx-hub/malerter/internal/constants"
@@ -19,6 +21,7 @@ import (
 // mockStorage is a test implementation of the Storage interface.
 type mockStorage struct {
 	metrics   []model.Metric
+	samples   []model.Sample
 	metric    model.Metric
 	failAdd   bool
 	failBatch bool
@@ -26,28 +29,65 @@ type mockStorage struct {
 	failGet   bool
 }
 
-func (m *mockStorage) Add(_ context.Context, _ model.Metric) error {
+func (m *mockStorage) Add(_ context.Context, metric model.Metric) (model.Metric, error) {
 	if m.failAdd {
-		return errors.New("add failed")
+		return model.Metric{}, errors.New("add failed")
 	}
-	return nil
+	metric.Revision++
+	return metric, nil
 }
 
-func (m *mockStorage) Batch(_ context.Context, _ []model.Metric) error {
+func (m *mockStorage) Batch(_ context.Context, batch []model.Metric) ([]model.Metric, error) {
 	if m.failBatch {
-		return errors.New("batch failed")
+		return nil, errors.New("batch failed")
 	}
-	return nil
+	merged := slices.Clone(batch)
+	for i := range merged {
+		merged[i].Revision++
+	}
+	return merged, nil
+}
+
+func (m *mockStorage) CompareAndSet(
+	_ context.Context, metric model.Metric, _ model.Condition,
+) (model.Metric, error) {
+	return metric, nil
 }
 
-func (m *mockStorage) Find(_ context.Context, _ string) (model.Metric, error) {
+func (m *mockStorage) Find(_ context.Context, _ model.MetricKey) (model.Metric, error) {
 	if m.failFind {
 		return model.Metric{}, &customerror.NotFoundError{Info: "not found"}
 	}
 	return m.metric, nil
 }
 
-func (m *mockStorage) Get(_ context.Context) ([]model.Metric, error) {
+func (m *mockStorage) FindByLabels(
+	_ context.Context, _ string, _ model.MetricType, _ string, _ model.Labels,
+) ([]model.Metric, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Delete(_ context.Context, _ model.MetricKey) error {
+	return nil
+}
+
+func (m *mockStorage) Reset(_ context.Context, _ model.MetricKey) error {
+	return nil
+}
+
+func (m *mockStorage) History(
+	_ context.Context, _ model.MetricKey, _, _ time.Time,
+) ([]model.Sample, error) {
+	return m.samples, nil
+}
+
+func (m *mockStorage) Rollups(
+	_ context.Context, _ model.MetricKey, _ time.Duration, _, _ time.Time,
+) ([]model.Rollup, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Get(_ context.Context, _ model.ListOptions) ([]model.Metric, error) {
 	if m.failGet {
 		return nil, &customerror.NotFoundError{Info: "repo error"}
 	}
@@ -306,36 +346,6 @@ func ExampleHTTPHandler_DumpMetricJSON_storageAddError() {
 	// Status code: 404
 }
 
-// ExampleHTTPHandler_DumpMetricJSON_findError демонстрирует ошибку при поиске метрики.
-func ExampleHTTPHandler_DumpMetricJSON_findError() {
-	st := &mockStorage{failFind: true}
-	log := logger.NewNopLogger()
-	handler := handlers.NewHTTPHandler(st, log)
-
-	metric := model.Metric{
-		Name:  "m2",
-		Type:  model.MetricTypeCounter,
-		Delta: new(int64),
-	}
-	body, _ := json.Marshal(metric)
-
-	req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
-	w := httptest.NewRecorder()
-	handler.DumpMetricJSON(w, req)
-
-	result := w.Result()
-	defer func() {
-		if err := result.Body.Close(); err != nil {
-			log := logger.NewNopLogger()
-			log.Error().Err(err).Msg("fail to close")
-		}
-	}()
-	fmt.Println("Status code:", result.StatusCode)
-
-	// Output:
-	// Status code: 500
-}
-
 // ExampleHTTPHandler_GetMetricJSON_success — успешный запрос на получение метрики.
 func ExampleHTTPHandler_GetMetricJSON_success() {
 	val := 123.456
@@ -477,3 +487,36 @@ func ExampleHTTPHandler_GetAll_storageError() {
 	// Output:
 	// Status code: 500
 }
+
+// ExampleHTTPHandler_QueryRange_success — история ряда с агрегатами по минутам.
+func ExampleHTTPHandler_QueryRange_success() {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock := &mockStorage{
+		samples: []model.Sample{
+			{Timestamp: start, Value: 1},
+			{Timestamp: start.Add(30 * time.Second), Value: 3},
+		},
+	}
+	log := logger.NewNopLogger()
+	handler := handlers.NewHTTPHandler(mock, log)
+
+	req := httptest.NewRequest(http.MethodGet,
+		"/api/v1/query_range?name=HeapAlloc&type=gauge"+
+			"&from=2024-01-01T00:00:00Z&to=2024-01-01T00:01:00Z&step=1m", http.NoBody)
+	w := httptest.NewRecorder()
+	handler.QueryRange(w, req)
+
+	result := w.Result()
+	defer func() {
+		if err := result.Body.Close(); err != nil {
+			log := logger.NewNopLogger()
+			log.Error().Err(err).Msg("fail to close")
+		}
+	}()
+	fmt.Println("Status code:", result.StatusCode)
+	fmt.Print(w.Body.String())
+
+	// Output:
+	// Status code: 200
+	// {"type":"gauge","id":"HeapAlloc","points":[{"timestamp":"2024-01-01T00:00:00Z","value":1},{"timestamp":"2024-01-01T00:00:30Z","value":3}],"steps":[{"start":"2024-01-01T00:00:00Z","min":1,"max":3,"avg":2,"last":3,"count":2}],"resolution":0}
+}
@@ -7,11 +7,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/talx-hub/malerter/internal/api/middlewares"
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/customerror"
 	"github.com/talx-hub/malerter/internal/logger"
@@ -25,17 +31,58 @@ const (
 
 // Storage определяет интерфейс для операций с хранилищем метрик.
 type Storage interface {
-	// Add сохраняет одну метрику.
-	Add(ctx context.Context, metric model.Metric) error
+	// Add сохраняет одну метрику и возвращает её значение
+	// после слияния с уже сохранённым.
+	Add(ctx context.Context, metric model.Metric) (model.Metric, error)
 
-	// Batch сохраняет несколько метрик за одну операцию.
-	Batch(ctx context.Context, metrics []model.Metric) error
+	// Batch сохраняет несколько метрик за одну операцию и возвращает
+	// их значения после слияния.
+	Batch(ctx context.Context, metrics []model.Metric) ([]model.Metric, error)
 
-	// Find возвращает метрику по ключу.
-	Find(ctx context.Context, key string) (model.Metric, error)
+	// CompareAndSet сохраняет метрику, как Add, только если её текущее
+	// состояние удовлетворяет условию cond, иначе возвращает
+	// customerror.ConflictError. Проверка и запись выполняются атомарно.
+	CompareAndSet(ctx context.Context, metric model.Metric, cond model.Condition) (model.Metric, error)
 
-	// Get возвращает все метрики.
-	Get(ctx context.Context) ([]model.Metric, error)
+	// Find возвращает метрику по ключу.
+	Find(ctx context.Context, key model.MetricKey) (model.Metric, error)
+
+	// FindByLabels возвращает все метрики арендатора tenant с заданными
+	// типом и именем, метки которых содержат все пары из matchers.
+	FindByLabels(
+		ctx context.Context,
+		tenant string,
+		mType model.MetricType,
+		name string,
+		matchers model.Labels,
+	) ([]model.Metric, error)
+
+	// Delete удаляет метрику вместе с её историей.
+	Delete(ctx context.Context, key model.MetricKey) error
+
+	// Reset обнуляет счётчик.
+	Reset(ctx context.Context, key model.MetricKey) error
+
+	// History возвращает точки истории ряда key в интервале [from, to],
+	// отсортированные по времени.
+	History(
+		ctx context.Context,
+		key model.MetricKey,
+		from, to time.Time,
+	) ([]model.Sample, error)
+
+	// Rollups возвращает агрегаты ряда key с разрешением resolution,
+	// начало которых лежит в интервале [from, to].
+	Rollups(
+		ctx context.Context,
+		key model.MetricKey,
+		resolution time.Duration,
+		from, to time.Time,
+	) ([]model.Rollup, error)
+
+	// Get возвращает метрики, отобранные по opts, в порядке model.CompareKeys.
+	// Нулевые opts соответствуют всем метрикам арендатора по умолчанию.
+	Get(ctx context.Context, opts model.ListOptions) ([]model.Metric, error)
 
 	// Ping проверяет доступность хранилища.
 	Ping(ctx context.Context) error
@@ -44,8 +91,10 @@ type Storage interface {
 // HTTPHandler реализует HTTP API для работы с метриками.
 // Он использует хранилище метрик и логгер для обработки запросов.
 type HTTPHandler struct {
-	storage Storage
-	log     *logger.ZeroLogger
+	storage   Storage
+	log       *logger.ZeroLogger
+	retention model.Retention
+	backups   Backups
 }
 
 // NewHTTPHandler создаёт новый экземпляр HTTPHandler.
@@ -53,25 +102,141 @@ func NewHTTPHandler(s Storage, log *logger.ZeroLogger) *HTTPHandler {
 	return &HTTPHandler{storage: s, log: log}
 }
 
+// SetRetention задаёт политику хранения, по которой запросы истории
+// выбирают разрешение данных.
+func (h *HTTPHandler) SetRetention(r model.Retention) {
+	h.retention = r
+}
+
 func getStatusFromError(err error) int {
 	var notFoundError *customerror.NotFoundError
 	var invalidArgumentError *customerror.InvalidArgumentError
+	var unavailableError *customerror.UnavailableError
+	var conflictError *customerror.ConflictError
 	switch {
 	case errors.As(err, &notFoundError):
 		return http.StatusNotFound
 	case errors.As(err, &invalidArgumentError):
 		return http.StatusBadRequest
+	case errors.As(err, &unavailableError):
+		return http.StatusServiceUnavailable
+	case errors.As(err, &conflictError):
+		// конфликт возникает, только когда не выполнено условие If-Match
+		return http.StatusPreconditionFailed
 	default:
 		return http.StatusInternalServerError
 	}
 }
 
-func extractJSON(body io.Reader) (model.Metric, error) {
+// writeStatus возвращает код ответа на ошибку записи метрики:
+// неверная метрика (например, другая раскладка корзин гистограммы) — 400,
+// недоступное хранилище — 503, остальные ошибки, как и прежде, — 404.
+func writeStatus(err error) int {
+	st := getStatusFromError(err)
+	if st == http.StatusInternalServerError {
+		return http.StatusNotFound
+	}
+	return st
+}
+
+// requestTenant возвращает арендатора запроса. Без подтверждённого
+// арендатора запрос относится к арендатору по умолчанию.
+func requestTenant(r *http.Request) string {
+	return middlewares.TenantFromContext(r.Context())
+}
+
+// requestConditions возвращает условия записи из заголовка If-Match
+// (RFC 9110, раздел 13.1.1): * — метрика должна существовать, иначе список
+// меток сущности, например, If-Match: "3", "4". Запись выполняется,
+// если выполнено одно из условий. Метки сравниваются строго, как того
+// требует If-Match: слабые метки (W/"3") и метки, отличные от ETag
+// из setRevision, не совпадают ни с чем. Метка "0" означает, что метрика
+// ещё не должна существовать. Без заголовка возвращает nil, а если
+// не может совпасть ни одна метка — пустой список.
+func requestConditions(r *http.Request) ([]model.Condition, error) {
+	values := r.Header.Values(constants.KeyIfMatch)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	star, tags, err := parseEntityTags(strings.Join(values, ","))
+	if err != nil {
+		return nil, &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf("invalid %s header: %s", constants.KeyIfMatch, err.Error()),
+		}
+	}
+	if star {
+		return []model.Condition{{Exists: true}}, nil
+	}
+	conds := make([]model.Condition, 0, len(tags))
+	for _, tag := range tags {
+		revision, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil || revision < 0 || strconv.FormatInt(revision, 10) != tag {
+			continue
+		}
+		conds = append(conds, model.Condition{Revision: &revision})
+	}
+	return conds, nil
+}
+
+// parseEntityTags разбирает значение If-Match: * или список меток
+// сущности через запятую, и возвращает содержимое сильных меток без кавычек.
+func parseEntityTags(header string) (bool, []string, error) {
+	rest := strings.TrimSpace(header)
+	if rest == "*" {
+		return true, nil, nil
+	}
+	tags := make([]string, 0)
+	found := false
+	for {
+		// пустые элементы списка допускаются, см. RFC 9110, раздел 5.6.1
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			break
+		}
+		weak := strings.HasPrefix(rest, "W/")
+		rest = strings.TrimPrefix(rest, "W/")
+		if !strings.HasPrefix(rest, `"`) {
+			return false, nil, errors.New("entity tag must be quoted")
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return false, nil, errors.New("entity tag is not closed")
+		}
+		tag := rest[1 : end+1]
+		if strings.ContainsFunc(tag, func(c rune) bool { return c < 0x21 || c == 0x7f }) {
+			return false, nil, errors.New("entity tag contains invalid characters")
+		}
+		rest = strings.TrimLeft(rest[end+2:], " \t")
+		if rest != "" && rest[0] != ',' {
+			return false, nil, errors.New("entity tags must be separated by commas")
+		}
+		found = true
+		if !weak {
+			tags = append(tags, tag)
+		}
+	}
+	if !found {
+		return false, nil, errors.New("no entity tags")
+	}
+	return false, tags, nil
+}
+
+// setRevision передаёт ревизию метрики в заголовке ETag.
+// Метрика без ревизии (например, записанная в журнал во время сбоя
+// основного хранилища) заголовка не получает.
+func setRevision(w http.ResponseWriter, metric model.Metric) {
+	if metric.Revision > 0 {
+		w.Header().Set(constants.KeyETag, fmt.Sprintf(`"%d"`, metric.Revision))
+	}
+}
+
+func extractJSON(body io.Reader, tenant string) (model.Metric, error) {
 	m := model.NewMetric()
 	if err := json.NewDecoder(body).Decode(m); err != nil {
 		return model.Metric{},
 			fmt.Errorf("unable to decode metric: %w", err)
 	}
+	m.Tenant = tenant
 	if err := m.CheckValid(); err != nil {
 		return model.Metric{},
 			&customerror.InvalidArgumentError{
@@ -81,29 +246,36 @@ func extractJSON(body io.Reader) (model.Metric, error) {
 	return *m, nil
 }
 
-func (h *HTTPHandler) extractJSONs(body io.Reader) ([]model.Metric, error) {
+func (h *HTTPHandler) extractJSONs(body io.Reader, tenant string) ([]model.Metric, error) {
 	var metrics []model.Metric
 	if err := json.NewDecoder(body).Decode(&metrics); err != nil {
 		return nil,
 			fmt.Errorf("unable to decode batch: %w", err)
 	}
 
+	now := time.Now()
 	validList := make([]model.Metric, 0)
 	for _, m := range metrics {
+		m.Tenant = tenant
 		if err := m.CheckValid(); err != nil || m.IsEmpty() {
 			h.log.Error().Err(err).Msg("decoded metric is invalid")
 			continue
 		}
+		m.StampIfMissing(now)
 		validList = append(validList, m)
 	}
 	return validList, nil
 }
 
-// DumpMetricList сохраняет список метрик, переданный в теле запроса в формате JSON.
+// DumpMetricList сохраняет список метрик, переданный в теле запроса в формате JSON,
+// и возвращает значения сохранённых метрик после слияния. Если хранилище
+// приняло метрики без слияния (например, в журнал во время сбоя базы),
+// возвращается 202 Accepted и метрики в том виде, в котором они получены.
 //
 // Пример запроса: POST /updates/.
 func (h *HTTPHandler) DumpMetricList(w http.ResponseWriter, r *http.Request) {
-	metrics, err := h.extractJSONs(r.Body)
+	tenant := requestTenant(r)
+	metrics, err := h.extractJSONs(r.Body, tenant)
 	if err != nil {
 		h.log.Error().Err(err).Msg("failed to extract metrics from JSON")
 		st := getStatusFromError(err)
@@ -112,23 +284,49 @@ func (h *HTTPHandler) DumpMetricList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	wrappedBatch := func(args ...any) (any, error) {
-		return nil, h.storage.Batch(r.Context(), metrics)
+		return h.storage.Batch(r.Context(), metrics)
 	}
-	if _, err = db.WithConnectionCheck(wrappedBatch); err != nil {
+	stored, err := db.WithConnectionCheck(wrappedBatch)
+	if err != nil {
 		h.log.Error().Err(err).Msg("failed to dump metrics in repo")
 		st := getStatusFromError(err)
 		http.Error(w, err.Error(), st)
 		return
 	}
+	merged, ok := stored.([]model.Metric)
+	if !ok {
+		h.log.Error().Msg("failed to convert 'any' to []model.Metric")
+		http.Error(w, "failed to convert 'batch' result", http.StatusInternalServerError)
+		return
+	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set(constants.KeyContentType, constants.ContentTypeJSON)
+	if slices.ContainsFunc(merged, func(m model.Metric) bool { return m.Revision == 0 }) {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	if err = json.NewEncoder(w).Encode(merged); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 }
 
-// DumpMetricJSON сохраняет метрику, переданную в теле запроса в формате JSON.
+// DumpMetricJSON сохраняет метрику, переданную в теле запроса в формате JSON,
+// и возвращает её значение после слияния, а ревизию — в заголовке ETag.
+// Метрика, принятая без слияния, возвращается как есть с 202 Accepted, см. DumpMetricList.
+// Гистограмма с другой раскладкой корзин отклоняется с 400 Bad Request.
+// С заголовком If-Match метрика сохраняется, только если её ревизия
+// совпадает с одной из переданных, иначе возвращается 412 Precondition Failed,
+// см. requestConditions.
 //
 // Пример запроса: POST /update/.
 func (h *HTTPHandler) DumpMetricJSON(w http.ResponseWriter, r *http.Request) {
-	metric, err := extractJSON(r.Body)
+	tenant := requestTenant(r)
+	conds, err := requestConditions(r)
+	if err != nil {
+		http.Error(w, err.Error(), getStatusFromError(err))
+		return
+	}
+	metric, err := extractJSON(r.Body, tenant)
 	if err != nil {
 		st := getStatusFromError(err)
 		http.Error(w, err.Error(), st)
@@ -138,26 +336,24 @@ func (h *HTTPHandler) DumpMetricJSON(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to dump empty metric", http.StatusBadRequest)
 		return
 	}
+	metric.StampIfMissing(time.Now())
 
 	wrappedAdd := func(args ...any) (any, error) {
-		return nil, h.storage.Add(r.Context(), metric)
+		if conds != nil {
+			return h.compareAndSet(r.Context(), metric, conds)
+		}
+		return h.storage.Add(r.Context(), metric)
 	}
-	_, err = db.WithConnectionCheck(wrappedAdd)
+	m, err := db.WithConnectionCheck(wrappedAdd)
 	if err != nil {
+		st := getStatusFromError(err)
+		if conds == nil {
+			st = writeStatus(err)
+		}
 		http.Error(
 			w,
 			fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()),
-			http.StatusNotFound)
-		return
-	}
-
-	dummyKey := metric.Type.String() + " " + metric.Name
-	wrappedFind := func(args ...any) (any, error) {
-		return h.storage.Find(r.Context(), dummyKey)
-	}
-	m, err := db.WithConnectionCheck(wrappedFind)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+			st)
 		return
 	}
 	metric, ok := m.(model.Metric)
@@ -165,18 +361,43 @@ func (h *HTTPHandler) DumpMetricJSON(w http.ResponseWriter, r *http.Request) {
 		h.log.Error().Msg("failed to convert 'any' to model.Metric")
 		http.Error(
 			w,
-			"failed to convert find result",
+			"failed to convert add result",
 			http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set(constants.KeyContentType, constants.ContentTypeJSON)
+	setRevision(w, metric)
+	if metric.Revision == 0 {
+		w.WriteHeader(http.StatusAccepted)
+	}
 	if err = json.NewEncoder(w).Encode(&metric); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// compareAndSet сохраняет метрику, если выполнено одно из условий conds.
+// Каждое условие проверяется атомарно, а выполниться может только одно:
+// у метрики одна ревизия.
+func (h *HTTPHandler) compareAndSet(
+	ctx context.Context, metric model.Metric, conds []model.Condition,
+) (model.Metric, error) {
+	var err error = &customerror.ConflictError{
+		Info: "no entity tag of " + constants.KeyIfMatch + " can match",
+	}
+	for _, cond := range conds {
+		var stored model.Metric
+		stored, err = h.storage.CompareAndSet(ctx, metric, cond)
+		var conflict *customerror.ConflictError
+		if !errors.As(err, &conflict) {
+			//nolint:wrapcheck // the storage error is reported as is
+			return stored, err
+		}
+	}
+	return model.Metric{}, err
+}
+
 // DumpMetric сохраняет метрику, переданную в виде URL-параметров.
 //
 // Пример запроса: POST /update/{type}/{name}/{value}.
@@ -184,6 +405,7 @@ func (h *HTTPHandler) DumpMetric(w http.ResponseWriter, r *http.Request) {
 	mName := chi.URLParam(r, "name")
 	mType := chi.URLParam(r, "type")
 	mValue := chi.URLParam(r, "val")
+	tenant := requestTenant(r)
 	metric, err := model.NewMetric().FromValues(
 		mName, model.MetricType(mType), mValue)
 	if err != nil {
@@ -191,6 +413,7 @@ func (h *HTTPHandler) DumpMetric(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
 		return
 	}
+	metric.Tenant = tenant
 	if metric.IsEmpty() {
 		http.Error(
 			w,
@@ -198,16 +421,67 @@ func (h *HTTPHandler) DumpMetric(w http.ResponseWriter, r *http.Request) {
 			http.StatusNotFound)
 		return
 	}
+	metric.StampIfMissing(time.Now())
 
 	wrappedAdd := func(args ...any) (any, error) {
-		return nil, h.storage.Add(r.Context(), metric)
+		return h.storage.Add(r.Context(), metric)
 	}
 	_, err = db.WithConnectionCheck(wrappedAdd)
 	if err != nil {
 		http.Error(
 			w,
 			fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()),
-			http.StatusNotFound)
+			writeStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteMetric удаляет метрику по имени и типу, переданным в URL.
+// Параметры запроса задают метки ряда.
+//
+// Пример запроса: DELETE /value/{type}/{name}?cpu=3.
+func (h *HTTPHandler) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	h.changeMetric(w, r, h.storage.Delete)
+}
+
+// ResetMetric обнуляет счётчик по имени, переданному в URL.
+// Параметры запроса задают метки ряда.
+//
+// Пример запроса: POST /reset/counter/{name}.
+func (h *HTTPHandler) ResetMetric(w http.ResponseWriter, r *http.Request) {
+	h.changeMetric(w, r, h.storage.Reset)
+}
+
+func (h *HTTPHandler) changeMetric(
+	w http.ResponseWriter,
+	r *http.Request,
+	change func(ctx context.Context, key model.MetricKey) error,
+) {
+	tenant := requestTenant(r)
+	metric, err := model.NewMetric().FromValues(
+		chi.URLParam(r, "name"), model.MetricType(chi.URLParam(r, "type")), "0")
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+	metric.Tenant = tenant
+	metric.Labels = labelMatchers(r)
+	if err = metric.Labels.CheckValid(); err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+
+	key := metric.Key()
+	wrappedChange := func(args ...any) (any, error) {
+		return nil, change(r.Context(), key)
+	}
+	if _, err = db.WithConnectionCheck(wrappedChange); err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
 		return
 	}
 
@@ -215,11 +489,14 @@ func (h *HTTPHandler) DumpMetric(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetMetric возвращает значение метрики по имени и типу, переданным в URL.
+// Параметры запроса трактуются как фильтры по меткам: будут возвращены
+// значения всех метрик, метки которых содержат переданные пары.
 //
-// Пример запроса: GET /value/{type}/{name}.
+// Пример запроса: GET /value/{type}/{name}?host=srv1.
 func (h *HTTPHandler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	mName := chi.URLParam(r, "name")
 	mType := chi.URLParam(r, "type")
+	tenant := requestTenant(r)
 	metric, err := model.NewMetric().FromValues(
 		mName, model.MetricType(mType), "0")
 	if err != nil {
@@ -227,9 +504,22 @@ func (h *HTTPHandler) GetMetric(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
 		return
 	}
-	dummyKey := mType + " " + mName
+	metric.Tenant = tenant
+
+	matchers := labelMatchers(r)
+	if err = matchers.CheckValid(); err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+	if len(matchers) != 0 {
+		h.getMetricsByLabels(w, r, metric, matchers)
+		return
+	}
+
+	key := metric.Key()
 	wrappedFind := func(args ...any) (any, error) {
-		return h.storage.Find(r.Context(), dummyKey)
+		return h.storage.Find(r.Context(), key)
 	}
 	m, err := db.WithConnectionCheck(wrappedFind)
 	if err != nil {
@@ -245,6 +535,11 @@ func (h *HTTPHandler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set(constants.KeyContentType, constants.ContentTypeText)
+	setRevision(w, metric)
+	if metric.Timestamp != nil {
+		w.Header().Set(constants.KeyLastModified,
+			metric.Timestamp.UTC().Format(http.TimeFormat))
+	}
 	valueStr := fmt.Sprintf("%v", metric.ActualValue())
 	_, err = w.Write([]byte(valueStr))
 	if err != nil {
@@ -252,20 +547,81 @@ func (h *HTTPHandler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func labelMatchers(r *http.Request) model.Labels {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+	matchers := make(model.Labels, len(query))
+	for k := range query {
+		matchers[k] = query.Get(k)
+	}
+	return matchers
+}
+
+func (h *HTTPHandler) getMetricsByLabels(
+	w http.ResponseWriter,
+	r *http.Request,
+	metric model.Metric,
+	matchers model.Labels,
+) {
+	wrappedFind := func(args ...any) (any, error) {
+		return h.storage.FindByLabels(
+			r.Context(), metric.Tenant, metric.Type, metric.Name, matchers)
+	}
+	found, err := db.WithConnectionCheck(wrappedFind)
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+	metrics, ok := found.([]model.Metric)
+	if !ok {
+		h.log.Error().Msg("failed to convert 'any' to []model.Metric")
+		http.Error(w, "failed to convert 'find' result", http.StatusInternalServerError)
+		return
+	}
+	if len(metrics) == 0 {
+		http.Error(w,
+			fmt.Sprintf(errMsgPattern, r.URL.Path, "no metrics match the labels"),
+			http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set(constants.KeyContentType, constants.ContentTypeText)
+	if len(metrics) == 1 {
+		_, err = fmt.Fprintf(w, "%v", metrics[0].ActualValue())
+	} else {
+		slices.SortFunc(metrics, func(a, b model.Metric) int {
+			return strings.Compare(a.Labels.String(), b.Labels.String())
+		})
+		for _, m := range metrics {
+			if _, err = fmt.Fprintf(w, "{%s} %v\n",
+				m.Labels.String(), m.ActualValue()); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		h.log.Error().Err(err).Msg("failed to write response")
+	}
+}
+
 // GetMetricJSON возвращает значение метрики, переданной в теле запроса в формате JSON.
 //
 // Пример запроса: POST /value/.
 func (h *HTTPHandler) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
-	metric, err := extractJSON(r.Body)
+	tenant := requestTenant(r)
+	metric, err := extractJSON(r.Body, tenant)
 	if err != nil {
 		st := getStatusFromError(err)
 		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
 		return
 	}
 
-	dummyKey := metric.Type.String() + " " + metric.Name
+	key := metric.Key()
 	wrappedFind := func(args ...any) (any, error) {
-		return h.storage.Find(r.Context(), dummyKey)
+		return h.storage.Find(r.Context(), key)
 	}
 	m, err := db.WithConnectionCheck(wrappedFind)
 	if err != nil {
@@ -281,19 +637,22 @@ func (h *HTTPHandler) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set(constants.KeyContentType, constants.ContentTypeJSON)
+	setRevision(w, metric)
 	if err = json.NewEncoder(w).Encode(&metric); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-// GetAll возвращает все метрики в виде HTML-страницы.
+// GetAll возвращает все метрики арендатора в виде HTML-страницы.
 //
 // Пример запроса: GET /.
 func (h *HTTPHandler) GetAll(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+
 	w.Header().Set(constants.KeyContentType, constants.ContentTypeHTML)
 	wrappedGet := func(args ...any) (any, error) {
-		return h.storage.Get(r.Context())
+		return h.storage.Get(r.Context(), model.ListOptions{Tenant: tenant})
 	}
 	metrics, err := db.WithConnectionCheck(wrappedGet)
 	if err != nil {
@@ -307,7 +666,7 @@ func (h *HTTPHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := createMetricsPage(m)
+	page := createMetricsPage(tenant, m)
 	_, err = w.Write([]byte(page))
 	if err != nil {
 		h.log.Error().Err(err).Msg("failed to write response")
@@ -331,21 +690,31 @@ func (h *HTTPHandler) Ping(w http.ResponseWriter, r *http.Request) {
 	}
 	_, err := db.WithConnectionCheck(wrappedPing)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		st := getStatusFromError(err)
+		http.Error(w, err.Error(), st)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func createMetricsPage(metrics []model.Metric) string {
+func createMetricsPage(tenant string, metrics []model.Metric) string {
 	var page = `<html>
 	<body>
 %s	</body>
 </html>`
 
+	// арендатор и метки метрик приходят от клиентов
 	var data string
+	if tenant != model.DefaultTenant {
+		data += fmt.Sprintf("\t\t<h1>%s</h1>\n", html.EscapeString(tenant))
+	}
 	for _, m := range metrics {
-		data += fmt.Sprintf("\t\t<p>%s</p>\n", m.String())
+		if m.Timestamp == nil {
+			data += fmt.Sprintf("\t\t<p>%s</p>\n", html.EscapeString(m.String()))
+			continue
+		}
+		data += fmt.Sprintf("\t\t<p>%s (updated %s)</p>\n",
+			html.EscapeString(m.String()), m.Timestamp.UTC().Format(time.RFC3339))
 	}
 	return fmt.Sprintf(page, data)
 }
@@ -3,19 +3,23 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"regexp"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/talx-hub/malerter/internal/api/middlewares"
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
@@ -70,6 +74,20 @@ func testRequest(t *testing.T,
 	return response, string(respBody)
 }
 
+// dropTimestamp проверяет, что сервер проставил время получения метрики,
+// и убирает его из ответа для сравнения с ожидаемым JSON.
+func dropTimestamp(t *testing.T, body string) string {
+	t.Helper()
+
+	var fields map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &fields))
+	require.Contains(t, fields, "timestamp")
+	delete(fields, "timestamp")
+	result, err := json.Marshal(fields)
+	require.NoError(t, err)
+	return string(result)
+}
+
 func TestNewHTTPHandler(t *testing.T) {
 	type args struct {
 		storage Storage
@@ -85,17 +103,17 @@ func TestNewHTTPHandler(t *testing.T) {
 		{
 			name: "simple constructor test #0",
 			args: args{nil},
-			want: &HTTPHandler{nil, lg},
+			want: &HTTPHandler{storage: nil, log: lg},
 		},
 		{
 			name: "simple constructor test #1",
 			args: args{storage: nil},
-			want: &HTTPHandler{nil, lg},
+			want: &HTTPHandler{storage: nil, log: lg},
 		},
 		{
 			name: "simple constructor test #2",
 			args: args{storage: memory.New(lg, nil)},
-			want: &HTTPHandler{memory.New(lg, nil), lg},
+			want: &HTTPHandler{storage: memory.New(lg, nil), log: lg},
 		},
 	}
 	for _, tt := range tests {
@@ -129,7 +147,7 @@ func TestHTTPHandler_DumpMetric(t *testing.T) {
 		{
 			"WRONG", "someMetric", "1",
 			"/update/WRONG/someMetric/1 fails: metric, constructed from values is incorrect: " +
-				"incorrect request: only counter and gauge types are allowed\n",
+				"incorrect request: only counter, gauge and histogram types are allowed\n",
 			400,
 		},
 		{
@@ -183,7 +201,7 @@ func TestHTTPHandler_GetMetric(t *testing.T) {
 		{
 			"wrong", "pi",
 			"/value/wrong/pi fails: metric, constructed from values is incorrect: " +
-				"incorrect request: only counter and gauge types are allowed\n",
+				"incorrect request: only counter, gauge and histogram types are allowed\n",
 			400,
 		},
 		{"gauge", "wrong",
@@ -201,8 +219,8 @@ func TestHTTPHandler_GetMetric(t *testing.T) {
 	m2, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
 	lg, _ := logger.New(constants.LogLevelDefault)
 	repository := memory.New(lg, nil)
-	_ = repository.Add(context.TODO(), m1)
-	_ = repository.Add(context.TODO(), m2)
+	_, _ = repository.Add(context.TODO(), m1)
+	_, _ = repository.Add(context.TODO(), m2)
 
 	handler := NewHTTPHandler(repository, lg).GetMetric
 
@@ -221,6 +239,157 @@ func TestHTTPHandler_GetMetric(t *testing.T) {
 	}
 }
 
+func TestHTTPHandler_DeleteMetric(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	m, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
+	_, err := repository.Add(context.TODO(), m)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(repository, lg).DeleteMetric
+
+	tests := []struct {
+		mType  string
+		mName  string
+		want   string
+		status int
+	}{
+		{"gauge", "pi", "", http.StatusOK},
+		{"gauge", "pi",
+			"/value/gauge/pi fails: DB op failed: on attempt #0 error occurred: not found: metric gauge pi\n",
+			http.StatusNotFound},
+		{"wrong", "pi",
+			"/value/wrong/pi fails: metric, constructed from values is incorrect: " +
+				"incorrect request: only counter, gauge and histogram types are allowed\n",
+			http.StatusBadRequest},
+	}
+	for i, tt := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			resp, got := testRequest(t, handler, http.MethodDelete,
+				"/value", "", nil, tt.mType, tt.mName)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.want, got)
+			require.NoError(t, resp.Body.Close())
+		})
+	}
+}
+
+func TestHTTPHandler_ResetMetric(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	c, _ := model.NewMetric().FromValues("hits", model.MetricTypeCounter, int64(42))
+	g, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
+	_, err := repository.Add(context.TODO(), c)
+	require.NoError(t, err)
+	_, err = repository.Add(context.TODO(), g)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(repository, lg).ResetMetric
+
+	resp, got := testRequest(t, handler, http.MethodPost, "/reset", "", nil, "counter", "hits")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, got)
+	require.NoError(t, resp.Body.Close())
+	found, err := repository.Find(context.TODO(), c.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), *found.Delta)
+
+	resp, got = testRequest(t, handler, http.MethodPost, "/reset", "", nil, "gauge", "pi")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, got, "only counter metric can be reset")
+	require.NoError(t, resp.Body.Close())
+
+	resp, _ = testRequest(t, handler, http.MethodPost, "/reset", "", nil, "counter", "missing")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestHTTPHandler_Timestamp(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	m, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
+	m.Timestamp = &ts
+	_, err := repository.Add(context.TODO(), m)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(repository, lg)
+
+	resp, got := testRequest(t, handler.GetMetric, http.MethodGet,
+		"/value", "", nil, "gauge", "pi")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "3.14", got)
+	assert.Equal(t, "Tue, 02 Jan 2024 03:04:05 GMT", resp.Header.Get(constants.KeyLastModified))
+	require.NoError(t, resp.Body.Close())
+
+	resp, got = testRequest(t, handler.GetAll, http.MethodGet, "/", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, got, "<p>pi(gauge): 3.14 (updated 2024-01-02T03:04:05Z)</p>")
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestHTTPHandler_GetAll_Escape(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	m, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
+	m.Labels = model.Labels{"host": "<script>alert(1)</script>"}
+	_, err := repository.Add(context.TODO(), m)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(repository, lg)
+
+	resp, got := testRequest(t, handler.GetAll, http.MethodGet, "/", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, got, "<script>")
+	assert.Contains(t, got, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestHTTPHandler_GetMetric_Labels(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	for i, host := range []string{"a", "a", "b"} {
+		value := float64(i + 1)
+		_, _ = repository.Add(context.TODO(), model.Metric{
+			Name:   "CPUutilization",
+			Type:   model.MetricTypeGauge,
+			Value:  &value,
+			Labels: model.Labels{"host": host, "cpu": strconv.Itoa(i)},
+		})
+	}
+	handler := NewHTTPHandler(repository, lg).GetMetric
+
+	tests := []struct {
+		query  string
+		want   string
+		status int
+	}{
+		{"?host=b", "3", http.StatusOK},
+		{"?host=a", "{cpu=\"0\",host=\"a\"} 1\n{cpu=\"1\",host=\"a\"} 2\n", http.StatusOK},
+		{"?host=a&cpu=1", "2", http.StatusOK},
+		{"?host=c", "/value/gauge/CPUutilization fails: no metrics match the labels\n", http.StatusNotFound},
+		{"?1host=c", "/value/gauge/CPUutilization fails: incorrect request: invalid label name <1host>\n",
+			http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(
+				http.MethodGet, "/value/gauge/CPUutilization"+tt.query, http.NoBody)
+			chiCtx := chi.NewRouteContext()
+			chiCtx.URLParams.Add("type", "gauge")
+			chiCtx.URLParams.Add("name", "CPUutilization")
+			r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, chiCtx))
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
 func TestHTTPHandler_DumpMetricJSON(t *testing.T) {
 	tests := []struct {
 		method       string
@@ -234,13 +403,13 @@ func TestHTTPHandler_DumpMetricJSON(t *testing.T) {
 			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"mainQuestion", "type":"counter", "delta":42}`,
 			expectedCode: http.StatusOK,
-			expectedBody: `{"id":"mainQuestion", "type":"counter", "delta":42}`,
+			expectedBody: `{"id":"mainQuestion", "type":"counter", "delta":42, "revision":1}`,
 		},
 		{
 			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"mainQuestion", "type":"counter", "delta":42}`,
 			expectedCode: http.StatusOK,
-			expectedBody: `{"id":"mainQuestion", "type":"counter", "delta":84}`,
+			expectedBody: `{"id":"mainQuestion", "type":"counter", "delta":84, "revision":2}`,
 		},
 		{
 			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
@@ -301,19 +470,63 @@ func TestHTTPHandler_DumpMetricJSON(t *testing.T) {
 			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"pi", "type":"gauge", "value":3.14}`,
 			expectedCode: http.StatusOK,
-			expectedBody: `{"id":"pi", "type":"gauge", "value":3.14}`,
+			expectedBody: `{"id":"pi", "type":"gauge", "value":3.14, "revision":1}`,
 		},
 		{
 			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"pi", "type":"gauge", "value":3.1415926}`,
 			expectedCode: http.StatusOK,
-			expectedBody: `{"id":"pi", "type":"gauge", "value":3.1415926}`,
+			expectedBody: `{"id":"pi", "type":"gauge", "value":3.1415926, "revision":2}`,
 		},
 		{
 			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"pi", "type":"gauge", "delta":3}`,
 			expectedCode: http.StatusBadRequest,
 		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body: `{"id":"latency", "type":"histogram",
+"histogram":{"bounds":[0.1,1], "counts":[1,2,0], "sum":1.5, "count":3}}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":"latency", "type":"histogram", "revision":1,
+"histogram":{"bounds":[0.1,1], "counts":[1,2,0], "sum":1.5, "count":3}}`,
+		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body: `{"id":"latency", "type":"histogram",
+"histogram":{"bounds":[0.1,1], "counts":[0,1,1], "sum":2.5, "count":2}}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":"latency", "type":"histogram", "revision":2,
+"histogram":{"bounds":[0.1,1], "counts":[1,3,1], "sum":4, "count":5}}`,
+		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body: `{"id":"latency", "type":"histogram",
+"histogram":{"bounds":[0.5,1], "counts":[0,1,1], "sum":2.5, "count":2}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body: `{"id":"latency", "type":"histogram",
+"histogram":{"bounds":[0.1,1], "counts":[0,1], "sum":2.5, "count":1}}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body:         `{"id":"latency", "type":"histogram", "value":3.14}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body:         `{"id":"pi", "type":"gauge", "value":3, "labels":{"host":"a"}}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":"pi", "type":"gauge", "value":3, "labels":{"host":"a"}, "revision":1}`,
+		},
+		{
+			method: http.MethodPost, url: "/update", contentType: constants.ContentTypeJSON,
+			body:         `{"id":"pi", "type":"gauge", "value":3, "labels":{"host name":"a"}}`,
+			expectedCode: http.StatusBadRequest,
+		},
 	}
 
 	lg, _ := logger.New(constants.LogLevelDefault)
@@ -325,7 +538,7 @@ func TestHTTPHandler_DumpMetricJSON(t *testing.T) {
 			resp, got := testRequest(t, handler.DumpMetricJSON, test.method, test.url, test.contentType, &test.body)
 			assert.Equal(t, test.expectedCode, resp.StatusCode)
 			if test.expectedCode == http.StatusOK {
-				assert.JSONEq(t, test.expectedBody, got)
+				assert.JSONEq(t, test.expectedBody, dropTimestamp(t, got))
 			}
 			if err := resp.Body.Close(); err != nil {
 				log.Fatal(err)
@@ -347,6 +560,14 @@ func TestHTTPHandler_DumpMetricList(t *testing.T) {
 {"id":"m42","type":"counter", "delta":42}]`,
 			expectedCode: http.StatusOK,
 		},
+		{
+			name: "batch with histogram",
+			body: `[
+{"id":"pi", "type":"gauge", "value":3},
+{"id":"latency","type":"histogram",
+"histogram":{"bounds":[1], "counts":[2,1], "sum":3.5, "count":3}}]`,
+			expectedCode: http.StatusOK,
+		},
 		{
 			name:         "empty batch",
 			body:         ``,
@@ -373,6 +594,64 @@ func TestHTTPHandler_DumpMetricList(t *testing.T) {
 	}
 }
 
+func TestHTTPHandler_DumpMetricList_Merged(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	handler := NewHTTPHandler(repository, lg)
+
+	body := `[
+{"id":"pi", "type":"gauge", "value":3},
+{"id":"m42", "type":"counter", "delta":40},
+{"id":"m42", "type":"counter", "delta":2}]`
+	resp, got := testRequest(t,
+		handler.DumpMetricList,
+		http.MethodPost, "/updates",
+		constants.ContentTypeJSON,
+		&body)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, constants.ContentTypeJSON, resp.Header.Get(constants.KeyContentType))
+
+	var merged []model.Metric
+	require.NoError(t, json.Unmarshal([]byte(got), &merged))
+	require.Len(t, merged, 3)
+	assert.Equal(t, 3.0, merged[0].ActualValue())
+	assert.Equal(t, int64(40), merged[1].ActualValue())
+	assert.Equal(t, int64(42), merged[2].ActualValue())
+}
+
+// journalingStorage принимает записи без слияния, как хранилище
+// во время сбоя базы.
+type journalingStorage struct {
+	*memory.Memory
+}
+
+func (s journalingStorage) Add(_ context.Context, metric model.Metric) (model.Metric, error) {
+	return metric, nil
+}
+
+func (s journalingStorage) Batch(_ context.Context, metrics []model.Metric) ([]model.Metric, error) {
+	return metrics, nil
+}
+
+func TestHTTPHandler_Accepted(t *testing.T) {
+	lg := logger.NewNopLogger()
+	handler := NewHTTPHandler(journalingStorage{memory.New(lg, nil)}, lg)
+
+	body := `{"id":"m42", "type":"counter", "delta":2}`
+	resp, _ := testRequest(t, handler.DumpMetricJSON, http.MethodPost, "/update",
+		constants.ContentTypeJSON, &body)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(constants.KeyETag))
+
+	body = `[` + body + `]`
+	resp, _ = testRequest(t, handler.DumpMetricList, http.MethodPost, "/updates",
+		constants.ContentTypeJSON, &body)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+}
+
 func TestHTTPHandler_GetMetricJSON(t *testing.T) {
 	tests := []struct {
 		method       string
@@ -386,7 +665,7 @@ func TestHTTPHandler_GetMetricJSON(t *testing.T) {
 			method: http.MethodPost, url: "/value", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"m42", "type":"counter"}`,
 			expectedCode: http.StatusOK,
-			expectedBody: `{"id":"m42", "type":"counter", "delta":42}`,
+			expectedBody: `{"id":"m42", "type":"counter", "delta":42, "revision":1}`,
 		},
 		{
 			method: http.MethodPost, url: "/value", contentType: constants.ContentTypeJSON,
@@ -417,7 +696,7 @@ func TestHTTPHandler_GetMetricJSON(t *testing.T) {
 			method: http.MethodPost, url: "/value", contentType: constants.ContentTypeJSON,
 			body:         `{"id":"pi", "type":"gauge"}`,
 			expectedCode: http.StatusOK,
-			expectedBody: `{"id":"pi", "type":"gauge", "value":3.14}`,
+			expectedBody: `{"id":"pi", "type":"gauge", "value":3.14, "revision":1}`,
 		},
 	}
 
@@ -425,8 +704,8 @@ func TestHTTPHandler_GetMetricJSON(t *testing.T) {
 	repository := memory.New(lg, nil)
 	m1, _ := model.NewMetric().FromValues("m42", model.MetricTypeCounter, int64(42))
 	m2, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
-	_ = repository.Add(context.TODO(), m1)
-	_ = repository.Add(context.TODO(), m2)
+	_, _ = repository.Add(context.TODO(), m1)
+	_, _ = repository.Add(context.TODO(), m2)
 
 	handler := NewHTTPHandler(repository, lg)
 
@@ -463,7 +742,7 @@ func TestHTTPHandler_GetAll(t *testing.T) {
 	lg, _ := logger.New(constants.LogLevelDefault)
 	repository := memory.New(lg, nil)
 	m1, _ := model.NewMetric().FromValues("m42", model.MetricTypeCounter, int64(42))
-	_ = repository.Add(context.TODO(), m1)
+	_, _ = repository.Add(context.TODO(), m1)
 
 	handler := NewHTTPHandler(repository, lg)
 
@@ -588,7 +867,7 @@ func TestExtractJSON(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			buf := bytes.NewBufferString(test.json)
-			m, err := extractJSON(buf)
+			m, err := extractJSON(buf, model.DefaultTenant)
 			if !test.wantErr {
 				require.NoError(t, err)
 				assert.Equal(t, test.want.Name, m.Name)
@@ -600,3 +879,115 @@ func TestExtractJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestHTTPHandler_Tenant(t *testing.T) {
+	lg := logger.NewNopLogger()
+	handler := NewHTTPHandler(memory.New(lg, nil), lg)
+
+	do := func(h http.HandlerFunc, method, path, tenant, body string) (int, string) {
+		t.Helper()
+
+		r := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("type", "gauge")
+		chiCtx.URLParams.Add("name", "Alloc")
+		ctx := middlewares.WithTenant(r.Context(), tenant)
+		r = r.WithContext(context.WithValue(ctx, chi.RouteCtxKey, chiCtx))
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	// арендатор из тела запроса игнорируется
+	status, _ := do(handler.DumpMetricJSON, http.MethodPost, "/update/", "team-a",
+		`{"id":"Alloc","type":"gauge","value":1,"tenant":"team-b"}`)
+	require.Equal(t, http.StatusOK, status)
+	status, _ = do(handler.DumpMetricJSON, http.MethodPost, "/update/", "",
+		`{"id":"Alloc","type":"gauge","value":2}`)
+	require.Equal(t, http.StatusOK, status)
+
+	status, body := do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc", "team-a", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "1", body)
+	status, body = do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc", "", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "2", body)
+	status, _ = do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc", "team-b", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, body = do(handler.GetAll, http.MethodGet, "/", "team-a", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "<html>\n\t<body>\n\t\t<h1>team-a</h1>\n\t\t<p>Alloc(gauge): 1.00</p>\n\t</body>\n</html>",
+		dropUpdated(body))
+
+	status, body = do(handler.ListMetrics, http.MethodGet, "/api/v1/metrics", "team-b", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"metrics":[]}`, body)
+}
+
+// dropUpdated убирает время обновления метрик из HTML-страницы.
+func dropUpdated(page string) string {
+	return regexp.MustCompile(` \(updated [^)]*\)`).ReplaceAllString(page, "")
+}
+
+func TestHTTPHandler_CompareAndSet(t *testing.T) {
+	lg := logger.NewNopLogger()
+	handler := NewHTTPHandler(memory.New(lg, nil), lg)
+
+	do := func(h http.HandlerFunc, path, ifMatch, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if ifMatch != "" {
+			r.Header.Set(constants.KeyIfMatch, ifMatch)
+		}
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("type", "gauge")
+		chiCtx.URLParams.Add("name", "Leader")
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, chiCtx))
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	// 0 — метрика ещё не должна существовать
+	w := do(handler.DumpMetricJSON, "/update/", `"0"`, `{"id":"Leader","type":"gauge","value":1}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get(constants.KeyETag))
+	w = do(handler.DumpMetricJSON, "/update/", `"0"`, `{"id":"Leader","type":"gauge","value":2}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = do(handler.DumpMetricJSON, "/update/", `"1"`, `{"id":"Leader","type":"gauge","value":2}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get(constants.KeyETag))
+	assert.JSONEq(t, `{"id":"Leader","type":"gauge","value":2,"revision":2}`, dropTimestamp(t, w.Body.String()))
+	w = do(handler.DumpMetricJSON, "/update/", `"1"`, `{"id":"Leader","type":"gauge","value":3}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	// метки сущности по RFC 9110: без кавычек — ошибка, слабые не совпадают,
+	// из списка совпадает любая, * — любая существующая метрика
+	for _, ifMatch := range []string{"latest", "0", `"1`, `"1" "2"`, `W/`, ","} {
+		w = do(handler.DumpMetricJSON, "/update/", ifMatch, `{"id":"Leader","type":"gauge","value":3}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code, ifMatch)
+	}
+	for _, ifMatch := range []string{`W/"2"`, `"02"`, `"latest"`, `"1", W/"2"`} {
+		w = do(handler.DumpMetricJSON, "/update/", ifMatch, `{"id":"Leader","type":"gauge","value":3}`)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, ifMatch)
+	}
+	w = do(handler.DumpMetricJSON, "/update/", `"1", "2"`, `{"id":"Leader","type":"gauge","value":2}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get(constants.KeyETag))
+	w = do(handler.DumpMetricJSON, "/update/", `*`, `{"id":"Leader","type":"gauge","value":2}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get(constants.KeyETag))
+	w = do(handler.DumpMetricJSON, "/update/", `*`, `{"id":"Follower","type":"gauge","value":2}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = do(handler.GetMetric, "/value/gauge/Leader", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Body.String())
+	assert.Equal(t, `"4"`, w.Header().Get(constants.KeyETag))
+	w = do(handler.GetMetricJSON, "/value/", "", `{"id":"Leader","type":"gauge"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get(constants.KeyETag))
+}
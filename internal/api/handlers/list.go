@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+type listResponse struct {
+	// NextCursor передаётся в параметре cursor для получения следующей страницы;
+	// пуст на последней странице.
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Metrics    []model.Metric `json:"metrics"`
+}
+
+// ListMetrics возвращает страницу метрик в формате JSON.
+// Параметры запроса:
+//   - type — тип метрик;
+//   - prefix — начало имени;
+//   - regex — регулярное выражение для имени;
+//   - limit — размер страницы, по умолчанию 100, не более 1000;
+//   - cursor — значение next_cursor из предыдущего ответа.
+//
+// Пример запроса: GET /api/v1/metrics?prefix=CPU&limit=10.
+func (h *HTTPHandler) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	opts, err := parseListOptions(r.URL.Query(), tenant)
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+
+	// запрашиваем на одну метрику больше, чтобы узнать, есть ли следующая страница
+	limit := opts.Limit
+	opts.Limit++
+	wrappedGet := func(args ...any) (any, error) {
+		return h.storage.Get(r.Context(), opts)
+	}
+	found, err := db.WithConnectionCheck(wrappedGet)
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+	metrics, ok := found.([]model.Metric)
+	if !ok {
+		h.log.Error().Msg("failed to convert 'any' to []model.Metric")
+		http.Error(w, "failed to convert 'get' result", http.StatusInternalServerError)
+		return
+	}
+
+	resp := listResponse{Metrics: metrics}
+	if len(metrics) > limit {
+		resp.Metrics = metrics[:limit]
+		resp.NextCursor, err = encodeCursor(resp.Metrics[limit-1].Key())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set(constants.KeyContentType, constants.ContentTypeJSON)
+	if err = json.NewEncoder(w).Encode(&resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseListOptions(query url.Values, tenant string) (model.ListOptions, error) {
+	opts := model.ListOptions{
+		Tenant:  tenant,
+		Type:    model.MetricType(query.Get("type")),
+		Prefix:  query.Get("prefix"),
+		Pattern: query.Get("regex"),
+		Limit:   defaultPageSize,
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return opts, &customerror.InvalidArgumentError{
+				Info: fmt.Sprintf("limit must be between 1 and %d", maxPageSize),
+			}
+		}
+		opts.Limit = limit
+	}
+	if v := query.Get("cursor"); v != "" {
+		after, err := decodeCursor(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.After = &after
+	}
+	if err := opts.CheckValid(); err != nil {
+		return opts, fmt.Errorf("invalid list options: %w", err)
+	}
+	return opts, nil
+}
+
+// курсор непрозрачен для клиента: это ключ последней метрики страницы.
+func encodeCursor(key model.MetricKey) (string, error) {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) (model.MetricKey, error) {
+	var key model.MetricKey
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &key)
+	}
+	if err != nil {
+		return key, &customerror.InvalidArgumentError{
+			Info: "invalid cursor <" + cursor + ">",
+		}
+	}
+	return key, nil
+}
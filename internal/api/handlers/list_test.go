@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+)
+
+func TestHTTPHandler_ListMetrics(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	for _, name := range []string{"CPUutilization1", "CPUutilization2", "CPUutilization3", "HeapAlloc"} {
+		m, err := model.NewMetric().FromValues(name, model.MetricTypeGauge, 1.5)
+		require.NoError(t, err)
+		_, err = repository.Add(context.TODO(), m)
+		require.NoError(t, err)
+	}
+	c, err := model.NewMetric().FromValues("PollCount", model.MetricTypeCounter, int64(3))
+	require.NoError(t, err)
+	_, err = repository.Add(context.TODO(), c)
+	require.NoError(t, err)
+	handler := NewHTTPHandler(repository, lg).ListMetrics
+
+	list := func(t *testing.T, query string) listResponse {
+		t.Helper()
+		resp, got := testRequest(t, handler, http.MethodGet, "/api/v1/metrics?"+query, "", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode, got)
+		require.NoError(t, resp.Body.Close())
+		var body listResponse
+		require.NoError(t, json.Unmarshal([]byte(got), &body))
+		return body
+	}
+	names := func(metrics []model.Metric) []string {
+		result := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			result = append(result, m.Name)
+		}
+		return result
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		page := list(t, "prefix=CPU&limit=2")
+		assert.Equal(t, []string{"CPUutilization1", "CPUutilization2"}, names(page.Metrics))
+		require.NotEmpty(t, page.NextCursor)
+
+		page = list(t, "prefix=CPU&limit=2&cursor="+page.NextCursor)
+		assert.Equal(t, []string{"CPUutilization3"}, names(page.Metrics))
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		assert.Equal(t, []string{"PollCount"}, names(list(t, "type=counter").Metrics))
+		assert.Equal(t, []string{"CPUutilization2", "HeapAlloc"},
+			names(list(t, "regex=(2|Alloc)$").Metrics))
+		assert.Empty(t, list(t, "prefix=Missing").Metrics)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, query := range []string{"limit=0", "limit=1001", "type=wrong", "regex=(", "cursor=%21"} {
+			resp, _ := testRequest(t, handler, http.MethodGet, "/api/v1/metrics?"+query, "", nil)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			require.NoError(t, resp.Body.Close())
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
+)
+
+const (
+	defaultRange  = time.Hour
+	defaultStep   = time.Minute
+	maxRangeSteps = 11000
+)
+
+// параметры запроса, не являющиеся метками ряда.
+var rangeParams = map[string]struct{}{
+	"name": {}, "type": {}, "from": {}, "to": {}, "step": {},
+}
+
+type rangeResponse struct {
+	Labels model.Labels          `json:"labels,omitempty"`
+	Type   model.MetricType      `json:"type"`
+	Name   string                `json:"id"`
+	Points []model.Sample        `json:"points"`
+	Steps  []model.StepAggregate `json:"steps"`
+	// Resolution — разрешение данных в секундах, 0 для исходных точек.
+	Resolution int64 `json:"resolution"`
+}
+
+// QueryRange возвращает историю ряда за интервал и агрегаты
+// (min/max/avg/last) по шагам длины step.
+// Параметры from и to принимают RFC3339 или unix-время в секундах,
+// step — длительность Go (например, 30s) или число секунд.
+// Остальные параметры запроса задают метки ряда.
+// Разрешение данных (исходные точки или агрегаты) выбирается
+// по политике хранения, см. model.Retention.Resolution.
+//
+// Пример запроса: GET /api/v1/query_range?name=HeapAlloc&type=gauge&step=1m.
+func (h *HTTPHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	query := r.URL.Query()
+	metric, err := model.NewMetric().FromValues(
+		query.Get("name"), model.MetricType(query.Get("type")), "0")
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+	metric.Tenant = tenant
+	metric.Labels = seriesLabels(query)
+	if err = metric.Labels.CheckValid(); err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+
+	now := time.Now()
+	from, to, step, err := parseRange(query, now)
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+
+	resp := rangeResponse{
+		Labels: metric.Labels,
+		Type:   metric.Type,
+		Name:   metric.Name,
+	}
+	key := metric.Key()
+	resolution := h.retention.Resolution(from, now)
+	if resolution == 0 {
+		resp.Points, resp.Steps, err = h.rawRange(r, key, from, to, step)
+	} else {
+		resp.Points, resp.Steps, err = h.rollupRange(r, key, resolution, from, to, step)
+		resp.Resolution = int64(resolution.Seconds())
+	}
+	if err != nil {
+		st := getStatusFromError(err)
+		http.Error(w, fmt.Sprintf(errMsgPattern, r.URL.Path, err.Error()), st)
+		return
+	}
+
+	w.Header().Set(constants.KeyContentType, constants.ContentTypeJSON)
+	if err = json.NewEncoder(w).Encode(&resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *HTTPHandler) rawRange(
+	r *http.Request,
+	key model.MetricKey,
+	from, to time.Time,
+	step time.Duration,
+) ([]model.Sample, []model.StepAggregate, error) {
+	wrappedHistory := func(args ...any) (any, error) {
+		return h.storage.History(r.Context(), key, from, to)
+	}
+	found, err := db.WithConnectionCheck(wrappedHistory)
+	if err != nil {
+		return nil, nil, err
+	}
+	samples, ok := found.([]model.Sample)
+	if !ok {
+		return nil, nil, errors.New("failed to convert 'history' result")
+	}
+	return samples, model.Aggregate(samples, from, step), nil
+}
+
+// rollupRange возвращает в качестве точек средние значения агрегатов.
+func (h *HTTPHandler) rollupRange(
+	r *http.Request,
+	key model.MetricKey,
+	resolution time.Duration,
+	from, to time.Time,
+	step time.Duration,
+) ([]model.Sample, []model.StepAggregate, error) {
+	wrappedRollups := func(args ...any) (any, error) {
+		return h.storage.Rollups(r.Context(), key, resolution, from, to)
+	}
+	found, err := db.WithConnectionCheck(wrappedRollups)
+	if err != nil {
+		return nil, nil, err
+	}
+	rollups, ok := found.([]model.Rollup)
+	if !ok {
+		return nil, nil, errors.New("failed to convert 'rollups' result")
+	}
+
+	points := make([]model.Sample, 0, len(rollups))
+	for _, rl := range rollups {
+		points = append(points, model.Sample{
+			Timestamp: rl.Start,
+			Value:     rl.Sum / float64(rl.Count),
+		})
+	}
+	return points, model.AggregateRollups(rollups, from, step), nil
+}
+
+func seriesLabels(query url.Values) model.Labels {
+	labels := make(model.Labels)
+	for k := range query {
+		if _, reserved := rangeParams[k]; !reserved {
+			labels[k] = query.Get(k)
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+func parseRange(query url.Values, now time.Time) (from, to time.Time, step time.Duration, err error) {
+	to = now.UTC()
+	if v := query.Get("to"); v != "" {
+		if to, err = parseTime(v); err != nil {
+			return from, to, step, err
+		}
+	}
+	from = to.Add(-defaultRange)
+	if v := query.Get("from"); v != "" {
+		if from, err = parseTime(v); err != nil {
+			return from, to, step, err
+		}
+	}
+	step = defaultStep
+	if v := query.Get("step"); v != "" {
+		if step, err = parseStep(v); err != nil {
+			return from, to, step, err
+		}
+	}
+
+	if from.After(to) {
+		return from, to, step, &customerror.InvalidArgumentError{
+			Info: "from must not be after to",
+		}
+	}
+	if step <= 0 {
+		return from, to, step, &customerror.InvalidArgumentError{
+			Info: "step must be positive",
+		}
+	}
+	if to.Sub(from)/step > maxRangeSteps {
+		return from, to, step, &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf("range exceeds %d steps", maxRangeSteps),
+		}
+	}
+	return from, to, step, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	sec, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, &customerror.InvalidArgumentError{
+			Info: "invalid time <" + v + ">",
+		}
+	}
+	return time.Unix(0, int64(sec*float64(time.Second))).UTC(), nil
+}
+
+func parseStep(v string) (time.Duration, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, nil
+	}
+	sec, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, &customerror.InvalidArgumentError{
+			Info: "invalid step <" + v + ">",
+		}
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
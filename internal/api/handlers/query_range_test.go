@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+)
+
+func TestHTTPHandler_QueryRange(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, v := range []float64{1, 5, 3, 10} {
+		ts := start.Add(time.Duration(i) * 30 * time.Second)
+		m, err := model.NewMetric().FromValues("HeapAlloc", model.MetricTypeGauge, v)
+		require.NoError(t, err)
+		m.Timestamp = &ts
+		_, err = repository.Add(context.TODO(), m)
+		require.NoError(t, err)
+	}
+	handler := NewHTTPHandler(repository, lg).QueryRange
+
+	t.Run("points and steps", func(t *testing.T) {
+		resp, got := testRequest(t, handler, http.MethodGet,
+			"/api/v1/query_range?name=HeapAlloc&type=gauge"+
+				"&from=2024-01-01T00:00:00Z&to=2024-01-01T00:05:00Z&step=1m",
+			"", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body rangeResponse
+		require.NoError(t, json.Unmarshal([]byte(got), &body))
+		assert.Equal(t, "HeapAlloc", body.Name)
+		assert.Len(t, body.Points, 4)
+		require.Len(t, body.Steps, 2)
+		assert.Equal(t, model.StepAggregate{
+			Start: start, Min: 1, Max: 5, Avg: 3, Last: 5, Count: 2,
+		}, body.Steps[0])
+		assert.Equal(t, model.StepAggregate{
+			Start: start.Add(time.Minute), Min: 3, Max: 10, Avg: 6.5, Last: 10, Count: 2,
+		}, body.Steps[1])
+	})
+
+	t.Run("unix seconds", func(t *testing.T) {
+		resp, got := testRequest(t, handler, http.MethodGet,
+			"/api/v1/query_range?name=HeapAlloc&type=gauge&from=1704067230&to=1704067260&step=60",
+			"", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body rangeResponse
+		require.NoError(t, json.Unmarshal([]byte(got), &body))
+		assert.Len(t, body.Points, 2)
+	})
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+	}{
+		{"empty name", "?type=gauge", http.StatusNotFound},
+		{"wrong type", "?name=HeapAlloc&type=wrong", http.StatusBadRequest},
+		{"wrong from", "?name=HeapAlloc&type=gauge&from=yesterday", http.StatusBadRequest},
+		{"negative step", "?name=HeapAlloc&type=gauge&step=-1s", http.StatusBadRequest},
+		{"from after to", "?name=HeapAlloc&type=gauge&from=20&to=10", http.StatusBadRequest},
+		{"too many steps", "?name=HeapAlloc&type=gauge&from=0&to=1000000&step=1", http.StatusBadRequest},
+		{"invalid label", "?name=HeapAlloc&type=gauge&1host=a", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := testRequest(t, handler, http.MethodGet,
+				"/api/v1/query_range"+tt.query, "", nil)
+			assert.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestHTTPHandler_QueryRange_Rollups(t *testing.T) {
+	lg := logger.NewNopLogger()
+	repository := memory.New(lg, nil)
+	now := time.Now().UTC()
+	start := model.BucketStart(now.Add(-3*time.Hour), time.Hour)
+	for i, v := range []float64{1, 3, 8} {
+		ts := start.Add(time.Duration(i) * 20 * time.Minute)
+		m, err := model.NewMetric().FromValues("HeapAlloc", model.MetricTypeGauge, v)
+		require.NoError(t, err)
+		m.Timestamp = &ts
+		_, err = repository.Add(context.TODO(), m)
+		require.NoError(t, err)
+	}
+	retention := model.NewRetention(time.Hour,
+		model.RollupTier{Resolution: time.Minute, Retention: 24 * time.Hour})
+	require.NoError(t, repository.Compact(context.TODO(), retention, now))
+
+	h := NewHTTPHandler(repository, lg)
+	h.SetRetention(retention)
+
+	resp, got := testRequest(t, h.QueryRange, http.MethodGet,
+		"/api/v1/query_range?name=HeapAlloc&type=gauge&step=1h&from="+
+			start.Format(time.RFC3339),
+		"", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body rangeResponse
+	require.NoError(t, json.Unmarshal([]byte(got), &body))
+	assert.Equal(t, int64(60), body.Resolution)
+	assert.Len(t, body.Points, 3)
+	require.Len(t, body.Steps, 1)
+	assert.Equal(t, model.StepAggregate{
+		Start: start, Min: 1, Max: 8, Avg: 4, Last: 8, Count: 3,
+	}, body.Steps[0])
+}
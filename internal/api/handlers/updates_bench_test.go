@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+)
+
+// agentBatchSize — размер пакета, который агент отправляет за один опрос.
+const agentBatchSize = 60
+
+// agentBatch возвращает тело POST /updates/, которое агент отправляет за один опрос.
+func agentBatch(b *testing.B) []byte {
+	b.Helper()
+
+	delta := int64(1)
+	metrics := make([]model.Metric, 0, agentBatchSize)
+	for i := range agentBatchSize - 1 {
+		value := float64(i)
+		metrics = append(metrics, model.Metric{
+			Name: "gauge" + strconv.Itoa(i), Type: model.MetricTypeGauge, Value: &value,
+		})
+	}
+	metrics = append(metrics, model.Metric{
+		Name: "PollCount", Type: model.MetricTypeCounter, Delta: &delta,
+	})
+	body, err := json.Marshal(metrics)
+	require.NoError(b, err)
+	return body
+}
+
+// benchmarkDumpMetricList отправляет пакет агента в обработчик b.N раз.
+func benchmarkDumpMetricList(b *testing.B, handler *HTTPHandler) {
+	b.Helper()
+
+	body := agentBatch(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		r.Header.Set(constants.KeyContentType, constants.ContentTypeJSON)
+		w := httptest.NewRecorder()
+		handler.DumpMetricList(w, r)
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status %d: %s", w.Code, w.Body.String())
+		}
+	}
+	b.ReportMetric(float64(b.N*agentBatchSize)/b.Elapsed().Seconds(), "metrics/s")
+}
+
+// BenchmarkHTTPHandler_DumpMetricList измеряет POST /updates/ с хранилищем
+// в памяти и не требует Postgres. Это базовая линия для
+// BenchmarkHTTPHandler_DumpMetricList_DB: разница между ними — стоимость
+// записи в базу, остальное — разбор запроса и ответ.
+//
+//	go test -run '^$' -bench DumpMetricList -count 10 ./internal/api/handlers/
+func BenchmarkHTTPHandler_DumpMetricList(b *testing.B) {
+	lg := logger.NewNopLogger()
+	benchmarkDumpMetricList(b, NewHTTPHandler(memory.New(lg, nil), lg))
+}
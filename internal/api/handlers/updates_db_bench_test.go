@@ -0,0 +1,34 @@
+//go:build integration_tests
+// +build integration_tests
+
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/repository/db"
+	"github.com/talx-hub/malerter/pkg/pgcontainer"
+)
+
+// BenchmarkHTTPHandler_DumpMetricList_DB измеряет пропускную способность
+// POST /updates/ с хранилищем в Postgres. Базовая линия без базы —
+// BenchmarkHTTPHandler_DumpMetricList, запись пакета одним pgx.Batch
+// и прежняя запись по одной метрике сравниваются в BenchmarkDB_Batch
+// и BenchmarkDB_Batch_tx пакета db:
+//
+//	go test -tags integration_tests -run '^$' -bench DumpMetricList -count 10 ./internal/api/handlers/
+func BenchmarkHTTPHandler_DumpMetricList_DB(b *testing.B) {
+	lg := logger.NewNopLogger()
+	pg := pgcontainer.New(lg)
+	require.NoError(b, pg.RunContainer())
+	defer pg.Close()
+
+	storage, err := db.New(context.Background(), pg.GetDSN(), lg, nil)
+	require.NoError(b, err)
+	defer storage.Close()
+	benchmarkDumpMetricList(b, NewHTTPHandler(storage, lg))
+}
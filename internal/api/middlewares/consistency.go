@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/repository/db"
+)
+
+const noCache = "no-cache"
+
+// ReadPrimary направляет чтения запроса на основную базу, если клиент
+// не согласен на устаревшие данные (Cache-Control: no-cache), например,
+// когда ему нужно увидеть только что отправленную метрику, или если
+// клиент писал в течение window: реплики могут ещё не получить запись.
+// Клиент определяется адресом соединения и арендатором, подтверждённым
+// CheckTenant, поэтому middleware подключается после него: заголовки
+// запроса клиент может подменить. Записью считается любой запрос,
+// кроме GET, HEAD, OPTIONS и POST на пути reads, которые только читают.
+func ReadPrimary(window time.Duration, reads ...string) func(http.Handler) http.Handler {
+	writers := newRecentWriters(window)
+	readOnly := make(map[string]struct{}, len(reads))
+	for _, path := range reads {
+		readOnly[strings.TrimSuffix(path, "/")] = struct{}{}
+	}
+	return func(next http.Handler) http.Handler {
+		readFn := func(w http.ResponseWriter, r *http.Request) {
+			now := time.Now()
+			client := clientOf(r)
+			if isWrite(r, readOnly) {
+				writers.wrote(client, now)
+			}
+			if writers.recent(client, now) || noCacheRequested(r) {
+				r = r.WithContext(db.WithPrimary(r.Context()))
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(readFn)
+	}
+}
+
+func noCacheRequested(r *http.Request) bool {
+	for _, directive := range strings.Split(r.Header.Get(constants.KeyCacheControl), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), noCache) {
+			return true
+		}
+	}
+	return false
+}
+
+func isWrite(r *http.Request, readOnly map[string]struct{}) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		_, read := readOnly[strings.TrimSuffix(r.URL.Path, "/")]
+		return !read
+	default:
+		return true
+	}
+}
+
+func clientOf(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	return TenantFromContext(r.Context()) + "@" + ip
+}
+
+// recentWriters помнит время последней записи клиентов.
+// Устаревшие записи удаляются не чаще раза в window.
+type recentWriters struct {
+	seen   map[string]time.Time
+	swept  time.Time
+	window time.Duration
+	m      sync.Mutex
+}
+
+func newRecentWriters(window time.Duration) *recentWriters {
+	return &recentWriters{seen: make(map[string]time.Time), window: window}
+}
+
+func (w *recentWriters) wrote(client string, now time.Time) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.seen[client] = now
+	if now.Sub(w.swept) < w.window {
+		return
+	}
+	for c, at := range w.seen {
+		if now.Sub(at) >= w.window {
+			delete(w.seen, c)
+		}
+	}
+	w.swept = now
+}
+
+func (w *recentWriters) recent(client string, now time.Time) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	at, ok := w.seen[client]
+	return ok && now.Sub(at) < w.window
+}
@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/repository/db"
+)
+
+func TestReadPrimary(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		want         bool
+	}{
+		{"no header", "", false},
+		{"no-cache", "no-cache", true},
+		{"among directives", "max-age=0, No-Cache", true},
+		{"other directive", "no-store", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+			handler := ReadPrimary(time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = db.ReadsPrimary(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if tt.cacheControl != "" {
+				req.Header.Set(constants.KeyCacheControl, tt.cacheControl)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// После записи чтения того же клиента идут на основную базу,
+// чтения других клиентов — нет. Клиент определяется адресом соединения
+// и подтверждённым арендатором, а не заголовками запроса.
+func TestReadPrimary_AfterWrite(t *testing.T) {
+	var got bool
+	handler := ReadPrimary(time.Minute, "/value/")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = db.ReadsPrimary(r.Context())
+	}))
+	request := func(method, path, ip, tenant string) bool {
+		req := httptest.NewRequest(method, path, http.NoBody)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-Real-IP", "10.0.0.1")
+		req.Header.Set(constants.KeyTenant, "team-a")
+		if tenant != "" {
+			req = req.WithContext(WithTenant(req.Context(), tenant))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	assert.False(t, request(http.MethodGet, "/", "10.0.0.1", ""))
+	request(http.MethodPost, "/update/", "10.0.0.1", "")
+	assert.True(t, request(http.MethodGet, "/", "10.0.0.1", ""))
+	assert.False(t, request(http.MethodGet, "/", "10.0.0.2", ""))
+	assert.False(t, request(http.MethodGet, "/", "10.0.0.1", "team-a"))
+
+	// чтение метрики через POST не считается записью
+	request(http.MethodPost, "/value/", "10.0.0.3", "")
+	assert.False(t, request(http.MethodGet, "/", "10.0.0.3", ""))
+	request(http.MethodPost, "/value", "10.0.0.3", "")
+	assert.False(t, request(http.MethodGet, "/", "10.0.0.3", ""))
+}
+
+func TestRecentWriters(t *testing.T) {
+	w := newRecentWriters(time.Second)
+	now := time.Now()
+	w.wrote("a", now)
+	assert.True(t, w.recent("a", now.Add(time.Second/2)))
+	assert.False(t, w.recent("a", now.Add(time.Second)))
+
+	// устаревшие записи удаляются при следующей записи
+	w.wrote("b", now.Add(2*time.Second))
+	assert.NotContains(t, w.seen, "a")
+	assert.Contains(t, w.seen, "b")
+}
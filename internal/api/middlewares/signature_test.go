@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gotest.tools/v3/assert"
@@ -54,6 +55,45 @@ func TestCheckSignature(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestCheckAdminSignature(t *testing.T) {
+	const adminKey = "admin-key"
+	admin := signature.NewVerifier(adminKey, time.Minute)
+	request := func(target, nonce, signKey string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(testBody))
+		u := r.URL
+		ts := signature.Timestamp(time.Now())
+		data := signature.Request(ts, nonce, []string{r.Method, u.EscapedPath(), u.RawQuery}, []byte(testBody))
+		r.Header.Set(constants.KeyAdminSignature, signature.Hash(data, signKey))
+		r.Header.Set(constants.KeyAdminTimestamp, ts)
+		r.Header.Set(constants.KeyAdminNonce, nonce)
+		return r
+	}
+
+	signed := request("/admin/restore?backup=a.bk", "n1", adminKey)
+	replayed := request("/admin/restore?backup=a.bk", "n1", adminKey)
+	changed := request("/admin/restore?backup=a.bk", "n2", adminKey)
+	changed.URL.RawQuery = "backup=b.bk"
+	for _, tt := range []struct {
+		name     string
+		admin    *signature.Verifier
+		r        *http.Request
+		wantCode int
+	}{
+		{"signed", admin, signed, http.StatusOK},
+		{"replayed", admin, replayed, http.StatusUnauthorized},
+		{"changed query", admin, changed, http.StatusUnauthorized},
+		{"agent key", admin, request("/admin/backups", "n3", key), http.StatusUnauthorized},
+		{"unsigned", admin, httptest.NewRequest(http.MethodGet, "/admin/backups", http.NoBody), http.StatusUnauthorized},
+		{"no admin key", nil, request("/admin/backups", "n4", adminKey), http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			CheckAdminSignature(tt.admin)(&sigStubHandler{}).ServeHTTP(w, tt.r)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
 func BenchmarkCheckSignature(b *testing.B) {
 	stub := sigStubHandler{}
 	withSignature := CheckSignature(key)(&stub)
@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/pkg/signature"
+)
+
+type tenantKey struct{}
+
+// TenantFromContext возвращает арендатора, подтверждённого CheckTenant.
+// Без подтверждённого арендатора запрос относится к арендатору по умолчанию.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// WithTenant возвращает контекст запроса арендатора tenant
+// без проверки подписи, например, для тестов обработчиков.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// CheckTenant подтверждает арендатора из заголовка X-Tenant:
+// запрос должен быть подписан ключом этого арендатора, подпись покрывает
+// метод, путь, параметры, арендатора и тело в том виде, в каком
+// оно пришло (см. signature.Request). Подтверждённый арендатор попадает
+// в контекст запроса, см. TenantFromContext.
+// Запросы без заголовка относятся к арендатору по умолчанию,
+// запросы арендатора без ключа отклоняются.
+func CheckTenant(tenants map[string]*signature.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant := r.Header.Get(constants.KeyTenant)
+			if tenant == model.DefaultTenant {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err := model.CheckTenant(tenant); err != nil {
+				http.Error(w, "invalid "+constants.KeyTenant+" header: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			verifier, ok := tenants[tenant]
+			if !ok {
+				http.Error(w, "unknown tenant <"+tenant+">", http.StatusForbidden)
+				return
+			}
+			body, err := getBody(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = verifier.Verify(
+				r.Header.Get(constants.KeyTenantSignature),
+				r.Header.Get(constants.KeyTenantTimestamp),
+				r.Header.Get(constants.KeyTenantNonce),
+				[]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, tenant},
+				body)
+			if err != nil {
+				http.Error(w, "tenant signature: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+			next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenant)))
+		})
+	}
+}
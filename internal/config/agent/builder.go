@@ -3,6 +3,7 @@ package agent
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/talx-hub/malerter/internal/config"
 	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/model"
 )
 
 const (
@@ -28,6 +30,8 @@ const (
 	EnvPollInterval   = "POLL_INTERVAL"
 	EnvRateLimit      = "RATE_LIMIT"
 	EnvReportInterval = "REPORT_INTERVAL"
+	EnvTenant         = "TENANT"
+	EnvTenantKey      = "TENANT_KEY"
 	EnvUseGRPC        = "USE_GRPC"
 )
 
@@ -43,6 +47,8 @@ type Builder struct {
 	LogLevel       string        `json:"log_level,omitempty"`
 	Secret         string        `json:"secret,omitempty"`
 	ServerAddress  string        `json:"server_address,omitempty"`
+	Tenant         string        `json:"tenant,omitempty"`
+	TenantKey      string        `json:"tenant_key,omitempty"`
 	RateLimit      int           `json:"rate_limit,omitempty"`
 	ReportInterval time.Duration `json:"report_interval,omitempty"`
 	PollInterval   time.Duration `json:"poll_interval,omitempty"`
@@ -55,6 +61,8 @@ func (b *Builder) LoadFromFlags() config.Builder {
 	flag.StringVar(&b.LogLevel, "ll", constants.LogLevelDefault, "server log level")
 	flag.StringVar(&b.ServerAddress, "a", HostDefault, "alert-host address")
 	flag.StringVar(&b.Secret, "k", constants.NoSecret, "secret key")
+	flag.StringVar(&b.Tenant, "tenant", model.DefaultTenant, "tenant the metrics belong to")
+	flag.StringVar(&b.TenantKey, "tenant-key", constants.NoSecret, "secret key of the tenant")
 
 	flag.IntVar(&b.RateLimit, "l", RateLimitDefault, "outgoing requests count")
 
@@ -107,6 +115,12 @@ func (b *Builder) LoadFromEnv() config.Builder {
 	if secret, found := os.LookupEnv(EnvSecretKey); found {
 		b.Secret = secret
 	}
+	if tenant, found := os.LookupEnv(EnvTenant); found {
+		b.Tenant = tenant
+	}
+	if key, found := os.LookupEnv(EnvTenantKey); found {
+		b.TenantKey = key
+	}
 	if _, found := os.LookupEnv(EnvUseGRPC); found {
 		b.UseGRPC = true
 	}
@@ -131,6 +145,12 @@ func (b *Builder) IsValid() (config.Builder, error) {
 	if b.PollInterval < 0 {
 		return nil, errors.New("poll interval must be positive")
 	}
+	if err := model.CheckTenant(b.Tenant); err != nil {
+		return nil, fmt.Errorf("invalid tenant: %w", err)
+	}
+	if b.Tenant != model.DefaultTenant && b.TenantKey == constants.NoSecret {
+		return nil, errors.New("tenant requires a tenant key")
+	}
 	return b, nil
 }
 
@@ -16,6 +16,8 @@ func TestBuilder_LoadFromEnv(t *testing.T) {
 	_ = os.Setenv(EnvPollInterval, "5")
 	_ = os.Setenv(EnvRateLimit, "10")
 	_ = os.Setenv(EnvReportInterval, "15")
+	_ = os.Setenv(EnvTenant, "team-a")
+	_ = os.Setenv(EnvTenantKey, "team-a-key")
 
 	defer func() {
 		_ = os.Unsetenv(EnvCryptoKeyPath)
@@ -24,6 +26,8 @@ func TestBuilder_LoadFromEnv(t *testing.T) {
 		_ = os.Unsetenv(EnvPollInterval)
 		_ = os.Unsetenv(EnvRateLimit)
 		_ = os.Unsetenv(EnvReportInterval)
+		_ = os.Unsetenv(EnvTenant)
+		_ = os.Unsetenv(EnvTenantKey)
 	}()
 
 	b := &Builder{}
@@ -35,6 +39,8 @@ func TestBuilder_LoadFromEnv(t *testing.T) {
 	assert.Equal(t, 10, b.RateLimit)
 	assert.Equal(t, 5*time.Second, b.PollInterval)
 	assert.Equal(t, 15*time.Second, b.ReportInterval)
+	assert.Equal(t, "team-a", b.Tenant)
+	assert.Equal(t, "team-a-key", b.TenantKey)
 }
 
 func TestBuilder_IsValid_Positive(t *testing.T) {
@@ -62,6 +68,16 @@ func TestBuilder_IsValid_Negative(t *testing.T) {
 			builder: Builder{ReportInterval: 1, PollInterval: -1},
 			wantErr: "poll interval must be positive",
 		},
+		{
+			name:    "Invalid Tenant",
+			builder: Builder{ReportInterval: 1, PollInterval: 1, Tenant: "team/a"},
+			wantErr: "invalid tenant: incorrect request: invalid tenant <team/a>",
+		},
+		{
+			name:    "Tenant Without Key",
+			builder: Builder{ReportInterval: 1, PollInterval: 1, Tenant: "team-a"},
+			wantErr: "tenant requires a tenant key",
+		},
 	}
 
 	for _, tt := range tests {
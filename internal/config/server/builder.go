@@ -3,13 +3,17 @@ package server
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/talx-hub/malerter/internal/config"
 	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/pkg/s3"
 )
 
 const (
@@ -17,18 +21,70 @@ const (
 	RestoreDefault       = true
 	StoreIntervalDefault = 300
 	UseGRPCDefault       = false
+	OverflowDefault      = string(model.OverflowSaturate)
+
+	StoragePathDefault     = "data"
+	RetentionRawDefault    = 24 * time.Hour
+	RetentionMinuteDefault = 30 * 24 * time.Hour
+	RetentionHourDefault   = 365 * 24 * time.Hour
+	CompactIntervalDefault = time.Minute
+	CacheTTLDefault        = time.Minute
+	SnapshotDefault        = time.Hour
+)
+
+// Доступные хранилища метрик. Пустое значение сохраняет прежнее поведение:
+// Postgres, если задан DSN, иначе память.
+const (
+	StorageAuto     = ""
+	StorageMemory   = "memory"
+	StoragePostgres = "postgres"
+	StorageDisk     = "disk"
+)
+
+// Сжатие файлов резервной копии.
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
 )
 
 const (
 	EnvAddress         = "ADDRESS"
+	EnvAdminKey        = "ADMIN_KEY"
+	EnvBackupCompress  = "BACKUP_COMPRESSION"
+	EnvBackupDir       = "BACKUP_DIR"
+	EnvBackupKeyPath   = "BACKUP_KEY"
+	EnvBackupMaxAge    = "BACKUP_MAX_AGE"
+	EnvBackupMaxFiles  = "BACKUP_MAX_FILES"
+	EnvBackupAccessKey = "BACKUP_S3_ACCESS_KEY"
+	EnvBackupBucket    = "BACKUP_S3_BUCKET"
+	EnvBackupEndpoint  = "BACKUP_S3_ENDPOINT"
+	EnvBackupPrefix    = "BACKUP_S3_PREFIX"
+	EnvBackupRegion    = "BACKUP_S3_REGION"
+	EnvBackupSecretKey = "BACKUP_S3_SECRET_KEY"
+	EnvCacheSize       = "CACHE_SIZE"
+	EnvCacheTTL        = "CACHE_TTL"
+	EnvCompactInterval = "COMPACT_INTERVAL"
 	EnvConfig          = "CONFIG"
+	EnvCounterOverflow = "COUNTER_OVERFLOW"
 	EnvCryptoKeyPath   = "CRYPTO_KEY"
 	EnvDatabaseDSN     = "DATABASE_DSN"
+	EnvFailFast        = "DATABASE_FAIL_FAST"
+	EnvReplicaDSN      = "DATABASE_REPLICA_DSN"
+	EnvFailoverJournal = "FAILOVER_JOURNAL"
 	EnvFileStoragePath = "FILE_STORAGE_PATH"
 	EnvLogLevel        = "LOG_LEVEL"
 	EnvRestore         = "RESTORE"
+	EnvRestoreUntil    = "RESTORE_UNTIL"
+	EnvRetentionRaw    = "RETENTION_RAW"
+	EnvRetentionMinute = "RETENTION_1M"
+	EnvRetentionHour   = "RETENTION_1H"
 	EnvSecretKey       = "KEY"
+	EnvSnapshot        = "SNAPSHOT_INTERVAL"
+	EnvStorage         = "STORAGE"
+	EnvStoragePath     = "STORAGE_PATH"
 	EnvStoreInterval   = "STORE_INTERVAL"
+	EnvTenantKeys      = "TENANT_KEYS"
 	EnvTrustedSubnet   = "TRUSTED_SUBNET"
 	EnvUseGRPC         = "USE_GRPC"
 )
@@ -44,15 +100,43 @@ func NewDirector() *config.Director {
 }
 
 type Builder struct {
+	AdminKey        string        `json:"admin_key,omitempty"`
+	BackupAccessKey string        `json:"backup_s3_access_key,omitempty"`
+	BackupBucket    string        `json:"backup_s3_bucket,omitempty"`
+	BackupCompress  string        `json:"backup_compression,omitempty"`
+	BackupDir       string        `json:"backup_dir,omitempty"`
+	BackupEndpoint  string        `json:"backup_s3_endpoint,omitempty"`
+	BackupKeyPath   string        `json:"backup_key_path,omitempty"`
+	BackupPrefix    string        `json:"backup_s3_prefix,omitempty"`
+	BackupRegion    string        `json:"backup_s3_region,omitempty"`
+	BackupSecretKey string        `json:"backup_s3_secret_key,omitempty"`
 	Config          string        `json:"config,omitempty"`
+	CounterOverflow string        `json:"counter_overflow,omitempty"`
 	CryptoKeyPath   string        `json:"crypto_key_path,omitempty"`
 	DatabaseDSN     string        `json:"database_dsn,omitempty"`
+	FailoverJournal string        `json:"failover_journal,omitempty"`
 	FileStoragePath string        `json:"file_storage_path,omitempty"`
 	LogLevel        string        `json:"log_level,omitempty"`
+	ReplicaDSN      string        `json:"database_replica_dsn,omitempty"`
+	RestoreUntil    string        `json:"restore_until,omitempty"`
 	RootAddress     string        `json:"root_address,omitempty"`
 	Secret          string        `json:"secret,omitempty"`
+	Storage         string        `json:"storage,omitempty"`
+	StoragePath     string        `json:"storage_path,omitempty"`
+	TenantKeys      string        `json:"tenant_keys,omitempty"`
 	TrustedSubnet   string        `json:"trusted_subnet"`
+	BackupMaxFiles  int           `json:"backup_max_files,omitempty"`
+	CacheSize       int           `json:"cache_size,omitempty"`
 	StoreInterval   time.Duration `json:"store_interval,omitempty"`
+	BackupMaxAge    time.Duration `json:"backup_max_age,omitempty"`
+	SnapshotPeriod  time.Duration `json:"snapshot_interval,omitempty"`
+	// Сроки хранения исходных точек и агрегатов, 0 — бессрочно.
+	RetentionRaw    time.Duration `json:"retention_raw,omitempty"`
+	RetentionMinute time.Duration `json:"retention_1m,omitempty"`
+	RetentionHour   time.Duration `json:"retention_1h,omitempty"`
+	CompactInterval time.Duration `json:"compact_interval,omitempty"`
+	CacheTTL        time.Duration `json:"cache_ttl,omitempty"`
+	FailFast        bool          `json:"database_fail_fast,omitempty"`
 	Restore         bool          `json:"restore,omitempty"`
 	UseGRPC         bool          `json:"use_grpc,omitempty"`
 }
@@ -64,13 +148,62 @@ func (b *Builder) LoadFromFlags() config.Builder {
 	flag.StringVar(&b.LogLevel, "l", constants.LogLevelDefault, "server log level")
 	flag.StringVar(&b.FileStoragePath, "f", FileStorageDefault(), "backup file path")
 	flag.StringVar(&b.TrustedSubnet, "t", "", "trusted subnet for agent host")
+	flag.StringVar(&b.CounterOverflow, "counter-overflow", OverflowDefault,
+		"counter overflow policy: saturate or wrap")
 
 	var backupInterval int64
-	flag.Int64Var(&backupInterval, "i", StoreIntervalDefault, "interval in seconds of repository backup")
-	flag.BoolVar(&b.Restore, "r", RestoreDefault, "restore backup while start")
+	flag.Int64Var(&backupInterval, "i", StoreIntervalDefault,
+		"interval in seconds of repository backup, 0 persists every update synchronously")
+	flag.StringVar(&b.BackupDir, "backup-dir", constants.EmptyPath,
+		"directory of rotated backups, the backup file path is used if empty")
+	flag.StringVar(&b.BackupCompress, "backup-compression", CompressionNone,
+		"compression of backup files: gzip or zstd, none if empty")
+	flag.StringVar(&b.BackupKeyPath, "backup-key", constants.EmptyPath,
+		"absolute path to private crypto key for backup encryption, backups are not encrypted if empty")
+	flag.StringVar(&b.BackupBucket, "backup-s3-bucket", "",
+		"bucket of rotated backups in S3-compatible storage, backups are kept on disk if empty")
+	flag.StringVar(&b.BackupEndpoint, "backup-s3-endpoint", "", "S3-compatible storage endpoint URL")
+	flag.StringVar(&b.BackupPrefix, "backup-s3-prefix", "", "key prefix of backups in the bucket")
+	flag.StringVar(&b.BackupRegion, "backup-s3-region", s3.RegionDefault, "S3 region")
+	flag.StringVar(&b.BackupAccessKey, "backup-s3-access-key", "", "S3 access key")
+	flag.StringVar(&b.BackupSecretKey, "backup-s3-secret-key", "", "S3 secret key")
+	flag.IntVar(&b.BackupMaxFiles, "backup-max-files", 0,
+		"number of backups kept in the backup directory, 0 to keep all")
+	flag.DurationVar(&b.BackupMaxAge, "backup-max-age", 0,
+		"how long backups are kept in the backup directory, 0 to keep forever")
+	flag.DurationVar(&b.SnapshotPeriod, "snapshot-interval", SnapshotDefault,
+		"interval of full backup snapshots, 0 to snapshot only when the backup log grows large")
+	flag.DurationVar(&b.RetentionRaw, "retention-raw", RetentionRawDefault,
+		"how long raw samples are kept, 0 to keep forever")
+	flag.DurationVar(&b.RetentionMinute, "retention-1m", RetentionMinuteDefault,
+		"how long 1-minute rollups are kept, 0 to keep forever")
+	flag.DurationVar(&b.RetentionHour, "retention-1h", RetentionHourDefault,
+		"how long 1-hour rollups are kept, 0 to keep forever")
+	flag.DurationVar(&b.CompactInterval, "compact-interval", CompactIntervalDefault,
+		"interval of history compaction")
+	flag.IntVar(&b.CacheSize, "cache-size", 0,
+		"number of metrics kept in the read cache, 0 disables the cache")
+	flag.DurationVar(&b.CacheTTL, "cache-ttl", CacheTTLDefault,
+		"how long a metric is kept in the read cache")
+	flag.BoolVar(&b.Restore, "r", RestoreDefault, "restore backup while start, in-memory storage only")
+	flag.StringVar(&b.RestoreUntil, "restore-until", "",
+		"restore backup as of the RFC3339 time while start, remove after the rollback")
 	flag.BoolVar(&b.UseGRPC, "grpc", UseGRPCDefault, "use grpc protocol instead of http")
 	flag.StringVar(&b.DatabaseDSN, "d", "", "database source name")
+	flag.StringVar(&b.ReplicaDSN, "replica-dsn", "",
+		"comma-separated source names of read replicas, reads go to the primary if empty")
+	flag.StringVar(&b.FailoverJournal, "failover-journal", constants.EmptyPath,
+		"directory of the journal for writes during database outages, memory if empty")
+	flag.StringVar(&b.Storage, "storage", StorageAuto,
+		"metrics storage: memory, postgres or disk; by default postgres if DSN is set, memory otherwise")
+	flag.BoolVar(&b.FailFast, "db-fail-fast", false,
+		"exit if the database is unreachable at startup instead of journaling writes until it recovers")
+	flag.StringVar(&b.StoragePath, "storage-path", StoragePathDefault, "directory of the disk storage")
 	flag.StringVar(&b.Secret, "k", constants.NoSecret, "secret key")
+	flag.StringVar(&b.AdminKey, "admin-key", constants.NoSecret,
+		"secret key of admin requests, the admin API is disabled if empty")
+	flag.StringVar(&b.TenantKeys, "tenant-keys", "",
+		"comma-separated tenant=key pairs, requests of other tenants are rejected")
 	flag.Parse()
 
 	b.StoreInterval = time.Duration(backupInterval) * time.Second
@@ -81,6 +214,9 @@ func (b *Builder) LoadFromEnv() config.Builder {
 	if cfg, found := os.LookupEnv(EnvConfig); found {
 		b.Config = cfg
 	}
+	if overflow, found := os.LookupEnv(EnvCounterOverflow); found {
+		b.CounterOverflow = overflow
+	}
 	if cryptoKeyPath, found := os.LookupEnv(EnvCryptoKeyPath); found {
 		b.CryptoKeyPath = cryptoKeyPath
 	}
@@ -100,6 +236,42 @@ func (b *Builder) LoadFromEnv() config.Builder {
 		}
 		b.StoreInterval = time.Duration(backupInterval) * time.Second
 	}
+	lookupDuration(EnvSnapshot, &b.SnapshotPeriod)
+	if dir, found := os.LookupEnv(EnvBackupDir); found {
+		b.BackupDir = dir
+	}
+	if c, found := os.LookupEnv(EnvBackupCompress); found {
+		b.BackupCompress = c
+	}
+	if k, found := os.LookupEnv(EnvBackupKeyPath); found {
+		b.BackupKeyPath = k
+	}
+	lookupString(EnvBackupBucket, &b.BackupBucket)
+	lookupString(EnvBackupEndpoint, &b.BackupEndpoint)
+	lookupString(EnvBackupPrefix, &b.BackupPrefix)
+	lookupString(EnvBackupRegion, &b.BackupRegion)
+	lookupString(EnvBackupAccessKey, &b.BackupAccessKey)
+	lookupString(EnvBackupSecretKey, &b.BackupSecretKey)
+	if n, found := os.LookupEnv(EnvBackupMaxFiles); found {
+		var err error
+		b.BackupMaxFiles, err = strconv.Atoi(n)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	lookupDuration(EnvBackupMaxAge, &b.BackupMaxAge)
+	lookupDuration(EnvRetentionRaw, &b.RetentionRaw)
+	lookupDuration(EnvRetentionMinute, &b.RetentionMinute)
+	lookupDuration(EnvRetentionHour, &b.RetentionHour)
+	lookupDuration(EnvCompactInterval, &b.CompactInterval)
+	if size, found := os.LookupEnv(EnvCacheSize); found {
+		var err error
+		b.CacheSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	lookupDuration(EnvCacheTTL, &b.CacheTTL)
 	if r, found := os.LookupEnv(EnvRestore); found {
 		var err error
 		b.Restore, err = strconv.ParseBool(r)
@@ -107,12 +279,34 @@ func (b *Builder) LoadFromEnv() config.Builder {
 			log.Fatal(err)
 		}
 	}
+	lookupString(EnvRestoreUntil, &b.RestoreUntil)
 	if d, found := os.LookupEnv(EnvDatabaseDSN); found {
 		b.DatabaseDSN = d
 	}
+	if d, found := os.LookupEnv(EnvReplicaDSN); found {
+		b.ReplicaDSN = d
+	}
+	if f, found := os.LookupEnv(EnvFailFast); found {
+		var err error
+		b.FailFast, err = strconv.ParseBool(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if j, found := os.LookupEnv(EnvFailoverJournal); found {
+		b.FailoverJournal = j
+	}
+	if s, found := os.LookupEnv(EnvStorage); found {
+		b.Storage = s
+	}
+	if p, found := os.LookupEnv(EnvStoragePath); found {
+		b.StoragePath = p
+	}
 	if k, found := os.LookupEnv(EnvSecretKey); found {
 		b.Secret = k
 	}
+	lookupString(EnvAdminKey, &b.AdminKey)
+	lookupString(EnvTenantKeys, &b.TenantKeys)
 	if subnet, found := os.LookupEnv(EnvTrustedSubnet); found {
 		b.TrustedSubnet = subnet
 	}
@@ -122,6 +316,22 @@ func (b *Builder) LoadFromEnv() config.Builder {
 	return b
 }
 
+func lookupString(env string, dst *string) {
+	if v, found := os.LookupEnv(env); found {
+		*dst = v
+	}
+}
+
+func lookupDuration(env string, dst *time.Duration) {
+	if v, found := os.LookupEnv(env); found {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*dst = d
+	}
+}
+
 func (b *Builder) LoadFromFile() config.Builder {
 	newConfig := *b
 	err := config.ReadFromFile(b.Config, &newConfig)
@@ -134,12 +344,140 @@ func (b *Builder) LoadFromFile() config.Builder {
 }
 
 func (b *Builder) IsValid() (config.Builder, error) {
+	if b.AdminKey != constants.NoSecret && b.AdminKey == b.Secret {
+		return nil, errors.New("admin key must differ from the agent key")
+	}
+	if err := b.checkTenantKeys(); err != nil {
+		return nil, err
+	}
 	if b.StoreInterval < 0 {
 		return nil, errors.New("store interval must be positive")
 	}
+	if b.SnapshotPeriod < 0 {
+		return nil, errors.New("snapshot interval must not be negative")
+	}
+	if b.BackupMaxFiles < 0 || b.BackupMaxAge < 0 {
+		return nil, errors.New("backup retention must not be negative")
+	}
+	if (b.BackupMaxFiles != 0 || b.BackupMaxAge != 0) && b.BackupDir == "" && b.BackupBucket == "" {
+		return nil, errors.New("backup retention requires backup directory or bucket")
+	}
+	if b.BackupBucket != "" {
+		if b.BackupDir != "" {
+			return nil, errors.New("backup directory and bucket are mutually exclusive")
+		}
+		if b.BackupEndpoint == "" {
+			return nil, errors.New("backup bucket requires S3 endpoint")
+		}
+		if (b.BackupAccessKey == "") != (b.BackupSecretKey == "") {
+			return nil, errors.New("S3 access key and secret key must be set together")
+		}
+	}
+	switch b.BackupCompress {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return nil, errors.New("backup compression must be gzip or zstd")
+	}
+	if b.RestoreUntil != "" {
+		if _, err := time.Parse(time.RFC3339, b.RestoreUntil); err != nil {
+			return nil, errors.New("restore point must be an RFC3339 time")
+		}
+	}
+	if b.RetentionRaw < 0 || b.RetentionMinute < 0 || b.RetentionHour < 0 {
+		return nil, errors.New("retention must not be negative")
+	}
+	if b.CompactInterval < 0 {
+		return nil, errors.New("compact interval must be positive")
+	}
+	if b.CacheSize < 0 {
+		return nil, errors.New("cache size must not be negative")
+	}
+	if b.CacheSize > 0 && b.CacheTTL <= 0 {
+		return nil, errors.New("cache TTL must be positive")
+	}
+	switch b.Storage {
+	case StorageAuto, StorageMemory, StorageDisk:
+	case StoragePostgres:
+		if b.DatabaseDSN == "" {
+			return nil, errors.New("postgres storage requires database DSN")
+		}
+	default:
+		return nil, errors.New("storage must be memory, postgres or disk")
+	}
+	if b.ReplicaDSN != "" && b.DatabaseDSN == "" {
+		return nil, errors.New("read replicas require database DSN")
+	}
+	if b.Storage == StorageDisk && b.StoragePath == "" {
+		return nil, errors.New("disk storage requires storage path")
+	}
+	if b.CounterOverflow == "" {
+		b.CounterOverflow = OverflowDefault
+	}
+	if !model.OverflowPolicy(b.CounterOverflow).IsValid() {
+		return nil, errors.New("counter overflow policy must be saturate or wrap")
+	}
 	return b, nil
 }
 
+// Retention возвращает политику хранения истории:
+// исходные точки, агрегаты по минутам и по часам.
+func (b *Builder) Retention() model.Retention {
+	return model.NewRetention(b.RetentionRaw,
+		model.RollupTier{Resolution: time.Minute, Retention: b.RetentionMinute},
+		model.RollupTier{Resolution: time.Hour, Retention: b.RetentionHour},
+	)
+}
+
+// Tenants возвращает ключи подписи запросов арендаторов.
+func (b *Builder) Tenants() map[string]string {
+	keys := make(map[string]string)
+	for _, pair := range strings.Split(b.TenantKeys, ",") {
+		if tenant, key, found := strings.Cut(strings.TrimSpace(pair), "="); found {
+			keys[tenant] = key
+		}
+	}
+	return keys
+}
+
+// checkTenantKeys проверяет пары арендатор=ключ: ключ арендатора
+// не должен совпадать с ключом агентов, администратора и других арендаторов.
+func (b *Builder) checkTenantKeys() error {
+	if strings.TrimSpace(b.TenantKeys) == "" {
+		return nil
+	}
+	used := map[string]bool{b.Secret: true, b.AdminKey: true}
+	tenants := make(map[string]bool)
+	for _, pair := range strings.Split(b.TenantKeys, ",") {
+		tenant, key, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || tenant == model.DefaultTenant {
+			return errors.New("tenant keys must be tenant=key pairs")
+		}
+		if err := model.CheckTenant(tenant); err != nil {
+			return fmt.Errorf("invalid tenant key: %w", err)
+		}
+		if tenants[tenant] {
+			return errors.New("tenant <" + tenant + "> has several keys")
+		}
+		if used[key] {
+			return errors.New("tenant <" + tenant + "> key must be unique and not empty")
+		}
+		tenants[tenant] = true
+		used[key] = true
+	}
+	return nil
+}
+
+// ReplicaDSNs возвращает строки подключения к репликам для чтения.
+func (b *Builder) ReplicaDSNs() []string {
+	dsns := make([]string, 0)
+	for _, dsn := range strings.Split(b.ReplicaDSN, ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			dsns = append(dsns, dsn)
+		}
+	}
+	return dsns
+}
+
 func (b *Builder) Build() config.Config {
 	return *b
 }
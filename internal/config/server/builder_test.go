@@ -16,9 +16,31 @@ func TestBuilder_LoadFromEnv(t *testing.T) {
 	_ = os.Setenv(EnvFileStoragePath, "data/backup.db")
 	_ = os.Setenv(EnvStoreInterval, "60")
 	_ = os.Setenv(EnvRestore, "true")
+	_ = os.Setenv(EnvRestoreUntil, "2025-03-01T12:00:00Z")
 	_ = os.Setenv(EnvDatabaseDSN, "user:pass@tcp(localhost:3306)/dbname")
+	_ = os.Setenv(EnvReplicaDSN, "postgres://replica-1/db,postgres://replica-2/db")
 	_ = os.Setenv(EnvSecretKey, "my-secret")
+	_ = os.Setenv(EnvAdminKey, "my-admin-secret")
+	_ = os.Setenv(EnvTenantKeys, "team-a=team-a-secret")
 	_ = os.Setenv(EnvTrustedSubnet, "127.0.0.0/24")
+	_ = os.Setenv(EnvCounterOverflow, "wrap")
+	_ = os.Setenv(EnvStorage, StorageDisk)
+	_ = os.Setenv(EnvStoragePath, "/var/lib/malerter")
+	_ = os.Setenv(EnvRetentionRaw, "12h")
+	_ = os.Setenv(EnvRetentionMinute, "168h")
+	_ = os.Setenv(EnvRetentionHour, "0s")
+	_ = os.Setenv(EnvCompactInterval, "30s")
+	_ = os.Setenv(EnvCacheSize, "1000")
+	_ = os.Setenv(EnvSnapshot, "15m")
+	_ = os.Setenv(EnvBackupCompress, CompressionZstd)
+	_ = os.Setenv(EnvBackupDir, "/var/backups/malerter")
+	_ = os.Setenv(EnvBackupKeyPath, "/etc/malerter/backup.key")
+	_ = os.Setenv(EnvBackupBucket, "backups")
+	_ = os.Setenv(EnvBackupEndpoint, "http://minio:9000")
+	_ = os.Setenv(EnvBackupPrefix, "malerter")
+	_ = os.Setenv(EnvBackupMaxFiles, "7")
+	_ = os.Setenv(EnvBackupMaxAge, "168h")
+	_ = os.Setenv(EnvCacheTTL, "10s")
 
 	defer func() {
 		_ = os.Unsetenv(EnvCryptoKeyPath)
@@ -27,9 +49,31 @@ func TestBuilder_LoadFromEnv(t *testing.T) {
 		_ = os.Unsetenv(EnvFileStoragePath)
 		_ = os.Unsetenv(EnvStoreInterval)
 		_ = os.Unsetenv(EnvRestore)
+		_ = os.Unsetenv(EnvRestoreUntil)
 		_ = os.Unsetenv(EnvDatabaseDSN)
+		_ = os.Unsetenv(EnvReplicaDSN)
 		_ = os.Unsetenv(EnvSecretKey)
+		_ = os.Unsetenv(EnvAdminKey)
+		_ = os.Unsetenv(EnvTenantKeys)
 		_ = os.Unsetenv(EnvTrustedSubnet)
+		_ = os.Unsetenv(EnvCounterOverflow)
+		_ = os.Unsetenv(EnvStorage)
+		_ = os.Unsetenv(EnvStoragePath)
+		_ = os.Unsetenv(EnvRetentionRaw)
+		_ = os.Unsetenv(EnvRetentionMinute)
+		_ = os.Unsetenv(EnvRetentionHour)
+		_ = os.Unsetenv(EnvCompactInterval)
+		_ = os.Unsetenv(EnvCacheSize)
+		_ = os.Unsetenv(EnvSnapshot)
+		_ = os.Unsetenv(EnvBackupCompress)
+		_ = os.Unsetenv(EnvBackupDir)
+		_ = os.Unsetenv(EnvBackupKeyPath)
+		_ = os.Unsetenv(EnvBackupBucket)
+		_ = os.Unsetenv(EnvBackupEndpoint)
+		_ = os.Unsetenv(EnvBackupPrefix)
+		_ = os.Unsetenv(EnvBackupMaxFiles)
+		_ = os.Unsetenv(EnvBackupMaxAge)
+		_ = os.Unsetenv(EnvCacheTTL)
 	}()
 
 	b := &Builder{}
@@ -41,9 +85,31 @@ func TestBuilder_LoadFromEnv(t *testing.T) {
 	assert.Equal(t, "data/backup.db", b.FileStoragePath)
 	assert.Equal(t, 60*time.Second, b.StoreInterval)
 	assert.True(t, b.Restore)
+	assert.Equal(t, "2025-03-01T12:00:00Z", b.RestoreUntil)
 	assert.Equal(t, "user:pass@tcp(localhost:3306)/dbname", b.DatabaseDSN)
+	assert.Equal(t, "postgres://replica-1/db,postgres://replica-2/db", b.ReplicaDSN)
 	assert.Equal(t, "my-secret", b.Secret)
+	assert.Equal(t, "my-admin-secret", b.AdminKey)
+	assert.Equal(t, "team-a=team-a-secret", b.TenantKeys)
 	assert.Equal(t, "127.0.0.0/24", b.TrustedSubnet)
+	assert.Equal(t, "wrap", b.CounterOverflow)
+	assert.Equal(t, StorageDisk, b.Storage)
+	assert.Equal(t, "/var/lib/malerter", b.StoragePath)
+	assert.Equal(t, 12*time.Hour, b.RetentionRaw)
+	assert.Equal(t, 168*time.Hour, b.RetentionMinute)
+	assert.Equal(t, time.Duration(0), b.RetentionHour)
+	assert.Equal(t, 30*time.Second, b.CompactInterval)
+	assert.Equal(t, 1000, b.CacheSize)
+	assert.Equal(t, 15*time.Minute, b.SnapshotPeriod)
+	assert.Equal(t, CompressionZstd, b.BackupCompress)
+	assert.Equal(t, "/var/backups/malerter", b.BackupDir)
+	assert.Equal(t, "/etc/malerter/backup.key", b.BackupKeyPath)
+	assert.Equal(t, "backups", b.BackupBucket)
+	assert.Equal(t, "http://minio:9000", b.BackupEndpoint)
+	assert.Equal(t, "malerter", b.BackupPrefix)
+	assert.Equal(t, 7, b.BackupMaxFiles)
+	assert.Equal(t, 168*time.Hour, b.BackupMaxAge)
+	assert.Equal(t, 10*time.Second, b.CacheTTL)
 }
 
 func TestBuilder_IsValid_Positive(t *testing.T) {
@@ -63,6 +129,150 @@ func TestBuilder_IsValid_Negative(t *testing.T) {
 	assert.EqualError(t, err, "store interval must be positive")
 }
 
+func TestBuilder_IsValid_AdminKey(t *testing.T) {
+	_, err := (&Builder{Secret: "key", AdminKey: "key"}).IsValid()
+	assert.EqualError(t, err, "admin key must differ from the agent key")
+	_, err = (&Builder{Secret: "key", AdminKey: "admin-key"}).IsValid()
+	assert.NoError(t, err)
+}
+
+func TestBuilder_IsValid_TenantKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		wantErr string
+	}{
+		{name: "valid", keys: "team-a=key-a, team-b=key-b"},
+		{name: "no key", keys: "team-a", wantErr: "tenant keys must be tenant=key pairs"},
+		{name: "empty key", keys: "team-a=", wantErr: "tenant <team-a> key must be unique and not empty"},
+		{name: "agent key", keys: "team-a=key", wantErr: "tenant <team-a> key must be unique and not empty"},
+		{name: "shared key", keys: "team-a=k,team-b=k", wantErr: "tenant <team-b> key must be unique and not empty"},
+		{name: "twice", keys: "team-a=k1,team-a=k2", wantErr: "tenant <team-a> has several keys"},
+		{
+			name:    "invalid tenant",
+			keys:    "team/a=k",
+			wantErr: "invalid tenant key: incorrect request: invalid tenant <team/a>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&Builder{Secret: "key", TenantKeys: tt.keys}).IsValid()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestBuilder_IsValid_Retention(t *testing.T) {
+	b := &Builder{RetentionRaw: -time.Hour}
+	_, err := b.IsValid()
+	assert.EqualError(t, err, "retention must not be negative")
+}
+
+func TestBuilder_IsValid_Backup(t *testing.T) {
+	_, err := (&Builder{SnapshotPeriod: -time.Minute}).IsValid()
+	assert.EqualError(t, err, "snapshot interval must not be negative")
+	_, err = (&Builder{BackupMaxFiles: -1}).IsValid()
+	assert.EqualError(t, err, "backup retention must not be negative")
+	_, err = (&Builder{BackupMaxAge: time.Hour}).IsValid()
+	assert.EqualError(t, err, "backup retention requires backup directory or bucket")
+	_, err = (&Builder{BackupDir: "backups", BackupMaxFiles: 3}).IsValid()
+	assert.NoError(t, err)
+	_, err = (&Builder{BackupBucket: "backups", BackupMaxAge: time.Hour}).IsValid()
+	assert.EqualError(t, err, "backup bucket requires S3 endpoint")
+	_, err = (&Builder{BackupBucket: "backups", BackupDir: "backups"}).IsValid()
+	assert.EqualError(t, err, "backup directory and bucket are mutually exclusive")
+	_, err = (&Builder{BackupBucket: "backups", BackupEndpoint: "http://minio:9000", BackupAccessKey: "a"}).IsValid()
+	assert.EqualError(t, err, "S3 access key and secret key must be set together")
+	_, err = (&Builder{BackupCompress: "lz4"}).IsValid()
+	assert.Error(t, err)
+	_, err = (&Builder{BackupCompress: CompressionGzip}).IsValid()
+	assert.NoError(t, err)
+	_, err = (&Builder{RestoreUntil: "2025-03-01 12:00"}).IsValid()
+	assert.EqualError(t, err, "restore point must be an RFC3339 time")
+	_, err = (&Builder{RestoreUntil: "2025-03-01T12:00:00+03:00"}).IsValid()
+	assert.NoError(t, err)
+}
+
+func TestBuilder_IsValid_Cache(t *testing.T) {
+	_, err := (&Builder{CacheSize: -1}).IsValid()
+	assert.EqualError(t, err, "cache size must not be negative")
+	_, err = (&Builder{CacheSize: 100}).IsValid()
+	assert.EqualError(t, err, "cache TTL must be positive")
+	_, err = (&Builder{CacheSize: 100, CacheTTL: time.Minute}).IsValid()
+	assert.NoError(t, err)
+}
+
+func TestBuilder_IsValid_Storage(t *testing.T) {
+	tests := []struct {
+		name    string
+		b       Builder
+		wantErr string
+	}{
+		{"auto", Builder{}, ""},
+		{"disk", Builder{Storage: StorageDisk, StoragePath: "data"}, ""},
+		{"disk without path", Builder{Storage: StorageDisk},
+			"disk storage requires storage path"},
+		{"postgres without dsn", Builder{Storage: StoragePostgres},
+			"postgres storage requires database DSN"},
+		{"unknown", Builder{Storage: "bolt"}, "storage must be memory, postgres or disk"},
+		{"replicas without dsn", Builder{ReplicaDSN: "postgres://replica/db"},
+			"read replicas require database DSN"},
+		{"replicas", Builder{DatabaseDSN: "postgres://primary/db", ReplicaDSN: "postgres://replica/db"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.b.IsValid()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestBuilder_Retention(t *testing.T) {
+	b := &Builder{
+		RetentionRaw:    time.Hour,
+		RetentionMinute: 24 * time.Hour,
+		RetentionHour:   0,
+	}
+	r := b.Retention()
+	assert.Equal(t, time.Hour, r.Raw)
+	require.Len(t, r.Tiers, 2)
+	assert.Equal(t, time.Minute, r.Tiers[0].Resolution)
+	assert.Equal(t, 24*time.Hour, r.Tiers[0].Retention)
+	assert.Equal(t, time.Hour, r.Tiers[1].Resolution)
+	assert.Equal(t, time.Duration(0), r.Tiers[1].Retention)
+}
+
+func TestBuilder_ReplicaDSNs(t *testing.T) {
+	assert.Empty(t, (&Builder{}).ReplicaDSNs())
+	b := &Builder{ReplicaDSN: " postgres://replica-1/db, ,postgres://replica-2/db"}
+	assert.Equal(t, []string{"postgres://replica-1/db", "postgres://replica-2/db"}, b.ReplicaDSNs())
+}
+
+func TestBuilder_Tenants(t *testing.T) {
+	assert.Empty(t, (&Builder{}).Tenants())
+	b := &Builder{TenantKeys: "team-a=key-a, team-b=key=b"}
+	assert.Equal(t, map[string]string{"team-a": "key-a", "team-b": "key=b"}, b.Tenants())
+}
+
+func TestBuilder_IsValid_CounterOverflow(t *testing.T) {
+	b := &Builder{}
+	_, err := b.IsValid()
+	require.NoError(t, err)
+	assert.Equal(t, OverflowDefault, b.CounterOverflow)
+
+	b.CounterOverflow = "clamp"
+	_, err = b.IsValid()
+	assert.EqualError(t, err, "counter overflow policy must be saturate or wrap")
+}
+
 func TestBuilder_Build(t *testing.T) {
 	b := &Builder{
 		CryptoKeyPath:   "/keys/private.pem",
@@ -7,12 +7,25 @@ const (
 	KeyContentEncoding = "Content-Encoding"
 	KeyAcceptEncoding  = "Accept-Encoding"
 	KeyHashSHA256      = "HashSHA256"
+	KeyLastModified    = "Last-Modified"
+	KeyTenant          = "X-Tenant"
+	KeyCacheControl    = "Cache-Control"
+	KeyIfMatch         = "If-Match"
+	KeyETag            = "ETag"
+	KeyDisposition     = "Content-Disposition"
+	KeyAdminSignature  = "X-Admin-Signature"
+	KeyAdminTimestamp  = "X-Admin-Timestamp"
+	KeyAdminNonce      = "X-Admin-Nonce"
+	KeyTenantSignature = "X-Tenant-Signature"
+	KeyTenantTimestamp = "X-Tenant-Timestamp"
+	KeyTenantNonce     = "X-Tenant-Nonce"
 )
 
 const (
 	ContentTypeJSON = "application/json"
 	ContentTypeHTML = "text/html"
 	ContentTypeText = "text/plain"
+	ContentTypeTar  = "application/x-tar"
 )
 
 const EncodingGzip = "gzip"
@@ -26,5 +39,16 @@ const TimeoutShutdown = 2 * time.Second
 const TimeoutAgentRequest = 3 * time.Second
 const TimeoutStorage = 1 * time.Second
 
+// AdminSignatureWindow — насколько подпись запроса администратора
+// может разойтись с часами сервера.
+const AdminSignatureWindow = 5 * time.Minute
+
+// TenantSignatureWindow — то же для подписи запроса арендатора.
+const TenantSignatureWindow = 5 * time.Minute
+
+// ReadYourWritesWindow — сколько после записи чтения клиента идут
+// на основную базу: за это время реплики успевают догнать её.
+const ReadYourWritesWindow = 5 * time.Second
+
 const NoSecret = ""
 const EmptyPath = ""
@@ -15,3 +15,19 @@ type InvalidArgumentError struct {
 func (e *InvalidArgumentError) Error() string {
 	return "incorrect request: " + e.Info
 }
+
+type UnavailableError struct {
+	Info string
+}
+
+func (e *UnavailableError) Error() string {
+	return "unavailable: " + e.Info
+}
+
+type ConflictError struct {
+	Info string
+}
+
+func (e *ConflictError) Error() string {
+	return "conflict: " + e.Info
+}
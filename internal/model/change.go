@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+// ChangeKind — вид изменения метрики в хранилище.
+type ChangeKind string
+
+const (
+	// ChangeUpdate — запись метрики, которая сливается с сохранённой.
+	ChangeUpdate ChangeKind = ""
+	// ChangeDelete — удаление метрики.
+	ChangeDelete ChangeKind = "delete"
+	// ChangeReset — обнуление счётчика, см. Metric.Reset.
+	ChangeReset ChangeKind = "reset"
+)
+
+// Change — изменение метрики, которое хранилище передаёт
+// резервному копированию. Для записи Metric — записанная метрика,
+// для удаления и обнуления Target — ключ метрики, а в Metric заданы
+// только ревизия и, для обнуления, его момент.
+// Запись сериализуется так же, как Metric.
+type Change struct {
+	Target *MetricKey `json:"target,omitempty"`
+	Kind   ChangeKind `json:"op,omitempty"`
+	Metric
+}
+
+// NewDeletion возвращает удаление метрики key, получившее ревизию revision.
+func NewDeletion(key MetricKey, revision int64) Change {
+	return Change{Kind: ChangeDelete, Target: &key, Metric: Metric{Revision: revision}}
+}
+
+// NewReset возвращает обнуление счётчика key в момент at,
+// получившее ревизию revision.
+func NewReset(key MetricKey, revision int64, at time.Time) Change {
+	ts := at.UTC()
+	return Change{
+		Kind:   ChangeReset,
+		Target: &key,
+		Metric: Metric{Revision: revision, Timestamp: &ts},
+	}
+}
+
+// Key возвращает ключ изменённой метрики.
+func (c *Change) Key() MetricKey {
+	if c.Target != nil {
+		return *c.Target
+	}
+	return c.Metric.Key()
+}
+
+func (c *Change) CheckValid() error {
+	switch c.Kind {
+	case ChangeUpdate:
+		if c.IsEmpty() {
+			return &customerror.InvalidArgumentError{Info: "metric has no value"}
+		}
+		return c.Metric.CheckValid()
+	case ChangeDelete, ChangeReset:
+		if c.Target == nil {
+			return &customerror.InvalidArgumentError{Info: "change has no target metric"}
+		}
+		if c.Kind == ChangeReset && (c.Target.Type != MetricTypeCounter || c.Timestamp == nil) {
+			return &customerror.InvalidArgumentError{Info: "reset must target a counter at a moment"}
+		}
+		return nil
+	default:
+		return &customerror.InvalidArgumentError{Info: "unknown change <" + string(c.Kind) + ">"}
+	}
+}
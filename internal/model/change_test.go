@@ -0,0 +1,39 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChange_JSON(t *testing.T) {
+	// записи журнала прежнего формата — записи метрик
+	var c Change
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"hits","type":"counter","delta":1,"revision":2}`), &c))
+	assert.Equal(t, ChangeUpdate, c.Kind)
+	assert.Equal(t, NewMetricKey(MetricTypeCounter, "hits", nil), c.Key())
+	assert.NoError(t, c.CheckValid())
+
+	key := NewMetricKey(MetricTypeCounter, "hits", Labels{"cpu": "1"})
+	data, err := json.Marshal(NewReset(key, 3, time.Unix(10, 0)))
+	require.NoError(t, err)
+	var reset Change
+	require.NoError(t, json.Unmarshal(data, &reset))
+	assert.Equal(t, ChangeReset, reset.Kind)
+	assert.Equal(t, key, reset.Key())
+	assert.Equal(t, int64(3), reset.Revision)
+	assert.NoError(t, reset.CheckValid())
+}
+
+func TestChange_CheckValid(t *testing.T) {
+	gauge := NewMetricKey(MetricTypeGauge, "cpu", nil)
+	deletion, reset := NewDeletion(gauge, 1), NewReset(gauge, 1, time.Now())
+	assert.NoError(t, deletion.CheckValid())
+	assert.Error(t, reset.CheckValid())
+	assert.Error(t, (&Change{Kind: ChangeDelete}).CheckValid())
+	assert.Error(t, (&Change{Metric: Metric{Name: "cpu", Type: MetricTypeGauge}}).CheckValid())
+	assert.Error(t, (&Change{Kind: "merge", Target: &gauge}).CheckValid())
+}
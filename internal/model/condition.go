@@ -0,0 +1,60 @@
+package model
+
+import (
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+// Condition — условие условной записи метрики.
+// Заданные поля должны совпасть с текущим состоянием метрики.
+type Condition struct {
+	// Revision — ожидаемая ревизия, 0 — метрика ещё не должна существовать.
+	Revision *int64
+	// Value — ожидаемое значение датчика.
+	Value *float64
+	// Delta — ожидаемое значение счётчика.
+	Delta *int64
+	// Exists — метрика должна существовать.
+	Exists bool
+}
+
+func (c Condition) CheckValid() error {
+	if c.Revision == nil && c.Value == nil && c.Delta == nil && !c.Exists {
+		return &customerror.InvalidArgumentError{
+			Info: "condition must set revision, value or existence",
+		}
+	}
+	if c.Revision != nil && *c.Revision < 0 {
+		return &customerror.InvalidArgumentError{
+			Info: "condition revision must be non-negative",
+		}
+	}
+	if c.Absent() && (c.Value != nil || c.Delta != nil || c.Exists) {
+		return &customerror.InvalidArgumentError{
+			Info: "condition on value requires an existing metric",
+		}
+	}
+	return nil
+}
+
+// Absent сообщает, что условие требует отсутствия метрики.
+func (c Condition) Absent() bool {
+	return c.Revision != nil && *c.Revision == 0
+}
+
+// Match проверяет условие для текущего состояния метрики;
+// found = false, если метрики нет.
+func (c Condition) Match(current Metric, found bool) bool {
+	if !found || c.Absent() {
+		return !found && c.Absent()
+	}
+	if c.Revision != nil && *c.Revision != current.Revision {
+		return false
+	}
+	if c.Value != nil && (current.Value == nil || *current.Value != *c.Value) {
+		return false
+	}
+	if c.Delta != nil && (current.Delta == nil || *current.Delta != *c.Delta) {
+		return false
+	}
+	return true
+}
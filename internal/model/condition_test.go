@@ -0,0 +1,53 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCondition_CheckValid(t *testing.T) {
+	zero, one, negative := int64(0), int64(1), int64(-1)
+	value := 1.5
+	assert.Error(t, Condition{}.CheckValid())
+	assert.Error(t, Condition{Revision: &negative}.CheckValid())
+	assert.Error(t, Condition{Revision: &zero, Value: &value}.CheckValid())
+	assert.NoError(t, Condition{Revision: &zero}.CheckValid())
+	assert.NoError(t, Condition{Revision: &one, Value: &value}.CheckValid())
+	assert.NoError(t, Condition{Delta: &one}.CheckValid())
+	assert.NoError(t, Condition{Exists: true}.CheckValid())
+	assert.Error(t, Condition{Revision: &zero, Exists: true}.CheckValid())
+}
+
+func TestCondition_Match(t *testing.T) {
+	zero, two, delta := int64(0), int64(2), int64(5)
+	value, other := 1.5, 2.5
+	gauge := Metric{Type: MetricTypeGauge, Name: "g", Value: &value, Revision: 2}
+	counter := Metric{Type: MetricTypeCounter, Name: "c", Delta: &delta, Revision: 1}
+
+	tests := []struct {
+		name    string
+		cond    Condition
+		current Metric
+		found   bool
+		want    bool
+	}{
+		{"absent, no metric", Condition{Revision: &zero}, Metric{}, false, true},
+		{"absent, metric exists", Condition{Revision: &zero}, gauge, true, false},
+		{"revision, no metric", Condition{Revision: &two}, Metric{}, false, false},
+		{"revision matches", Condition{Revision: &two}, gauge, true, true},
+		{"revision differs", Condition{Revision: &two}, counter, true, false},
+		{"value matches", Condition{Value: &value}, gauge, true, true},
+		{"value differs", Condition{Value: &other}, gauge, true, false},
+		{"value of counter", Condition{Value: &value}, counter, true, false},
+		{"delta matches", Condition{Delta: &delta}, counter, true, true},
+		{"revision and value", Condition{Revision: &two, Value: &other}, gauge, true, false},
+		{"exists, metric exists", Condition{Exists: true}, counter, true, true},
+		{"exists, no metric", Condition{Exists: true}, Metric{}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cond.Match(tt.current, tt.found))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"math"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+// OverflowPolicy определяет поведение счётчика при переполнении int64.
+type OverflowPolicy string
+
+const (
+	// OverflowSaturate останавливает счётчик на границе int64.
+	OverflowSaturate OverflowPolicy = "saturate"
+	// OverflowWrap продолжает счёт с противоположной границы
+	// и отмечает переполнение как сброс счётчика.
+	OverflowWrap OverflowPolicy = "wrap"
+)
+
+func (p OverflowPolicy) IsValid() bool {
+	return p == OverflowSaturate || p == OverflowWrap
+}
+
+// addCounter прибавляет к счётчику значение other.
+// Для накопительного счётчика приращение вычисляется как разница
+// с предыдущим итогом, уменьшение итога считается перезапуском агента.
+func (m *Metric) addCounter(other Metric, policy OverflowPolicy) {
+	inc := *other.Delta
+	if other.Cumulative {
+		total := *other.Delta
+		if m.Total != nil {
+			if total < *m.Total {
+				m.Resets++
+			} else {
+				inc = total - *m.Total
+			}
+		}
+		m.Total = &total
+	}
+
+	sum, overflowed := addInt64(*m.Delta, inc)
+	if overflowed {
+		if policy == OverflowWrap {
+			m.Resets++
+		} else {
+			sum = math.MaxInt64
+			if inc < 0 {
+				sum = math.MinInt64
+			}
+		}
+	}
+	m.Delta = &sum
+}
+
+func addInt64(a, b int64) (int64, bool) {
+	sum := a + b
+	return sum, (b > 0 && sum < a) || (b < 0 && sum > a)
+}
+
+// Baseline возвращает метрику в том виде, в котором она сохраняется
+// при первом появлении: итог накопительного счётчика становится
+// точкой отсчёта для следующих значений.
+func (m *Metric) Baseline() Metric {
+	stored := *m
+	if stored.Cumulative && stored.Delta != nil {
+		total := *stored.Delta
+		stored.Total = &total
+		stored.Cumulative = false
+	}
+	return stored
+}
+
+// Reset обнуляет счётчик и отмечает это как сброс.
+// Итог накопительного счётчика сохраняется, поэтому следующее значение
+// агента будет учтено как приращение от него.
+func (m *Metric) Reset(now time.Time) error {
+	if m.Type != MetricTypeCounter {
+		return &customerror.InvalidArgumentError{
+			Info: "only counter metric can be reset",
+		}
+	}
+	var zero int64
+	ts := now.UTC()
+	m.Delta = &zero
+	m.Resets++
+	m.Timestamp = &ts
+	return nil
+}
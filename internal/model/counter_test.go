@@ -0,0 +1,118 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func counter(delta int64) Metric {
+	return Metric{Name: "hits", Type: MetricTypeCounter, Delta: &delta}
+}
+
+func cumulative(total int64) Metric {
+	m := counter(total)
+	m.Cumulative = true
+	return m
+}
+
+func TestMetricUpdateCounterOverflow(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     OverflowPolicy
+		lhs        int64
+		rhs        int64
+		want       int64
+		wantResets int64
+	}{
+		{
+			name:   "no overflow",
+			policy: OverflowSaturate,
+			lhs:    1, rhs: 2,
+			want: 3,
+		},
+		{
+			name:   "saturate at max",
+			policy: OverflowSaturate,
+			lhs:    math.MaxInt64 - 1, rhs: 5,
+			want: math.MaxInt64,
+		},
+		{
+			name:   "saturate at min",
+			policy: OverflowSaturate,
+			lhs:    math.MinInt64 + 1, rhs: -5,
+			want: math.MinInt64,
+		},
+		{
+			name:   "wrap",
+			policy: OverflowWrap,
+			lhs:    math.MaxInt64, rhs: 2,
+			want: math.MinInt64 + 1, wantResets: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lhs := counter(test.lhs)
+			require.NoError(t, lhs.Update(counter(test.rhs), test.policy))
+			assert.Equal(t, test.want, *lhs.Delta)
+			assert.Equal(t, test.wantResets, lhs.Resets)
+		})
+	}
+}
+
+func TestMetricUpdateCumulative(t *testing.T) {
+	first := cumulative(100)
+	stored := first.Baseline()
+	assert.False(t, stored.Cumulative)
+	require.NotNil(t, stored.Total)
+	assert.Equal(t, int64(100), *stored.Total)
+	assert.Equal(t, int64(100), *stored.Delta)
+
+	require.NoError(t, stored.Update(cumulative(130), OverflowSaturate))
+	assert.Equal(t, int64(130), *stored.Delta)
+	assert.Equal(t, int64(130), *stored.Total)
+
+	// агент перезапустился и начал счёт заново
+	require.NoError(t, stored.Update(cumulative(20), OverflowSaturate))
+	assert.Equal(t, int64(150), *stored.Delta)
+	assert.Equal(t, int64(20), *stored.Total)
+	assert.Equal(t, int64(1), stored.Resets)
+
+	// обычное приращение не меняет итог
+	require.NoError(t, stored.Update(counter(5), OverflowSaturate))
+	assert.Equal(t, int64(155), *stored.Delta)
+	assert.Equal(t, int64(20), *stored.Total)
+}
+
+func TestMetricCheckValidCumulative(t *testing.T) {
+	valid := cumulative(1)
+	assert.NoError(t, valid.CheckValid())
+	negative := cumulative(-1)
+	assert.Error(t, negative.CheckValid())
+
+	value := 1.0
+	gauge := Metric{Name: "cpu", Type: MetricTypeGauge, Value: &value, Cumulative: true}
+	assert.Error(t, gauge.CheckValid())
+}
+
+func TestMetricReset(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	stored := counter(0)
+	require.NoError(t, stored.Update(cumulative(10), OverflowSaturate))
+	require.NoError(t, stored.Reset(now))
+	assert.Equal(t, int64(0), *stored.Delta)
+	assert.Equal(t, int64(1), stored.Resets)
+	assert.Equal(t, now, *stored.Timestamp)
+
+	// следующее накопительное значение считается от сохранённого итога
+	require.NoError(t, stored.Update(cumulative(15), OverflowSaturate))
+	assert.Equal(t, int64(5), *stored.Delta)
+
+	value := 1.5
+	gauge := Metric{Name: "cpu", Type: MetricTypeGauge, Value: &value}
+	assert.Error(t, gauge.Reset(now))
+}
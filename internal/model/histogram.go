@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+// Histogram хранит распределение значений по корзинам.
+// Bounds задаёт верхние границы корзин (включительно) в порядке возрастания,
+// Counts содержит количество наблюдений в каждой корзине,
+// последняя корзина Counts[len(Bounds)] соответствует +Inf.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []int64   `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  int64     `json:"count"`
+}
+
+func (h *Histogram) CheckValid() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf(
+				"histogram must have %d bucket counts for %d bounds, got %d",
+				len(h.Bounds)+1, len(h.Bounds), len(h.Counts)),
+		}
+	}
+	for i := 1; i < len(h.Bounds); i++ {
+		if h.Bounds[i-1] >= h.Bounds[i] {
+			return &customerror.InvalidArgumentError{
+				Info: "histogram bounds must be strictly increasing",
+			}
+		}
+	}
+
+	var total int64
+	for _, c := range h.Counts {
+		if c < 0 {
+			return &customerror.InvalidArgumentError{
+				Info: "histogram bucket count must be non-negative",
+			}
+		}
+		total += c
+	}
+	if total != h.Count {
+		return &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf(
+				"histogram count %d does not match sum of buckets %d",
+				h.Count, total),
+		}
+	}
+	return nil
+}
+
+func (h *Histogram) SameLayout(other *Histogram) bool {
+	return slices.Equal(h.Bounds, other.Bounds)
+}
+
+// Merge возвращает новую гистограмму, не изменяя исходные.
+func (h *Histogram) Merge(other *Histogram) (*Histogram, error) {
+	if !h.SameLayout(other) {
+		return nil, &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf(
+				"histogram bucket layouts differ: %v and %v",
+				h.Bounds, other.Bounds),
+		}
+	}
+
+	merged := &Histogram{
+		Bounds: slices.Clone(h.Bounds),
+		Counts: make([]int64, len(h.Counts)),
+		Sum:    h.Sum + other.Sum,
+		Count:  h.Count + other.Count,
+	}
+	for i := range h.Counts {
+		merged.Counts[i] = h.Counts[i] + other.Counts[i]
+	}
+	return merged, nil
+}
+
+func (h Histogram) String() string {
+	return fmt.Sprintf("count=%d sum=%.2f buckets=%v/%v",
+		h.Count, h.Sum, h.Bounds, h.Counts)
+}
@@ -0,0 +1,192 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+func TestHistogramCheckValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       Histogram
+		wantErr bool
+	}{
+		{
+			name: "valid histogram",
+			h: Histogram{
+				Bounds: []float64{0.1, 0.5, 1},
+				Counts: []int64{1, 2, 3, 4},
+				Sum:    7.5,
+				Count:  10,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "single +Inf bucket",
+			h:       Histogram{Counts: []int64{3}, Sum: 1, Count: 3},
+			wantErr: false,
+		},
+		{
+			name: "wrong number of buckets",
+			h: Histogram{
+				Bounds: []float64{0.1, 0.5},
+				Counts: []int64{1, 2},
+				Count:  3,
+			},
+			wantErr: true,
+		},
+		{
+			name: "bounds not increasing",
+			h: Histogram{
+				Bounds: []float64{0.5, 0.1},
+				Counts: []int64{1, 2, 3},
+				Count:  6,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative bucket",
+			h: Histogram{
+				Bounds: []float64{1},
+				Counts: []int64{-1, 1},
+				Count:  0,
+			},
+			wantErr: true,
+		},
+		{
+			name: "count mismatch",
+			h: Histogram{
+				Bounds: []float64{1},
+				Counts: []int64{1, 1},
+				Count:  3,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.h.CheckValid()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestMetricUpdateHistogram(t *testing.T) {
+	lhs := Metric{
+		Name: "latency",
+		Type: MetricTypeHistogram,
+		Histogram: &Histogram{
+			Bounds: []float64{0.1, 1},
+			Counts: []int64{1, 2, 0},
+			Sum:    1.2,
+			Count:  3,
+		},
+	}
+	rhs := Metric{
+		Name: "latency",
+		Type: MetricTypeHistogram,
+		Histogram: &Histogram{
+			Bounds: []float64{0.1, 1},
+			Counts: []int64{0, 1, 1},
+			Sum:    3.5,
+			Count:  2,
+		},
+	}
+	original := lhs.Histogram
+
+	require.NoError(t, lhs.Update(rhs, OverflowSaturate))
+	assert.Equal(t, []int64{1, 3, 1}, lhs.Histogram.Counts)
+	assert.InDelta(t, 4.7, lhs.Histogram.Sum, 1e-9)
+	assert.Equal(t, int64(5), lhs.Histogram.Count)
+	assert.Equal(t, []int64{1, 2, 0}, original.Counts,
+		"update must not mutate the previous histogram")
+
+	other := Metric{
+		Name: "latency",
+		Type: MetricTypeHistogram,
+		Histogram: &Histogram{
+			Bounds: []float64{0.5, 1},
+			Counts: []int64{0, 1, 0},
+			Sum:    0.7,
+			Count:  1,
+		},
+	}
+	err := lhs.Update(other, OverflowSaturate)
+	require.Error(t, err)
+	var invalidArgErr *customerror.InvalidArgumentError
+	assert.True(t, errors.As(err, &invalidArgErr))
+}
+
+func TestMetricCheckValidHistogram(t *testing.T) {
+	value := 1.0
+	tests := []struct {
+		name    string
+		metric  Metric
+		wantErr bool
+	}{
+		{
+			name: "valid histogram metric",
+			metric: Metric{
+				Name:      "latency",
+				Type:      MetricTypeHistogram,
+				Histogram: &Histogram{Counts: []int64{1}, Count: 1},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "empty histogram metric",
+			metric:  Metric{Name: "latency", Type: MetricTypeHistogram},
+			wantErr: false,
+		},
+		{
+			name: "histogram with value",
+			metric: Metric{
+				Name:      "latency",
+				Type:      MetricTypeHistogram,
+				Value:     &value,
+				Histogram: &Histogram{Counts: []int64{1}, Count: 1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "gauge with histogram",
+			metric: Metric{
+				Name:      "latency",
+				Type:      MetricTypeGauge,
+				Value:     &value,
+				Histogram: &Histogram{Counts: []int64{1}, Count: 1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "histogram with invalid buckets",
+			metric: Metric{
+				Name:      "latency",
+				Type:      MetricTypeHistogram,
+				Histogram: &Histogram{Counts: []int64{1}, Count: 2},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.metric.CheckValid()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
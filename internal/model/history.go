@@ -0,0 +1,76 @@
+package model
+
+import "time"
+
+// Sample — значение метрики в момент времени.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Sample возвращает текущее значение метрики как точку истории.
+// Для счётчика это накопленное значение, для гистограммы — число наблюдений.
+// Если у метрики нет отметки времени, используется now.
+func (m *Metric) Sample(now time.Time) (Sample, bool) {
+	var value float64
+	switch {
+	case m.Type == MetricTypeGauge && m.Value != nil:
+		value = *m.Value
+	case m.Type == MetricTypeCounter && m.Delta != nil:
+		value = float64(*m.Delta)
+	case m.Type == MetricTypeHistogram && m.Histogram != nil:
+		value = float64(m.Histogram.Count)
+	default:
+		return Sample{}, false
+	}
+
+	ts := now
+	if m.Timestamp != nil {
+		ts = *m.Timestamp
+	}
+	return Sample{Timestamp: ts.UTC(), Value: value}, true
+}
+
+// StepAggregate содержит агрегаты точек, попавших в интервал
+// [Start, Start+step).
+type StepAggregate struct {
+	Start time.Time `json:"start"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"`
+	Count int       `json:"count"`
+}
+
+// Aggregate разбивает отсортированные по времени точки на интервалы
+// длины step, начиная с from. Интервалы без точек пропускаются.
+func Aggregate(samples []Sample, from time.Time, step time.Duration) []StepAggregate {
+	rollups := make([]Rollup, 0, len(samples))
+	for _, s := range samples {
+		rollups = append(rollups, s.Rollup())
+	}
+	return AggregateRollups(rollups, from, step)
+}
+
+// AggregateRollups объединяет отсортированные по времени агрегаты
+// в интервалы длины step, начиная с from.
+func AggregateRollups(rollups []Rollup, from time.Time, step time.Duration) []StepAggregate {
+	result := make([]StepAggregate, 0)
+	if step <= 0 {
+		return result
+	}
+
+	after := make([]Rollup, 0, len(rollups))
+	for _, r := range rollups {
+		if !r.Start.Before(from) {
+			after = append(after, r)
+		}
+	}
+	grouped := group(after, func(t time.Time) time.Time {
+		return from.Add(t.Sub(from) / step * step)
+	})
+	for _, r := range grouped {
+		result = append(result, r.stepAggregate())
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricSample(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m := counter(7)
+	s, ok := m.Sample(now)
+	require.True(t, ok)
+	assert.Equal(t, Sample{Timestamp: now, Value: 7}, s)
+
+	ts := now.Add(time.Hour)
+	m.Timestamp = &ts
+	s, ok = m.Sample(now)
+	require.True(t, ok)
+	assert.Equal(t, ts, s.Timestamp)
+
+	h := Metric{Name: "latency", Type: MetricTypeHistogram,
+		Histogram: &Histogram{Counts: []int64{3}, Count: 3}}
+	s, ok = h.Sample(now)
+	require.True(t, ok)
+	assert.InDelta(t, 3.0, s.Value, 1e-9)
+
+	_, ok = (&Metric{Name: "empty", Type: MetricTypeGauge}).Sample(now)
+	assert.False(t, ok)
+}
+
+func TestAggregate(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(sec int, v float64) Sample {
+		return Sample{Timestamp: from.Add(time.Duration(sec) * time.Second), Value: v}
+	}
+	samples := []Sample{at(-5, 100), at(0, 2), at(10, 4), at(130, 1)}
+
+	got := Aggregate(samples, from, time.Minute)
+	require.Len(t, got, 2)
+	assert.Equal(t, StepAggregate{
+		Start: from, Min: 2, Max: 4, Avg: 3, Last: 4, Count: 2,
+	}, got[0])
+	assert.Equal(t, StepAggregate{
+		Start: from.Add(2 * time.Minute), Min: 1, Max: 1, Avg: 1, Last: 1, Count: 1,
+	}, got[1])
+
+	assert.Empty(t, Aggregate(samples, from, 0))
+	assert.Empty(t, Aggregate(nil, from, time.Minute))
+}
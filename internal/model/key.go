@@ -0,0 +1,97 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+const (
+	MaxNameLength       = 128
+	MaxLabelNameLength  = 128
+	MaxLabelValueLength = 256
+	MaxTenantLength     = 64
+)
+
+// DefaultTenant — пространство имён метрик, для которых арендатор не указан.
+const DefaultTenant = ""
+
+var tenantRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]*$`)
+
+// MetricKey однозначно идентифицирует метрику в хранилище.
+// Структура сравнима, поэтому может использоваться как ключ map.
+type MetricKey struct {
+	// Tenant — пространство имён, метрики разных арендаторов не пересекаются.
+	Tenant string
+	Type   MetricType
+	Name   string
+	// Labels — каноническое представление меток, см. Labels.String.
+	Labels string
+}
+
+func NewMetricKey(t MetricType, name string, labels Labels) MetricKey {
+	return MetricKey{
+		Type:   t,
+		Name:   name,
+		Labels: labels.String(),
+	}
+}
+
+func (k MetricKey) String() string {
+	name := k.Name
+	if k.Tenant != DefaultTenant {
+		name = k.Tenant + "/" + name
+	}
+	if k.Labels == "" {
+		return k.Type.String() + " " + name
+	}
+	return k.Type.String() + " " + name + "{" + k.Labels + "}"
+}
+
+// CheckTenant проверяет имя арендатора: латинские буквы, цифры, '_', '.' и '-',
+// не длиннее MaxTenantLength байт. Пустое имя — DefaultTenant.
+func CheckTenant(tenant string) error {
+	if len(tenant) > MaxTenantLength {
+		return &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf("tenant must be at most %d bytes", MaxTenantLength),
+		}
+	}
+	if !tenantRe.MatchString(tenant) {
+		return &customerror.InvalidArgumentError{
+			Info: "invalid tenant <" + tenant + ">",
+		}
+	}
+	return nil
+}
+
+func checkName(name string) error {
+	if len(name) > MaxNameLength {
+		return &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf("metric name must be at most %d bytes", MaxNameLength),
+		}
+	}
+	if strings.Contains(name, "/") {
+		return &customerror.InvalidArgumentError{
+			Info: "metric name must not contain '/'",
+		}
+	}
+	return checkText(name, "metric name")
+}
+
+func checkText(s, what string) error {
+	if !utf8.ValidString(s) {
+		return &customerror.InvalidArgumentError{
+			Info: what + " must be valid UTF-8",
+		}
+	}
+	if strings.IndexFunc(s, unicode.IsControl) >= 0 {
+		return &customerror.InvalidArgumentError{
+			Info: what + " must not contain control characters",
+		}
+	}
+	return nil
+}
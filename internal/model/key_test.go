@@ -0,0 +1,86 @@
+package model
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+func TestMetricKey(t *testing.T) {
+	value := 1.0
+	lhs := Metric{Name: "heap alloc", Type: MetricTypeGauge, Value: &value}
+	rhs := Metric{Name: "heap", Type: MetricTypeGauge, Value: &value}
+	assert.NotEqual(t, lhs.Key(), rhs.Key())
+	assert.Equal(t, MetricKey{Type: MetricTypeGauge, Name: "heap alloc"}, lhs.Key())
+
+	labeled := Metric{Name: "heap", Type: MetricTypeGauge, Value: &value,
+		Labels: Labels{"host": "a"}}
+	assert.NotEqual(t, rhs.Key(), labeled.Key())
+	assert.Equal(t,
+		NewMetricKey(MetricTypeGauge, "heap", Labels{"host": "a"}),
+		labeled.Key())
+
+	tenant := Metric{Name: "heap", Type: MetricTypeGauge, Value: &value, Tenant: "team-a"}
+	assert.NotEqual(t, rhs.Key(), tenant.Key())
+	assert.Equal(t, "gauge team-a/heap", tenant.Key().String())
+}
+
+func TestCheckTenant(t *testing.T) {
+	tests := []struct {
+		tenant  string
+		wantErr bool
+	}{
+		{tenant: DefaultTenant, wantErr: false},
+		{tenant: "team-a.prod_1", wantErr: false},
+		{tenant: strings.Repeat("a", MaxTenantLength), wantErr: false},
+		{tenant: strings.Repeat("a", MaxTenantLength+1), wantErr: true},
+		{tenant: "team/a", wantErr: true},
+		{tenant: "team a", wantErr: true},
+		{tenant: "команда", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.tenant, func(t *testing.T) {
+			err := CheckTenant(test.tenant)
+			if !test.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var invalidArgErr *customerror.InvalidArgumentError
+			assert.True(t, errors.As(err, &invalidArgErr))
+		})
+	}
+}
+
+func TestMetricCheckValidName(t *testing.T) {
+	value := 1.0
+	tests := []struct {
+		name    string
+		mName   string
+		wantErr bool
+	}{
+		{name: "name with space", mName: "heap alloc", wantErr: false},
+		{name: "unicode name", mName: "память", wantErr: false},
+		{name: "max length", mName: strings.Repeat("a", MaxNameLength), wantErr: false},
+		{name: "too long", mName: strings.Repeat("a", MaxNameLength+1), wantErr: true},
+		{name: "slash", mName: "heap/alloc", wantErr: true},
+		{name: "control character", mName: "heap\nalloc", wantErr: true},
+		{name: "zero byte", mName: "heap\x00", wantErr: true},
+		{name: "invalid utf8", mName: "heap\xff", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := Metric{Name: test.mName, Type: MetricTypeGauge, Value: &value}
+			err := m.CheckValid()
+			if !test.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var invalidArgErr *customerror.InvalidArgumentError
+			assert.True(t, errors.As(err, &invalidArgErr))
+		})
+	}
+}
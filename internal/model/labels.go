@@ -0,0 +1,81 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels — набор измерений метрики, входящий в её идентичность.
+type Labels map[string]string
+
+func (l Labels) CheckValid() error {
+	for k, v := range l {
+		if !labelNameRe.MatchString(k) || len(k) > MaxLabelNameLength {
+			return &customerror.InvalidArgumentError{
+				Info: "invalid label name <" + k + ">",
+			}
+		}
+		if len(v) > MaxLabelValueLength {
+			return &customerror.InvalidArgumentError{
+				Info: fmt.Sprintf("label <%s> value must be at most %d bytes",
+					k, MaxLabelValueLength),
+			}
+		}
+		if err := checkText(v, "label value"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String возвращает каноническое представление меток:
+// ключи отсортированы, значения экранированы, например `cpu="1",host="a"`.
+// Для пустого набора возвращается пустая строка.
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys, values := l.Keys()
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(values[i]))
+	}
+	return sb.String()
+}
+
+// Match проверяет, что набор содержит все пары из matchers.
+func (l Labels) Match(matchers Labels) bool {
+	for k, v := range matchers {
+		if got, ok := l[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys возвращает ключи и значения в каноническом порядке.
+func (l Labels) Keys() (keys, values []string) {
+	keys = make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	values = make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = l[k]
+	}
+	return keys, values
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelsString(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels Labels
+		want   string
+	}{
+		{name: "nil labels", labels: nil, want: ""},
+		{name: "empty labels", labels: Labels{}, want: ""},
+		{name: "single label", labels: Labels{"host": "a"}, want: `host="a"`},
+		{
+			name:   "sorted keys",
+			labels: Labels{"host": "a", "cpu": "1"},
+			want:   `cpu="1",host="a"`,
+		},
+		{
+			name:   "escaped value",
+			labels: Labels{"path": `a"b,c`},
+			want:   `path="a\"b,c"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, test.labels.String())
+		})
+	}
+}
+
+func TestLabelsMatch(t *testing.T) {
+	labels := Labels{"host": "a", "cpu": "1"}
+
+	assert.True(t, labels.Match(nil))
+	assert.True(t, labels.Match(Labels{"host": "a"}))
+	assert.True(t, labels.Match(Labels{"host": "a", "cpu": "1"}))
+	assert.False(t, labels.Match(Labels{"host": "b"}))
+	assert.False(t, labels.Match(Labels{"dc": "x"}))
+	assert.False(t, Labels(nil).Match(Labels{"host": "a"}))
+}
+
+func TestLabelsCheckValid(t *testing.T) {
+	assert.NoError(t, Labels{"host": "a", "_cpu1": ""}.CheckValid())
+	assert.Error(t, Labels{"": "a"}.CheckValid())
+	assert.Error(t, Labels{"1cpu": "a"}.CheckValid())
+	assert.Error(t, Labels{"host name": "a"}.CheckValid())
+}
+
+func TestMetricKeyWithLabels(t *testing.T) {
+	value := 1.0
+	m := Metric{Name: "CPUutilization", Type: MetricTypeGauge, Value: &value}
+	assert.Equal(t, "gauge CPUutilization", m.Key().String())
+
+	m.Labels = Labels{"host": "a", "cpu": "1"}
+	assert.Equal(t, `gauge CPUutilization{cpu="1",host="a"}`, m.Key().String())
+	assert.Equal(t, `CPUutilization{cpu="1",host="a"}(gauge): 1.00`, m.String())
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+)
+
+// ListOptions задаёт фильтр и страницу списка метрик.
+// Нулевое значение соответствует всем метрикам арендатора по умолчанию.
+// Метрики упорядочены по CompareKeys.
+type ListOptions struct {
+	// After — ключ последней метрики предыдущей страницы.
+	After *MetricKey
+	// Tenant — арендатор, метрики других арендаторов не возвращаются.
+	Tenant string
+	// Type оставляет только метрики заданного типа.
+	Type MetricType
+	// Prefix оставляет метрики, имя которых начинается с Prefix.
+	Prefix string
+	// Pattern — регулярное выражение RE2 (синтаксис пакета regexp),
+	// которому должно соответствовать имя. Все хранилища проверяют его в Go.
+	Pattern string
+	// Limit ограничивает размер страницы, 0 — без ограничения.
+	Limit int
+	// AllTenants возвращает метрики всех арендаторов, Tenant не учитывается.
+	// Нужен служебным операциям, например снимку хранилища.
+	AllTenants bool
+}
+
+func (o ListOptions) CheckValid() error {
+	if err := CheckTenant(o.Tenant); err != nil {
+		return err
+	}
+	if o.Type != "" && !o.Type.IsValid() {
+		return &customerror.InvalidArgumentError{
+			Info: "only counter, gauge and histogram types are allowed",
+		}
+	}
+	if o.Limit < 0 {
+		return &customerror.InvalidArgumentError{
+			Info: "limit must not be negative",
+		}
+	}
+	if _, err := regexp.Compile(o.Pattern); err != nil {
+		return &customerror.InvalidArgumentError{
+			Info: "invalid name pattern: " + err.Error(),
+		}
+	}
+	return nil
+}
+
+// Matcher возвращает проверку ключа на соответствие фильтрам,
+// без учёта страницы. Параметры должны быть проверены CheckValid.
+func (o ListOptions) Matcher() func(MetricKey) bool {
+	var re *regexp.Regexp
+	if o.Pattern != "" {
+		re = regexp.MustCompile(o.Pattern)
+	}
+	return func(k MetricKey) bool {
+		return (o.AllTenants || k.Tenant == o.Tenant) &&
+			(o.Type == "" || k.Type == o.Type) &&
+			strings.HasPrefix(k.Name, o.Prefix) &&
+			(re == nil || re.MatchString(k.Name))
+	}
+}
+
+// CompareKeys задаёт порядок метрик в списке: по арендатору, имени, типу и меткам.
+// Строки сравниваются побайтно.
+func CompareKeys(a, b MetricKey) int {
+	if c := strings.Compare(a.Tenant, b.Tenant); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	if c := strings.Compare(string(a.Type), string(b.Type)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Labels, b.Labels)
+}
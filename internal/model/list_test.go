@@ -0,0 +1,44 @@
+package model
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListOptions(t *testing.T) {
+	keys := []MetricKey{
+		{Type: MetricTypeGauge, Name: "b"},
+		{Type: MetricTypeGauge, Name: "a", Labels: "cpu=2"},
+		{Type: MetricTypeCounter, Name: "a"},
+		{Type: MetricTypeGauge, Name: "a"},
+		{Type: MetricTypeGauge, Name: "B"},
+	}
+	slices.SortFunc(keys, CompareKeys)
+	assert.Equal(t, []MetricKey{
+		{Type: MetricTypeGauge, Name: "B"},
+		{Type: MetricTypeCounter, Name: "a"},
+		{Type: MetricTypeGauge, Name: "a"},
+		{Type: MetricTypeGauge, Name: "a", Labels: "cpu=2"},
+		{Type: MetricTypeGauge, Name: "b"},
+	}, keys)
+
+	match := ListOptions{Type: MetricTypeGauge, Pattern: "^[ab]$"}.Matcher()
+	assert.True(t, match(MetricKey{Type: MetricTypeGauge, Name: "a"}))
+	assert.False(t, match(MetricKey{Type: MetricTypeCounter, Name: "a"}))
+	assert.False(t, match(MetricKey{Type: MetricTypeGauge, Name: "B"}))
+
+	assert.False(t, match(MetricKey{Tenant: "team-a", Type: MetricTypeGauge, Name: "a"}))
+	assert.True(t, ListOptions{Tenant: "team-a"}.Matcher()(
+		MetricKey{Tenant: "team-a", Type: MetricTypeGauge, Name: "a"}))
+	assert.Negative(t, CompareKeys(
+		MetricKey{Type: MetricTypeGauge, Name: "b"},
+		MetricKey{Tenant: "team-a", Type: MetricTypeGauge, Name: "a"}))
+
+	assert.NoError(t, ListOptions{}.CheckValid())
+	assert.Error(t, ListOptions{Tenant: "team/a"}.CheckValid())
+	assert.Error(t, ListOptions{Type: "wrong"}.CheckValid())
+	assert.Error(t, ListOptions{Limit: -1}.CheckValid())
+	assert.Error(t, ListOptions{Pattern: "("}.CheckValid())
+}
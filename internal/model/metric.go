@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/talx-hub/malerter/internal/customerror"
 )
@@ -11,12 +12,13 @@ import (
 type MetricType string
 
 const (
-	MetricTypeGauge   MetricType = "gauge"
-	MetricTypeCounter MetricType = "counter"
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeCounter   MetricType = "counter"
+	MetricTypeHistogram MetricType = "histogram"
 )
 
 func (t MetricType) IsValid() bool {
-	return t == MetricTypeGauge || t == MetricTypeCounter
+	return t == MetricTypeGauge || t == MetricTypeCounter || t == MetricTypeHistogram
 }
 
 func (t MetricType) String() string {
@@ -24,10 +26,25 @@ func (t MetricType) String() string {
 }
 
 type Metric struct {
-	Delta *int64     `json:"delta,omitempty"`
-	Value *float64   `json:"value,omitempty"`
+	Delta     *int64     `json:"delta,omitempty"`
+	Value     *float64   `json:"value,omitempty"`
+	Histogram *Histogram `json:"histogram,omitempty"`
+	Labels    Labels     `json:"labels,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	// Total — последний полученный итог накопительного счётчика.
+	Total *int64     `json:"total,omitempty"`
 	Type  MetricType `json:"type"`
 	Name  string     `json:"id"`
+	// Tenant — арендатор, которому принадлежит метрика, см. MetricKey.Tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// Resets — число обнаруженных сбросов и переполнений счётчика.
+	Resets int64 `json:"resets,omitempty"`
+	// Revision растёт с каждым изменением метрики в хранилище, начиная с 1.
+	// Хранилище назначает его само, значение от клиента не учитывается.
+	Revision int64 `json:"revision,omitempty"`
+	// Cumulative означает, что Delta содержит итог с момента запуска агента,
+	// а не приращение.
+	Cumulative bool `json:"cumulative,omitempty"`
 }
 
 func NewMetric() *Metric {
@@ -35,6 +52,10 @@ func NewMetric() *Metric {
 }
 
 func (m *Metric) setValue(val any) error {
+	if hVal, ok := val.(Histogram); ok {
+		m.Histogram = &hVal
+		return nil
+	}
 	if iVal, ok := val.(int64); ok {
 		m.Delta = &iVal
 		return nil
@@ -76,10 +97,15 @@ func (m *Metric) clean() {
 	if m.Type == MetricTypeCounter && m.Value != nil && m.Delta != nil {
 		m.Value = nil
 	}
+	// гистограмму нельзя задать строкой или числом
+	if m.Type == MetricTypeHistogram {
+		m.Delta = nil
+		m.Value = nil
+	}
 }
 
 func (m *Metric) IsEmpty() bool {
-	if m.Delta == nil && m.Value == nil {
+	if m.Delta == nil && m.Value == nil && m.Histogram == nil {
 		return true
 	}
 	return false
@@ -101,25 +127,75 @@ func (m *Metric) CheckValid() error {
 		}
 	}
 
-	// только два типа метрик позволены
+	// имя должно быть представимо во всех хранилищах и в URL
+	if err := checkName(m.Name); err != nil {
+		return err
+	}
+
+	// только три типа метрик позволены
 	if !m.Type.IsValid() {
 		return &customerror.InvalidArgumentError{
-			Info: "only counter and gauge types are allowed",
+			Info: "only counter, gauge and histogram types are allowed",
 		}
 	}
 
 	// значение должно соответствовать типу
-	wrongCounter := m.Type == MetricTypeCounter && (m.Value != nil || m.Delta == nil)
-	wrongGauge := m.Type == MetricTypeGauge && (m.Delta != nil || m.Value == nil)
-	if !m.IsEmpty() && (wrongCounter || wrongGauge) {
+	wrongCounter := m.Type == MetricTypeCounter &&
+		(m.Value != nil || m.Histogram != nil || m.Delta == nil)
+	wrongGauge := m.Type == MetricTypeGauge &&
+		(m.Delta != nil || m.Histogram != nil || m.Value == nil)
+	wrongHistogram := m.Type == MetricTypeHistogram &&
+		(m.Delta != nil || m.Value != nil || m.Histogram == nil)
+	if !m.IsEmpty() && (wrongCounter || wrongGauge || wrongHistogram) {
 		return &customerror.InvalidArgumentError{
 			Info: "metric has invalid value",
 		}
 	}
 
+	if m.Cumulative {
+		if m.Type != MetricTypeCounter {
+			return &customerror.InvalidArgumentError{
+				Info: "only counter metric can be cumulative",
+			}
+		}
+		if m.Delta != nil && *m.Delta < 0 {
+			return &customerror.InvalidArgumentError{
+				Info: "cumulative counter total must be non-negative",
+			}
+		}
+	}
+
+	if m.Histogram != nil {
+		if err := m.Histogram.CheckValid(); err != nil {
+			return fmt.Errorf("metric has invalid histogram: %w", err)
+		}
+	}
+
+	if err := m.Labels.CheckValid(); err != nil {
+		return fmt.Errorf("metric has invalid labels: %w", err)
+	}
+
+	if err := CheckTenant(m.Tenant); err != nil {
+		return fmt.Errorf("metric has invalid tenant: %w", err)
+	}
+
 	return nil
 }
 
+// Key возвращает идентичность метрики: арендатора, тип, имя и метки.
+func (m *Metric) Key() MetricKey {
+	key := NewMetricKey(m.Type, m.Name, m.Labels)
+	key.Tenant = m.Tenant
+	return key
+}
+
+func (m *Metric) fullName() string {
+	if len(m.Labels) == 0 {
+		return m.Name
+	}
+	return m.Name + "{" + m.Labels.String() + "}"
+}
+
 func (m *Metric) ActualValue() any {
 	if m.Type == MetricTypeGauge && m.Value != nil {
 		return *m.Value
@@ -127,17 +203,20 @@ func (m *Metric) ActualValue() any {
 	if m.Type == MetricTypeCounter && m.Delta != nil {
 		return *m.Delta
 	}
+	if m.Type == MetricTypeHistogram && m.Histogram != nil {
+		return *m.Histogram
+	}
 	return nil
 }
 
 func (m *Metric) String() string {
 	if m.IsEmpty() {
-		return fmt.Sprintf("%s(%s): <nil>", m.Name, m.Type.String())
+		return fmt.Sprintf("%s(%s): <nil>", m.fullName(), m.Type.String())
 	}
 	if m.Type == MetricTypeGauge {
-		return fmt.Sprintf("%s(%s): %.2f", m.Name, m.Type.String(), m.ActualValue())
+		return fmt.Sprintf("%s(%s): %.2f", m.fullName(), m.Type.String(), m.ActualValue())
 	}
-	return fmt.Sprintf("%s(%s): %v", m.Name, m.Type.String(), m.ActualValue())
+	return fmt.Sprintf("%s(%s): %v", m.fullName(), m.Type.String(), m.ActualValue())
 }
 
 func (m *Metric) ToURL() string {
@@ -150,7 +229,7 @@ func (m *Metric) ToURL() string {
 	return fmt.Sprintf("%s/%s/%v", m.Type.String(), m.Name, m.ActualValue())
 }
 
-func (m *Metric) Update(other Metric) error {
+func (m *Metric) Update(other Metric, policy OverflowPolicy) error {
 	// TODO: может убрать эти проверки???
 	// невалидные метрики вообще не должны иметь возможность быть созданными клиентским кодом
 	if err := m.CheckValid(); err != nil {
@@ -169,16 +248,34 @@ func (m *Metric) Update(other Metric) error {
 		return errors.New("lhs and rhs metrics type are different, cannot update")
 	}
 
-	if m.Type == MetricTypeGauge {
+	switch m.Type {
+	case MetricTypeGauge:
 		m.Value = other.Value
-	} else {
-		// TODO: handle overflow
-		updated := *m.Delta + *other.Delta
-		m.Delta = &updated
+	case MetricTypeHistogram:
+		merged, err := m.Histogram.Merge(other.Histogram)
+		if err != nil {
+			return fmt.Errorf("unable to merge histograms: %w", err)
+		}
+		m.Histogram = merged
+	default:
+		m.addCounter(other, policy)
+	}
+	if other.Timestamp != nil {
+		m.Timestamp = other.Timestamp
 	}
 	return nil
 }
 
+// StampIfMissing проставляет время получения метрики,
+// если источник не передал собственную отметку времени.
+func (m *Metric) StampIfMissing(now time.Time) {
+	if m.Timestamp != nil {
+		return
+	}
+	ts := now.UTC()
+	m.Timestamp = &ts
+}
+
 func (m *Metric) FromValues(name string, t MetricType, value any) (Metric, error) {
 	m.Name = name
 	m.Type = t
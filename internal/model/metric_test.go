@@ -2,6 +2,7 @@ package model
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -23,6 +24,11 @@ func TestIsValid(t *testing.T) {
 			typeStr: "counter",
 			want:    true,
 		},
+		{
+			name:    "histogram metric type",
+			typeStr: "histogram",
+			want:    true,
+		},
 		{
 			name:    "wrong metric type",
 			typeStr: "wrong",
@@ -482,7 +488,7 @@ func TestMetricUpdate(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			err := test.lhs.Update(test.rhs)
+			err := test.lhs.Update(test.rhs, OverflowSaturate)
 			if !test.wantErr {
 				require.NoError(t, err)
 				assert.Equal(t, test.want.ActualValue(), test.lhs.ActualValue())
@@ -493,6 +499,29 @@ func TestMetricUpdate(t *testing.T) {
 	}
 }
 
+func TestMetricTimestamp(t *testing.T) {
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	second := first.Add(time.Minute)
+
+	m, err := NewMetric().FromValues("cpu", MetricTypeGauge, 1.0)
+	require.NoError(t, err)
+	m.StampIfMissing(first)
+	require.NotNil(t, m.Timestamp)
+	assert.Equal(t, first, *m.Timestamp)
+
+	m.StampIfMissing(second)
+	assert.Equal(t, first, *m.Timestamp, "existing timestamp must be kept")
+
+	other, err := NewMetric().FromValues("cpu", MetricTypeGauge, 2.0)
+	require.NoError(t, err)
+	require.NoError(t, m.Update(other, OverflowSaturate))
+	assert.Equal(t, first, *m.Timestamp, "update without timestamp keeps the old one")
+
+	other.Timestamp = &second
+	require.NoError(t, m.Update(other, OverflowSaturate))
+	assert.Equal(t, second, *m.Timestamp)
+}
+
 func TestFromValues(t *testing.T) {
 	type values struct {
 		name  string
@@ -0,0 +1,58 @@
+package model
+
+import (
+	"slices"
+	"time"
+)
+
+// RollupTier задаёт разрешение агрегатов и срок их хранения.
+// Нулевой срок хранения означает бессрочное хранение.
+type RollupTier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Retention описывает, сколько хранятся исходные точки и агрегаты.
+// Уровни агрегации упорядочены по возрастанию разрешения,
+// каждый следующий уровень строится из предыдущего.
+type Retention struct {
+	Tiers []RollupTier
+	Raw   time.Duration
+}
+
+// NewRetention создаёт политику хранения, отбрасывая уровни
+// с неположительным разрешением.
+func NewRetention(raw time.Duration, tiers ...RollupTier) Retention {
+	r := Retention{Raw: raw}
+	for _, t := range tiers {
+		if t.Resolution > 0 {
+			r.Tiers = append(r.Tiers, t)
+		}
+	}
+	slices.SortFunc(r.Tiers, func(a, b RollupTier) int {
+		return int(a.Resolution - b.Resolution)
+	})
+	return r
+}
+
+func covers(retention time.Duration, from, now time.Time) bool {
+	return retention <= 0 || !from.Before(now.Add(-retention))
+}
+
+// Resolution выбирает разрешение для запроса интервала, начинающегося с from.
+// Ноль означает исходные точки. Пока исходные точки хранятся за from,
+// используются они: шаг запроса набирается из точек при агрегации.
+// Дальше используется самый точный уровень, который ещё хранит данные
+// за from; если таких нет, — исходные точки.
+func (r Retention) Resolution(from, now time.Time) time.Duration {
+	if covers(r.Raw, from, now) {
+		return 0
+	}
+	// уровни упорядочены от точного к грубому
+	for _, t := range r.Tiers {
+		if covers(t.Retention, from, now) {
+			return t.Resolution
+		}
+	}
+	return 0
+}
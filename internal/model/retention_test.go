@@ -0,0 +1,41 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionResolution(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	r := NewRetention(day,
+		RollupTier{Resolution: time.Hour, Retention: 365 * day},
+		RollupTier{Resolution: time.Minute, Retention: 30 * day},
+		RollupTier{Resolution: 0, Retention: day},
+	)
+	assert.Len(t, r.Tiers, 2)
+	assert.Equal(t, time.Minute, r.Tiers[0].Resolution)
+
+	tests := []struct {
+		name string
+		from time.Time
+		want time.Duration
+	}{
+		// исходные точки хранятся сутки: агрегаты не нужны при любом шаге
+		{"recent", now.Add(-time.Hour), 0},
+		{"raw retention edge", now.Add(-day), 0},
+		{"week ago", now.Add(-7 * day), time.Minute},
+		{"half a year ago", now.Add(-180 * day), time.Hour},
+		{"beyond retention", now.Add(-400 * day), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, r.Resolution(tt.from, now))
+		})
+	}
+
+	assert.Equal(t, time.Duration(0), Retention{}.Resolution(now.Add(-400*day), now),
+		"without tiers raw samples are used")
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+// Rollup — сжатое представление точек ряда за интервал [Start, Start+разрешение).
+type Rollup struct {
+	Start time.Time `json:"start"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Sum   float64   `json:"sum"`
+	Last  float64   `json:"last"`
+	Count int64     `json:"count"`
+}
+
+// Rollup представляет точку как агрегат из одного значения.
+func (s Sample) Rollup() Rollup {
+	return Rollup{
+		Start: s.Timestamp,
+		Min:   s.Value,
+		Max:   s.Value,
+		Sum:   s.Value,
+		Last:  s.Value,
+		Count: 1,
+	}
+}
+
+// merge добавляет более поздний агрегат other.
+func (r *Rollup) merge(other Rollup) {
+	r.Min = math.Min(r.Min, other.Min)
+	r.Max = math.Max(r.Max, other.Max)
+	r.Sum += other.Sum
+	r.Last = other.Last
+	r.Count += other.Count
+}
+
+func (r *Rollup) stepAggregate() StepAggregate {
+	return StepAggregate{
+		Start: r.Start,
+		Min:   r.Min,
+		Max:   r.Max,
+		Avg:   r.Sum / float64(r.Count),
+		Last:  r.Last,
+		Count: int(r.Count),
+	}
+}
+
+// group объединяет соседние агрегаты, попадающие в один интервал.
+// bucket возвращает начало интервала для момента времени.
+func group(rollups []Rollup, bucket func(time.Time) time.Time) []Rollup {
+	result := make([]Rollup, 0)
+	for _, r := range rollups {
+		start := bucket(r.Start)
+		if n := len(result); n > 0 && result[n-1].Start.Equal(start) {
+			result[n-1].merge(r)
+			continue
+		}
+		r.Start = start
+		result = append(result, r)
+	}
+	return result
+}
+
+// BucketStart возвращает начало интервала длины resolution,
+// отсчитываемого от начала эпохи Unix, которому принадлежит t.
+func BucketStart(t time.Time, resolution time.Duration) time.Time {
+	ns := t.UnixNano()
+	offset := ns % int64(resolution)
+	if offset < 0 {
+		offset += int64(resolution)
+	}
+	return time.Unix(0, ns-offset).UTC()
+}
+
+// RollUp сжимает отсортированные по времени точки до разрешения resolution.
+func RollUp(samples []Sample, resolution time.Duration) []Rollup {
+	rollups := make([]Rollup, 0, len(samples))
+	for _, s := range samples {
+		rollups = append(rollups, s.Rollup())
+	}
+	return Coarsen(rollups, resolution)
+}
+
+// Coarsen сжимает отсортированные по времени агрегаты до разрешения resolution.
+func Coarsen(rollups []Rollup, resolution time.Duration) []Rollup {
+	return group(rollups, func(t time.Time) time.Time {
+		return BucketStart(t, resolution)
+	})
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketStart(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 10, 17, 42, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 17, 0, 0, time.UTC), BucketStart(ts, time.Minute))
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), BucketStart(ts, time.Hour))
+
+	// интервалы отсчитываются от эпохи Unix, в том числе до неё
+	before := time.Unix(-1, 0)
+	assert.Equal(t, time.Unix(-60, 0).UTC(), BucketStart(before, time.Minute))
+}
+
+func TestRollUpAndCoarsen(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration, v float64) Sample {
+		return Sample{Timestamp: start.Add(d), Value: v}
+	}
+	samples := []Sample{
+		at(10*time.Second, 4),
+		at(50*time.Second, 2),
+		at(70*time.Second, 6),
+		at(61*time.Minute, 1),
+	}
+
+	minutes := RollUp(samples, time.Minute)
+	require.Len(t, minutes, 3)
+	assert.Equal(t, Rollup{Start: start, Min: 2, Max: 4, Sum: 6, Last: 2, Count: 2}, minutes[0])
+	assert.Equal(t, Rollup{
+		Start: start.Add(time.Minute), Min: 6, Max: 6, Sum: 6, Last: 6, Count: 1,
+	}, minutes[1])
+
+	hours := Coarsen(minutes, time.Hour)
+	require.Len(t, hours, 2)
+	assert.Equal(t, Rollup{Start: start, Min: 2, Max: 6, Sum: 12, Last: 6, Count: 3}, hours[0])
+	assert.Equal(t, int64(1), hours[1].Count)
+
+	steps := AggregateRollups(minutes, start, time.Hour)
+	require.Len(t, steps, 2)
+	assert.Equal(t, StepAggregate{
+		Start: start, Min: 2, Max: 6, Avg: 4, Last: 6, Count: 3,
+	}, steps[0])
+}
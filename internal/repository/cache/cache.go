@@ -0,0 +1,350 @@
+// Package cache реализует кеш метрик перед хранилищем.
+//
+// Кеш заполняется при чтении и при записи: хранилище возвращает значение
+// после слияния, поэтому в кеш попадает сумма счётчика, а не приращение.
+// Каждое значение помечено ревизией, и кеш не заменяет значение
+// более старой ревизией.
+//
+// Если хранилище сообщает об изменениях (Postgres, см. db.DB.Watch),
+// кеш сбрасывает значения, изменённые другими экземплярами сервера.
+// Пока уведомления недоступны, кеш сбрасывается целиком.
+// Записи, пересекающиеся с удалением метрики, могут оставить в кеше
+// устаревшее значение, но не дольше TTL.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/api/handlers"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
+)
+
+// StatsInterval — период, с которым Run записывает статистику кеша.
+const StatsInterval = time.Minute
+
+// Метрики статистики кеша, которые Run записывает в хранилище
+// арендатора по умолчанию рядом с метриками агентов.
+const (
+	MetricHits   = "MetricCacheHits"
+	MetricMisses = "MetricCacheMisses"
+	MetricSize   = "MetricCacheSize"
+)
+
+// watcher — хранилище, сообщающее об изменениях метрик.
+type watcher interface {
+	Watch(onChange db.ChangeFunc, onGap func())
+}
+
+type entry struct {
+	expires time.Time
+	metric  model.Metric
+	key     model.MetricKey
+	// lease не равен нулю, пока значение читается из хранилища:
+	// если за это время метрика изменилась, прочитанное не сохраняется.
+	lease uint64
+}
+
+// Stats — статистика кеша.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// Metrics возвращает статистику как метрики в момент at: попадания
+// и промахи — накопительные счётчики с запуска сервера, размер — gauge.
+func (s Stats) Metrics(at time.Time) []model.Metric {
+	hits, misses, size := int64(s.Hits), int64(s.Misses), float64(s.Size)
+	return []model.Metric{
+		{Name: MetricHits, Type: model.MetricTypeCounter, Delta: &hits, Cumulative: true, Timestamp: &at},
+		{Name: MetricMisses, Type: model.MetricTypeCounter, Delta: &misses, Cumulative: true, Timestamp: &at},
+		{Name: MetricSize, Type: model.MetricTypeGauge, Value: &size, Timestamp: &at},
+	}
+}
+
+// Cache — хранилище с кешем перед handlers.Storage.
+type Cache struct {
+	handlers.Storage
+	log     *logger.ZeroLogger
+	now     func() time.Time
+	entries map[model.MetricKey]*list.Element
+	// lru упорядочен от недавно использованных к давно использованным.
+	lru    *list.List
+	ttl    time.Duration
+	size   int
+	leases uint64
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	m      sync.Mutex
+}
+
+// New создаёт кеш на size метрик, каждая из которых хранится не дольше ttl.
+func New(storage handlers.Storage, log *logger.ZeroLogger, size int, ttl time.Duration) *Cache {
+	c := &Cache{
+		Storage: storage,
+		log:     log,
+		now:     time.Now,
+		entries: make(map[model.MetricKey]*list.Element, size),
+		lru:     list.New(),
+		ttl:     ttl,
+		size:    size,
+	}
+	if w, ok := storage.(watcher); ok {
+		w.Watch(c.Invalidate, c.Flush)
+	}
+	return c
+}
+
+// Find возвращает метрику из кеша, а при промахе читает её из хранилища.
+// Чтение с контекстом db.WithPrimary идёт мимо кеша.
+func (c *Cache) Find(ctx context.Context, key model.MetricKey) (model.Metric, error) {
+	if db.ReadsPrimary(ctx) {
+		//nolint:wrapcheck // the cache is transparent
+		return c.Storage.Find(ctx, key)
+	}
+	metric, lease, found := c.lookup(key)
+	if found {
+		return metric, nil
+	}
+	// значение для кеша читается с основной базы: реплика может отставать
+	metric, err := c.Storage.Find(db.WithPrimary(ctx), key)
+	if err != nil {
+		c.release(key, lease)
+		//nolint:wrapcheck // the cache is transparent
+		return model.Metric{}, err
+	}
+	c.fill(key, lease, metric)
+	return metric, nil
+}
+
+// Add сохраняет метрику и кеширует её значение после слияния.
+func (c *Cache) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
+	stored, err := c.Storage.Add(ctx, metric)
+	c.written(metric.Key(), stored, err)
+	//nolint:wrapcheck // the cache is transparent
+	return stored, err
+}
+
+// CompareAndSet сохраняет метрику при выполнении условия и кеширует
+// её значение. При конфликте значение в кеше сбрасывается.
+func (c *Cache) CompareAndSet(
+	ctx context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	stored, err := c.Storage.CompareAndSet(ctx, metric, cond)
+	c.written(metric.Key(), stored, err)
+	//nolint:wrapcheck // the cache is transparent
+	return stored, err
+}
+
+// Batch сохраняет метрики и кеширует их значения после слияния.
+func (c *Cache) Batch(ctx context.Context, metrics []model.Metric) ([]model.Metric, error) {
+	stored, err := c.Storage.Batch(ctx, metrics)
+	if err != nil {
+		for _, m := range metrics {
+			c.Invalidate(m.Key(), 0)
+		}
+		//nolint:wrapcheck // the cache is transparent
+		return nil, err
+	}
+	for _, m := range stored {
+		c.written(m.Key(), m, nil)
+	}
+	return stored, nil
+}
+
+// Delete удаляет метрику из хранилища и из кеша.
+func (c *Cache) Delete(ctx context.Context, key model.MetricKey) error {
+	err := c.Storage.Delete(ctx, key)
+	c.Invalidate(key, 0)
+	//nolint:wrapcheck // the cache is transparent
+	return err
+}
+
+// replacer — хранилище, метрики которого можно заменить целиком.
+type replacer interface {
+	Replace(ctx context.Context, metrics []model.Metric) error
+}
+
+// Replace заменяет все метрики хранилища и сбрасывает кеш целиком.
+func (c *Cache) Replace(ctx context.Context, metrics []model.Metric) error {
+	r, ok := c.Storage.(replacer)
+	if !ok {
+		return errors.New("storage does not support replacing metrics")
+	}
+	err := r.Replace(ctx, metrics)
+	c.Flush()
+	//nolint:wrapcheck // the cache is transparent
+	return err
+}
+
+// Reset обнуляет счётчик и сбрасывает его значение в кеше.
+func (c *Cache) Reset(ctx context.Context, key model.MetricKey) error {
+	err := c.Storage.Reset(ctx, key)
+	c.Invalidate(key, 0)
+	//nolint:wrapcheck // the cache is transparent
+	return err
+}
+
+// written кеширует результат записи. Значение без ревизии
+// (например, записанное в журнал во время сбоя) не кешируется.
+func (c *Cache) written(key model.MetricKey, stored model.Metric, err error) {
+	if err != nil || stored.Revision == 0 {
+		c.Invalidate(key, 0)
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, ok := c.entry(key); ok && e.lease == 0 && e.metric.Revision > stored.Revision {
+		return
+	}
+	c.put(key, stored)
+}
+
+// Invalidate сбрасывает значение метрики key, если его ревизия
+// меньше revision. Ревизия 0 сбрасывает значение безусловно.
+func (c *Cache) Invalidate(key model.MetricKey, revision int64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, ok := c.entry(key)
+	if !ok {
+		return
+	}
+	if revision == 0 || e.lease != 0 || e.metric.Revision < revision {
+		c.remove(key)
+	}
+}
+
+// Flush сбрасывает весь кеш.
+func (c *Cache) Flush() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.entries = make(map[model.MetricKey]*list.Element, c.size)
+	c.lru.Init()
+	c.log.Debug().Msg("metric cache flushed")
+}
+
+// Stats возвращает статистику кеша.
+func (c *Cache) Stats() Stats {
+	c.m.Lock()
+	size := c.lru.Len()
+	c.m.Unlock()
+
+	return Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+		Size:   size,
+	}
+}
+
+// Run периодически записывает статистику кеша в хранилище, см. Stats.Metrics,
+// и в лог.
+func (c *Cache) Run(ctx context.Context) {
+	ticker := time.NewTicker(StatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.record(ctx)
+		}
+	}
+}
+
+// record записывает текущую статистику кеша.
+func (c *Cache) record(ctx context.Context) {
+	s := c.Stats()
+	c.log.Info().
+		Uint64("hits", s.Hits).
+		Uint64("misses", s.Misses).
+		Int("size", s.Size).
+		Msg("metric cache stats")
+	if _, err := c.Batch(ctx, s.Metrics(c.now().UTC())); err != nil {
+		c.log.Error().Err(err).Msg("failed to record metric cache stats")
+	}
+}
+
+// lookup возвращает закешированную метрику, а при промахе
+// выдаёт аренду на заполнение.
+func (c *Cache) lookup(key model.MetricKey) (model.Metric, uint64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	e, ok := c.entry(key)
+	if ok && e.lease == 0 && c.now().Before(e.expires) {
+		c.lru.MoveToFront(c.entries[key])
+		c.hits.Add(1)
+		return e.metric, 0, true
+	}
+	c.misses.Add(1)
+	c.leases++
+	c.insert(&entry{key: key, lease: c.leases})
+	return model.Metric{}, c.leases, false
+}
+
+// fill сохраняет прочитанное значение, если аренда ещё действует.
+func (c *Cache) fill(key model.MetricKey, lease uint64, metric model.Metric) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, ok := c.entry(key); ok && e.lease == lease {
+		c.put(key, metric)
+	}
+}
+
+// release снимает аренду после неудачного чтения.
+func (c *Cache) release(key model.MetricKey, lease uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if e, ok := c.entry(key); ok && e.lease == lease {
+		c.remove(key)
+	}
+}
+
+func (c *Cache) entry(key model.MetricKey) (*entry, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e, ok := el.Value.(*entry)
+	return e, ok
+}
+
+func (c *Cache) put(key model.MetricKey, metric model.Metric) {
+	c.insert(&entry{key: key, metric: metric, expires: c.now().Add(c.ttl)})
+}
+
+func (c *Cache) insert(e *entry) {
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest, ok := c.lru.Back().Value.(*entry)
+		if !ok {
+			break
+		}
+		c.remove(oldest.key)
+	}
+}
+
+func (c *Cache) remove(key model.MetricKey) {
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
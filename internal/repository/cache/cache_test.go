@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+)
+
+// countingStorage считает чтения и позволяет вмешаться в чтение.
+type countingStorage struct {
+	*memory.Memory
+	onFind func()
+	finds  int
+}
+
+func (s *countingStorage) Find(ctx context.Context, key model.MetricKey) (model.Metric, error) {
+	s.finds++
+	if s.onFind != nil {
+		s.onFind()
+	}
+	//nolint:wrapcheck // it's tests
+	return s.Memory.Find(ctx, key)
+}
+
+func newCache(size int) (*Cache, *countingStorage) {
+	storage := &countingStorage{Memory: memory.New(logger.NewNopLogger(), nil)}
+	return New(storage, logger.NewNopLogger(), size, time.Minute), storage
+}
+
+func counter(name string, delta int64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeCounter, Delta: &delta}
+}
+
+func gauge(name string, value float64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeGauge, Value: &value}
+}
+
+func key(m model.Metric) model.MetricKey {
+	return m.Key()
+}
+
+func TestCache_ReadThrough(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+	_, err := storage.Memory.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+
+	for range 3 {
+		found, err := c.Find(ctx, key(gauge("cpu", 0)))
+		require.NoError(t, err)
+		assert.InDelta(t, 1, *found.Value, 0)
+	}
+	assert.Equal(t, 1, storage.finds)
+	assert.Equal(t, Stats{Hits: 2, Misses: 1, Size: 1}, c.Stats())
+
+	var notFound *customerror.NotFoundError
+	_, err = c.Find(ctx, key(gauge("ram", 0)))
+	require.ErrorAs(t, err, &notFound)
+	_, err = c.Find(ctx, key(gauge("ram", 0)))
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, 3, storage.finds)
+	assert.Equal(t, 1, c.Stats().Size)
+}
+
+func TestCache_RecordStats(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+	_, err := c.Find(ctx, key(gauge("cpu", 0)))
+	require.Error(t, err)
+
+	c.record(ctx)
+	// статистика накопительная: повторная запись не удваивает счётчики
+	c.record(ctx)
+
+	misses, err := storage.Memory.Find(ctx, key(counter(MetricMisses, 0)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *misses.Delta)
+	hits, err := storage.Memory.Find(ctx, key(counter(MetricHits, 0)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), *hits.Delta)
+	// записанные метрики статистики тоже попадают в кеш
+	size, err := storage.Memory.Find(ctx, key(gauge(MetricSize, 0)))
+	require.NoError(t, err)
+	assert.InDelta(t, 3, *size.Value, 0)
+}
+
+func TestCache_WritesCounterSum(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+
+	_, err := c.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+	_, err = c.Batch(ctx, []model.Metric{counter("hits", 3), counter("hits", 4)})
+	require.NoError(t, err)
+
+	found, err := c.Find(ctx, key(counter("hits", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), *found.Delta)
+	assert.Equal(t, int64(3), found.Revision)
+	assert.Equal(t, 0, storage.finds)
+
+	require.NoError(t, c.Reset(ctx, key(counter("hits", 0))))
+	found, err = c.Find(ctx, key(counter("hits", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), *found.Delta)
+	assert.Equal(t, 1, storage.finds)
+
+	require.NoError(t, c.Delete(ctx, key(counter("hits", 0))))
+	_, err = c.Find(ctx, key(counter("hits", 0)))
+	var notFound *customerror.NotFoundError
+	require.ErrorAs(t, err, &notFound)
+}
+
+func TestCache_CompareAndSetConflict(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+	_, err := c.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+	// запись другого экземпляра, уведомление о которой ещё не пришло
+	_, err = storage.Memory.Add(ctx, gauge("cpu", 2))
+	require.NoError(t, err)
+
+	revision := int64(1)
+	_, err = c.CompareAndSet(ctx, gauge("cpu", 3), model.Condition{Revision: &revision})
+	var conflict *customerror.ConflictError
+	require.ErrorAs(t, err, &conflict)
+
+	found, err := c.Find(ctx, key(gauge("cpu", 0)))
+	require.NoError(t, err)
+	assert.InDelta(t, 2, *found.Value, 0)
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+	stored, err := c.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+
+	// уведомление о собственной записи не сбрасывает кеш
+	c.Invalidate(stored.Key(), stored.Revision)
+	_, err = c.Find(ctx, stored.Key())
+	require.NoError(t, err)
+	assert.Equal(t, 0, storage.finds)
+
+	updated, err := storage.Memory.Add(ctx, gauge("cpu", 2))
+	require.NoError(t, err)
+	c.Invalidate(updated.Key(), updated.Revision)
+	found, err := c.Find(ctx, stored.Key())
+	require.NoError(t, err)
+	assert.InDelta(t, 2, *found.Value, 0)
+	assert.Equal(t, 1, storage.finds)
+
+	// запоздавший результат записи не заменяет более новое значение
+	c.written(stored.Key(), stored, nil)
+	found, err = c.Find(ctx, stored.Key())
+	require.NoError(t, err)
+	assert.InDelta(t, 2, *found.Value, 0)
+
+	c.Flush()
+	assert.Equal(t, 0, c.Stats().Size)
+}
+
+func TestCache_ChangeDuringRead(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+	_, err := storage.Memory.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+
+	// значение меняется после того, как чтение его получило,
+	// но до заполнения кеша
+	storage.onFind = func() {
+		storage.onFind = nil
+		c.Invalidate(key(gauge("cpu", 0)), 2)
+	}
+	_, err = c.Find(ctx, key(gauge("cpu", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Stats().Size)
+}
+
+func TestCache_EvictionAndTTL(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(2)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := c.Add(ctx, gauge(name, 1))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, c.Stats().Size)
+	_, err := c.Find(ctx, key(gauge("a", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, storage.finds)
+
+	now = now.Add(2 * time.Minute)
+	_, err = c.Find(ctx, key(gauge("a", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, storage.finds)
+}
+
+func TestCache_ReadsPrimary(t *testing.T) {
+	ctx := context.Background()
+	c, storage := newCache(10)
+	_, err := c.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+
+	_, err = c.Find(db.WithPrimary(ctx), key(gauge("cpu", 0)))
+	require.NoError(t, err)
+	assert.Equal(t, 1, storage.finds)
+	assert.Equal(t, Stats{Size: 1}, c.Stats())
+}
@@ -5,7 +5,8 @@ import (
 	"embed"
 	"errors"
 	"fmt"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -24,16 +25,20 @@ import (
 )
 
 type DB struct {
-	pool   *pgxpool.Pool
-	log    *logger.ZeroLogger
-	buffer *queue.Queue[model.Metric]
+	pool      *pgxpool.Pool
+	replicas  *replicaSet
+	log       *logger.ZeroLogger
+	buffer    *queue.Queue[model.Change]
+	watchStop context.CancelFunc
+	watchWG   sync.WaitGroup
+	overflow  model.OverflowPolicy
 }
 
 func New(
 	ctx context.Context,
 	dsn string,
 	log *logger.ZeroLogger,
-	buf *queue.Queue[model.Metric],
+	buf *queue.Queue[model.Change],
 ) (*DB, error) {
 	if err := runMigrations(dsn); err != nil {
 		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
@@ -43,15 +48,25 @@ func New(
 		return nil, fmt.Errorf("failed to init DB pool: %w", err)
 	}
 	return &DB{
-		pool:   pool,
-		log:    log,
-		buffer: buf,
+		pool:     pool,
+		log:      log,
+		buffer:   buf,
+		overflow: model.OverflowSaturate,
 	}, nil
 }
 
+// SetOverflowPolicy задаёт поведение счётчиков при переполнении.
+func (db *DB) SetOverflowPolicy(policy model.OverflowPolicy) {
+	db.overflow = policy
+}
+
 //go:embed migrations/*.sql
 var migrationsDir embed.FS
 
+// listScanSize — размер страницы, которой Get читает список,
+// когда имя фильтруется регулярным выражением.
+const listScanSize = 1000
+
 func runMigrations(dsn string) error {
 	d, err := iofs.New(migrationsDir, "migrations")
 	if err != nil {
@@ -87,65 +102,407 @@ func initPool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 }
 
 const (
-	tryNameQuery = `INSERT INTO designation(name_designation)
-VALUES ($1)
-ON CONFLICT (name_designation) DO NOTHING;`
+	// upsertPrefix и upsertSuffix обрамляют вставку метрики в запрос,
+	// который за один проход сохраняет имя, метрику, её метки и точку истории.
+	// Общие параметры: $1 — имя, $2 — тип, $3 — метки в каноническом виде,
+	// $4 — время получения, $5 и $6 — ключи и значения меток, $7 — арендатор.
+	// Запрос возвращает значения метрики после слияния.
+	// Имя и метки обновляются при конфликте, чтобы RETURNING вернул
+	// идентификаторы и уже существующих строк.
+	upsertPrefix = `WITH name AS (
+    INSERT INTO designation(name_designation)
+    VALUES ($1)
+    ON CONFLICT (name_designation) DO UPDATE
+    SET name_designation = EXCLUDED.name_designation
+    RETURNING id_designation
+), upserted AS (
+`
+
+	// значения метрики после слияния, их возвращает весь запрос.
+	upsertReturning = `
+RETURNING
+    id_metric, delta_metric, value_metric,
+    hist_bounds_metric, hist_counts_metric, hist_sum_metric, hist_count_metric,
+    last_updated_metric, total_metric, resets_metric, revision_metric`
 
-	gaugeQuery = `INSERT INTO metric(value_metric, type_metric, name_metric)
+	// в историю попадает значение метрики после слияния
+	upsertSuffix = `
+), labels AS (
+    INSERT INTO label(key_label, value_label)
+    SELECT * FROM unnest($5::VARCHAR[], $6::VARCHAR[])
+    ON CONFLICT (key_label, value_label) DO UPDATE
+    SET key_label = EXCLUDED.key_label
+    RETURNING id_label
+), linked AS (
+    INSERT INTO metric_label(metric_metric_label, label_metric_label)
+    SELECT u.id_metric, l.id_label
+    FROM upserted u CROSS JOIN labels l
+    ON CONFLICT DO NOTHING
+), sampled AS (
+    INSERT INTO metric_history(
+        metric_metric_history, time_metric_history, value_metric_history)
+    SELECT
+        id_metric,
+        COALESCE($4, now()),
+        COALESCE(value_metric, delta_metric::DOUBLE PRECISION, hist_count_metric::DOUBLE PRECISION)
+    FROM upserted
+)
+SELECT
+    delta_metric, value_metric,
+    hist_bounds_metric, hist_counts_metric, hist_sum_metric, hist_count_metric,
+    last_updated_metric, total_metric, resets_metric, revision_metric
+FROM upserted;`
+
+	// $8 — значение.
+	gaugeQuery = upsertPrefix + `INSERT INTO metric(
+    value_metric, labels_metric, tenant_metric, last_updated_metric,
+    type_metric, name_metric)
 VALUES (
+    $8,
     $3,
+    $7,
+    $4,
     (SELECT id_type FROM type WHERE name_type = $2),
-    (SELECT id_designation FROM designation WHERE name_designation = $1)
+    (SELECT id_designation FROM name)
 )
-ON CONFLICT (type_metric, name_metric) DO UPDATE
-SET value_metric = EXCLUDED.value_metric;`
+ON CONFLICT (tenant_metric, type_metric, name_metric, labels_metric) DO UPDATE
+SET value_metric = EXCLUDED.value_metric,
+    last_updated_metric = COALESCE(
+        EXCLUDED.last_updated_metric, metric.last_updated_metric),
+    revision_metric = metric.revision_metric + 1
+` + upsertReturning + upsertSuffix
 
-	counterQuery = `INSERT INTO metric(delta_metric, type_metric, name_metric)
+	// $8 — приращение или итог. Семантика совпадает с model.Metric.Update:
+	// для накопительного счётчика ($9) приращение считается от предыдущего
+	// итога, уменьшение итога — сброс; переполнение int64 либо
+	// заворачивается ($10), либо насыщается.
+	counterQuery = upsertPrefix + `INSERT INTO metric(
+    delta_metric, total_metric, labels_metric, tenant_metric, last_updated_metric,
+    type_metric, name_metric)
 VALUES (
+    $8,
+    CASE WHEN $9::BOOLEAN THEN $8::BIGINT END,
     $3,
+    $7,
+    $4,
     (SELECT id_type FROM type WHERE name_type = $2),
-    (SELECT id_designation FROM designation WHERE name_designation = $1)
+    (SELECT id_designation FROM name)
 )
-ON CONFLICT (type_metric, name_metric) DO UPDATE
-SET delta_metric = metric.delta_metric + EXCLUDED.delta_metric;`
+ON CONFLICT (tenant_metric, type_metric, name_metric, labels_metric) DO UPDATE
+SET (delta_metric, total_metric, resets_metric, last_updated_metric) = (
+    SELECT
+        CASE
+            WHEN s.sum BETWEEN -9223372036854775808 AND 9223372036854775807
+                THEN s.sum
+            WHEN $10::BOOLEAN
+                THEN mod(mod(s.sum + 9223372036854775808, 18446744073709551616)
+                    + 18446744073709551616, 18446744073709551616)
+                    - 9223372036854775808
+            WHEN s.sum > 0 THEN 9223372036854775807
+            ELSE -9223372036854775808
+        END::BIGINT,
+        COALESCE(EXCLUDED.total_metric, metric.total_metric),
+        metric.resets_metric
+            + s.reset::INT
+            + ($10::BOOLEAN AND s.sum NOT BETWEEN
+                -9223372036854775808 AND 9223372036854775807)::INT,
+        COALESCE(EXCLUDED.last_updated_metric, metric.last_updated_metric)
+    FROM (SELECT
+        metric.delta_metric::NUMERIC + CASE
+            WHEN EXCLUDED.total_metric IS NULL OR metric.total_metric IS NULL
+                THEN EXCLUDED.delta_metric
+            WHEN EXCLUDED.total_metric < metric.total_metric
+                THEN EXCLUDED.total_metric
+            ELSE EXCLUDED.total_metric::NUMERIC - metric.total_metric
+        END AS sum,
+        COALESCE(EXCLUDED.total_metric < metric.total_metric, FALSE) AS reset
+    ) AS s
+),
+    revision_metric = metric.revision_metric + 1
+` + upsertReturning + upsertSuffix
 
-	findQuery = `SELECT 
-d.name_designation, t.name_type, m.delta_metric, m.value_metric
-FROM 
-	metric m
-JOIN 
-	designation d ON m.name_metric = d.id_designation
-JOIN
-	type t ON m.type_metric = t.id_type
-WHERE 
+	// $8-$11 — границы, счётчики корзин, сумма и число наблюдений.
+	// При несовпадении границ корзин строка не обновляется,
+	// и запрос не возвращает ни одной строки.
+	histogramQuery = upsertPrefix + `INSERT INTO metric(
+    hist_bounds_metric, hist_counts_metric, hist_sum_metric, hist_count_metric,
+    labels_metric, tenant_metric, last_updated_metric, type_metric, name_metric)
+VALUES (
+    $8, $9, $10, $11,
+    $3,
+    $7,
+    $4,
+    (SELECT id_type FROM type WHERE name_type = $2),
+    (SELECT id_designation FROM name)
+)
+ON CONFLICT (tenant_metric, type_metric, name_metric, labels_metric) DO UPDATE
+SET hist_counts_metric = (
+        SELECT array_agg(old_c + new_c ORDER BY i)
+        FROM unnest(metric.hist_counts_metric, EXCLUDED.hist_counts_metric)
+            WITH ORDINALITY AS c(old_c, new_c, i)
+    ),
+    hist_sum_metric = metric.hist_sum_metric + EXCLUDED.hist_sum_metric,
+    hist_count_metric = metric.hist_count_metric + EXCLUDED.hist_count_metric,
+    last_updated_metric = COALESCE(
+        EXCLUDED.last_updated_metric, metric.last_updated_metric),
+    revision_metric = metric.revision_metric + 1
+WHERE metric.hist_bounds_metric = EXCLUDED.hist_bounds_metric
+` + upsertReturning + upsertSuffix
+
+	// добавляет в историю текущее значение метрики $1
+	historyQuery = `INSERT INTO metric_history(
+    metric_metric_history, time_metric_history, value_metric_history)
+SELECT
+    id_metric,
+    COALESCE($2, now()),
+    COALESCE(value_metric, delta_metric::DOUBLE PRECISION, hist_count_metric::DOUBLE PRECISION)
+FROM metric
+WHERE id_metric = $1;`
+
+	rangeQuery = `SELECT h.time_metric_history, h.value_metric_history
+FROM metric_history h
+JOIN metric m ON h.metric_metric_history = m.id_metric
+JOIN designation d ON m.name_metric = d.id_designation
+JOIN type t ON m.type_metric = t.id_type
+WHERE
+	t.name_type = $1
+	AND d.name_designation = $2
+	AND m.labels_metric = $3
+	AND m.tenant_metric = $4
+	AND h.time_metric_history BETWEEN $5 AND $6
+ORDER BY h.time_metric_history;`
+
+	rollupsQuery = `SELECT
+    r.start_metric_rollup, r.min_metric_rollup, r.max_metric_rollup,
+    r.sum_metric_rollup, r.last_metric_rollup, r.count_metric_rollup
+FROM metric_rollup r
+JOIN metric m ON r.metric_metric_rollup = m.id_metric
+JOIN designation d ON m.name_metric = d.id_designation
+JOIN type t ON m.type_metric = t.id_type
+WHERE
 	t.name_type = $1
-	AND d.name_designation = $2;`
+	AND d.name_designation = $2
+	AND m.labels_metric = $3
+	AND m.tenant_metric = $4
+	AND r.resolution_metric_rollup = $5
+	AND r.start_metric_rollup BETWEEN $6 AND $7
+ORDER BY r.start_metric_rollup;`
+
+	// сжимает исходные точки, завершившиеся до $2, в агрегаты
+	// разрешения $1 секунд; уже сжатые интервалы пропускаются.
+	rollupRawQuery = `INSERT INTO metric_rollup(
+    metric_metric_rollup, resolution_metric_rollup, start_metric_rollup,
+    min_metric_rollup, max_metric_rollup, sum_metric_rollup,
+    last_metric_rollup, count_metric_rollup)
+SELECT
+    h.metric_metric_history,
+    $1::BIGINT,
+    to_timestamp((floor(extract(epoch FROM h.time_metric_history) / $1::BIGINT)
+        * $1::BIGINT)::DOUBLE PRECISION),
+    min(h.value_metric_history),
+    max(h.value_metric_history),
+    sum(h.value_metric_history),
+    (array_agg(h.value_metric_history ORDER BY h.time_metric_history DESC))[1],
+    count(*)
+FROM metric_history h
+WHERE h.time_metric_history < $2
+    AND h.time_metric_history >= COALESCE(
+        (SELECT max(r.start_metric_rollup) + make_interval(secs => $1::BIGINT)
+            FROM metric_rollup r
+            WHERE r.metric_metric_rollup = h.metric_metric_history
+                AND r.resolution_metric_rollup = $1::BIGINT),
+        '-infinity')
+GROUP BY 1, 3
+ON CONFLICT DO NOTHING;`
+
+	// сжимает агрегаты разрешения $2 в агрегаты разрешения $1.
+	rollupTierQuery = `INSERT INTO metric_rollup(
+    metric_metric_rollup, resolution_metric_rollup, start_metric_rollup,
+    min_metric_rollup, max_metric_rollup, sum_metric_rollup,
+    last_metric_rollup, count_metric_rollup)
+SELECT
+    s.metric_metric_rollup,
+    $1::BIGINT,
+    to_timestamp((floor(extract(epoch FROM s.start_metric_rollup) / $1::BIGINT)
+        * $1::BIGINT)::DOUBLE PRECISION),
+    min(s.min_metric_rollup),
+    max(s.max_metric_rollup),
+    sum(s.sum_metric_rollup),
+    (array_agg(s.last_metric_rollup ORDER BY s.start_metric_rollup DESC))[1],
+    sum(s.count_metric_rollup)
+FROM metric_rollup s
+WHERE s.resolution_metric_rollup = $2
+    AND s.start_metric_rollup < $3
+    AND s.start_metric_rollup >= COALESCE(
+        (SELECT max(r.start_metric_rollup) + make_interval(secs => $1::BIGINT)
+            FROM metric_rollup r
+            WHERE r.metric_metric_rollup = s.metric_metric_rollup
+                AND r.resolution_metric_rollup = $1::BIGINT),
+        '-infinity')
+GROUP BY 1, 3
+ON CONFLICT DO NOTHING;`
+
+	// история, агрегаты и связи с метками удаляются каскадно.
+	deleteQuery = `DELETE FROM metric m
+USING designation d, type t
+WHERE
+	m.name_metric = d.id_designation
+	AND m.type_metric = t.id_type
+	AND t.name_type = $1
+	AND d.name_designation = $2
+	AND m.labels_metric = $3
+	AND m.tenant_metric = $4
+RETURNING m.name_metric, m.revision_metric;`
+
+	orphanNameQuery = `DELETE FROM designation d
+WHERE d.id_designation = $1
+	AND NOT EXISTS (SELECT 1 FROM metric m WHERE m.name_metric = d.id_designation);`
+
+	resetQuery = `UPDATE metric m
+SET delta_metric = 0,
+    resets_metric = m.resets_metric + 1,
+    last_updated_metric = $5,
+    revision_metric = m.revision_metric + 1
+FROM designation d, type t
+WHERE
+	m.name_metric = d.id_designation
+	AND m.type_metric = t.id_type
+	AND t.name_type = $1
+	AND d.name_designation = $2
+	AND m.labels_metric = $3
+	AND m.tenant_metric = $4
+RETURNING m.id_metric, m.revision_metric;`
+
+	// вставляет метрику в том состоянии, в котором её вернула резервная копия.
+	// $8-$13 — дельта, значение и гистограмма, $14 — итог, $15 — сбросы,
+	// $16 — ревизия. Таблица к этому моменту пуста и заблокирована.
+	restoreQuery = upsertPrefix + `INSERT INTO metric(
+    delta_metric, value_metric,
+    hist_bounds_metric, hist_counts_metric, hist_sum_metric, hist_count_metric,
+    total_metric, resets_metric, revision_metric,
+    labels_metric, tenant_metric, last_updated_metric, type_metric, name_metric)
+VALUES (
+    $8, $9, $10, $11, $12, $13, $14, $15, $16,
+    $3,
+    $7,
+    $4,
+    (SELECT id_type FROM type WHERE name_type = $2),
+    (SELECT id_designation FROM name)
+)` + upsertReturning + upsertSuffix
+
+	// запись в таблицу метрик ждёт конца замены, чтение — нет.
+	lockMetricsQuery = `LOCK TABLE metric IN EXCLUSIVE MODE;`
+
+	// история, агрегаты и связи с метками удаляются каскадно.
+	clearQuery = `DELETE FROM metric;`
+
+	clearNamesQuery = `DELETE FROM designation;`
 
-	getQuery = `SELECT 
-d.name_designation, t.name_type, m.delta_metric, m.value_metric
+	// проверяет условие записи и блокирует строку метрики до конца транзакции.
+	// $5 — ожидаемая ревизия, $6 и $7 — ожидаемые значения датчика и счётчика,
+	// NULL снимает проверку.
+	conditionQuery = `UPDATE metric m
+SET revision_metric = m.revision_metric
+FROM designation d, type t
+WHERE
+	m.name_metric = d.id_designation
+	AND m.type_metric = t.id_type
+	AND t.name_type = $1
+	AND d.name_designation = $2
+	AND m.labels_metric = $3
+	AND m.tenant_metric = $4
+	AND ($5::BIGINT IS NULL OR m.revision_metric = $5)
+	AND ($6::DOUBLE PRECISION IS NULL OR m.value_metric = $6)
+	AND ($7::BIGINT IS NULL OR m.delta_metric = $7)
+RETURNING m.revision_metric;`
+
+	expireHistoryQuery = `DELETE FROM metric_history WHERE time_metric_history < $1;`
+
+	expireRollupsQuery = `DELETE FROM metric_rollup
+WHERE resolution_metric_rollup = $1 AND start_metric_rollup < $2;`
+
+	selectQuery = `SELECT 
+d.name_designation, t.name_type, m.delta_metric, m.value_metric,
+m.hist_bounds_metric, m.hist_counts_metric, m.hist_sum_metric, m.hist_count_metric,
+m.last_updated_metric, m.total_metric, m.resets_metric, m.tenant_metric,
+m.revision_metric,
+(SELECT json_object_agg(l.key_label, l.value_label)
+    FROM metric_label ml
+    JOIN label l ON ml.label_metric_label = l.id_label
+    WHERE ml.metric_metric_label = m.id_metric)
 FROM 
 	metric m
 JOIN 
 	designation d ON m.name_metric = d.id_designation
 JOIN
 	type t ON m.type_metric = t.id_type`
+
+	findQuery = selectQuery + `
+WHERE 
+	t.name_type = $1
+	AND d.name_designation = $2
+	AND m.labels_metric = $3
+	AND m.tenant_metric = $4;`
+
+	findByLabelsQuery = selectQuery + `
+WHERE 
+	t.name_type = $1
+	AND d.name_designation = $2
+	AND m.tenant_metric = $5
+	AND (SELECT count(*)
+		FROM metric_label ml
+		JOIN label l ON ml.label_metric_label = l.id_label
+		JOIN unnest($3::VARCHAR[], $4::VARCHAR[]) AS n(k, v)
+			ON l.key_label = n.k AND l.value_label = n.v
+		WHERE ml.metric_metric_label = m.id_metric
+	) = cardinality($3::VARCHAR[]);`
+
+	// порядок совпадает с model.CompareKeys: строки сравниваются побайтно.
+	// $3-$5 и $8 — ключ, после которого начинается страница; $6 — размер страницы,
+	// NULL снимает ограничение; $7 — арендатор, NULL — все арендаторы.
+	// Регулярное выражение имени проверяется не здесь, а в Get.
+	listQuery = selectQuery + `
+WHERE
+	($7::TEXT IS NULL OR m.tenant_metric = $7)
+	AND ($1 = '' OR t.name_type = $1)
+	AND starts_with(d.name_designation, $2)
+	AND ($3::TEXT IS NULL OR
+		(m.tenant_metric COLLATE "C", d.name_designation COLLATE "C",
+			t.name_type COLLATE "C", m.labels_metric COLLATE "C")
+		> ($8::TEXT COLLATE "C", $3::TEXT COLLATE "C", $4::TEXT COLLATE "C", $5::TEXT COLLATE "C"))
+ORDER BY
+	m.tenant_metric COLLATE "C", d.name_designation COLLATE "C",
+	t.name_type COLLATE "C", m.labels_metric COLLATE "C"
+LIMIT $6;`
 )
 
-func (db *DB) Add(ctx context.Context, m model.Metric) error {
-	if err := push(ctx, m, db.pool); err != nil {
-		return fmt.Errorf("failed to add the metric %s: %w", m.String(), err)
-	}
-	if db.buffer != nil && !db.buffer.IsClosed() {
-		db.buffer.Push(m)
+// Add сохраняет метрику и возвращает её значение после слияния.
+func (db *DB) Add(ctx context.Context, m model.Metric) (model.Metric, error) {
+	stored, err := push(ctx, m, db.pool, db.overflow)
+	if err != nil {
+		return model.Metric{},
+			fmt.Errorf("failed to add the metric %s: %w", m.String(), err)
 	}
+	m.Revision = stored.Revision
+	db.changed(model.Change{Metric: m})
 
-	return nil
+	return stored, nil
 }
 
-func (db *DB) Batch(ctx context.Context, batch []model.Metric) error {
+// Batch отправляет все метрики одним пакетом в транзакции:
+// запросы выполняются за один обмен с сервером, а благодаря кешу
+// подготовленных выражений pgx каждый тип запроса разбирается один раз.
+// Пакет применяется целиком или не применяется вовсе: конфликт раскладки
+// корзин гистограммы не является ошибкой SQL, поэтому транзакция
+// откатывается явно. Возвращает значения метрик после слияния.
+func (db *DB) Batch(ctx context.Context, batch []model.Metric) ([]model.Metric, error) {
+	if len(batch) == 0 {
+		return []model.Metric{}, nil
+	}
+
 	tx, err := db.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to start transaction: %w", err)
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer func() {
 		err := tx.Rollback(ctx)
@@ -155,74 +512,533 @@ func (db *DB) Batch(ctx context.Context, batch []model.Metric) error {
 				Msg("rollback failed")
 		}
 	}()
+
+	b := &pgx.Batch{}
+	for _, m := range batch {
+		query, args := upsert(m, db.overflow)
+		b.Queue(query, args...)
+	}
+	results := tx.SendBatch(ctx, b)
+	merged := make([]model.Metric, 0, len(batch))
 	for _, m := range batch {
-		err = push(ctx, m, tx)
-		if err == nil {
-			if db.buffer != nil && !db.buffer.IsClosed() {
-				db.buffer.Push(m)
+		stored, err := fromUpsert(results.QueryRow(), m)
+		if err != nil {
+			if closeErr := results.Close(); closeErr != nil {
+				db.log.Err(closeErr).Msg("failed to close batch results")
 			}
-			continue
+			return nil, fmt.Errorf("batch failed on the metric %s: %w", m.String(), err)
+		}
+		merged = append(merged, stored)
+	}
+	if err = results.Close(); err != nil {
+		return nil, fmt.Errorf("batch failed: %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit failed: %w", err)
+	}
+
+	for i, m := range batch {
+		m.Revision = merged[i].Revision
+		db.changed(model.Change{Metric: m})
+	}
+	return merged, nil
+}
+
+// changed передаёт изменение в буфер резервного копирования.
+func (db *DB) changed(c model.Change) {
+	if db.buffer != nil && !db.buffer.IsClosed() {
+		db.buffer.Push(c)
+	}
+}
+
+// querier — пул соединений или транзакция.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func push(
+	ctx context.Context, m model.Metric, q querier, overflow model.OverflowPolicy,
+) (model.Metric, error) {
+	query, args := upsert(m, overflow)
+	return fromUpsert(q.QueryRow(ctx, query, args...), m)
+}
+
+// upsert возвращает запрос, сохраняющий метрику m, и его аргументы.
+func upsert(m model.Metric, overflow model.OverflowPolicy) (string, []any) {
+	keys, values := m.Labels.Keys()
+	args := []any{
+		m.Name, m.Type.String(), m.Labels.String(), m.Timestamp, keys, values, m.Tenant,
+	}
+	switch m.Type {
+	case model.MetricTypeGauge:
+		return gaugeQuery, append(args, m.ActualValue())
+	case model.MetricTypeHistogram:
+		h := m.Histogram
+		return histogramQuery, append(args, h.Bounds, h.Counts, h.Sum, h.Count)
+	default:
+		return counterQuery, append(args,
+			m.ActualValue(), m.Cumulative, overflow == model.OverflowWrap)
+	}
+}
+
+// fromUpsert собирает метрику после слияния из ответа на upsert;
+// имя, тип, метки и арендатор берутся из сохраняемой метрики m.
+func fromUpsert(row pgx.Row, m model.Metric) (model.Metric, error) {
+	stored := model.Metric{Name: m.Name, Type: m.Type, Labels: m.Labels, Tenant: m.Tenant}
+	var hist model.Histogram
+	var histSum *float64
+	var histCount *int64
+	err := row.Scan(
+		&stored.Delta,
+		&stored.Value,
+		&hist.Bounds,
+		&hist.Counts,
+		&histSum,
+		&histCount,
+		&stored.Timestamp,
+		&stored.Total,
+		&stored.Resets,
+		&stored.Revision,
+	)
+	if m.Type == model.MetricTypeHistogram && errors.Is(err, pgx.ErrNoRows) {
+		return model.Metric{}, &customerror.InvalidArgumentError{
+			Info: "histogram bucket layouts differ for metric " + m.Name,
+		}
+	}
+	if err != nil {
+		return model.Metric{}, fmt.Errorf("DB upsert error: %w", err)
+	}
+	if histCount != nil && histSum != nil {
+		hist.Sum = *histSum
+		hist.Count = *histCount
+		stored.Histogram = &hist
+	}
+	return stored, nil
+}
+
+// CompareAndSet сохраняет метрику, только если её текущее состояние
+// удовлетворяет условию cond. Условие проверяется запросом UPDATE ... WHERE,
+// который блокирует строку метрики, и запись выполняется в той же транзакции.
+// Если метрика не должна существовать, строку заблокировать нельзя:
+// тогда конкурирующая вставка обнаруживается по ревизии после записи.
+func (db *DB) CompareAndSet(
+	ctx context.Context, m model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	if err := cond.CheckValid(); err != nil {
+		return model.Metric{}, fmt.Errorf("invalid condition: %w", err)
+	}
+	conflict := &customerror.ConflictError{
+		Info: "condition does not match metric " + m.Key().String(),
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return model.Metric{}, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.log.
+				Err(err).
+				Msg("rollback failed")
+		}
+	}()
+
+	var revision int64
+	if !cond.Absent() {
+		key := m.Key()
+		err = tx.QueryRow(ctx, conditionQuery,
+			key.Type.String(), key.Name, key.Labels, key.Tenant,
+			cond.Revision, cond.Value, cond.Delta).Scan(&revision)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.Metric{}, conflict
+		}
+		if err != nil {
+			return model.Metric{}, fmt.Errorf("failed to check the condition: %w", err)
 		}
+	}
+
+	stored, err := push(ctx, m, tx, db.overflow)
+	if err != nil {
+		return model.Metric{},
+			fmt.Errorf("failed to add the metric %s: %w", m.String(), err)
+	}
+	if stored.Revision != revision+1 {
+		return model.Metric{}, conflict
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return model.Metric{}, fmt.Errorf("commit failed: %w", err)
+	}
+	m.Revision = stored.Revision
+	db.changed(model.Change{Metric: m})
+	return stored, nil
+}
 
-		db.log.
-			Err(err).
-			Msg("failed to add the metric: " + m.String())
-		if err = tx.Rollback(ctx); err != nil {
-			return fmt.Errorf("rollback failed: %w", err)
+// Delete удаляет метрику вместе с историей и агрегатами,
+// а также имя метрики, если оно больше не используется.
+func (db *DB) Delete(ctx context.Context, key model.MetricKey) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.log.
+				Err(err).
+				Msg("rollback failed")
 		}
-		return fmt.Errorf("transaction failed: %w", err)
+	}()
+
+	var nameID int
+	var revision int64
+	err = tx.QueryRow(ctx, deleteQuery,
+		key.Type.String(), key.Name, key.Labels, key.Tenant).Scan(&nameID, &revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &customerror.NotFoundError{Info: "metric " + key.String()}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete the metric %s: %w", key, err)
+	}
+	if _, err = tx.Exec(ctx, orphanNameQuery, nameID); err != nil {
+		return fmt.Errorf("failed to delete unused metric name: %w", err)
 	}
 
 	if err = tx.Commit(ctx); err != nil {
 		return fmt.Errorf("commit failed: %w", err)
 	}
+	db.changed(model.NewDeletion(key, revision+1))
 	return nil
 }
 
-type executor interface {
-	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
-}
+// Reset обнуляет счётчик, см. model.Metric.Reset.
+func (db *DB) Reset(ctx context.Context, key model.MetricKey) error {
+	if key.Type != model.MetricTypeCounter {
+		return &customerror.InvalidArgumentError{
+			Info: "only counter metric can be reset",
+		}
+	}
+
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.log.
+				Err(err).
+				Msg("rollback failed")
+		}
+	}()
 
-func push(ctx context.Context, m model.Metric, e executor) error {
-	var err error
-	_, err = e.Exec(ctx, tryNameQuery, m.Name)
+	now := time.Now().UTC()
+	var id int
+	var revision int64
+	err = tx.QueryRow(ctx, resetQuery,
+		key.Type.String(), key.Name, key.Labels, key.Tenant, now).Scan(&id, &revision)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &customerror.NotFoundError{Info: "metric " + key.String()}
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update the metric name in DB: %w", err)
+		return fmt.Errorf("failed to reset the metric %s: %w", key, err)
+	}
+	if _, err = tx.Exec(ctx, historyQuery, id, now); err != nil {
+		return fmt.Errorf("DB history insert error: %w", err)
 	}
 
-	if m.Type == model.MetricTypeGauge {
-		_, err = e.Exec(
-			ctx, gaugeQuery, m.Name, m.Type.String(), m.ActualValue())
-	} else {
-		_, err = e.Exec(
-			ctx, counterQuery, m.Name, m.Type.String(), m.ActualValue())
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
 	}
+	db.changed(model.NewReset(key, revision, now))
+	return nil
+}
+
+// Replace заменяет все метрики хранилища метриками metrics в одной
+// транзакции: при ошибке прежнее состояние сохраняется. Запись в хранилище
+// ждёт конца замены. Метрики сохраняются без слияния, с их ревизиями,
+// и не попадают в буфер резервного копирования.
+func (db *DB) Replace(ctx context.Context, metrics []model.Metric) error {
+	tx, err := db.pool.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("DB insert error: %w", err)
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.log.
+				Err(err).
+				Msg("rollback failed")
+		}
+	}()
+
+	for _, query := range []string{lockMetricsQuery, clearQuery, clearNamesQuery} {
+		if _, err = tx.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to clear metrics: %w", err)
+		}
+	}
+	if len(metrics) != 0 {
+		b := &pgx.Batch{}
+		for _, m := range metrics {
+			b.Queue(restoreQuery, restoreArgs(m)...)
+		}
+		if err = tx.SendBatch(ctx, b).Close(); err != nil {
+			return fmt.Errorf("failed to insert metrics: %w", err)
+		}
 	}
 
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
 	return nil
 }
 
-func (db *DB) Find(ctx context.Context, typeAndName string) (model.Metric, error) {
-	const typePos = 0
-	const namePos = 1
-	result := strings.Split(typeAndName, " ")
+// restoreArgs возвращает аргументы restoreQuery для метрики m.
+func restoreArgs(m model.Metric) []any {
+	keys, values := m.Labels.Keys()
+	var bounds []float64
+	var counts []int64
+	var sum *float64
+	var count *int64
+	if h := m.Histogram; h != nil {
+		bounds, counts, sum, count = h.Bounds, h.Counts, &h.Sum, &h.Count
+	}
+	return []any{
+		m.Name, m.Type.String(), m.Labels.String(), m.Timestamp, keys, values, m.Tenant,
+		m.Delta, m.Value, bounds, counts, sum, count, m.Total, m.Resets, max(m.Revision, 1),
+	}
+}
 
-	row := db.pool.QueryRow(
-		ctx, findQuery, result[typePos], result[namePos])
-	metric, err := fromRow(row)
+func (db *DB) Find(ctx context.Context, key model.MetricKey) (model.Metric, error) {
+	var metric *model.Metric
+	err := db.read(ctx, func(pool *pgxpool.Pool) error {
+		var err error
+		metric, err = fromRow(pool.QueryRow(
+			ctx, findQuery, key.Type.String(), key.Name, key.Labels, key.Tenant))
+		return err
+	})
 	if err != nil {
 		return model.Metric{}, fmt.Errorf("failed DB query: %w", err)
 	}
 	return *metric, nil
 }
 
-func (db *DB) Get(ctx context.Context) ([]model.Metric, error) {
-	rows, err := db.pool.Query(ctx, getQuery)
+func (db *DB) FindByLabels(
+	ctx context.Context,
+	tenant string,
+	mType model.MetricType,
+	name string,
+	matchers model.Labels,
+) ([]model.Metric, error) {
+	keys, values := matchers.Keys()
+	var metrics []model.Metric
+	err := db.read(ctx, func(pool *pgxpool.Pool) error {
+		rows, err := pool.Query(
+			ctx, findByLabelsQuery, mType.String(), name, keys, values, tenant)
+		if err != nil {
+			return fmt.Errorf("failed to query DB: %w", err)
+		}
+		metrics, err = db.collect(rows)
+		return err
+	})
+	return metrics, err
+}
+
+func (db *DB) History(
+	ctx context.Context, key model.MetricKey, from, to time.Time,
+) ([]model.Sample, error) {
+	var samples []model.Sample
+	err := db.read(ctx, func(pool *pgxpool.Pool) error {
+		rows, err := pool.Query(ctx, rangeQuery,
+			key.Type.String(), key.Name, key.Labels, key.Tenant, from, to)
+		if err != nil {
+			return fmt.Errorf("failed to query DB: %w", err)
+		}
+		defer rows.Close()
+
+		samples = make([]model.Sample, 0)
+		for rows.Next() {
+			var s model.Sample
+			if err = rows.Scan(&s.Timestamp, &s.Value); err != nil {
+				return fmt.Errorf("failed to scan a history row: %w", err)
+			}
+			s.Timestamp = s.Timestamp.UTC()
+			samples = append(samples, s)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("failed to read rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func (db *DB) Rollups(
+	ctx context.Context,
+	key model.MetricKey,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]model.Rollup, error) {
+	var rollups []model.Rollup
+	err := db.read(ctx, func(pool *pgxpool.Pool) error {
+		rows, err := pool.Query(ctx, rollupsQuery,
+			key.Type.String(), key.Name, key.Labels, key.Tenant,
+			int64(resolution.Seconds()), from, to)
+		if err != nil {
+			return fmt.Errorf("failed to query DB: %w", err)
+		}
+		defer rows.Close()
+
+		rollups = make([]model.Rollup, 0)
+		for rows.Next() {
+			var r model.Rollup
+			if err = rows.Scan(
+				&r.Start, &r.Min, &r.Max, &r.Sum, &r.Last, &r.Count,
+			); err != nil {
+				return fmt.Errorf("failed to scan a rollup row: %w", err)
+			}
+			r.Start = r.Start.UTC()
+			rollups = append(rollups, r)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("failed to read rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// Compact сжимает завершённые интервалы в агрегаты каждого уровня
+// и удаляет данные, срок хранения которых истёк.
+func (db *DB) Compact(ctx context.Context, policy model.Retention, now time.Time) error {
+	tx, err := db.pool.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query DB: %w", err)
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		err := tx.Rollback(ctx)
+		if err != nil && !errors.Is(err, pgx.ErrTxClosed) {
+			db.log.
+				Err(err).
+				Msg("rollback failed")
+		}
+	}()
+
+	var source int64
+	for _, t := range policy.Tiers {
+		resolution := int64(t.Resolution.Seconds())
+		cutoff := model.BucketStart(now, t.Resolution)
+		if source == 0 {
+			_, err = tx.Exec(ctx, rollupRawQuery, resolution, cutoff)
+		} else {
+			_, err = tx.Exec(ctx, rollupTierQuery, resolution, source, cutoff)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to roll up samples by %s: %w", t.Resolution, err)
+		}
+		source = resolution
+	}
+
+	if policy.Raw > 0 {
+		if _, err = tx.Exec(ctx, expireHistoryQuery, now.Add(-policy.Raw)); err != nil {
+			return fmt.Errorf("failed to delete expired samples: %w", err)
+		}
+	}
+	for _, t := range policy.Tiers {
+		if t.Retention <= 0 {
+			continue
+		}
+		if _, err = tx.Exec(ctx, expireRollupsQuery,
+			int64(t.Resolution.Seconds()), now.Add(-t.Retention),
+		); err != nil {
+			return fmt.Errorf("failed to delete expired rollups: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+	return nil
+}
+
+// Get возвращает страницу метрик, отобранных по opts.
+// Get возвращает страницу списка метрик. Регулярное выражение имени
+// проверяется в Go, как и в остальных хранилищах: синтаксис POSIX-выражений
+// Postgres отличается от RE2. Поэтому с выражением список читается
+// страницами по listScanSize, пока не наберётся opts.Limit подходящих метрик.
+func (db *DB) Get(ctx context.Context, opts model.ListOptions) ([]model.Metric, error) {
+	if err := opts.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid list options: %w", err)
 	}
+	if opts.Pattern == "" {
+		return db.list(ctx, opts)
+	}
+
+	match := opts.Matcher()
+	scan := opts
+	scan.Pattern = ""
+	scan.Limit = max(opts.Limit, listScanSize)
+	metrics := make([]model.Metric, 0)
+	for {
+		page, err := db.list(ctx, scan)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page {
+			if !match(m.Key()) {
+				continue
+			}
+			metrics = append(metrics, m)
+			if len(metrics) == opts.Limit {
+				return metrics, nil
+			}
+		}
+		if len(page) < scan.Limit {
+			return metrics, nil
+		}
+		last := page[len(page)-1].Key()
+		scan.After = &last
+	}
+}
+
+// list возвращает страницу списка метрик без учёта opts.Pattern.
+func (db *DB) list(ctx context.Context, opts model.ListOptions) ([]model.Metric, error) {
+	var afterTenant, afterName, afterType, afterLabels *string
+	if opts.After != nil {
+		t := opts.After.Type.String()
+		afterTenant, afterName, afterType, afterLabels =
+			&opts.After.Tenant, &opts.After.Name, &t, &opts.After.Labels
+	}
+	var limit *int
+	if opts.Limit > 0 {
+		limit = &opts.Limit
+	}
+	tenant := &opts.Tenant
+	if opts.AllTenants {
+		tenant = nil
+	}
+
+	var metrics []model.Metric
+	err := db.read(ctx, func(pool *pgxpool.Pool) error {
+		rows, err := pool.Query(ctx, listQuery,
+			opts.Type.String(), opts.Prefix,
+			afterName, afterType, afterLabels, limit, tenant, afterTenant)
+		if err != nil {
+			return fmt.Errorf("failed to query DB: %w", err)
+		}
+		metrics, err = db.collect(rows)
+		return err
+	})
+	return metrics, err
+}
+
+func (db *DB) collect(rows pgx.Rows) ([]model.Metric, error) {
 	defer rows.Close()
 
 	metrics := make([]model.Metric, 0)
@@ -236,6 +1052,9 @@ func (db *DB) Get(ctx context.Context) ([]model.Metric, error) {
 		}
 		metrics = append(metrics, *m)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rows: %w", err)
+	}
 
 	return metrics, nil
 }
@@ -243,11 +1062,24 @@ func (db *DB) Get(ctx context.Context) ([]model.Metric, error) {
 func fromRow(row pgx.Row) (*model.Metric, error) {
 	var t string
 	var metric model.Metric
+	var hist model.Histogram
+	var histSum *float64
+	var histCount *int64
 	if err := row.Scan(
 		&metric.Name,
 		&t,
 		&metric.Delta,
 		&metric.Value,
+		&hist.Bounds,
+		&hist.Counts,
+		&histSum,
+		&histCount,
+		&metric.Timestamp,
+		&metric.Total,
+		&metric.Resets,
+		&metric.Tenant,
+		&metric.Revision,
+		&metric.Labels,
 	); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil,
@@ -258,11 +1090,21 @@ func fromRow(row pgx.Row) (*model.Metric, error) {
 		return nil, fmt.Errorf("failed to scan a response row: %w", err)
 	}
 	metric.Type = model.MetricType(t)
+	if histCount != nil && histSum != nil {
+		hist.Sum = *histSum
+		hist.Count = *histCount
+		metric.Histogram = &hist
+	}
 
 	return &metric, nil
 }
 
 func (db *DB) Close() {
+	if db.watchStop != nil {
+		db.watchStop()
+	}
+	db.watchWG.Wait()
+	db.replicas.close()
 	db.pool.Close()
 }
 
@@ -277,6 +1119,37 @@ func ping(ctx context.Context, pool *pgxpool.Pool) error {
 	return nil
 }
 
+// ValidateDSN проверяет строку подключения, не подключаясь к базе.
+func ValidateDSN(dsn string) error {
+	if _, err := pgxpool.ParseConfig(dsn); err != nil {
+		return fmt.Errorf("failed to parse the DSN: %w", err)
+	}
+	return nil
+}
+
+// IsUnavailable сообщает, что операция не выполнена из-за недоступности базы:
+// соединение не установлено, запрос не был отправлен
+// или сервер разорвал соединение до фиксации транзакции.
+// Ошибки, после которых исход операции неизвестен (например, таймаут),
+// недоступностью не считаются.
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgerrcode.IsConnectionException(pgErr.Code) ||
+		pgErr.Code == pgerrcode.AdminShutdown ||
+		pgErr.Code == pgerrcode.CrashShutdown ||
+		pgErr.Code == pgerrcode.CannotConnectNow
+}
+
 func WithConnectionCheck(dbMethod retry.Callback) (any, error) {
 	connectionPred := func(err error) bool {
 		var pgErr *pgconn.PgError
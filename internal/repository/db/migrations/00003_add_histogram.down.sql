@@ -0,0 +1,14 @@
+BEGIN TRANSACTION;
+
+DELETE FROM metric
+    WHERE type_metric = (SELECT id_type FROM type WHERE name_type = 'histogram');
+
+ALTER TABLE metric
+    DROP COLUMN hist_bounds_metric,
+    DROP COLUMN hist_counts_metric,
+    DROP COLUMN hist_sum_metric,
+    DROP COLUMN hist_count_metric;
+
+DELETE FROM type WHERE name_type = 'histogram';
+
+COMMIT;
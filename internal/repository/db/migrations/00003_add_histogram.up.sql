@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+
+INSERT INTO type(name_type)
+    VALUES ('histogram');
+
+ALTER TABLE metric
+    ADD COLUMN hist_bounds_metric DOUBLE PRECISION[] DEFAULT NULL,
+    ADD COLUMN hist_counts_metric BIGINT[] DEFAULT NULL,
+    ADD COLUMN hist_sum_metric DOUBLE PRECISION DEFAULT NULL,
+    ADD COLUMN hist_count_metric BIGINT DEFAULT NULL;
+
+COMMIT;
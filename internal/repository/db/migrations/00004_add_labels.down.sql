@@ -0,0 +1,17 @@
+BEGIN TRANSACTION;
+
+DROP TABLE metric_label;
+DROP TABLE label;
+
+DELETE FROM metric WHERE labels_metric <> '';
+
+ALTER TABLE metric
+    DROP CONSTRAINT unique_type_name_labels;
+
+ALTER TABLE metric
+    ADD CONSTRAINT unique_type_name UNIQUE (type_metric, name_metric);
+
+ALTER TABLE metric
+    DROP COLUMN labels_metric;
+
+COMMIT;
@@ -0,0 +1,28 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    ADD COLUMN labels_metric TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE metric
+    DROP CONSTRAINT unique_type_name;
+
+ALTER TABLE metric
+    ADD CONSTRAINT unique_type_name_labels
+        UNIQUE (type_metric, name_metric, labels_metric);
+
+CREATE TABLE label(
+    id_label INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    key_label VARCHAR(128) NOT NULL,
+    value_label VARCHAR(256) NOT NULL,
+    CONSTRAINT unique_key_value UNIQUE (key_label, value_label)
+);
+
+CREATE TABLE metric_label(
+    metric_metric_label INT NOT NULL REFERENCES metric(id_metric) ON DELETE CASCADE,
+    label_metric_label INT NOT NULL REFERENCES label(id_label),
+    PRIMARY KEY (metric_metric_label, label_metric_label)
+);
+
+CREATE INDEX idx_metric_label_label ON metric_label(label_metric_label);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    DROP COLUMN last_updated_metric;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    ADD COLUMN last_updated_metric TIMESTAMPTZ DEFAULT NULL;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    DROP COLUMN resets_metric,
+    DROP COLUMN total_metric;
+
+COMMIT;
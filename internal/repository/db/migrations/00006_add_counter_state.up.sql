@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    ADD COLUMN total_metric BIGINT DEFAULT NULL,
+    ADD COLUMN resets_metric BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
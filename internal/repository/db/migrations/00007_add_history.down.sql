@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP TABLE metric_history;
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE metric_history(
+    metric_metric_history INT NOT NULL REFERENCES metric(id_metric) ON DELETE CASCADE,
+    time_metric_history TIMESTAMPTZ NOT NULL,
+    value_metric_history DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX idx_metric_history_metric_time
+    ON metric_history(metric_metric_history, time_metric_history);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX idx_metric_history_time;
+
+DROP TABLE metric_rollup;
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE metric_rollup(
+    metric_metric_rollup INT NOT NULL REFERENCES metric(id_metric) ON DELETE CASCADE,
+    resolution_metric_rollup BIGINT NOT NULL,
+    start_metric_rollup TIMESTAMPTZ NOT NULL,
+    min_metric_rollup DOUBLE PRECISION NOT NULL,
+    max_metric_rollup DOUBLE PRECISION NOT NULL,
+    sum_metric_rollup DOUBLE PRECISION NOT NULL,
+    last_metric_rollup DOUBLE PRECISION NOT NULL,
+    count_metric_rollup BIGINT NOT NULL,
+    PRIMARY KEY (metric_metric_rollup, resolution_metric_rollup, start_metric_rollup)
+);
+
+CREATE INDEX idx_metric_history_time ON metric_history(time_metric_history);
+
+COMMIT;
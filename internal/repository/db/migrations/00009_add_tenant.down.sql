@@ -0,0 +1,15 @@
+BEGIN TRANSACTION;
+
+DELETE FROM metric WHERE tenant_metric <> '';
+
+ALTER TABLE metric
+    DROP CONSTRAINT unique_tenant_type_name_labels;
+
+ALTER TABLE metric
+    ADD CONSTRAINT unique_type_name_labels
+        UNIQUE (type_metric, name_metric, labels_metric);
+
+ALTER TABLE metric
+    DROP COLUMN tenant_metric;
+
+COMMIT;
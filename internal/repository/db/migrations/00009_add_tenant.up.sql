@@ -0,0 +1,13 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    ADD COLUMN tenant_metric VARCHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE metric
+    DROP CONSTRAINT unique_type_name_labels;
+
+ALTER TABLE metric
+    ADD CONSTRAINT unique_tenant_type_name_labels
+        UNIQUE (tenant_metric, type_metric, name_metric, labels_metric);
+
+COMMIT;
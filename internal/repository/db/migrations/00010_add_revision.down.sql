@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    DROP COLUMN revision_metric;
+
+COMMIT;
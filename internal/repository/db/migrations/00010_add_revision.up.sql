@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE metric
+    ADD COLUMN revision_metric BIGINT NOT NULL DEFAULT 1;
+
+COMMIT;
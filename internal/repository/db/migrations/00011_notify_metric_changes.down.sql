@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP TRIGGER metric_changed ON metric;
+
+DROP FUNCTION notify_metric_changed();
+
+COMMIT;
@@ -0,0 +1,43 @@
+BEGIN TRANSACTION;
+
+DROP TRIGGER metric_inserted ON metric;
+
+DROP TRIGGER metric_updated ON metric;
+
+DROP TRIGGER metric_deleted ON metric;
+
+DROP FUNCTION notify_metrics_changed();
+
+-- сообщает о каждом изменении метрики, чтобы кеши всех экземпляров сервера
+-- могли сбросить устаревшие значения; ревизия 0 означает удаление.
+-- Слишком длинный ключ не помещается в уведомление, тогда кеш сбрасывается целиком.
+CREATE FUNCTION notify_metric_changed() RETURNS TRIGGER AS $$
+DECLARE
+    m metric;
+    payload TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        m := OLD;
+    ELSE
+        m := NEW;
+    END IF;
+    payload := json_build_object(
+        'tenant', m.tenant_metric,
+        'type', (SELECT name_type FROM type WHERE id_type = m.type_metric),
+        'name', (SELECT name_designation FROM designation WHERE id_designation = m.name_metric),
+        'labels', m.labels_metric,
+        'revision', CASE WHEN TG_OP = 'DELETE' THEN 0 ELSE m.revision_metric END
+    )::TEXT;
+    IF octet_length(payload) > 7900 THEN
+        payload := '*';
+    END IF;
+    PERFORM pg_notify('metric_changed', payload);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER metric_changed
+    AFTER INSERT OR UPDATE OR DELETE ON metric
+    FOR EACH ROW EXECUTE FUNCTION notify_metric_changed();
+
+COMMIT;
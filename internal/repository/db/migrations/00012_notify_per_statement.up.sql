@@ -0,0 +1,56 @@
+BEGIN TRANSACTION;
+
+DROP TRIGGER metric_changed ON metric;
+
+DROP FUNCTION notify_metric_changed();
+
+-- сообщает об изменениях метрик одним уведомлением на оператор: массив
+-- ключей изменённых строк из таблицы переходов changed_metric с новыми
+-- ревизиями, ревизия 0 означает удаление. Построчный триггер вызывал
+-- pg_notify на каждую строку, и очистка или восстановление таблицы
+-- отправляли по уведомлению на метрику.
+-- Слишком длинный список не помещается в уведомление, тогда кеш сбрасывается целиком.
+CREATE FUNCTION notify_metrics_changed() RETURNS TRIGGER AS $$
+DECLARE
+    payload TEXT;
+BEGIN
+    SELECT json_agg(json_build_object(
+        'tenant', m.tenant_metric,
+        'type', t.name_type,
+        'name', d.name_designation,
+        'labels', m.labels_metric,
+        'revision', CASE WHEN TG_OP = 'DELETE' THEN 0 ELSE m.revision_metric END
+    ))::TEXT INTO payload
+    FROM changed_metric m
+    JOIN type t ON t.id_type = m.type_metric
+    JOIN designation d ON d.id_designation = m.name_metric;
+    -- INSERT ... ON CONFLICT DO UPDATE вызывает оба триггера,
+    -- один из них — с пустой таблицей переходов
+    IF payload IS NULL THEN
+        RETURN NULL;
+    END IF;
+    IF octet_length(payload) > 7900 THEN
+        payload := '*';
+    END IF;
+    PERFORM pg_notify('metric_changed', payload);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- таблицы переходов допускаются только у триггеров на одно событие
+CREATE TRIGGER metric_inserted
+    AFTER INSERT ON metric
+    REFERENCING NEW TABLE AS changed_metric
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_metrics_changed();
+
+CREATE TRIGGER metric_updated
+    AFTER UPDATE ON metric
+    REFERENCING NEW TABLE AS changed_metric
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_metrics_changed();
+
+CREATE TRIGGER metric_deleted
+    AFTER DELETE ON metric
+    REFERENCING OLD TABLE AS changed_metric
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_metrics_changed();
+
+COMMIT;
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ReplicaCheckInterval — период проверки доступности реплик по умолчанию.
+const ReplicaCheckInterval = 5 * time.Second
+
+type primaryKey struct{}
+
+// WithPrimary помечает контекст: чтения с ним выполняются на основной базе.
+// Нужен чтениям, которые должны видеть только что подтверждённую запись,
+// ведь реплики могут отставать.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// ReadsPrimary сообщает, что контекст помечен WithPrimary.
+func ReadsPrimary(ctx context.Context) bool {
+	primary, ok := ctx.Value(primaryKey{}).(bool)
+	return ok && primary
+}
+
+type replica struct {
+	pool    *pgxpool.Pool
+	host    string
+	healthy atomic.Bool
+}
+
+type replicaSet struct {
+	stop     context.CancelFunc
+	replicas []*replica
+	wg       sync.WaitGroup
+	next     atomic.Uint64
+}
+
+// ConnectReplicas подключает реплики для чтения и запускает проверку
+// их доступности с периодом interval. Недоступная при запуске реплика
+// не считается ошибкой: она начнёт обслуживать чтения после восстановления.
+// Вызывается один раз, до начала работы с хранилищем.
+func (db *DB) ConnectReplicas(ctx context.Context, dsns []string, interval time.Duration) error {
+	if len(dsns) == 0 {
+		return nil
+	}
+	set := &replicaSet{replicas: make([]*replica, 0, len(dsns))}
+	for _, dsn := range dsns {
+		poolCfg, err := pgxpool.ParseConfig(dsn)
+		if err != nil {
+			set.close()
+			return fmt.Errorf("failed to parse the replica DSN: %w", err)
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+		if err != nil {
+			set.close()
+			return fmt.Errorf("failed to create replica connection pool: %w", err)
+		}
+		set.replicas = append(set.replicas,
+			&replica{pool: pool, host: poolCfg.ConnConfig.Host})
+	}
+
+	db.check(ctx, set)
+	ctxCheck, cancel := context.WithCancel(context.Background())
+	set.stop = cancel
+	set.wg.Add(1)
+	go func() {
+		defer set.wg.Done()
+		db.runCheck(ctxCheck, set, interval)
+	}()
+	db.replicas = set
+	return nil
+}
+
+func (db *DB) runCheck(ctx context.Context, set *replicaSet, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			db.check(ctx, set)
+		}
+	}
+}
+
+func (db *DB) check(ctx context.Context, set *replicaSet) {
+	for _, r := range set.replicas {
+		ctxTO, cancel := context.WithTimeout(ctx, ReplicaCheckInterval)
+		err := ping(ctxTO, r.pool)
+		cancel()
+		db.setHealthy(r, err)
+	}
+}
+
+func (db *DB) setHealthy(r *replica, err error) {
+	healthy := err == nil
+	if r.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		db.log.Info().Str("replica", r.host).Msg("read replica is available")
+	} else {
+		db.log.Warn().Err(err).Str("replica", r.host).Msg("read replica is unavailable")
+	}
+}
+
+// pick возвращает доступную реплику по кругу или nil,
+// если чтение нужно выполнить на основной базе.
+func (s *replicaSet) pick(ctx context.Context) *replica {
+	if s == nil || len(s.replicas) == 0 || ReadsPrimary(ctx) {
+		return nil
+	}
+	healthy := make([]*replica, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		if r.healthy.Load() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[s.next.Add(1)%uint64(len(healthy))]
+}
+
+func (s *replicaSet) close() {
+	if s == nil {
+		return
+	}
+	if s.stop != nil {
+		s.stop()
+	}
+	s.wg.Wait()
+	for _, r := range s.replicas {
+		r.pool.Close()
+	}
+}
+
+// read выполняет чтение на реплике, а если её нет или она стала
+// недоступна — на основной базе.
+func (db *DB) read(ctx context.Context, query func(pool *pgxpool.Pool) error) error {
+	if r := db.replicas.pick(ctx); r != nil {
+		err := query(r.pool)
+		if !IsUnavailable(err) {
+			return err
+		}
+		db.setHealthy(r, err)
+	}
+	return query(db.pool)
+}
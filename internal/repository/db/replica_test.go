@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newReplicaSet(healthy ...bool) *replicaSet {
+	set := &replicaSet{}
+	for _, h := range healthy {
+		r := &replica{}
+		r.healthy.Store(h)
+		set.replicas = append(set.replicas, r)
+	}
+	return set
+}
+
+func TestReplicaSet_pick(t *testing.T) {
+	ctx := context.Background()
+
+	var empty *replicaSet
+	assert.Nil(t, empty.pick(ctx))
+	assert.Nil(t, newReplicaSet(false, false).pick(ctx))
+
+	set := newReplicaSet(true, false, true)
+	picked := map[*replica]int{}
+	for range 4 {
+		picked[set.pick(ctx)]++
+	}
+	assert.Equal(t, map[*replica]int{set.replicas[0]: 2, set.replicas[2]: 2}, picked)
+
+	assert.Nil(t, set.pick(WithPrimary(ctx)))
+}
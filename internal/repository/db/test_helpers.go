@@ -63,7 +63,7 @@ func initGetDB(log *logger.ZeroLogger) error {
 	initCtx, cancel := context.WithTimeout(context.Background(), initTO)
 	defer cancel()
 
-	buffer := queue.New[model.Metric]()
+	buffer := queue.New[model.Change]()
 	defer buffer.Close()
 	db, err := New(initCtx, dsn, log, &buffer)
 	if err != nil {
@@ -111,11 +111,42 @@ func fillDB(b *testing.B, db *DB, container **pgcontainer.PGContainer) {
 	b.Helper()
 
 	adapterForAdd := func(ctx context.Context, val model.Metric) error {
-		return db.Add(ctx, val)
+		_, err := db.Add(ctx, val)
+		return err
 	}
 	benchmark(b, adapterForAdd, getMetricGenerator(), container)
 }
 
+// tryNameQuery — прежний отдельный запрос, сохранявший имя метрики.
+const tryNameQuery = `INSERT INTO designation(name_designation)
+VALUES ($1)
+ON CONFLICT (name_designation) DO NOTHING;`
+
+// txBatch записывает пакет так, как до перехода на pgx.Batch:
+// в транзакции, по одной метрике, отдельными запросами на имя
+// и на саму метрику, каждый — отдельный обмен с сервером.
+func txBatch(ctx context.Context, db *DB, batch []model.Metric) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+	for _, m := range batch {
+		if _, err = tx.Exec(ctx, tryNameQuery, m.Name); err != nil {
+			return fmt.Errorf("failed to update the metric name in DB: %w", err)
+		}
+		if _, err = push(ctx, m, tx, db.overflow); err != nil {
+			return fmt.Errorf("failed to add the metric %s: %w", m.String(), err)
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit failed: %w", err)
+	}
+	return nil
+}
+
 func getMetricGenerator() func() model.Metric {
 	i := 0
 	return func() model.Metric {
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+const (
+	// changesChannel — канал уведомлений об изменениях метрик,
+	// см. миграцию 00012_notify_per_statement.
+	changesChannel = "metric_changed"
+	// changesFlush — уведомление, после которого сбрасывается всё.
+	changesFlush = "*"
+	// WatchRetryInterval — пауза перед повторным подключением к каналу.
+	WatchRetryInterval = 5 * time.Second
+)
+
+// ChangeFunc получает ключ изменённой метрики и её новую ревизию,
+// 0 — метрика удалена.
+type ChangeFunc func(key model.MetricKey, revision int64)
+
+type change struct {
+	Tenant   string `json:"tenant"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Labels   string `json:"labels"`
+	Revision int64  `json:"revision"`
+}
+
+// Watch сообщает об изменениях метрик, сделанных любым экземпляром сервера,
+// в том числе этим. Уведомления читаются из отдельного соединения;
+// после его потери и при запуске вызывается onGap: изменения за время
+// без соединения неизвестны. Слежение останавливается в Close.
+// Вызывается один раз.
+func (db *DB) Watch(onChange ChangeFunc, onGap func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db.watchStop = cancel
+	db.watchWG.Add(1)
+	go func() {
+		defer db.watchWG.Done()
+		for {
+			err := db.listen(ctx, onChange, onGap)
+			if ctx.Err() != nil {
+				return
+			}
+			db.log.Warn().Err(err).Msg("lost metric changes channel, reconnecting")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(WatchRetryInterval):
+			}
+		}
+	}()
+}
+
+func (db *DB) listen(ctx context.Context, onChange ChangeFunc, onGap func()) error {
+	// соединение с LISTEN не возвращается в пул,
+	// иначе уведомления копились бы в нём без читателя
+	conn, err := pgx.ConnectConfig(ctx, db.pool.Config().ConnConfig.Copy())
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			db.log.Error().Err(err).Msg("failed to close changes connection")
+		}
+	}()
+	if _, err = conn.Exec(ctx, "LISTEN "+changesChannel); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	onGap()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to wait for notification: %w", err)
+		}
+		if n.Payload == changesFlush {
+			onGap()
+			continue
+		}
+		// одно уведомление перечисляет все метрики, изменённые оператором
+		var changes []change
+		if err = json.Unmarshal([]byte(n.Payload), &changes); err != nil {
+			db.log.Error().Err(err).Msg("invalid metric change notification")
+			onGap()
+			continue
+		}
+		for _, c := range changes {
+			onChange(model.MetricKey{
+				Tenant: c.Tenant,
+				Type:   model.MetricType(c.Type),
+				Name:   c.Name,
+				Labels: c.Labels,
+			}, c.Revision)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+// Package disk реализует встроенное хранилище метрик на диске
+// для серверов без Postgres.
+//
+// Состояние хранится в памяти (memory.Memory) и восстанавливается из двух файлов
+// в каталоге хранилища: снимка snapshot.json и журнала wal.log, в который
+// до ответа клиенту дописывается каждое изменение: добавление, удаление или сброс метрики.
+// Изменение попадает в память только после записи в журнал на диск,
+// поэтому читатели не видят изменений, которые пропадут при сбое.
+// Журнал периодически сворачивается в снимок.
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/pkg/atomicfile"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+	// checkpointRecords — число записей журнала, после которого
+	// состояние сбрасывается в снимок.
+	checkpointRecords = 10000
+	permissionDir     = 0o700
+	permissionFile    = constants.PermissionFilePrivate
+)
+
+// Операции журнала. Пустая операция — добавление метрики.
+const (
+	opAdd    = ""
+	opDelete = "delete"
+	opReset  = "reset"
+)
+
+type record struct {
+	Metric *model.Metric    `json:"metric,omitempty"`
+	Key    *model.MetricKey `json:"key,omitempty"`
+	Op     string           `json:"op,omitempty"`
+	Seq    uint64           `json:"seq"`
+}
+
+type snapshot struct {
+	Series []memory.Series `json:"series"`
+	// Seq — номер последней записи журнала, вошедшей в снимок.
+	Seq uint64 `json:"seq"`
+}
+
+type Disk struct {
+	*memory.Memory
+	log      *logger.ZeroLogger
+	wal      *os.File
+	dir      string
+	overflow model.OverflowPolicy
+	// size — длина журнала в байтах.
+	size    int64
+	seq     uint64
+	pending int
+	m       sync.Mutex
+}
+
+// New открывает хранилище в каталоге dir и восстанавливает состояние
+// из снимка и журнала. Политика переполнения нужна уже при воспроизведении
+// журнала, поэтому передаётся здесь.
+func New(log *logger.ZeroLogger, dir string, overflow model.OverflowPolicy) (*Disk, error) {
+	if err := os.MkdirAll(dir, permissionDir); err != nil {
+		return nil, fmt.Errorf("failed to create storage dir: %w", err)
+	}
+
+	mem := memory.New(log, nil)
+	mem.SetOverflowPolicy(overflow)
+	d := &Disk{
+		Memory:   mem,
+		log:      log,
+		dir:      dir,
+		overflow: overflow,
+	}
+	if err := d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := d.replay(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Disk) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(d.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err = json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+	d.Memory.Load(snap.Series)
+	d.seq = snap.Seq
+	return nil
+}
+
+// replay применяет записи журнала, не вошедшие в снимок.
+// Недописанный при сбое хвост журнала отбрасывается.
+func (d *Disk) replay() error {
+	f, err := os.OpenFile(filepath.Join(d.dir, walFile),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND, permissionFile)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			break
+		}
+		var rec record
+		if err != nil || json.Unmarshal(bytes.TrimSpace(line), &rec) != nil {
+			d.log.Warn().
+				Int64("offset", offset).
+				Msg("journal tail is corrupted, truncating")
+			if err = f.Truncate(offset); err != nil {
+				_ = f.Close()
+				return fmt.Errorf("failed to truncate journal: %w", err)
+			}
+			break
+		}
+		offset += int64(len(line))
+
+		if rec.Seq <= d.seq {
+			continue
+		}
+		if err = d.apply(rec); err != nil {
+			d.log.Error().Err(err).Msg("failed to replay journal record")
+		}
+		d.seq = rec.Seq
+		d.pending++
+	}
+
+	d.wal = f
+	d.size = offset
+	return nil
+}
+
+func (d *Disk) apply(rec record) error {
+	ctx := context.Background()
+	switch {
+	case rec.Op == opAdd && rec.Metric != nil:
+		_, err := d.Memory.Add(ctx, *rec.Metric)
+		return err
+	case rec.Op == opDelete && rec.Key != nil:
+		return d.Memory.Delete(ctx, *rec.Key)
+	case rec.Op == opReset && rec.Key != nil:
+		return d.Memory.Reset(ctx, *rec.Key)
+	default:
+		return fmt.Errorf("unknown journal record #%d", rec.Seq)
+	}
+}
+
+func (d *Disk) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if err := d.prepare(ctx, metric, make(map[model.MetricKey]model.Metric)); err != nil {
+		return model.Metric{}, fmt.Errorf("failed to add the metric: %w", err)
+	}
+	at := d.mark()
+	if err := d.write(record{Metric: &metric}); err != nil {
+		return model.Metric{}, err
+	}
+	stored, err := d.Memory.Add(ctx, metric)
+	if err != nil {
+		d.undo(at)
+		return model.Metric{}, fmt.Errorf("failed to add the metric: %w", err)
+	}
+	d.commit(1)
+	return stored, nil
+}
+
+// CompareAndSet выполняет условную запись. В журнал попадает обычное
+// добавление: при воспроизведении условие уже выполнено.
+func (d *Disk) CompareAndSet(
+	ctx context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if err := cond.CheckValid(); err != nil {
+		return model.Metric{}, fmt.Errorf("invalid condition: %w", err)
+	}
+	current, err := d.Memory.Find(ctx, metric.Key())
+	if !cond.Match(current, err == nil) {
+		return model.Metric{}, &customerror.ConflictError{
+			Info: "condition does not match metric " + metric.Key().String(),
+		}
+	}
+	if err = d.prepare(ctx, metric, make(map[model.MetricKey]model.Metric)); err != nil {
+		return model.Metric{}, fmt.Errorf("failed to add the metric: %w", err)
+	}
+	at := d.mark()
+	if err = d.write(record{Metric: &metric}); err != nil {
+		return model.Metric{}, err
+	}
+	// запись журнала и хранилище меняются под одной блокировкой,
+	// поэтому условие, проверенное выше, ещё выполнено
+	stored, err := d.Memory.CompareAndSet(ctx, metric, cond)
+	if err != nil {
+		d.undo(at)
+		return model.Metric{}, fmt.Errorf("failed to add the metric: %w", err)
+	}
+	d.commit(1)
+	return stored, nil
+}
+
+// Batch сохраняет пакет целиком, как memory.Memory.Batch: если хотя бы
+// одна запись не применится, пакет отклоняется и в журнал не попадает.
+func (d *Disk) Batch(ctx context.Context, batch []model.Metric) ([]model.Metric, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	state := make(map[model.MetricKey]model.Metric)
+	records := make([]record, 0, len(batch))
+	for _, m := range batch {
+		if err := d.prepare(ctx, m, state); err != nil {
+			return nil, fmt.Errorf("batch failed on the metric %s: %w", m.String(), err)
+		}
+		records = append(records, record{Metric: &m})
+	}
+	at := d.mark()
+	if err := d.write(records...); err != nil {
+		return nil, err
+	}
+	merged, err := d.Memory.Batch(ctx, batch)
+	if err != nil {
+		d.undo(at)
+		return nil, fmt.Errorf("batch failed: %w", err)
+	}
+	d.commit(len(records))
+	return merged, nil
+}
+
+func (d *Disk) Delete(ctx context.Context, key model.MetricKey) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if _, err := d.Memory.Find(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete the metric: %w", err)
+	}
+	at := d.mark()
+	if err := d.write(record{Key: &key, Op: opDelete}); err != nil {
+		return err
+	}
+	if err := d.Memory.Delete(ctx, key); err != nil {
+		d.undo(at)
+		return fmt.Errorf("failed to delete the metric: %w", err)
+	}
+	d.commit(1)
+	return nil
+}
+
+func (d *Disk) Reset(ctx context.Context, key model.MetricKey) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	current, err := d.Memory.Find(ctx, key)
+	if err == nil {
+		err = current.Reset(time.Now())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reset the metric: %w", err)
+	}
+	at := d.mark()
+	if err = d.write(record{Key: &key, Op: opReset}); err != nil {
+		return err
+	}
+	if err = d.Memory.Reset(ctx, key); err != nil {
+		d.undo(at)
+		return fmt.Errorf("failed to reset the metric: %w", err)
+	}
+	d.commit(1)
+	return nil
+}
+
+// prepare проверяет, что запись metric применится к хранилищу,
+// не меняя его. state — значения после предыдущих записей той же
+// операции, в него попадает значение после этой записи.
+// Изменение сначала пишется в журнал и только потом применяется,
+// поэтому в журнал попадают лишь записи, которые применятся.
+// Вызывается под блокировкой.
+func (d *Disk) prepare(ctx context.Context, metric model.Metric, state map[model.MetricKey]model.Metric) error {
+	key := metric.Key()
+	current, found := state[key]
+	if !found {
+		stored, err := d.Memory.Find(ctx, key)
+		current, found = stored, err == nil
+	}
+	if !found {
+		state[key] = metric.Baseline()
+		return nil
+	}
+	if err := current.Update(metric, d.overflow); err != nil {
+		return fmt.Errorf("unable to update metric in storage: %w", err)
+	}
+	state[key] = current
+	return nil
+}
+
+// walMark — длина журнала и номер последней записи до дописывания.
+type walMark struct {
+	size int64
+	seq  uint64
+}
+
+func (d *Disk) mark() walMark {
+	return walMark{size: d.size, seq: d.seq}
+}
+
+// write дописывает записи в журнал и дожидается их записи на диск.
+// Если записать не удалось, журнал возвращается к прежней длине.
+// Вызывается под блокировкой.
+func (d *Disk) write(records ...record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	at := d.mark()
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, rec := range records {
+		d.seq++
+		rec.Seq = d.seq
+		if err := encoder.Encode(rec); err != nil {
+			d.seq = at.seq
+			return fmt.Errorf("failed to encode journal record: %w", err)
+		}
+	}
+	if _, err := d.wal.Write(buf.Bytes()); err != nil {
+		d.undo(at)
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := d.wal.Sync(); err != nil {
+		d.undo(at)
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	d.size += int64(buf.Len())
+	return nil
+}
+
+// undo отрезает от журнала записи, дописанные после at, если изменение
+// не удалось применить. Если отрезать не удалось, воспроизведение
+// журнала пропустит эти записи так же, как их пропустило хранилище.
+// Вызывается под блокировкой.
+func (d *Disk) undo(at walMark) {
+	d.seq = at.seq
+	d.size = at.size
+	err := d.wal.Truncate(at.size)
+	if err == nil {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		d.log.Error().Err(err).Msg("failed to undo journal records")
+	}
+}
+
+// commit учитывает применённые записи журнала и при необходимости
+// сбрасывает состояние в снимок. Вызывается под блокировкой.
+func (d *Disk) commit(records int) {
+	d.pending += records
+	if d.pending >= checkpointRecords {
+		if err := d.checkpoint(); err != nil {
+			d.log.Error().Err(err).Msg("checkpoint failed")
+		}
+	}
+}
+
+// checkpoint сохраняет состояние в снимок и очищает журнал.
+// Снимок записывается во временный файл и атомарно подменяет прежний,
+// поэтому при сбое остаётся либо старый снимок с журналом, либо новый.
+// Вызывается под блокировкой.
+func (d *Disk) checkpoint() error {
+	return d.save(d.Memory.Dump())
+}
+
+// save записывает ряды series в снимок вместо прежнего состояния и очищает
+// журнал. Записи журнала уже входят в снимок, поэтому, если очистить его
+// не удалось, воспроизведение их пропустит. Вызывается под блокировкой.
+func (d *Disk) save(series []memory.Series) error {
+	data, err := json.Marshal(snapshot{Series: series, Seq: d.seq})
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if err = atomicfile.Write(filepath.Join(d.dir, snapshotFile), data, permissionFile); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err = d.wal.Truncate(0); err != nil {
+		d.log.Error().Err(err).Msg("failed to truncate journal")
+		return nil
+	}
+	d.size = 0
+	d.pending = 0
+	return nil
+}
+
+// Replace заменяет все метрики хранилища, см. memory.Memory.Replace.
+// Новое состояние сначала сохраняется в снимок, и только потом
+// заменяется в памяти: при сбое остаётся либо прежнее состояние, либо новое.
+func (d *Disk) Replace(ctx context.Context, metrics []model.Metric) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	replaced := memory.New(d.log, nil)
+	if err := replaced.Replace(ctx, metrics); err != nil {
+		return fmt.Errorf("failed to replace metrics: %w", err)
+	}
+	if err := d.save(replaced.Dump()); err != nil {
+		return err
+	}
+	if err := d.Memory.Replace(ctx, metrics); err != nil {
+		return fmt.Errorf("failed to replace metrics: %w", err)
+	}
+	return nil
+}
+
+// Compact сжимает историю и сразу сохраняет результат в снимок.
+func (d *Disk) Compact(ctx context.Context, policy model.Retention, now time.Time) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if err := d.Memory.Compact(ctx, policy, now); err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	return d.checkpoint()
+}
+
+func (d *Disk) Ping(_ context.Context) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.wal == nil {
+		return errors.New("disk storage is closed")
+	}
+	return nil
+}
+
+// Close сохраняет снимок и закрывает журнал.
+func (d *Disk) Close() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.wal == nil {
+		return nil
+	}
+	err := d.checkpoint()
+	if closeErr := d.wal.Close(); closeErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to close journal: %w", closeErr))
+	}
+	d.wal = nil
+	return err
+}
@@ -0,0 +1,272 @@
+package disk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+func counter(name string, delta int64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeCounter, Delta: &delta}
+}
+
+func gauge(name string, value float64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeGauge, Value: &value}
+}
+
+func open(t *testing.T, dir string) *Disk {
+	t.Helper()
+	d, err := New(logger.NewNopLogger(), dir, model.OverflowSaturate)
+	require.NoError(t, err)
+	return d
+}
+
+func find(t *testing.T, d *Disk, m model.Metric) model.Metric {
+	t.Helper()
+	found, err := d.Find(context.Background(), m.Key())
+	require.NoError(t, err)
+	return found
+}
+
+func TestDisk_ReplayAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	_, err := d.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+	_, err = d.Batch(ctx, []model.Metric{counter("hits", 3), gauge("cpu", 0.5)})
+	require.NoError(t, err)
+	// имитируем сбой: журнал не сворачивается в снимок
+	require.NoError(t, d.wal.Close())
+
+	restored := open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	assert.Equal(t, int64(5), *find(t, restored, counter("hits", 0)).Delta)
+	assert.InDelta(t, 0.5, *find(t, restored, gauge("cpu", 0)).Value, 1e-9)
+
+	key := model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}
+	samples, err := restored.History(ctx, key, time.Time{}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, samples, 2)
+}
+
+func TestDisk_CheckpointNotReplayedTwice(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	_, err := d.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	d.m.Lock()
+	require.NoError(t, d.checkpoint())
+	d.m.Unlock()
+	_, err = d.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	restored := open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	assert.Equal(t, int64(2), *find(t, restored, counter("hits", 0)).Delta)
+}
+
+func TestDisk_CorruptedTail(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	_, err := d.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	_, err = d.wal.WriteString(`{"metric":{"type":"coun`)
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	restored := open(t, dir)
+	assert.Equal(t, int64(1), *find(t, restored, counter("hits", 0)).Delta)
+	_, err = restored.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	require.NoError(t, restored.wal.Close())
+
+	restored = open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	assert.Equal(t, int64(2), *find(t, restored, counter("hits", 0)).Delta)
+}
+
+func TestDisk_Close(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	_, err := d.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+	require.NoError(t, d.Close())
+	require.NoError(t, d.Close())
+	assert.Error(t, d.Ping(ctx))
+
+	info, err := os.Stat(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	restored := open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	assert.NoError(t, restored.Ping(ctx))
+	assert.InDelta(t, 1.0, *find(t, restored, gauge("cpu", 0)).Value, 1e-9)
+}
+
+func TestDisk_Compact(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := open(t, dir)
+	for _, sec := range []int{0, 30, 90} {
+		ts := start.Add(time.Duration(sec) * time.Second)
+		m := gauge("cpu", float64(sec))
+		m.Timestamp = &ts
+		_, err := d.Add(ctx, m)
+		require.NoError(t, err)
+	}
+	policy := model.NewRetention(time.Minute, model.RollupTier{Resolution: time.Minute})
+	require.NoError(t, d.Compact(ctx, policy, start.Add(2*time.Minute)))
+	require.NoError(t, d.wal.Close())
+
+	restored := open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	key := model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}
+	rollups, err := restored.Rollups(ctx, key, time.Minute, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, rollups, 2)
+	raw, err := restored.History(ctx, key, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, raw, 1)
+}
+
+func TestDisk_DeleteReset(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	_, err := d.Add(ctx, counter("hits", 5))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, gauge("cpu", 0.5))
+	require.NoError(t, err)
+	require.NoError(t, d.Reset(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}))
+	require.NoError(t, d.Delete(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}))
+	assert.Error(t, d.Delete(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}))
+	require.NoError(t, d.wal.Close())
+
+	restored := open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	hits := find(t, restored, counter("hits", 0))
+	assert.Equal(t, int64(0), *hits.Delta)
+	assert.Equal(t, int64(1), hits.Resets)
+	all, err := restored.Get(ctx, model.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
+
+func TestDisk_CompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	absent := int64(0)
+
+	d := open(t, dir)
+	stored, err := d.CompareAndSet(ctx, gauge("flag", 1), model.Condition{Revision: &absent})
+	require.NoError(t, err)
+	_, err = d.CompareAndSet(ctx, gauge("flag", 3), model.Condition{Revision: &absent})
+	var conflict *customerror.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	_, err = d.CompareAndSet(ctx, gauge("flag", 2), model.Condition{Revision: &stored.Revision})
+	require.NoError(t, err)
+	require.NoError(t, d.wal.Close())
+
+	restored := open(t, dir)
+	defer func() { require.NoError(t, restored.Close()) }()
+	found := find(t, restored, gauge("flag", 0))
+	assert.InDelta(t, 2.0, *found.Value, 1e-9)
+	assert.Equal(t, int64(2), found.Revision)
+}
+
+func TestDisk_JournalFirst(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	defer func() { require.NoError(t, d.Close()) }()
+	cpu := model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}
+	_, err := d.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+	hist := func(bounds ...float64) model.Metric {
+		h := model.Histogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+		return model.Metric{Name: "latency", Type: model.MetricTypeHistogram, Histogram: &h}
+	}
+	_, err = d.Add(ctx, hist(1, 2))
+	require.NoError(t, err)
+	info, err := d.wal.Stat()
+	require.NoError(t, err)
+	size := info.Size()
+
+	// запись, которая не применится, не попадает в журнал
+	_, err = d.Add(ctx, hist(1, 5))
+	require.Error(t, err)
+	_, err = d.Batch(ctx, []model.Metric{gauge("ram", 1), hist(1, 5)})
+	require.Error(t, err)
+	_, err = d.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "ram"})
+	require.Error(t, err)
+	require.Error(t, d.Reset(ctx, cpu))
+	info, err = d.wal.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, size, info.Size())
+
+	// если журнал не записан, изменение не применяется
+	wal := d.wal
+	d.wal, err = os.Open(filepath.Join(dir, walFile))
+	require.NoError(t, err)
+	_, err = d.Add(ctx, gauge("cpu", 2))
+	require.Error(t, err)
+	_, err = d.Batch(ctx, []model.Metric{gauge("ram", 1)})
+	require.Error(t, err)
+	require.Error(t, d.Delete(ctx, cpu))
+	require.NoError(t, d.wal.Close())
+	d.wal = wal
+
+	assert.InDelta(t, 1.0, *find(t, d, gauge("cpu", 0)).Value, 1e-9)
+	_, err = d.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "ram"})
+	var notFound *customerror.NotFoundError
+	require.ErrorAs(t, err, &notFound)
+}
+
+func TestDisk_Replace(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	d := open(t, dir)
+	_, err := d.Add(ctx, gauge("cpu", 1))
+	require.NoError(t, err)
+	restored := gauge("ram", 2)
+	restored.Revision = 5
+	require.NoError(t, d.Replace(ctx, []model.Metric{restored}))
+	_, err = d.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	// имитируем сбой: восстановленное состояние уже в снимке
+	require.NoError(t, d.wal.Close())
+
+	reopened := open(t, dir)
+	defer func() { require.NoError(t, reopened.Close()) }()
+	all, err := reopened.Get(ctx, model.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+	ram := find(t, reopened, gauge("ram", 0))
+	assert.InDelta(t, 2.0, *ram.Value, 1e-9)
+	assert.Equal(t, int64(5), ram.Revision)
+	assert.Equal(t, int64(1), *find(t, reopened, counter("hits", 0)).Delta)
+}
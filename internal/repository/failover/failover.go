@@ -0,0 +1,601 @@
+// Package failover реализует хранилище, которое пишет в основное хранилище
+// (Postgres), пока оно доступно, а на время его недоступности сохраняет
+// операции записи в локальный журнал.
+//
+// Когда соединение восстанавливается, журнал воспроизводится в основном
+// хранилище в порядке поступления операций. Воспроизводятся сами операции,
+// а не итоговые значения, поэтому приращения счётчиков и накопительные
+// счётчики учитываются так же, как если бы сбоя не было.
+// Между применением части журнала и отметкой о её воспроизведении
+// остаётся окно: при сбое сервера в этот момент часть будет применена повторно.
+//
+// Записи, попавшие в журнал, возвращаются без ревизии (Revision 0):
+// значение после слияния станет известно только при воспроизведении.
+//
+// Чтение идёт только из основного хранилища. Пока запись идёт в журнал,
+// чтение отклоняется с customerror.UnavailableError (503), даже если
+// хранилище уже доступно: оно ещё не учитывает невоспроизведённый журнал.
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
+)
+
+const (
+	// DefaultRecoverInterval — период проверки основного хранилища во время сбоя.
+	DefaultRecoverInterval = 5 * time.Second
+	// replayBatchSize — число добавлений, воспроизводимых одним пакетом.
+	replayBatchSize = 500
+)
+
+// Primary — основное хранилище.
+type Primary interface {
+	Add(ctx context.Context, metric model.Metric) (model.Metric, error)
+	Batch(ctx context.Context, metrics []model.Metric) ([]model.Metric, error)
+	CompareAndSet(ctx context.Context, metric model.Metric, cond model.Condition) (model.Metric, error)
+	Find(ctx context.Context, key model.MetricKey) (model.Metric, error)
+	FindByLabels(
+		ctx context.Context,
+		tenant string,
+		mType model.MetricType,
+		name string,
+		matchers model.Labels,
+	) ([]model.Metric, error)
+	Delete(ctx context.Context, key model.MetricKey) error
+	Reset(ctx context.Context, key model.MetricKey) error
+	Replace(ctx context.Context, metrics []model.Metric) error
+	History(
+		ctx context.Context,
+		key model.MetricKey,
+		from, to time.Time,
+	) ([]model.Sample, error)
+	Rollups(
+		ctx context.Context,
+		key model.MetricKey,
+		resolution time.Duration,
+		from, to time.Time,
+	) ([]model.Rollup, error)
+	Get(ctx context.Context, opts model.ListOptions) ([]model.Metric, error)
+	Compact(ctx context.Context, policy model.Retention, now time.Time) error
+	Ping(ctx context.Context) error
+	Close()
+}
+
+// Connector подключается к основному хранилищу.
+type Connector func(ctx context.Context) (Primary, error)
+
+// Failover переключает запись между основным хранилищем и журналом.
+type Failover struct {
+	log     *logger.ZeroLogger
+	connect Connector
+	journal Journal
+	// primary равен nil, пока не удалось подключиться.
+	primary  Primary
+	interval time.Duration
+	// degraded означает, что запись идёт в журнал.
+	degraded atomic.Bool
+	// onChange и onGap передаются основному хранилищу,
+	// если оно сообщает об изменениях, см. Watch.
+	onChange db.ChangeFunc
+	onGap    func()
+	// m защищает primary и подписку на изменения. Запись идёт под блокировкой на чтение,
+	// переключение на основное хранилище — под блокировкой на запись,
+	// поэтому после переключения в журнал ничего не попадёт.
+	m sync.RWMutex
+}
+
+// New создаёт хранилище и сразу пытается подключиться к основному.
+// Если это не удалось, хранилище начинает работу в режиме сбоя,
+// а подключение повторяется в Run.
+func New(
+	ctx context.Context,
+	log *logger.ZeroLogger,
+	connect Connector,
+	journal Journal,
+	interval time.Duration,
+) *Failover {
+	f := &Failover{
+		log:      log,
+		connect:  connect,
+		journal:  journal,
+		interval: interval,
+	}
+	primary, err := connect(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("primary storage is unavailable, writes go to the journal")
+		f.degraded.Store(true)
+		return f
+	}
+	f.primary = primary
+	if journal.Len() != 0 {
+		// журнал остался от прошлого запуска
+		f.degraded.Store(true)
+	}
+	return f
+}
+
+// Run периодически проверяет основное хранилище во время сбоя
+// и, когда оно становится доступным, воспроизводит журнал.
+func (f *Failover) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if f.degraded.Load() {
+				f.recover(ctx)
+			}
+		}
+	}
+}
+
+type watcher interface {
+	Watch(onChange db.ChangeFunc, onGap func())
+}
+
+// Watch подписывается на изменения метрик в основном хранилище,
+// если оно это поддерживает. Если основное хранилище ещё не подключено,
+// подписка оформляется при подключении.
+func (f *Failover) Watch(onChange db.ChangeFunc, onGap func()) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.onChange = onChange
+	f.onGap = onGap
+	f.watch()
+}
+
+func (f *Failover) watch() {
+	if f.primary == nil || f.onChange == nil {
+		return
+	}
+	if w, ok := f.primary.(watcher); ok {
+		w.Watch(f.onChange, f.onGap)
+	}
+}
+
+// Connected сообщает, удалось ли подключиться к основному хранилищу.
+func (f *Failover) Connected() bool {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	return f.primary != nil
+}
+
+// Degraded сообщает, идёт ли запись в журнал.
+func (f *Failover) Degraded() bool {
+	return f.degraded.Load()
+}
+
+func (f *Failover) recover(ctx context.Context) {
+	f.m.RLock()
+	primary := f.primary
+	f.m.RUnlock()
+
+	if primary == nil {
+		p, err := f.connect(ctx)
+		if err != nil {
+			f.log.Debug().Err(err).Msg("primary storage is still unavailable")
+			return
+		}
+		f.m.Lock()
+		f.primary = p
+		f.watch()
+		f.m.Unlock()
+		primary = p
+	}
+	if err := primary.Ping(ctx); err != nil {
+		f.log.Debug().Err(err).Msg("primary storage is still unavailable")
+		return
+	}
+
+	// основная часть журнала воспроизводится, не останавливая запись
+	if err := f.replay(ctx, primary); err != nil {
+		f.log.Warn().Err(err).Msg("journal replay interrupted")
+		return
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	if err := f.replay(ctx, primary); err != nil {
+		f.log.Warn().Err(err).Msg("journal replay interrupted")
+		return
+	}
+	f.degraded.Store(false)
+	f.log.Info().Msg("primary storage recovered, journal replayed")
+}
+
+// replay воспроизводит журнал, отмечая воспроизведённые части.
+// Останавливается, если основное хранилище снова недоступно.
+func (f *Failover) replay(ctx context.Context, primary Primary) error {
+	ops, err := f.journal.Pending()
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	for len(ops) > 0 {
+		n := nextChunk(ops)
+		err = apply(ctx, primary, ops[:n])
+		switch {
+		case db.IsUnavailable(err):
+			return err
+		case err != nil && n > 1:
+			if err = f.replayEach(ctx, primary, ops[:n]); err != nil {
+				return err
+			}
+		case err != nil:
+			f.log.Error().Err(err).Msg("journal operation rejected, skipping")
+			fallthrough
+		default:
+			if err = f.journal.Commit(ops[n-1].Seq); err != nil {
+				return fmt.Errorf("failed to commit journal: %w", err)
+			}
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// replayEach воспроизводит по одной операции пакета, отклонённого целиком.
+// Операция, отклонённая не из-за недоступности хранилища, пропускается,
+// чтобы не блокировать остальной журнал.
+func (f *Failover) replayEach(ctx context.Context, primary Primary, ops []Op) error {
+	for _, op := range ops {
+		err := applyOne(ctx, primary, op)
+		if db.IsUnavailable(err) {
+			return err
+		}
+		if err != nil {
+			f.log.Error().Err(err).Msg("journal operation rejected, skipping")
+		}
+		if err = f.journal.Commit(op.Seq); err != nil {
+			return fmt.Errorf("failed to commit journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// nextChunk возвращает длину очередной части журнала: подряд идущие
+// добавления воспроизводятся одним пакетом, удаление и сброс — по одному.
+func nextChunk(ops []Op) int {
+	n := 1
+	if ops[0].Kind != OpAdd {
+		return n
+	}
+	for n < len(ops) && n < replayBatchSize && ops[n].Kind == OpAdd {
+		n++
+	}
+	return n
+}
+
+func apply(ctx context.Context, primary Primary, ops []Op) error {
+	if len(ops) == 1 {
+		return applyOne(ctx, primary, ops[0])
+	}
+	batch := make([]model.Metric, 0, len(ops))
+	for _, op := range ops {
+		batch = append(batch, *op.Metric)
+	}
+	if _, err := primary.Batch(ctx, batch); err != nil {
+		return fmt.Errorf("failed to replay batch: %w", err)
+	}
+	return nil
+}
+
+func applyOne(ctx context.Context, primary Primary, op Op) error {
+	var err error
+	switch {
+	case op.Kind == OpAdd && op.Metric != nil:
+		_, err = primary.Add(ctx, *op.Metric)
+	case op.Kind == OpDelete && op.Key != nil:
+		err = primary.Delete(ctx, *op.Key)
+	case op.Kind == OpReset && op.Key != nil:
+		err = primary.Reset(ctx, *op.Key)
+	default:
+		return &customerror.InvalidArgumentError{
+			Info: fmt.Sprintf("unknown journal operation #%d", op.Seq),
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to replay operation #%d: %w", op.Seq, err)
+	}
+	return nil
+}
+
+// write выполняет операцию в основном хранилище или, если оно недоступно,
+// сохраняет её в журнал. Возвращает true, если операция записана в журнал.
+func (f *Failover) write(ops []Op, do func(Primary) error) (bool, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	if !f.degraded.Load() {
+		err := do(f.primary)
+		if !db.IsUnavailable(err) {
+			return false, err
+		}
+		if f.degraded.CompareAndSwap(false, true) {
+			f.log.Warn().Err(err).Msg("primary storage is unavailable, writes go to the journal")
+		}
+	}
+	if err := f.journal.Append(ops...); err != nil {
+		return false, fmt.Errorf("failed to journal the operation: %w", err)
+	}
+	return true, nil
+}
+
+// Add сохраняет метрику. Во время сбоя возвращает метрику
+// в том виде, в котором она получена, без ревизии: значение после слияния неизвестно.
+func (f *Failover) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
+	var stored model.Metric
+	journaled, err := f.write([]Op{{Metric: &metric}}, func(p Primary) error {
+		var err error
+		if stored, err = p.Add(ctx, metric); err != nil {
+			return fmt.Errorf("failed to add the metric: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return model.Metric{}, err
+	}
+	if journaled {
+		metric.Revision = 0
+		return metric, nil
+	}
+	return stored, nil
+}
+
+// Batch сохраняет метрики, см. Add.
+func (f *Failover) Batch(ctx context.Context, metrics []model.Metric) ([]model.Metric, error) {
+	ops := make([]Op, 0, len(metrics))
+	for _, m := range metrics {
+		ops = append(ops, Op{Metric: &m})
+	}
+	var merged []model.Metric
+	journaled, err := f.write(ops, func(p Primary) error {
+		var err error
+		if merged, err = p.Batch(ctx, metrics); err != nil {
+			return fmt.Errorf("batch failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if journaled {
+		accepted := slices.Clone(metrics)
+		for i := range accepted {
+			accepted[i].Revision = 0
+		}
+		return accepted, nil
+	}
+	return merged, nil
+}
+
+var errConditionalWrite = &customerror.UnavailableError{
+	Info: "conditional writes are unavailable while primary storage is down",
+}
+
+// CompareAndSet выполняет условную запись в основном хранилище.
+// Во время сбоя и до воспроизведения журнала условие проверить не по чему,
+// поэтому запись отклоняется, а не попадает в журнал.
+func (f *Failover) CompareAndSet(
+	ctx context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	if f.degraded.Load() {
+		return model.Metric{}, errConditionalWrite
+	}
+	stored, err := f.primary.CompareAndSet(ctx, metric, cond)
+	if db.IsUnavailable(err) {
+		if f.degraded.CompareAndSwap(false, true) {
+			f.log.Warn().Err(err).Msg("primary storage is unavailable, writes go to the journal")
+		}
+		return model.Metric{}, errConditionalWrite
+	}
+	if err != nil {
+		return model.Metric{}, fmt.Errorf("conditional write failed: %w", err)
+	}
+	return stored, nil
+}
+
+// Delete удаляет метрику. Во время сбоя существование метрики не проверяется.
+func (f *Failover) Delete(ctx context.Context, key model.MetricKey) error {
+	_, err := f.write([]Op{{Key: &key, Kind: OpDelete}}, func(p Primary) error {
+		if err := p.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete the metric: %w", err)
+		}
+		return nil
+	})
+	return err
+}
+
+// Reset обнуляет счётчик. Во время сбоя тип и существование метрики не проверяются.
+func (f *Failover) Reset(ctx context.Context, key model.MetricKey) error {
+	_, err := f.write([]Op{{Key: &key, Kind: OpReset}}, func(p Primary) error {
+		if err := p.Reset(ctx, key); err != nil {
+			return fmt.Errorf("failed to reset the metric: %w", err)
+		}
+		return nil
+	})
+	return err
+}
+
+var errReplace = &customerror.UnavailableError{
+	Info: "metrics cannot be replaced while primary storage is down",
+}
+
+// Replace заменяет все метрики основного хранилища. Во время сбоя
+// и до воспроизведения журнала замена отклоняется: операции журнала
+// применились бы уже к новому состоянию.
+func (f *Failover) Replace(ctx context.Context, metrics []model.Metric) error {
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	if f.degraded.Load() {
+		return errReplace
+	}
+	err := f.primary.Replace(ctx, metrics)
+	if db.IsUnavailable(err) {
+		if f.degraded.CompareAndSwap(false, true) {
+			f.log.Warn().Err(err).Msg("primary storage is unavailable, writes go to the journal")
+		}
+		return errReplace
+	}
+	if err != nil {
+		return fmt.Errorf("failed to replace metrics: %w", err)
+	}
+	return nil
+}
+
+var errNotConnected = &customerror.UnavailableError{
+	Info: "primary storage is not connected",
+}
+
+// current возвращает основное хранилище для чтения.
+func (f *Failover) current() (Primary, error) {
+	if f.degraded.Load() {
+		return nil, f.degradedError()
+	}
+	f.m.RLock()
+	defer f.m.RUnlock()
+
+	if f.primary == nil {
+		return nil, errNotConnected
+	}
+	return f.primary, nil
+}
+
+func (f *Failover) Find(ctx context.Context, key model.MetricKey) (model.Metric, error) {
+	p, err := f.current()
+	if err != nil {
+		return model.Metric{}, err
+	}
+	m, err := p.Find(ctx, key)
+	if err != nil {
+		return model.Metric{}, fmt.Errorf("failed to find the metric: %w", err)
+	}
+	return m, nil
+}
+
+func (f *Failover) FindByLabels(
+	ctx context.Context,
+	tenant string,
+	mType model.MetricType,
+	name string,
+	matchers model.Labels,
+) ([]model.Metric, error) {
+	p, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := p.FindByLabels(ctx, tenant, mType, name, matchers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find metrics by labels: %w", err)
+	}
+	return metrics, nil
+}
+
+func (f *Failover) History(
+	ctx context.Context, key model.MetricKey, from, to time.Time,
+) ([]model.Sample, error) {
+	p, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	samples, err := p.History(ctx, key, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return samples, nil
+}
+
+func (f *Failover) Rollups(
+	ctx context.Context,
+	key model.MetricKey,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]model.Rollup, error) {
+	p, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	rollups, err := p.Rollups(ctx, key, resolution, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollups: %w", err)
+	}
+	return rollups, nil
+}
+
+func (f *Failover) Get(ctx context.Context, opts model.ListOptions) ([]model.Metric, error) {
+	p, err := f.current()
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := p.Get(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// Compact сжимает историю в основном хранилище. Во время сбоя
+// сжатие откладывается, чтобы не отбросить точки из журнала как опоздавшие.
+func (f *Failover) Compact(ctx context.Context, policy model.Retention, now time.Time) error {
+	p, err := f.current()
+	if err != nil {
+		return err
+	}
+	if err = p.Compact(ctx, policy, now); err != nil {
+		return fmt.Errorf("failed to compact history: %w", err)
+	}
+	return nil
+}
+
+// Ping сообщает о режиме сбоя ошибкой customerror.UnavailableError.
+func (f *Failover) Ping(ctx context.Context) error {
+	p, err := f.current()
+	if err != nil {
+		return err
+	}
+	if err = p.Ping(ctx); err != nil {
+		return fmt.Errorf("primary storage ping failed: %w", err)
+	}
+	return nil
+}
+
+func (f *Failover) degradedError() error {
+	return &customerror.UnavailableError{
+		Info: fmt.Sprintf("storage is degraded, %d operations are journaled", f.journal.Len()),
+	}
+}
+
+// Close закрывает основное хранилище и журнал.
+// Невоспроизведённые операции файлового журнала сохраняются до следующего запуска.
+func (f *Failover) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.primary != nil {
+		f.primary.Close()
+	}
+	if n := f.journal.Len(); n != 0 {
+		f.log.Warn().Int("operations", n).Msg("journal is not replayed")
+	}
+	if err := f.journal.Close(); err != nil {
+		return errors.Join(errors.New("failed to close failover storage"), err)
+	}
+	return nil
+}
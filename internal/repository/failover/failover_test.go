@@ -0,0 +1,300 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+)
+
+var errDown = &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+
+// fakePrimary — основное хранилище в памяти, которое можно «выключить».
+type fakePrimary struct {
+	*memory.Memory
+	down    atomic.Bool
+	watches atomic.Int32
+}
+
+func newFakePrimary() *fakePrimary {
+	return &fakePrimary{Memory: memory.New(logger.NewNopLogger(), nil)}
+}
+
+func (p *fakePrimary) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
+	if p.down.Load() {
+		return model.Metric{}, errDown
+	}
+	//nolint:wrapcheck // it's tests
+	return p.Memory.Add(ctx, metric)
+}
+
+func (p *fakePrimary) Batch(ctx context.Context, batch []model.Metric) ([]model.Metric, error) {
+	if p.down.Load() {
+		return nil, errDown
+	}
+	//nolint:wrapcheck // it's tests
+	return p.Memory.Batch(ctx, batch)
+}
+
+func (p *fakePrimary) CompareAndSet(
+	ctx context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	if p.down.Load() {
+		return model.Metric{}, errDown
+	}
+	//nolint:wrapcheck // it's tests
+	return p.Memory.CompareAndSet(ctx, metric, cond)
+}
+
+func (p *fakePrimary) Delete(ctx context.Context, key model.MetricKey) error {
+	if p.down.Load() {
+		return errDown
+	}
+	//nolint:wrapcheck // it's tests
+	return p.Memory.Delete(ctx, key)
+}
+
+func (p *fakePrimary) Reset(ctx context.Context, key model.MetricKey) error {
+	if p.down.Load() {
+		return errDown
+	}
+	//nolint:wrapcheck // it's tests
+	return p.Memory.Reset(ctx, key)
+}
+
+func (p *fakePrimary) Ping(_ context.Context) error {
+	if p.down.Load() {
+		return errDown
+	}
+	return nil
+}
+
+func (p *fakePrimary) Close() {}
+
+func (p *fakePrimary) Watch(db.ChangeFunc, func()) {
+	p.watches.Add(1)
+}
+
+func connected(p *fakePrimary) Connector {
+	return func(context.Context) (Primary, error) {
+		return p, nil
+	}
+}
+
+func counter(name string, delta int64, cumulative bool) model.Metric {
+	return model.Metric{
+		Name: name, Type: model.MetricTypeCounter, Delta: &delta, Cumulative: cumulative,
+	}
+}
+
+func find(t *testing.T, s interface {
+	Find(context.Context, model.MetricKey) (model.Metric, error)
+}, name string) model.Metric {
+	t.Helper()
+
+	m, err := s.Find(context.Background(), model.MetricKey{Type: model.MetricTypeCounter, Name: name})
+	require.NoError(t, err)
+	return m
+}
+
+func TestFailover_ReplayAfterOutage(t *testing.T) {
+	ctx := context.Background()
+	primary := newFakePrimary()
+	journal := NewMemoryJournal(MemoryJournalSizeDefault)
+	f := New(ctx, logger.NewNopLogger(), connected(primary), journal, DefaultRecoverInterval)
+	require.False(t, f.Degraded())
+
+	_, err := f.Add(ctx, counter("hits", 1, false))
+	require.NoError(t, err)
+	_, err = f.Add(ctx, counter("total", 10, true))
+	require.NoError(t, err)
+	_, err = f.Add(ctx, counter("gone", 1, false))
+	require.NoError(t, err)
+
+	primary.down.Store(true)
+	sent := counter("hits", 2, false)
+	sent.Revision = 7
+	stored, err := f.Add(ctx, sent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *stored.Delta)
+	assert.Zero(t, stored.Revision)
+	require.True(t, f.Degraded())
+	accepted, err := f.Batch(ctx, []model.Metric{
+		counter("hits", 3, false),
+		counter("total", 15, true),
+		counter("total", 20, true),
+	})
+	require.NoError(t, err)
+	for _, m := range accepted {
+		assert.Zero(t, m.Revision)
+	}
+	require.NoError(t, f.Delete(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "gone"}))
+	assert.Equal(t, 5, journal.Len())
+
+	var unavailable *customerror.UnavailableError
+	require.ErrorAs(t, f.Ping(ctx), &unavailable)
+	assert.ErrorContains(t, f.Ping(ctx), "5 operations")
+
+	// хранилище ещё недоступно: журнал сохраняется
+	f.recover(ctx)
+	require.True(t, f.Degraded())
+	assert.Equal(t, 5, journal.Len())
+
+	// хранилище доступно, но журнал ещё не воспроизведён
+	primary.down.Store(false)
+	_, err = f.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"})
+	require.ErrorAs(t, err, &unavailable)
+
+	f.recover(ctx)
+	require.False(t, f.Degraded())
+	assert.Zero(t, journal.Len())
+	require.NoError(t, f.Ping(ctx))
+
+	assert.Equal(t, int64(6), *find(t, f, "hits").Delta)
+	assert.Equal(t, int64(20), *find(t, f, "total").Delta)
+	_, err = f.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "gone"})
+	var notFound *customerror.NotFoundError
+	assert.ErrorAs(t, err, &notFound)
+}
+
+func TestFailover_StartDisconnected(t *testing.T) {
+	ctx := context.Background()
+	primary := newFakePrimary()
+	var up atomic.Bool
+	connect := func(context.Context) (Primary, error) {
+		if !up.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return primary, nil
+	}
+
+	f := New(ctx, logger.NewNopLogger(), connect, NewMemoryJournal(MemoryJournalSizeDefault), DefaultRecoverInterval)
+	require.True(t, f.Degraded())
+
+	_, err := f.Add(ctx, counter("hits", 2, false))
+	require.NoError(t, err)
+	require.NoError(t, f.Reset(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}))
+	_, err = f.Add(ctx, counter("hits", 3, false))
+	require.NoError(t, err)
+
+	var unavailable *customerror.UnavailableError
+	_, err = f.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"})
+	require.ErrorAs(t, err, &unavailable)
+	require.ErrorAs(t, f.Compact(ctx, model.Retention{}, time.Now()), &unavailable)
+
+	f.recover(ctx)
+	require.True(t, f.Degraded())
+
+	up.Store(true)
+	f.recover(ctx)
+	require.False(t, f.Degraded())
+	hits := find(t, f, "hits")
+	assert.Equal(t, int64(3), *hits.Delta)
+	assert.Equal(t, int64(1), hits.Resets)
+}
+
+func TestFailover_RejectedOperationSkipped(t *testing.T) {
+	ctx := context.Background()
+	primary := newFakePrimary()
+	journal := NewMemoryJournal(MemoryJournalSizeDefault)
+	f := New(ctx, logger.NewNopLogger(), connected(primary), journal, DefaultRecoverInterval)
+
+	primary.down.Store(true)
+	require.NoError(t, f.Reset(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "missing"}))
+	_, err := f.Add(ctx, counter("hits", 1, false))
+	require.NoError(t, err)
+
+	primary.down.Store(false)
+	f.recover(ctx)
+	require.False(t, f.Degraded())
+	assert.Zero(t, journal.Len())
+	assert.Equal(t, int64(1), *find(t, f, "hits").Delta)
+}
+
+func TestFailover_LeftoverJournal(t *testing.T) {
+	ctx := context.Background()
+	journal, err := NewFileJournal(t.TempDir())
+	require.NoError(t, err)
+	m := counter("hits", 4, false)
+	require.NoError(t, journal.Append(Op{Metric: &m}))
+
+	primary := newFakePrimary()
+	f := New(ctx, logger.NewNopLogger(), connected(primary), journal, DefaultRecoverInterval)
+	require.True(t, f.Degraded())
+
+	f.recover(ctx)
+	require.False(t, f.Degraded())
+	assert.Equal(t, int64(4), *find(t, primary, "hits").Delta)
+	require.NoError(t, f.Close())
+}
+
+func TestFailover_CompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	primary := newFakePrimary()
+	journal := NewMemoryJournal(MemoryJournalSizeDefault)
+	f := New(ctx, logger.NewNopLogger(), connected(primary), journal, DefaultRecoverInterval)
+
+	absent, stale := int64(0), int64(5)
+	stored, err := f.CompareAndSet(ctx, counter("lock", 1, false), model.Condition{Revision: &absent})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stored.Revision)
+	var conflict *customerror.ConflictError
+	_, err = f.CompareAndSet(ctx, counter("lock", 1, false), model.Condition{Revision: &stale})
+	require.ErrorAs(t, err, &conflict)
+
+	// во время сбоя условную запись не с чем сверить, в журнал она не попадает
+	primary.down.Store(true)
+	revision := stored.Revision
+	var unavailable *customerror.UnavailableError
+	_, err = f.CompareAndSet(ctx, counter("lock", 1, false), model.Condition{Revision: &revision})
+	require.ErrorAs(t, err, &unavailable)
+	require.True(t, f.Degraded())
+	_, err = f.CompareAndSet(ctx, counter("lock", 1, false), model.Condition{Revision: &revision})
+	require.ErrorAs(t, err, &unavailable)
+	assert.Zero(t, journal.Len())
+
+	primary.down.Store(false)
+	f.recover(ctx)
+	stored, err = f.CompareAndSet(ctx, counter("lock", 1, false), model.Condition{Revision: &revision})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *stored.Delta)
+	assert.Equal(t, int64(2), stored.Revision)
+}
+
+func TestFailover_Watch(t *testing.T) {
+	ctx := context.Background()
+	primary := newFakePrimary()
+	var up atomic.Bool
+	connect := func(context.Context) (Primary, error) {
+		if !up.Load() {
+			return nil, errors.New("connection refused")
+		}
+		return primary, nil
+	}
+
+	f := New(ctx, logger.NewNopLogger(), connect, NewMemoryJournal(MemoryJournalSizeDefault), DefaultRecoverInterval)
+	f.Watch(func(model.MetricKey, int64) {}, func() {})
+	assert.Equal(t, int32(0), primary.watches.Load())
+
+	up.Store(true)
+	f.recover(ctx)
+	assert.Equal(t, int32(1), primary.watches.Load())
+
+	connectedPrimary := newFakePrimary()
+	f = New(ctx, logger.NewNopLogger(), connected(connectedPrimary),
+		NewMemoryJournal(MemoryJournalSizeDefault), DefaultRecoverInterval)
+	f.Watch(func(model.MetricKey, int64) {}, func() {})
+	assert.Equal(t, int32(1), connectedPrimary.watches.Load())
+}
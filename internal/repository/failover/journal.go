@@ -0,0 +1,279 @@
+package failover
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/pkg/atomicfile"
+)
+
+// Операции журнала. Пустая операция — добавление метрики.
+const (
+	OpAdd    = ""
+	OpDelete = "delete"
+	OpReset  = "reset"
+)
+
+// Op — операция записи, принятая во время недоступности основного хранилища.
+type Op struct {
+	Metric *model.Metric    `json:"metric,omitempty"`
+	Key    *model.MetricKey `json:"key,omitempty"`
+	Kind   string           `json:"op,omitempty"`
+	// Seq — номер операции, назначается журналом.
+	Seq uint64 `json:"seq"`
+}
+
+// Journal хранит операции до их воспроизведения в основном хранилище.
+// Методы безопасны для конкурентного вызова.
+type Journal interface {
+	// Append сохраняет операции в порядке следования.
+	Append(ops ...Op) error
+	// Pending возвращает невоспроизведённые операции в порядке добавления.
+	Pending() ([]Op, error)
+	// Commit отмечает операции с номерами до seq включительно воспроизведёнными.
+	Commit(seq uint64) error
+	// Len возвращает число невоспроизведённых операций.
+	Len() int
+	Close() error
+}
+
+// MemoryJournalSizeDefault — число операций, которое вмещает журнал в памяти.
+const MemoryJournalSizeDefault = 100_000
+
+// ErrJournalFull — журнал заполнен, операция не принята.
+var ErrJournalFull = &customerror.UnavailableError{Info: "failover journal is full"}
+
+// MemoryJournal хранит операции в памяти, они теряются при перезапуске сервера.
+// Журнал вмещает не больше size операций: когда он заполнен, новые операции
+// отклоняются целиком с ErrJournalFull, а принятые ранее сохраняются.
+// Клиент получает 503 и может повторить запись после восстановления.
+type MemoryJournal struct {
+	ops  []Op
+	seq  uint64
+	size int
+	m    sync.Mutex
+}
+
+func NewMemoryJournal(size int) *MemoryJournal {
+	return &MemoryJournal{size: size}
+}
+
+func (j *MemoryJournal) Append(ops ...Op) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if len(j.ops)+len(ops) > j.size {
+		return ErrJournalFull
+	}
+	for _, op := range ops {
+		j.seq++
+		op.Seq = j.seq
+		j.ops = append(j.ops, op)
+	}
+	return nil
+}
+
+func (j *MemoryJournal) Pending() ([]Op, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	return slices.Clone(j.ops), nil
+}
+
+func (j *MemoryJournal) Commit(seq uint64) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	i, _ := slices.BinarySearchFunc(j.ops, seq+1, func(op Op, s uint64) int {
+		return cmp.Compare(op.Seq, s)
+	})
+	j.ops = slices.Delete(j.ops, 0, i)
+	return nil
+}
+
+func (j *MemoryJournal) Len() int {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	return len(j.ops)
+}
+
+func (j *MemoryJournal) Close() error {
+	return nil
+}
+
+const (
+	journalFile = "journal.log"
+	// commitFile хранит номер последней воспроизведённой операции.
+	commitFile    = "journal.commit"
+	permissionDir = 0o700
+)
+
+// FileJournal хранит операции в файле journal.log каталога dir
+// и переживает перезапуск сервера. Каждая запись дожидается сброса на диск.
+// Номер последней воспроизведённой операции хранится в journal.commit;
+// когда воспроизведены все операции, журнал очищается.
+// Операции нумеруются подряд, поэтому невоспроизведённых seq - committed.
+type FileJournal struct {
+	file      *os.File
+	dir       string
+	seq       uint64
+	committed uint64
+	m         sync.Mutex
+}
+
+// NewFileJournal открывает журнал в каталоге dir.
+// Недописанный при сбое хвост журнала отбрасывается.
+func NewFileJournal(dir string) (*FileJournal, error) {
+	if err := os.MkdirAll(dir, permissionDir); err != nil {
+		return nil, fmt.Errorf("failed to create journal dir: %w", err)
+	}
+	j := &FileJournal{dir: dir}
+
+	data, err := os.ReadFile(filepath.Join(dir, commitFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read journal commit: %w", err)
+	default:
+		if j.committed, err = strconv.ParseUint(string(bytes.TrimSpace(data)), 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse journal commit: %w", err)
+		}
+	}
+	j.seq = j.committed
+
+	j.file, err = os.OpenFile(filepath.Join(dir, journalFile),
+		os.O_RDWR|os.O_CREATE|os.O_APPEND, constants.PermissionFilePrivate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	ops, err := j.read()
+	if err != nil {
+		_ = j.file.Close()
+		return nil, err
+	}
+	for _, op := range ops {
+		j.seq = max(j.seq, op.Seq)
+	}
+	return j, nil
+}
+
+// read читает все записи журнала. Вызывается под блокировкой.
+func (j *FileJournal) read() ([]Op, error) {
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek journal: %w", err)
+	}
+
+	ops := make([]Op, 0)
+	reader := bufio.NewReader(j.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			break
+		}
+		var op Op
+		if err != nil || json.Unmarshal(bytes.TrimSpace(line), &op) != nil {
+			if err = j.file.Truncate(offset); err != nil {
+				return nil, fmt.Errorf("failed to truncate journal: %w", err)
+			}
+			break
+		}
+		offset += int64(len(line))
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func (j *FileJournal) Append(ops ...Op) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if len(ops) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, op := range ops {
+		op.Seq = j.seq + 1
+		if err := encoder.Encode(op); err != nil {
+			return fmt.Errorf("failed to encode journal record: %w", err)
+		}
+		j.seq++
+	}
+	if _, err := j.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+func (j *FileJournal) Pending() ([]Op, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	ops, err := j.read()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(ops, func(op Op) bool {
+		return op.Seq <= j.committed
+	}), nil
+}
+
+func (j *FileJournal) Commit(seq uint64) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if seq <= j.committed {
+		return nil
+	}
+	err := atomicfile.Write(filepath.Join(j.dir, commitFile),
+		[]byte(strconv.FormatUint(seq, 10)), constants.PermissionFilePrivate)
+	if err != nil {
+		return fmt.Errorf("failed to write journal commit: %w", err)
+	}
+	j.committed = seq
+	if j.committed < j.seq {
+		return nil
+	}
+
+	// номер последней операции сохранён в journal.commit,
+	// поэтому нумерация продолжится и после очистки
+	if err = j.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	return nil
+}
+
+func (j *FileJournal) Len() int {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	return int(j.seq - j.committed)
+}
+
+func (j *FileJournal) Close() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("failed to close journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package failover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+func addOp(name string, delta int64) Op {
+	m := counter(name, delta, false)
+	return Op{Metric: &m}
+}
+
+func TestFileJournal(t *testing.T) {
+	dir := t.TempDir()
+	j, err := NewFileJournal(dir)
+	require.NoError(t, err)
+	require.NoError(t, j.Append(addOp("a", 1), addOp("b", 2)))
+	require.NoError(t, j.Append(Op{Kind: OpDelete, Key: &model.MetricKey{Name: "a", Type: model.MetricTypeCounter}}))
+	require.NoError(t, j.Close())
+
+	j, err = NewFileJournal(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 3, j.Len())
+	ops, err := j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 3)
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{ops[0].Seq, ops[1].Seq, ops[2].Seq})
+	assert.Equal(t, OpDelete, ops[2].Kind)
+
+	require.NoError(t, j.Commit(2))
+	require.NoError(t, j.Close())
+	j, err = NewFileJournal(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, j.Len())
+	ops, err = j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	assert.Equal(t, uint64(3), ops[0].Seq)
+
+	require.NoError(t, j.Commit(3))
+	assert.Zero(t, j.Len())
+	info, err := os.Stat(filepath.Join(dir, journalFile))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	// нумерация продолжается после очистки журнала
+	require.NoError(t, j.Append(addOp("c", 3)))
+	ops, err = j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	assert.Equal(t, uint64(4), ops[0].Seq)
+	require.NoError(t, j.Close())
+}
+
+func TestFileJournal_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	j, err := NewFileJournal(dir)
+	require.NoError(t, err)
+	require.NoError(t, j.Append(addOp("a", 1)))
+	require.NoError(t, j.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"metric":{"id":"b"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, err = NewFileJournal(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, j.Len())
+	require.NoError(t, j.Append(addOp("b", 2)))
+	ops, err := j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, "b", ops[1].Metric.Name)
+	assert.Equal(t, uint64(2), ops[1].Seq)
+	require.NoError(t, j.Close())
+}
+
+func TestMemoryJournal_Commit(t *testing.T) {
+	j := NewMemoryJournal(MemoryJournalSizeDefault)
+	require.NoError(t, j.Append(addOp("a", 1), addOp("b", 2), addOp("c", 3)))
+
+	require.NoError(t, j.Commit(2))
+	ops, err := j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	assert.Equal(t, "c", ops[0].Metric.Name)
+
+	require.NoError(t, j.Commit(3))
+	assert.Zero(t, j.Len())
+}
+
+// Заполненный журнал отклоняет операции целиком, сохраняя принятые.
+func TestMemoryJournal_Full(t *testing.T) {
+	j := NewMemoryJournal(2)
+	require.NoError(t, j.Append(addOp("a", 1)))
+	require.ErrorIs(t, j.Append(addOp("b", 2), addOp("c", 3)), ErrJournalFull)
+	assert.Equal(t, 1, j.Len())
+	require.NoError(t, j.Append(addOp("b", 2)))
+	require.ErrorIs(t, j.Append(addOp("c", 3)), ErrJournalFull)
+
+	require.NoError(t, j.Commit(1))
+	require.NoError(t, j.Append(addOp("c", 3)))
+	ops, err := j.Pending()
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, uint64(3), ops[1].Seq)
+}
@@ -0,0 +1,67 @@
+// Package gated задерживает запись в хранилище, пока её не пропустит
+// внешняя блокировка.
+//
+// Восстановление резервной копии заменяет состояние хранилища и очищает
+// буфер изменений. Запись, пришедшая в это время, попала бы в закрытый
+// буфер или была бы стёрта вместе с ним, поэтому она ждёт замены.
+package gated
+
+import (
+	"context"
+	"sync"
+
+	"github.com/talx-hub/malerter/internal/api/handlers"
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+// Gate выдаёт блокировку, которую запись держит, пока меняет хранилище.
+type Gate interface {
+	Writes() sync.Locker
+}
+
+// Storage — хранилище, запись в которое держит блокировку gate.
+type Storage struct {
+	handlers.Storage
+	gate sync.Locker
+}
+
+func New(storage handlers.Storage, gate sync.Locker) *Storage {
+	return &Storage{Storage: storage, gate: gate}
+}
+
+func (s *Storage) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+	//nolint:wrapcheck // errors of the storage are returned as they are
+	return s.Storage.Add(ctx, metric)
+}
+
+func (s *Storage) CompareAndSet(
+	ctx context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+	//nolint:wrapcheck // errors of the storage are returned as they are
+	return s.Storage.CompareAndSet(ctx, metric, cond)
+}
+
+func (s *Storage) Batch(ctx context.Context, metrics []model.Metric) ([]model.Metric, error) {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+	//nolint:wrapcheck // errors of the storage are returned as they are
+	return s.Storage.Batch(ctx, metrics)
+}
+
+func (s *Storage) Delete(ctx context.Context, key model.MetricKey) error {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+	//nolint:wrapcheck // errors of the storage are returned as they are
+	return s.Storage.Delete(ctx, key)
+}
+
+func (s *Storage) Reset(ctx context.Context, key model.MetricKey) error {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+	//nolint:wrapcheck // errors of the storage are returned as they are
+	return s.Storage.Reset(ctx, key)
+}
@@ -0,0 +1,51 @@
+package gated
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/pkg/queue"
+)
+
+func counter(name string, delta int64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeCounter, Delta: &delta}
+}
+
+func TestStorage_WaitsForGate(t *testing.T) {
+	ctx := context.Background()
+	buffer := queue.New[model.Change]()
+	var gate sync.RWMutex
+	s := New(memory.New(logger.NewNopLogger(), &buffer), gate.RLocker())
+
+	gate.Lock()
+	done := make(chan error)
+	go func() {
+		_, err := s.Add(ctx, counter("hits", 1))
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("write passed a closed gate")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Zero(t, buffer.Len())
+
+	gate.Unlock()
+	require.NoError(t, <-done)
+	assert.Equal(t, 1, buffer.Len())
+
+	// открытые ворота пропускают записи одновременно
+	gate.RLock()
+	_, err := s.Batch(ctx, []model.Metric{counter("hits", 2), counter("errors", 1)})
+	gate.RUnlock()
+	require.NoError(t, err)
+	assert.Equal(t, 3, buffer.Len())
+}
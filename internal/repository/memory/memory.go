@@ -1,92 +1,504 @@
 package memory
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
-	"sync"
+	"hash/maphash"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/talx-hub/malerter/internal/customerror"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/pkg/queue"
+	"github.com/talx-hub/malerter/pkg/ring"
 )
 
+// HistorySize — число последних точек, хранимых для каждого ряда.
+const HistorySize = 1024
+
+// DefaultShards — число шардов хранилища по умолчанию.
+const DefaultShards = 32
+
+// Memory хранит метрики в шардах: ключ метрики по хешу определяет шард,
+// у каждого шарда своя блокировка, поэтому запись разных рядов
+// не сериализуется.
 type Memory struct {
 	log    *logger.ZeroLogger
-	buffer *queue.Queue[model.Metric]
-	data   map[string]model.Metric
-	m      sync.RWMutex
+	buffer *queue.Queue[model.Change]
+	// overflow хранит model.OverflowPolicy.
+	overflow atomic.Value
+	shards   []*shard
+}
+
+// seed — затравка хеша, распределяющего ключи по шардам.
+var seed = maphash.MakeSeed()
+
+func New(log *logger.ZeroLogger, buf *queue.Queue[model.Change]) *Memory {
+	return NewSharded(log, buf, DefaultShards)
 }
 
-func New(log *logger.ZeroLogger, buf *queue.Queue[model.Metric]) *Memory {
-	return &Memory{
+// NewSharded создаёт хранилище из n шардов.
+// При n = 1 все записи идут под одной блокировкой.
+func NewSharded(log *logger.ZeroLogger, buf *queue.Queue[model.Change], n int) *Memory {
+	r := &Memory{
 		log:    log,
 		buffer: buf,
-		data:   make(map[string]model.Metric),
+		shards: make([]*shard, max(n, 1)),
+	}
+	for i := range r.shards {
+		r.shards[i] = newShard()
 	}
+	r.SetOverflowPolicy(model.OverflowSaturate)
+	return r
 }
 
-func (r *Memory) Add(_ context.Context, metric model.Metric) error {
-	dummyKey := metric.Type.String() + " " + metric.Name
+func (r *Memory) shard(key model.MetricKey) *shard {
+	return r.shards[maphash.Comparable(seed, key)%uint64(len(r.shards))]
+}
 
-	r.m.Lock()
-	defer r.m.Unlock()
+// SetOverflowPolicy задаёт поведение счётчиков при переполнении.
+func (r *Memory) SetOverflowPolicy(policy model.OverflowPolicy) {
+	r.overflow.Store(policy)
+}
 
-	if old, found := r.data[dummyKey]; found {
-		err := old.Update(metric)
-		if err != nil {
-			return fmt.Errorf("unable to update metric in storage: %w", err)
-		}
-		n := old
-		r.data[dummyKey] = n
-	} else {
-		r.data[dummyKey] = metric
+// Add сохраняет метрику и возвращает её значение после слияния.
+// В буфер резервного копирования метрика попадает после снятия
+// блокировки шарда, вместе с ревизией, которую получил ряд:
+// изменения ряда могут попасть в буфер не в порядке применения
+// и упорядочиваются по ревизии при восстановлении.
+func (r *Memory) Add(_ context.Context, metric model.Metric) (model.Metric, error) {
+	policy, _ := r.overflow.Load().(model.OverflowPolicy)
+	s := r.shard(metric.Key())
+	stored, err := s.add(metric, policy)
+	if err != nil {
+		return model.Metric{}, err
 	}
+	r.push(s, metric, stored)
+	return stored, nil
+}
+
+// push передаёт записанную метрику в буфер резервного копирования
+// с ревизией, которую получил ряд. Вызывается без блокировки шарда.
+func (r *Memory) push(s *shard, metric, stored model.Metric) {
+	metric.Revision = stored.Revision
+	r.changed(model.Change{Metric: metric})
+	s.pushes.Done()
+}
+
+// changed передаёт изменение в буфер резервного копирования.
+func (r *Memory) changed(c model.Change) {
 	if r.buffer != nil && !r.buffer.IsClosed() {
-		r.buffer.Push(metric)
+		r.buffer.Push(c)
 	}
-	return nil
 }
 
-func (r *Memory) Batch(ctx context.Context, batch []model.Metric) error {
+// CompareAndSet сохраняет метрику, только если её текущее состояние
+// удовлетворяет условию cond, иначе возвращает customerror.ConflictError.
+// Проверка и запись выполняются под одной блокировкой шарда.
+func (r *Memory) CompareAndSet(
+	_ context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	if err := cond.CheckValid(); err != nil {
+		return model.Metric{}, fmt.Errorf("invalid condition: %w", err)
+	}
+	policy, _ := r.overflow.Load().(model.OverflowPolicy)
+	s := r.shard(metric.Key())
+	stored, err := s.compareAndSet(metric, cond, policy)
+	if err != nil {
+		return model.Metric{}, err
+	}
+	r.push(s, metric, stored)
+	return stored, nil
+}
+
+// Batch сохраняет метрики и возвращает их значения после слияния.
+// Пакет применяется целиком или не применяется вовсе:
+// если хотя бы одну метрику нельзя слить с сохранённой (например,
+// у гистограммы другая раскладка корзин), возвращается её ошибка.
+// Проверка и запись выполняются под блокировками всех затронутых шардов,
+// в буфер резервного копирования метрики попадают после их снятия.
+func (r *Memory) Batch(_ context.Context, batch []model.Metric) ([]model.Metric, error) {
+	policy, _ := r.overflow.Load().(model.OverflowPolicy)
+	merged, err := r.batch(batch, policy)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range batch {
+		r.push(r.shard(m.Key()), m, merged[i])
+	}
+	return merged, nil
+}
+
+func (r *Memory) batch(batch []model.Metric, policy model.OverflowPolicy) ([]model.Metric, error) {
+	touched := make(map[*shard]struct{}, len(r.shards))
 	for _, m := range batch {
-		if err := r.Add(ctx, m); err != nil {
-			r.log.Error().Err(err).Msg("failed to update batch metric")
+		touched[r.shard(m.Key())] = struct{}{}
+	}
+	// шарды блокируются в одном порядке, чтобы пакеты не ждали друг друга по кругу
+	for _, s := range r.shards {
+		if _, ok := touched[s]; ok {
+			s.m.Lock()
+			defer s.m.Unlock()
 		}
 	}
-	return nil
-}
 
-func (r *Memory) Find(_ context.Context, key string) (model.Metric, error) {
-	r.m.RLock()
-	defer r.m.RUnlock()
+	staged := make(map[model.MetricKey]model.Metric, len(batch))
+	for _, m := range batch {
+		key := m.Key()
+		current, found := staged[key]
+		if !found {
+			current, found = r.shard(key).data[key]
+		}
+		if !found {
+			staged[key] = m.Baseline()
+			continue
+		}
+		if err := current.Update(m, policy); err != nil {
+			return nil, fmt.Errorf("batch failed on the metric %s: %w", m.String(), err)
+		}
+		staged[key] = current
+	}
+
+	merged := make([]model.Metric, 0, len(batch))
+	for _, m := range batch {
+		stored, err := r.shard(m.Key()).merge(m, policy)
+		if err != nil {
+			// проверка выше выполнена под теми же блокировками
+			return nil, fmt.Errorf("batch failed on the metric %s: %w", m.String(), err)
+		}
+		merged = append(merged, stored)
+	}
+	for _, m := range batch {
+		r.shard(m.Key()).pushes.Add(1)
+	}
+	return merged, nil
+}
 
-	if m, found := r.data[key]; found {
+func (r *Memory) Find(_ context.Context, key model.MetricKey) (model.Metric, error) {
+	if m, found := r.shard(key).find(key); found {
 		return m, nil
 	}
 	return model.Metric{},
 		&customerror.NotFoundError{}
 }
 
-func (r *Memory) Get(_ context.Context) ([]model.Metric, error) {
-	r.m.RLock()
-	defer r.m.RUnlock()
+func (r *Memory) FindByLabels(
+	_ context.Context,
+	tenant string,
+	mType model.MetricType,
+	name string,
+	matchers model.Labels,
+) ([]model.Metric, error) {
+	metrics := make([]model.Metric, 0)
+	for _, s := range r.shards {
+		s.m.RLock()
+		for _, m := range s.data {
+			if m.Tenant == tenant && m.Type == mType && m.Name == name &&
+				m.Labels.Match(matchers) {
+				metrics = append(metrics, m)
+			}
+		}
+		s.m.RUnlock()
+	}
+	return metrics, nil
+}
+
+// Delete удаляет метрику вместе с её историей и агрегатами.
+func (r *Memory) Delete(_ context.Context, key model.MetricKey) error {
+	s := r.shard(key)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	m, found := s.data[key]
+	if !found {
+		return &customerror.NotFoundError{Info: "metric " + key.String()}
+	}
+	delete(s.data, key)
+	s.removeKey(key)
+	delete(s.history, key)
+	delete(s.rollups, key)
+	// ряд, созданный заново, начнётся с ревизии 1, поэтому записи,
+	// применённые до удаления, должны попасть в буфер раньше него
+	s.pushes.Wait()
+	r.changed(model.NewDeletion(key, m.Revision+1))
+	return nil
+}
+
+// Reset обнуляет счётчик, см. model.Metric.Reset.
+func (r *Memory) Reset(_ context.Context, key model.MetricKey) error {
+	s := r.shard(key)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	m, found := s.data[key]
+	if !found {
+		return &customerror.NotFoundError{Info: "metric " + key.String()}
+	}
+	now := time.Now()
+	if err := m.Reset(now); err != nil {
+		return fmt.Errorf("unable to reset metric in storage: %w", err)
+	}
+	m.Revision++
+	s.data[key] = m
+	s.record(key)
+	r.changed(model.NewReset(key, m.Revision, now))
+	return nil
+}
+
+func (r *Memory) History(
+	_ context.Context, key model.MetricKey, from, to time.Time,
+) ([]model.Sample, error) {
+	s := r.shard(key)
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	samples := make([]model.Sample, 0)
+	h, found := s.history[key]
+	if !found {
+		return samples, nil
+	}
+	for _, sample := range sortedSamples(h) {
+		if !sample.Timestamp.Before(from) && !sample.Timestamp.After(to) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+// sortedSamples возвращает точки в хронологическом порядке:
+// отметки времени задаёт агент, поэтому порядок добавления
+// не обязан совпадать с хронологическим.
+func sortedSamples(h *ring.Ring[model.Sample]) []model.Sample {
+	samples := h.Items()
+	slices.SortStableFunc(samples, func(a, b model.Sample) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return samples
+}
+
+func (r *Memory) Rollups(
+	_ context.Context,
+	key model.MetricKey,
+	resolution time.Duration,
+	from, to time.Time,
+) ([]model.Rollup, error) {
+	s := r.shard(key)
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	rollups := make([]model.Rollup, 0)
+	for _, rl := range s.rollups[key][resolution] {
+		if !rl.Start.Before(from) && !rl.Start.After(to) {
+			rollups = append(rollups, rl)
+		}
+	}
+	return rollups, nil
+}
+
+// Compact сжимает завершённые интервалы в агрегаты каждого уровня
+// и удаляет данные, срок хранения которых истёк.
+// Точки, пришедшие в уже сжатый интервал, в агрегаты не попадают.
+func (r *Memory) Compact(_ context.Context, policy model.Retention, now time.Time) error {
+	for _, s := range r.shards {
+		s.compact(policy, now)
+	}
+	return nil
+}
+
+func (s *shard) compact(policy model.Retention, now time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for key, h := range s.history {
+		tiers, found := s.rollups[key]
+		if !found {
+			tiers = make(map[time.Duration][]model.Rollup)
+			s.rollups[key] = tiers
+		}
+
+		source := make([]model.Rollup, 0, h.Len())
+		for _, sample := range sortedSamples(h) {
+			source = append(source, sample.Rollup())
+		}
+		for _, t := range policy.Tiers {
+			tiers[t.Resolution] = rollUp(tiers[t.Resolution], source, t.Resolution, now)
+			source = tiers[t.Resolution]
+		}
+
+		if policy.Raw > 0 {
+			expired := now.Add(-policy.Raw)
+			h.Retain(func(sample model.Sample) bool {
+				return !sample.Timestamp.Before(expired)
+			})
+		}
+		for _, t := range policy.Tiers {
+			if t.Retention > 0 {
+				expired := now.Add(-t.Retention)
+				tiers[t.Resolution] = slices.DeleteFunc(tiers[t.Resolution],
+					func(rl model.Rollup) bool { return rl.Start.Before(expired) })
+			}
+		}
+	}
+}
+
+// rollUp дополняет агрегаты разрешения resolution интервалами из source,
+// которые завершились к моменту now и ещё не были сжаты.
+func rollUp(
+	rollups, source []model.Rollup, resolution time.Duration, now time.Time,
+) []model.Rollup {
+	var watermark time.Time
+	if n := len(rollups); n > 0 {
+		watermark = rollups[n-1].Start.Add(resolution)
+	}
+	cutoff := model.BucketStart(now, resolution)
+
+	pending := make([]model.Rollup, 0)
+	for _, rl := range source {
+		if !rl.Start.Before(watermark) && rl.Start.Before(cutoff) {
+			pending = append(pending, rl)
+		}
+	}
+	return append(rollups, model.Coarsen(pending, resolution)...)
+}
+
+// Get возвращает страницу метрик, отобранных по opts.
+// Упорядоченные ключи шардов читаются без блокировки и сливаются,
+// значение каждой отобранной метрики читается под блокировкой её шарда,
+// поэтому чтение списка не останавливает запись.
+func (r *Memory) Get(_ context.Context, opts model.ListOptions) ([]model.Metric, error) {
+	if err := opts.CheckValid(); err != nil {
+		return nil, fmt.Errorf("invalid list options: %w", err)
+	}
+	match := opts.Matcher()
+
+	start := model.MetricKey{Tenant: opts.Tenant, Name: opts.Prefix}
+	if opts.AllTenants {
+		start = model.MetricKey{}
+	}
+	cursors := make(keyCursors, 0, len(r.shards))
+	for _, s := range r.shards {
+		keys := s.sortedKeys()
+		i, _ := slices.BinarySearchFunc(keys, start, model.CompareKeys)
+		if opts.After != nil {
+			j, found := slices.BinarySearchFunc(keys, *opts.After, model.CompareKeys)
+			if found {
+				j++
+			}
+			i = max(i, j)
+		}
+		if i < len(keys) {
+			cursors = append(cursors, keyCursor{keys: keys[i:], shard: s})
+		}
+	}
+	heap.Init(&cursors)
 
 	var metrics = make([]model.Metric, 0)
-	for _, m := range r.data {
-		metrics = append(metrics, m)
+	for cursors.Len() > 0 {
+		if opts.Limit > 0 && len(metrics) == opts.Limit {
+			break
+		}
+		c := &cursors[0]
+		key, s := c.keys[0], c.shard
+		// ключи упорядочены по арендатору и имени,
+		// дальше арендатор и префикс уже не встретятся
+		if !opts.AllTenants && (key.Tenant != opts.Tenant || !strings.HasPrefix(key.Name, opts.Prefix)) {
+			break
+		}
+		if c.keys = c.keys[1:]; len(c.keys) == 0 {
+			heap.Pop(&cursors)
+		} else {
+			heap.Fix(&cursors, 0)
+		}
+		if !match(key) {
+			continue
+		}
+		// ряд мог быть удалён после снятия снимка ключей
+		if m, found := s.find(key); found {
+			metrics = append(metrics, m)
+		}
 	}
 	return metrics, nil
 }
 
+// keyCursor — непрочитанная часть снимка ключей шарда.
+type keyCursor struct {
+	shard *shard
+	keys  []model.MetricKey
+}
+
+// keyCursors — куча курсоров, упорядоченная по первому ключу.
+type keyCursors []keyCursor
+
+func (c keyCursors) Len() int { return len(c) }
+func (c keyCursors) Less(i, j int) bool {
+	return model.CompareKeys(c[i].keys[0], c[j].keys[0]) < 0
+}
+func (c keyCursors) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c *keyCursors) Push(x any) {
+	if cursor, ok := x.(keyCursor); ok {
+		*c = append(*c, cursor)
+	}
+}
+func (c *keyCursors) Pop() any {
+	old := *c
+	n := len(old)
+	last := old[n-1]
+	*c = old[:n-1]
+	return last
+}
+
+// Replace заменяет все метрики хранилища метриками metrics вместе
+// с их историей и агрегатами. Все шарды блокируются на время замены,
+// поэтому ни запись, ни чтение не застают хранилище наполовину заменённым.
+// Метрики сохраняются без слияния, с их ревизиями, и не попадают
+// в буфер резервного копирования.
+func (r *Memory) Replace(_ context.Context, metrics []model.Metric) error {
+	byShard := make(map[*shard][]model.Metric, len(r.shards))
+	for _, m := range metrics {
+		if err := m.CheckValid(); err != nil {
+			return fmt.Errorf("invalid metric %s: %w", m.String(), err)
+		}
+		s := r.shard(m.Key())
+		byShard[s] = append(byShard[s], m)
+	}
+
+	for _, s := range r.shards {
+		s.m.Lock()
+	}
+	defer func() {
+		for _, s := range r.shards {
+			s.m.Unlock()
+		}
+	}()
+	for _, s := range r.shards {
+		s.clear(len(byShard[s]))
+		keys := make([]model.MetricKey, 0, len(byShard[s]))
+		for _, m := range byShard[s] {
+			key := m.Key()
+			m.Revision = max(m.Revision, 1)
+			s.data[key] = m
+			keys = append(keys, key)
+			s.record(key)
+		}
+		slices.SortFunc(keys, model.CompareKeys)
+		s.keys.Store(slices.Compact(keys))
+	}
+	return nil
+}
+
 func (r *Memory) Ping(_ context.Context) error {
 	return errors.New("a DB is not initialised, store in memory")
 }
 
 func (r *Memory) Clear() {
-	r.m.Lock()
-	defer r.m.Unlock()
-
-	r.data = make(map[string]model.Metric)
+	for _, s := range r.shards {
+		s.m.Lock()
+		s.clear(0)
+		s.m.Unlock()
+	}
 }
@@ -0,0 +1,88 @@
+package memory_test
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/pkg/queue"
+)
+
+// benchSeries — число рядов, которые пишут параллельные агенты.
+const benchSeries = 1024
+
+func benchMetrics() []model.Metric {
+	metrics := make([]model.Metric, 0, benchSeries)
+	for i := range benchSeries {
+		metrics = append(metrics, newMetric("gauge"+strconv.Itoa(i), model.MetricTypeGauge, float64(i)))
+	}
+	return metrics
+}
+
+// BenchmarkMemory_Add_Parallel сравнивает запись из многих горутин
+// в хранилище с одной блокировкой и в шардированное:
+//
+//	go test -run '^$' -bench Memory_Add_Parallel -cpu 1,4,8 ./internal/repository/memory/
+func BenchmarkMemory_Add_Parallel(b *testing.B) {
+	for _, shards := range []int{1, memory.DefaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			buf := queue.New[model.Change]()
+			mem := memory.NewSharded(logger.NewNopLogger(), &buf, shards)
+			metrics := benchMetrics()
+			var next atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 97
+				for pb.Next() {
+					i++
+					if _, err := mem.Add(context.Background(), metrics[i%benchSeries]); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMemory_AddGet_Parallel измеряет запись при параллельном
+// постраничном чтении: каждая десятая операция читает страницу списка.
+func BenchmarkMemory_AddGet_Parallel(b *testing.B) {
+	for _, shards := range []int{1, memory.DefaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			mem := memory.NewSharded(logger.NewNopLogger(), nil, shards)
+			metrics := benchMetrics()
+			for _, m := range metrics {
+				if _, err := mem.Add(context.Background(), m); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var next atomic.Int64
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 97
+				for pb.Next() {
+					i++
+					var err error
+					if i%10 == 0 {
+						_, err = mem.Get(context.Background(), model.ListOptions{Limit: 100})
+					} else {
+						_, err = mem.Add(context.Background(), metrics[i%benchSeries])
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -3,7 +3,13 @@ package memory_test
 import (
 	"context"
 	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -12,6 +18,7 @@ import (
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/pkg/queue"
 )
 
 func newMetric(name string, mType model.MetricType, value float64) model.Metric {
@@ -32,10 +39,10 @@ func TestMemory_AddAndFind(t *testing.T) {
 
 	metric := newMetric("CPU", model.MetricTypeGauge, 99.9)
 
-	err := mem.Add(ctx, metric)
+	_, err := mem.Add(ctx, metric)
 	require.NoError(t, err)
 
-	found, err := mem.Find(ctx, "gauge CPU")
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "CPU"})
 	require.NoError(t, err)
 	assert.Equal(t, *metric.Value, *found.Value)
 }
@@ -46,24 +53,44 @@ func TestMemory_Add_UpdateExisting(t *testing.T) {
 	mem := memory.New(log, nil)
 
 	metric := newMetric("RAM", model.MetricTypeGauge, 50.0)
-	err := mem.Add(ctx, metric)
+	_, err := mem.Add(ctx, metric)
 	require.NoError(t, err)
 
 	updatedMetric := newMetric("RAM", model.MetricTypeGauge, 75.0)
-	err = mem.Add(ctx, updatedMetric)
+	_, err = mem.Add(ctx, updatedMetric)
 	require.NoError(t, err)
 
-	found, err := mem.Find(ctx, "gauge RAM")
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "RAM"})
 	require.NoError(t, err)
 	assert.Equal(t, 75.0, *found.Value)
 }
 
+func TestMemory_Add_ReturnsMerged(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	_, err := mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 2))
+	require.NoError(t, err)
+	stored, err := mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 3))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *stored.Delta)
+
+	merged, err := mem.Batch(ctx, []model.Metric{
+		newMetric("hits", model.MetricTypeCounter, 1),
+		newMetric("cpu", model.MetricTypeGauge, 0.5),
+	})
+	require.NoError(t, err)
+	require.Len(t, merged, 2)
+	assert.Equal(t, int64(6), *merged[0].Delta)
+	assert.Equal(t, 0.5, *merged[1].Value)
+}
+
 func TestMemory_Find_NotFound(t *testing.T) {
 	ctx := context.Background()
 	log := logger.NewNopLogger()
 	mem := memory.New(log, nil)
 
-	_, err := mem.Find(ctx, "gauge NotExist")
+	_, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "NotExist"})
 	assert.Error(t, err)
 	var notFoundErr *customerror.NotFoundError
 	assert.True(t, errors.As(err, &notFoundErr))
@@ -77,10 +104,10 @@ func TestMemory_Get(t *testing.T) {
 	metric1 := newMetric("M1", model.MetricTypeGauge, 10.2)
 	metric2 := newMetric("M2", model.MetricTypeCounter, 20)
 
-	_ = mem.Add(ctx, metric1)
-	_ = mem.Add(ctx, metric2)
+	_, _ = mem.Add(ctx, metric1)
+	_, _ = mem.Add(ctx, metric2)
 
-	all, err := mem.Get(ctx)
+	all, err := mem.Get(ctx, model.ListOptions{})
 	require.NoError(t, err)
 	assert.Len(t, all, 2)
 }
@@ -99,10 +126,10 @@ func TestMemory_Clear(t *testing.T) {
 	log := logger.NewNopLogger()
 	mem := memory.New(log, nil)
 
-	_ = mem.Add(ctx, newMetric("ClearMe", model.MetricTypeGauge, 1.1))
+	_, _ = mem.Add(ctx, newMetric("ClearMe", model.MetricTypeGauge, 1.1))
 	mem.Clear()
 
-	all, err := mem.Get(ctx)
+	all, err := mem.Get(ctx, model.ListOptions{})
 	require.NoError(t, err)
 	assert.Len(t, all, 0)
 }
@@ -117,10 +144,664 @@ func TestMemory_Batch(t *testing.T) {
 		newMetric("B2", model.MetricTypeGauge, 2.0),
 	}
 
-	err := mem.Batch(ctx, batch)
+	_, err := mem.Batch(ctx, batch)
 	require.NoError(t, err)
 
-	all, err := mem.Get(ctx)
+	all, err := mem.Get(ctx, model.ListOptions{})
 	require.NoError(t, err)
 	assert.Len(t, all, 2)
 }
+
+// Пакет, в котором одну метрику нельзя слить с сохранённой,
+// отклоняется целиком.
+func TestMemory_Batch_Atomic(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+	hist := func(bounds ...float64) model.Metric {
+		h := model.Histogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+		return model.Metric{Name: "latency", Type: model.MetricTypeHistogram, Histogram: &h}
+	}
+
+	_, err := mem.Batch(ctx, []model.Metric{newMetric("B1", model.MetricTypeGauge, 1.0), hist(1, 2)})
+	require.NoError(t, err)
+	_, err = mem.Batch(ctx, []model.Metric{newMetric("B1", model.MetricTypeGauge, 5.0), hist(1, 5)})
+	var invalid *customerror.InvalidArgumentError
+	require.ErrorAs(t, err, &invalid)
+
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "B1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, *found.Value)
+	assert.Equal(t, int64(1), found.Revision)
+}
+
+func TestMemory_Add_Histogram(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewNopLogger()
+	mem := memory.New(log, nil)
+
+	h := model.Metric{
+		Name: "latency",
+		Type: model.MetricTypeHistogram,
+		Histogram: &model.Histogram{
+			Bounds: []float64{0.1, 1},
+			Counts: []int64{1, 1, 0},
+			Sum:    0.6,
+			Count:  2,
+		},
+	}
+	_, err := mem.Add(ctx, h)
+	require.NoError(t, err)
+	_, err = mem.Add(ctx, h)
+	require.NoError(t, err)
+
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeHistogram, Name: "latency"})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 2, 0}, found.Histogram.Counts)
+	assert.Equal(t, int64(4), found.Histogram.Count)
+
+	other := model.Metric{
+		Name: "latency",
+		Type: model.MetricTypeHistogram,
+		Histogram: &model.Histogram{
+			Bounds: []float64{0.5},
+			Counts: []int64{1, 0},
+			Sum:    0.3,
+			Count:  1,
+		},
+	}
+	_, err = mem.Add(ctx, other)
+	var invalidArgErr *customerror.InvalidArgumentError
+	assert.True(t, errors.As(err, &invalidArgErr))
+}
+
+func TestMemory_FindByLabels(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewNopLogger()
+	mem := memory.New(log, nil)
+
+	cpu1 := newMetric("CPUutilization", model.MetricTypeGauge, 10)
+	cpu1.Labels = model.Labels{"host": "a", "cpu": "1"}
+	cpu2 := newMetric("CPUutilization", model.MetricTypeGauge, 20)
+	cpu2.Labels = model.Labels{"host": "a", "cpu": "2"}
+	other := newMetric("CPUutilization", model.MetricTypeGauge, 30)
+	other.Labels = model.Labels{"host": "b", "cpu": "1"}
+	for _, m := range []model.Metric{cpu1, cpu2, other} {
+		_, err := mem.Add(ctx, m)
+		require.NoError(t, err)
+	}
+
+	found, err := mem.FindByLabels(
+		ctx, model.DefaultTenant, model.MetricTypeGauge, "CPUutilization", model.Labels{"host": "a"})
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	found, err = mem.FindByLabels(
+		ctx, model.DefaultTenant, model.MetricTypeGauge, "CPUutilization", model.Labels{"cpu": "1"})
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	found, err = mem.FindByLabels(
+		ctx, model.DefaultTenant, model.MetricTypeCounter, "CPUutilization", model.Labels{"cpu": "1"})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	exact, err := mem.Find(ctx, cpu2.Key())
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, *exact.Value)
+}
+
+func TestMemory_Find_NameWithSpace(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewNopLogger()
+	mem := memory.New(log, nil)
+
+	_, err := mem.Add(ctx, newMetric("heap alloc", model.MetricTypeGauge, 1))
+	require.NoError(t, err)
+	_, err = mem.Add(ctx, newMetric("heap", model.MetricTypeGauge, 2))
+	require.NoError(t, err)
+
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "heap alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, *found.Value)
+
+	found, err = mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "heap"})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, *found.Value)
+}
+
+func TestMemory_Add_Timestamp(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second)
+	metric := newMetric("hits", model.MetricTypeCounter, 1)
+	metric.Timestamp = &first
+	_, err := mem.Add(ctx, metric)
+	require.NoError(t, err)
+
+	metric.Timestamp = &second
+	_, err = mem.Add(ctx, metric)
+	require.NoError(t, err)
+
+	found, err := mem.Find(ctx, metric.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *found.Delta)
+	require.NotNil(t, found.Timestamp)
+	assert.Equal(t, second, *found.Timestamp)
+}
+
+func TestMemory_Add_CounterOverflow(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+	mem.SetOverflowPolicy(model.OverflowWrap)
+
+	key := model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}
+	maxDelta := int64(math.MaxInt64)
+	_, err := mem.Add(ctx, model.Metric{
+		Name: "hits", Type: model.MetricTypeCounter, Delta: &maxDelta})
+	require.NoError(t, err)
+	_, err = mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 1))
+	require.NoError(t, err)
+
+	found, err := mem.Find(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MinInt64), *found.Delta)
+	assert.Equal(t, int64(1), found.Resets)
+}
+
+func TestMemory_Add_Cumulative(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	for _, total := range []int64{10, 25, 5} {
+		m := newMetric("Mallocs", model.MetricTypeCounter, float64(total))
+		m.Cumulative = true
+		_, err := mem.Add(ctx, m)
+		require.NoError(t, err)
+	}
+
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "Mallocs"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), *found.Delta)
+	assert.Equal(t, int64(5), *found.Total)
+	assert.Equal(t, int64(1), found.Resets)
+	assert.False(t, found.Cumulative)
+}
+
+func TestMemory_History(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// точки приходят не по порядку
+	for _, sec := range []int{20, 0, 10} {
+		ts := start.Add(time.Duration(sec) * time.Second)
+		m := newMetric("hits", model.MetricTypeCounter, 1)
+		m.Timestamp = &ts
+		_, err := mem.Add(ctx, m)
+		require.NoError(t, err)
+	}
+
+	key := model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}
+	samples, err := mem.History(ctx, key, start, start.Add(15*time.Second))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, model.Sample{Timestamp: start, Value: 2}, samples[0])
+	assert.Equal(t, model.Sample{Timestamp: start.Add(10 * time.Second), Value: 3}, samples[1])
+
+	for i := 0; i < memory.HistorySize; i++ {
+		_, err := mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 1))
+		require.NoError(t, err)
+	}
+	samples, err = mem.History(ctx, key, time.Time{}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, samples, memory.HistorySize)
+
+	mem.Clear()
+	samples, err = mem.History(ctx, key, time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestMemory_Compact(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, sec := range []int{0, 30, 60, 90, 3600} {
+		ts := start.Add(time.Duration(sec) * time.Second)
+		m := newMetric("cpu", model.MetricTypeGauge, float64(sec))
+		m.Timestamp = &ts
+		_, err := mem.Add(ctx, m)
+		require.NoError(t, err)
+	}
+	key := model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}
+	policy := model.NewRetention(time.Hour,
+		model.RollupTier{Resolution: time.Minute, Retention: 24 * time.Hour},
+		model.RollupTier{Resolution: time.Hour, Retention: 0},
+	)
+
+	// завершены только интервалы до 01:00:30 по минутам и до 01:00 по часам
+	now := start.Add(time.Hour + 30*time.Second)
+	require.NoError(t, mem.Compact(ctx, policy, now))
+
+	minutes, err := mem.Rollups(ctx, key, time.Minute, start, now)
+	require.NoError(t, err)
+	require.Len(t, minutes, 2)
+	assert.Equal(t, model.Rollup{Start: start, Min: 0, Max: 30, Sum: 30, Last: 30, Count: 2}, minutes[0])
+
+	hours, err := mem.Rollups(ctx, key, time.Hour, start, now)
+	require.NoError(t, err)
+	require.Len(t, hours, 1)
+	assert.Equal(t, int64(4), hours[0].Count)
+
+	// исходные точки старше часа удалены
+	raw, err := mem.History(ctx, key, start, now)
+	require.NoError(t, err)
+	require.Len(t, raw, 4)
+	assert.Equal(t, start.Add(30*time.Second), raw[0].Timestamp)
+
+	// повторное сжатие не дублирует агрегаты, а минутные агрегаты истекают
+	require.NoError(t, mem.Compact(ctx, policy, start.Add(25*time.Hour)))
+	minutes, err = mem.Rollups(ctx, key, time.Minute, start, start.Add(25*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, minutes, 1)
+	assert.Equal(t, start.Add(time.Hour), minutes[0].Start)
+	hours, err = mem.Rollups(ctx, key, time.Hour, start, start.Add(25*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, hours, 2)
+	raw, err = mem.History(ctx, key, start, start.Add(25*time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, raw)
+}
+
+func TestMemory_DumpLoad(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, sec := range []int{0, 30, 90} {
+		ts := start.Add(time.Duration(sec) * time.Second)
+		m := newMetric("cpu", model.MetricTypeGauge, float64(sec))
+		m.Timestamp = &ts
+		_, err := mem.Add(ctx, m)
+		require.NoError(t, err)
+	}
+	_, err := mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 5))
+	require.NoError(t, err)
+	policy := model.NewRetention(0, model.RollupTier{Resolution: time.Minute})
+	require.NoError(t, mem.Compact(ctx, policy, start.Add(2*time.Minute)))
+
+	restored := memory.New(logger.NewNopLogger(), nil)
+	restored.Load(mem.Dump())
+
+	key := model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}
+	want, err := mem.Rollups(ctx, key, time.Minute, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	got, err := restored.Rollups(ctx, key, time.Minute, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	samples, err := restored.History(ctx, key, start, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, samples, 3)
+
+	// загруженный счётчик продолжает накапливаться, а не начинается заново
+	_, err = restored.Add(ctx, newMetric("hits", model.MetricTypeCounter, 2))
+	require.NoError(t, err)
+	hits, err := restored.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *hits.Delta)
+}
+
+func TestMemory_DeleteReset(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	_, err := mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 5))
+	require.NoError(t, err)
+	_, err = mem.Add(ctx, newMetric("cpu", model.MetricTypeGauge, 0.5))
+	require.NoError(t, err)
+	hits := model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}
+	cpu := model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}
+
+	require.NoError(t, mem.Reset(ctx, hits))
+	found, err := mem.Find(ctx, hits)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), *found.Delta)
+	assert.Equal(t, int64(1), found.Resets)
+	samples, err := mem.History(ctx, hits, time.Time{}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, 0.0, samples[1].Value)
+
+	var invalidArgumentError *customerror.InvalidArgumentError
+	assert.ErrorAs(t, mem.Reset(ctx, cpu), &invalidArgumentError)
+
+	require.NoError(t, mem.Delete(ctx, hits))
+	_, err = mem.Find(ctx, hits)
+	var notFoundError *customerror.NotFoundError
+	assert.ErrorAs(t, err, &notFoundError)
+	assert.ErrorAs(t, mem.Delete(ctx, hits), &notFoundError)
+	assert.ErrorAs(t, mem.Reset(ctx, hits), &notFoundError)
+	samples, err = mem.History(ctx, hits, time.Time{}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestMemory_Replace(t *testing.T) {
+	ctx := context.Background()
+	tunnel := queue.New[model.Change]()
+	mem := memory.New(logger.NewNopLogger(), &tunnel)
+
+	_, err := mem.Add(ctx, newMetric("old", model.MetricTypeGauge, 1))
+	require.NoError(t, err)
+	_, err = mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 5))
+	require.NoError(t, err)
+	for tunnel.Len() > 0 {
+		tunnel.Pop()
+	}
+
+	hits := newMetric("hits", model.MetricTypeCounter, 3)
+	hits.Revision = 7
+	require.NoError(t, mem.Replace(ctx, []model.Metric{hits, newMetric("cpu", model.MetricTypeGauge, 0.5)}))
+	assert.Zero(t, tunnel.Len())
+
+	// метрики не сливаются с прежними, ревизии сохраняются
+	found, err := mem.Find(ctx, hits.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *found.Delta)
+	assert.Equal(t, int64(7), found.Revision)
+	_, err = mem.Find(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "old"})
+	var notFoundError *customerror.NotFoundError
+	assert.ErrorAs(t, err, &notFoundError)
+	all, err := mem.Get(ctx, model.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// негодная метрика не меняет хранилище
+	require.Error(t, mem.Replace(ctx, []model.Metric{{Name: "broken"}}))
+	all, err = mem.Get(ctx, model.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+}
+
+func TestMemory_Get_Options(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+	for _, name := range []string{"cpu2", "ram", "cpu1", "cpu3"} {
+		_, err := mem.Add(ctx, newMetric(name, model.MetricTypeGauge, 1))
+		require.NoError(t, err)
+	}
+	_, err := mem.Add(ctx, newMetric("cpu1", model.MetricTypeCounter, 1))
+	require.NoError(t, err)
+
+	names := func(metrics []model.Metric) []string {
+		result := make([]string, 0, len(metrics))
+		for _, m := range metrics {
+			result = append(result, m.Type.String()+" "+m.Name)
+		}
+		return result
+	}
+
+	page, err := mem.Get(ctx, model.ListOptions{Prefix: "cpu", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"counter cpu1", "gauge cpu1"}, names(page))
+
+	after := page[1].Key()
+	page, err = mem.Get(ctx, model.ListOptions{Prefix: "cpu", Limit: 2, After: &after})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge cpu2", "gauge cpu3"}, names(page))
+
+	page, err = mem.Get(ctx, model.ListOptions{Type: model.MetricTypeGauge, Pattern: "[13]$"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge cpu1", "gauge cpu3"}, names(page))
+
+	require.NoError(t, mem.Delete(ctx, model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu2"}))
+	page, err = mem.Get(ctx, model.ListOptions{After: &after})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gauge cpu3", "gauge ram"}, names(page))
+
+	_, err = mem.Get(ctx, model.ListOptions{Pattern: "("})
+	var invalidArgumentError *customerror.InvalidArgumentError
+	assert.ErrorAs(t, err, &invalidArgumentError)
+}
+
+func TestMemory_ConcurrentAdd(t *testing.T) {
+	ctx := context.Background()
+	buf := queue.New[model.Change]()
+	mem := memory.New(logger.NewNopLogger(), &buf)
+
+	const writers, adds, series = 8, 500, 50
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range adds {
+				name := "c" + strconv.Itoa((w+i)%series)
+				_, err := mem.Add(ctx, newMetric(name, model.MetricTypeCounter, 1))
+				assert.NoError(t, err)
+				_, err = mem.Get(ctx, model.ListOptions{Limit: 10})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	all, err := mem.Get(ctx, model.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, all, series)
+	var total int64
+	for i, m := range all {
+		if i > 0 {
+			assert.Negative(t, model.CompareKeys(all[i-1].Key(), m.Key()))
+		}
+		total += *m.Delta
+	}
+	assert.Equal(t, int64(writers*adds), total)
+	require.Equal(t, writers*adds, buf.Len())
+
+	// каждое изменение ряда попадает в буфер со своей ревизией,
+	// хотя порядок записей в буфере может отличаться от порядка применения
+	revisions := make(map[model.MetricKey][]int64, series)
+	for buf.Len() > 0 {
+		c := buf.Pop()
+		revisions[c.Key()] = append(revisions[c.Key()], c.Revision)
+	}
+	for key, got := range revisions {
+		slices.Sort(got)
+		for i, rev := range got {
+			require.Equal(t, int64(i+1), rev, key.String())
+		}
+	}
+}
+
+// Удаление попадает в буфер после всех записей ряда, применённых до него.
+func TestMemory_DeleteAfterPushes(t *testing.T) {
+	ctx := context.Background()
+	buf := queue.New[model.Change]()
+	mem := memory.New(logger.NewNopLogger(), &buf)
+	key := model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				_, err := mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 1))
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for range 20 {
+		_ = mem.Delete(ctx, key)
+	}
+	wg.Wait()
+
+	// между удалениями в буфере ровно записи одного поколения ряда:
+	// ревизии с 1 подряд, а удаление получает следующую
+	var generation []int64
+	check := func(next int64) {
+		slices.Sort(generation)
+		for i, rev := range generation {
+			require.Equal(t, int64(i+1), rev)
+		}
+		require.Equal(t, int64(len(generation)+1), next)
+		generation = generation[:0]
+	}
+	for buf.Len() > 0 {
+		c := buf.Pop()
+		if c.Kind == model.ChangeDelete {
+			check(c.Revision)
+			continue
+		}
+		generation = append(generation, c.Revision)
+	}
+	check(int64(len(generation) + 1))
+}
+
+func TestNewSharded(t *testing.T) {
+	ctx := context.Background()
+	for _, n := range []int{0, 1, 7} {
+		mem := memory.NewSharded(logger.NewNopLogger(), nil, n)
+		for i := range 20 {
+			_, err := mem.Add(ctx, newMetric("g"+strconv.Itoa(i), model.MetricTypeGauge, 1))
+			require.NoError(t, err)
+		}
+		page, err := mem.Get(ctx, model.ListOptions{Prefix: "g1", Limit: 5})
+		require.NoError(t, err)
+		require.Len(t, page, 5)
+		assert.Equal(t, "g1", page[0].Name)
+		assert.Equal(t, "g10", page[1].Name)
+		assert.Equal(t, "g13", page[4].Name)
+	}
+}
+
+func TestMemory_Tenant(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+
+	shared := newMetric("PollCount", model.MetricTypeCounter, 1)
+	teamA := newMetric("PollCount", model.MetricTypeCounter, 5)
+	teamA.Tenant = "team-a"
+	teamB := newMetric("Alloc", model.MetricTypeGauge, 7)
+	teamB.Tenant = "team-b"
+	for _, m := range []model.Metric{shared, teamA, teamA, teamB} {
+		_, err := mem.Add(ctx, m)
+		require.NoError(t, err)
+	}
+
+	found, err := mem.Find(ctx, shared.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *found.Delta)
+	found, err = mem.Find(ctx, teamA.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *found.Delta)
+	assert.Equal(t, "team-a", found.Tenant)
+
+	for tenant, want := range map[string][]string{
+		model.DefaultTenant: {"PollCount"},
+		"team-a":            {"PollCount"},
+		"team-b":            {"Alloc"},
+		"team-c":            {},
+	} {
+		list, err := mem.Get(ctx, model.ListOptions{Tenant: tenant})
+		require.NoError(t, err)
+		names := make([]string, 0, len(list))
+		for _, m := range list {
+			assert.Equal(t, tenant, m.Tenant)
+			names = append(names, m.Name)
+		}
+		assert.Equal(t, want, names, tenant)
+	}
+	all, err := mem.Get(ctx, model.ListOptions{AllTenants: true})
+	require.NoError(t, err)
+	keys := make([]model.MetricKey, 0, len(all))
+	for _, m := range all {
+		keys = append(keys, m.Key())
+	}
+	assert.Equal(t, []model.MetricKey{shared.Key(), teamA.Key(), teamB.Key()}, keys)
+
+	byLabels, err := mem.FindByLabels(ctx, "team-b", model.MetricTypeCounter, "PollCount", nil)
+	require.NoError(t, err)
+	assert.Empty(t, byLabels)
+
+	require.NoError(t, mem.Delete(ctx, teamA.Key()))
+	_, err = mem.Find(ctx, shared.Key())
+	require.NoError(t, err)
+}
+
+func TestMemory_CompareAndSet(t *testing.T) {
+	ctx := context.Background()
+	buf := queue.New[model.Change]()
+	mem := memory.New(logger.NewNopLogger(), &buf)
+	absent, stale := int64(0), int64(7)
+
+	stored, err := mem.CompareAndSet(ctx, newMetric("flag", model.MetricTypeGauge, 1),
+		model.Condition{Revision: &absent})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stored.Revision)
+
+	var conflict *customerror.ConflictError
+	_, err = mem.CompareAndSet(ctx, newMetric("flag", model.MetricTypeGauge, 2),
+		model.Condition{Revision: &absent})
+	require.ErrorAs(t, err, &conflict)
+	_, err = mem.CompareAndSet(ctx, newMetric("flag", model.MetricTypeGauge, 2),
+		model.Condition{Revision: &stale})
+	require.ErrorAs(t, err, &conflict)
+	_, err = mem.CompareAndSet(ctx, newMetric("other", model.MetricTypeGauge, 2),
+		model.Condition{Revision: &stored.Revision})
+	require.ErrorAs(t, err, &conflict)
+
+	expected := 1.0
+	stored, err = mem.CompareAndSet(ctx, newMetric("flag", model.MetricTypeGauge, 2),
+		model.Condition{Value: &expected})
+	require.NoError(t, err)
+	assert.InDelta(t, 2.0, *stored.Value, 1e-9)
+	assert.Equal(t, int64(2), stored.Revision)
+	assert.Equal(t, 2, buf.Len())
+
+	_, err = mem.CompareAndSet(ctx, newMetric("flag", model.MetricTypeGauge, 3), model.Condition{})
+	var invalidArgumentError *customerror.InvalidArgumentError
+	require.ErrorAs(t, err, &invalidArgumentError)
+
+	// сброс тоже меняет ревизию
+	_, err = mem.Add(ctx, newMetric("hits", model.MetricTypeCounter, 5))
+	require.NoError(t, err)
+	require.NoError(t, mem.Reset(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}))
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), found.Revision)
+}
+
+func TestMemory_CompareAndSet_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New(logger.NewNopLogger(), nil)
+	_, err := mem.Add(ctx, newMetric("lock", model.MetricTypeCounter, 1))
+	require.NoError(t, err)
+
+	const writers = 8
+	var wg sync.WaitGroup
+	var won atomic.Int64
+	revision := int64(1)
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mem.CompareAndSet(ctx, newMetric("lock", model.MetricTypeCounter, 1),
+				model.Condition{Revision: &revision})
+			if err == nil {
+				won.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), won.Load())
+	found, err := mem.Find(ctx, model.MetricKey{Type: model.MetricTypeCounter, Name: "lock"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *found.Delta)
+}
@@ -0,0 +1,157 @@
+package memory
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/pkg/ring"
+)
+
+// shard — часть хранилища со своей блокировкой.
+// Ряд целиком (значение, история, агрегаты) хранится в одном шарде.
+type shard struct {
+	data map[model.MetricKey]model.Metric
+	// keys — ключи data, упорядоченные по model.CompareKeys,
+	// чтобы список метрик читался страницами без сортировки.
+	// Срез не изменяется, при добавлении и удалении ряда
+	// он заменяется копией, поэтому читается без блокировки.
+	keys    atomic.Value
+	history map[model.MetricKey]*ring.Ring[model.Sample]
+	rollups map[model.MetricKey]map[time.Duration][]model.Rollup
+	// pushes — записи, применённые под блокировкой,
+	// но ещё не переданные в буфер резервного копирования.
+	pushes sync.WaitGroup
+	m      sync.RWMutex
+}
+
+func newShard() *shard {
+	s := &shard{}
+	s.clear(0)
+	return s
+}
+
+// Вызывается под блокировкой на запись.
+func (s *shard) clear(size int) {
+	s.data = make(map[model.MetricKey]model.Metric, size)
+	s.keys.Store([]model.MetricKey{})
+	s.history = make(map[model.MetricKey]*ring.Ring[model.Sample], size)
+	s.rollups = make(map[model.MetricKey]map[time.Duration][]model.Rollup, size)
+}
+
+// add сохраняет метрику. После успешной записи вызывающий
+// передаёт её в буфер и отмечает это вызовом pushes.Done.
+func (s *shard) add(metric model.Metric, policy model.OverflowPolicy) (model.Metric, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	stored, err := s.merge(metric, policy)
+	if err != nil {
+		return model.Metric{}, err
+	}
+	s.pushes.Add(1)
+	return stored, nil
+}
+
+// compareAndSet сохраняет метрику, только если её текущее состояние
+// удовлетворяет условию cond. После успешной записи вызывающий
+// передаёт её в буфер, как после add.
+func (s *shard) compareAndSet(
+	metric model.Metric, cond model.Condition, policy model.OverflowPolicy,
+) (model.Metric, error) {
+	key := metric.Key()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if current, found := s.data[key]; !cond.Match(current, found) {
+		return model.Metric{}, &customerror.ConflictError{
+			Info: "condition does not match metric " + key.String(),
+		}
+	}
+	stored, err := s.merge(metric, policy)
+	if err != nil {
+		return model.Metric{}, err
+	}
+	s.pushes.Add(1)
+	return stored, nil
+}
+
+// merge сливает метрику с сохранённой и увеличивает ревизию ряда.
+// Вызывается под блокировкой на запись.
+func (s *shard) merge(metric model.Metric, policy model.OverflowPolicy) (model.Metric, error) {
+	key := metric.Key()
+	if old, found := s.data[key]; found {
+		err := old.Update(metric, policy)
+		if err != nil {
+			return model.Metric{},
+				fmt.Errorf("unable to update metric in storage: %w", err)
+		}
+		old.Revision++
+		s.data[key] = old
+	} else {
+		stored := metric.Baseline()
+		stored.Revision = 1
+		s.data[key] = stored
+		s.insertKey(key)
+	}
+	s.record(key)
+	return s.data[key], nil
+}
+
+// record добавляет текущее значение ряда в историю.
+// Вызывается под блокировкой на запись.
+func (s *shard) record(key model.MetricKey) {
+	stored := s.data[key]
+	sample, ok := stored.Sample(time.Now())
+	if !ok {
+		return
+	}
+	h, found := s.history[key]
+	if !found {
+		h = ring.New[model.Sample](HistorySize)
+		s.history[key] = h
+	}
+	h.Push(sample)
+}
+
+func (s *shard) find(key model.MetricKey) (model.Metric, bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	m, found := s.data[key]
+	return m, found
+}
+
+// sortedKeys возвращает снимок упорядоченных ключей шарда.
+func (s *shard) sortedKeys() []model.MetricKey {
+	keys, _ := s.keys.Load().([]model.MetricKey)
+	return keys
+}
+
+// Вызывается под блокировкой на запись.
+func (s *shard) insertKey(key model.MetricKey) {
+	keys := s.sortedKeys()
+	i, found := slices.BinarySearchFunc(keys, key, model.CompareKeys)
+	if !found {
+		next := make([]model.MetricKey, 0, len(keys)+1)
+		next = append(next, keys[:i]...)
+		next = append(next, key)
+		next = append(next, keys[i:]...)
+		s.keys.Store(next)
+	}
+}
+
+// Вызывается под блокировкой на запись.
+func (s *shard) removeKey(key model.MetricKey) {
+	keys := s.sortedKeys()
+	i, found := slices.BinarySearchFunc(keys, key, model.CompareKeys)
+	if found {
+		keys = slices.Delete(slices.Clone(keys), i, i+1)
+		s.keys.Store(keys)
+	}
+}
@@ -0,0 +1,85 @@
+package memory
+
+import (
+	"slices"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/pkg/ring"
+)
+
+// Series — полное состояние ряда: метрика, её история и агрегаты.
+type Series struct {
+	Rollups map[time.Duration][]model.Rollup `json:"rollups,omitempty"`
+	History []model.Sample                   `json:"history,omitempty"`
+	Metric  model.Metric                     `json:"metric"`
+}
+
+// Dump возвращает состояние всех рядов хранилища.
+// Состояние согласовано в пределах шарда, но не между шардами.
+func (r *Memory) Dump() []Series {
+	series := make([]Series, 0)
+	for _, s := range r.shards {
+		series = append(series, s.dump()...)
+	}
+	return series
+}
+
+func (s *shard) dump() []Series {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	series := make([]Series, 0, len(s.data))
+	for key, m := range s.data {
+		ser := Series{Metric: m}
+		if tiers, found := s.rollups[key]; found {
+			ser.Rollups = make(map[time.Duration][]model.Rollup, len(tiers))
+			for res, rollups := range tiers {
+				ser.Rollups[res] = slices.Clone(rollups)
+			}
+		}
+		if h, found := s.history[key]; found {
+			ser.History = sortedSamples(h)
+		}
+		series = append(series, ser)
+	}
+	return series
+}
+
+// Load заменяет содержимое хранилища состоянием, полученным из Dump.
+// Метрики не проходят через слияние и не попадают в буфер резервного копирования.
+func (r *Memory) Load(series []Series) {
+	byShard := make(map[*shard][]Series, len(r.shards))
+	for _, s := range series {
+		sh := r.shard(s.Metric.Key())
+		byShard[sh] = append(byShard[sh], s)
+	}
+	for _, sh := range r.shards {
+		sh.load(byShard[sh])
+	}
+}
+
+func (s *shard) load(series []Series) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.clear(len(series))
+	keys := make([]model.MetricKey, 0, len(series))
+	for _, ser := range series {
+		key := ser.Metric.Key()
+		s.data[key] = ser.Metric
+		keys = append(keys, key)
+		if len(ser.History) != 0 {
+			h := ring.New[model.Sample](HistorySize)
+			for _, sample := range ser.History {
+				h.Push(sample)
+			}
+			s.history[key] = h
+		}
+		if len(ser.Rollups) != 0 {
+			s.rollups[key] = ser.Rollups
+		}
+	}
+	slices.SortFunc(keys, model.CompareKeys)
+	s.keys.Store(keys)
+}
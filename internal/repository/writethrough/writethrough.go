@@ -0,0 +1,77 @@
+// Package writethrough реализует синхронное резервное копирование:
+// запись в хранилище завершается, только когда её изменения сохранены
+// в журнале резервной копии.
+//
+// Хранилище кладёт изменения в буфер резервной копии, а затем запись
+// ждёт Syncer. Одновременные записи ждут одного сохранения журнала,
+// поэтому сброс на диск делится между ними.
+package writethrough
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/talx-hub/malerter/internal/api/handlers"
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+// Syncer сохраняет в журнал изменения, уже попавшие в буфер.
+type Syncer interface {
+	Sync(ctx context.Context) error
+}
+
+// Storage — хранилище, запись в которое дожидается резервной копии.
+type Storage struct {
+	handlers.Storage
+	syncer Syncer
+}
+
+func New(storage handlers.Storage, syncer Syncer) *Storage {
+	return &Storage{Storage: storage, syncer: syncer}
+}
+
+func (s *Storage) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
+	stored, err := s.Storage.Add(ctx, metric)
+	if err != nil {
+		//nolint:wrapcheck // errors of the storage are returned as they are
+		return stored, err
+	}
+	if err = s.sync(ctx); err != nil {
+		return model.Metric{}, err
+	}
+	return stored, nil
+}
+
+func (s *Storage) CompareAndSet(
+	ctx context.Context, metric model.Metric, cond model.Condition,
+) (model.Metric, error) {
+	stored, err := s.Storage.CompareAndSet(ctx, metric, cond)
+	if err != nil {
+		//nolint:wrapcheck // errors of the storage are returned as they are
+		return stored, err
+	}
+	if err = s.sync(ctx); err != nil {
+		return model.Metric{}, err
+	}
+	return stored, nil
+}
+
+// Batch ждёт одного сохранения журнала на все метрики.
+func (s *Storage) Batch(ctx context.Context, metrics []model.Metric) ([]model.Metric, error) {
+	stored, err := s.Storage.Batch(ctx, metrics)
+	if err != nil {
+		//nolint:wrapcheck // errors of the storage are returned as they are
+		return nil, err
+	}
+	if err = s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (s *Storage) sync(ctx context.Context) error {
+	if err := s.syncer.Sync(ctx); err != nil {
+		return fmt.Errorf("metrics are stored, but not backed up: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package writethrough
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/customerror"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/pkg/queue"
+)
+
+// bufferSyncer сохраняет буфер хранилища при каждом вызове Sync.
+type bufferSyncer struct {
+	err    error
+	buffer *queue.Queue[model.Change]
+	saved  []model.Change
+	syncs  int
+}
+
+func (s *bufferSyncer) Sync(_ context.Context) error {
+	s.syncs++
+	if s.err != nil {
+		return s.err
+	}
+	for s.buffer.Len() > 0 {
+		s.saved = append(s.saved, s.buffer.Pop())
+	}
+	return nil
+}
+
+func counter(name string, delta int64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeCounter, Delta: &delta}
+}
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	buffer := queue.New[model.Change]()
+	syncer := &bufferSyncer{buffer: &buffer}
+	s := New(memory.New(logger.NewNopLogger(), &buffer), syncer)
+
+	stored, err := s.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *stored.Delta)
+	assert.Len(t, syncer.saved, 1)
+
+	batch, err := s.Batch(ctx, []model.Metric{counter("hits", 3), counter("errors", 1)})
+	require.NoError(t, err)
+	assert.Len(t, batch, 2)
+	assert.Len(t, syncer.saved, 3)
+	assert.Equal(t, 2, syncer.syncs)
+
+	delta := int64(4)
+	_, err = s.CompareAndSet(ctx, counter("hits", 1), model.Condition{Delta: &delta})
+	var conflict *customerror.ConflictError
+	require.ErrorAs(t, err, &conflict)
+	assert.Equal(t, 2, syncer.syncs)
+
+	syncer.err = errors.New("disk is full")
+	_, err = s.Add(ctx, counter("hits", 1))
+	require.ErrorIs(t, err, syncer.err)
+}
@@ -43,6 +43,8 @@ func NewAgent(
 			encrypter,
 			cfg.ServerAddress,
 			cfg.Secret,
+			cfg.Tenant,
+			cfg.TenantKey,
 		)
 		if err != nil {
 			log.Fatal().Err(err).Msg("failed to start grpc agent")
@@ -67,6 +69,8 @@ func NewAgent(
 			compress:  true,
 			log:       log,
 			secret:    cfg.Secret,
+			tenant:    cfg.Tenant,
+			tenantKey: cfg.TenantKey,
 			encrypter: encrypter,
 		},
 	}
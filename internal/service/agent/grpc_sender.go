@@ -4,18 +4,20 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/pkg/crypto"
+	"github.com/talx-hub/malerter/pkg/signature"
 	pb "github.com/talx-hub/malerter/proto"
 )
 
@@ -23,9 +25,11 @@ type GRPCSender struct {
 	client pb.MetricsClient
 	conn   *grpc.ClientConn
 	log    *logger.ZeroLogger
+	tenant string
+	key    string
 }
 
-func NewGRPCSender(log *logger.ZeroLogger, encrypter *crypto.Encrypter, host, secret string,
+func NewGRPCSender(log *logger.ZeroLogger, encrypter *crypto.Encrypter, host, secret, tenant, tenantKey string,
 ) (*GRPCSender, error) {
 	conn, err := grpc.NewClient(host, grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithChainUnaryInterceptor(
@@ -43,6 +47,8 @@ func NewGRPCSender(log *logger.ZeroLogger, encrypter *crypto.Encrypter, host, se
 		conn:   conn,
 		client: client,
 		log:    log,
+		tenant: tenant,
+		key:    tenantKey,
 	}, nil
 }
 
@@ -82,6 +88,10 @@ func marshalBatch(ch <-chan model.Metric) *pb.BatchRequest {
 	for m := range ch {
 		protoM := &pb.Metric{}
 		protoM.Name = m.Name
+		protoM.Labels = m.Labels
+		if m.Timestamp != nil {
+			protoM.Timestamp = timestamppb.New(*m.Timestamp)
+		}
 		switch m.Type {
 		case model.MetricTypeCounter:
 			if m.Delta == nil {
@@ -89,12 +99,24 @@ func marshalBatch(ch <-chan model.Metric) *pb.BatchRequest {
 			}
 			protoM.Type = pb.Metric_Counter
 			protoM.Delta = *m.Delta
+			protoM.Cumulative = m.Cumulative
 		case model.MetricTypeGauge:
 			if m.Value == nil {
 				continue
 			}
 			protoM.Type = pb.Metric_Gauge
 			protoM.Value = *m.Value
+		case model.MetricTypeHistogram:
+			if m.Histogram == nil {
+				continue
+			}
+			protoM.Type = pb.Metric_Histogram
+			protoM.Histogram = &pb.Histogram{
+				Bounds: m.Histogram.Bounds,
+				Counts: m.Histogram.Counts,
+				Sum:    m.Histogram.Sum,
+				Count:  m.Histogram.Count,
+			}
 		default:
 			protoM.Type = pb.Metric_Unspecified
 		}
@@ -116,6 +138,20 @@ func (s *GRPCSender) sendBatch(batch *pb.BatchRequest) error {
 	ctx, cancel := context.WithTimeout(
 		context.Background(), constants.TimeoutAgentRequest)
 	defer cancel()
+	if s.tenant != model.DefaultTenant {
+		// подписывается сообщение до шифрования: сервер проверяет
+		// подпись арендатора у расшифрованного сообщения
+		data, err := pb.SigningBytes(batch)
+		if err != nil {
+			return fmt.Errorf("failed to sign batch: %w", err)
+		}
+		signed := signature.Sign(s.key, time.Now(), []string{pb.Metrics_Batch_FullMethodName, s.tenant}, data)
+		ctx = metadata.AppendToOutgoingContext(ctx,
+			"x-tenant", s.tenant,
+			"x-tenant-signature", signed.Signature,
+			"x-tenant-timestamp", signed.Timestamp,
+			"x-tenant-nonce", signed.Nonce)
+	}
 
 	_, err := s.client.Batch(ctx, batch)
 	if err != nil {
@@ -142,7 +178,7 @@ func marshalMessage(grpcRequest any) ([]byte, error) {
 			"request does not implement *pb.BathRequest: got %T", grpcRequest)
 	}
 
-	data, err := proto.Marshal(protoMsg)
+	data, err := pb.SigningBytes(protoMsg)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"error in marshalling req to bytes: %w", err)
@@ -173,8 +209,7 @@ func NewSigningInterceptor(secret string, log *logger.ZeroLogger,
 		}
 
 		sig := trySign(data, secret)
-		md := metadata.Pairs("signature", sig)
-		mdCtx := metadata.NewOutgoingContext(ctx, md)
+		mdCtx := metadata.AppendToOutgoingContext(ctx, "signature", sig)
 
 		return invoker(mdCtx, method, req, reply, cc, opts...)
 	}
@@ -209,8 +244,7 @@ func NewEncryptingInterceptor(encrypter *crypto.Encrypter, log *logger.ZeroLogge
 			return status.Errorf(
 				codes.Internal, "encrypting failed: %v", err)
 		}
-		md := metadata.Pairs("x-encrypted", "true")
-		mdCtx := metadata.NewOutgoingContext(ctx, md)
+		mdCtx := metadata.AppendToOutgoingContext(ctx, "x-encrypted", "true")
 
 		return invoker(mdCtx, method,
 			&pb.BatchRequest{
@@ -18,6 +18,7 @@ import (
 	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/internal/repository/memory"
 	"github.com/talx-hub/malerter/internal/service/server/customgrpc"
+	"github.com/talx-hub/malerter/pkg/signature"
 	pb "github.com/talx-hub/malerter/proto"
 )
 
@@ -61,6 +62,11 @@ func TestGRPCSender_Send(t *testing.T) {
 		addr,
 		constants.NoSecret,
 		nil,
+		nil,
+		nil,
+		map[string]*signature.Verifier{
+			"team-a": signature.NewVerifier("team-a-key", constants.TenantSignatureWindow),
+		},
 	)
 	defer func() {
 		ctxTO, cancel := context.WithTimeout(
@@ -81,6 +87,8 @@ func TestGRPCSender_Send(t *testing.T) {
 		nil,
 		addr,
 		constants.NoSecret,
+		"team-a",
+		"team-a-key",
 	)
 	require.NoError(t, err)
 	wg := sync.WaitGroup{}
@@ -96,9 +104,12 @@ func TestGRPCSender_Send(t *testing.T) {
 	cancel()
 	wg.Wait()
 
-	result, err := storage.Get(context.Background())
+	result, err := storage.Get(context.Background(), model.ListOptions{Tenant: "team-a"})
 	require.NoError(t, err)
 	assert.Equal(t, wantCount, len(result))
+	result, err = storage.Get(context.Background(), model.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result)
 }
 func TestMarshalMessage_Valid(t *testing.T) {
 	msg1 := &pb.BatchRequest{}
@@ -119,6 +130,26 @@ func TestMarshalMessage_Valid(t *testing.T) {
 	assert.NotEmpty(t, data)
 }
 
+// Подпись сообщения с метками не должна зависеть от порядка обхода map.
+func TestMarshalMessage_Labels(t *testing.T) {
+	msg := &pb.BatchRequest{
+		Payload: &pb.BatchRequest_MetricList{
+			MetricList: &pb.MetricList{
+				Metrics: []*pb.Metric{{
+					Name: "Alloc", Type: pb.Metric_Gauge, Value: 1,
+					Labels: map[string]string{"host": "a", "dc": "b", "rack": "c", "zone": "d"},
+				}},
+			}},
+	}
+	want, err := marshalMessage(msg)
+	require.NoError(t, err)
+	for range 100 {
+		data, err := marshalMessage(msg)
+		require.NoError(t, err)
+		require.Equal(t, want, data)
+	}
+}
+
 func TestMarshalMessage_Invalid(t *testing.T) {
 	data, err := marshalMessage("not a proto message")
 	assert.Error(t, err)
@@ -159,6 +190,25 @@ func TestSigningInterceptor_WithSecret(t *testing.T) {
 	assert.NotEmpty(t, signature)
 }
 
+func TestSigningInterceptor_KeepsMetadata(t *testing.T) {
+	log := logger.NewNopLogger()
+	interceptor := NewSigningInterceptor("my-secret", log)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "team-a")
+	var tenant []string
+	err := interceptor(ctx, "/pb.Metrics/Send", &pb.BatchRequest{}, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, ok := metadata.FromOutgoingContext(ctx)
+			require.True(t, ok)
+			tenant = md.Get("x-tenant")
+			return nil
+		},
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team-a"}, tenant)
+}
+
 func TestSigningInterceptor_MarshalFails(t *testing.T) {
 	log := logger.NewNopLogger()
 	interceptor := NewSigningInterceptor("secret", log)
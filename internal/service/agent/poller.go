@@ -65,12 +65,13 @@ func (p *Poller) update() chan model.Metric {
 	metricCh := make(chan model.Metric, chanCap)
 	defer close(metricCh)
 
+	now := time.Now()
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		runtimeMetrics := collectRuntime()
-		push(metricCh, runtimeMetrics)
+		push(metricCh, runtimeMetrics, now)
 	}()
 
 	wg.Add(1)
@@ -81,15 +82,16 @@ func (p *Poller) update() chan model.Metric {
 			p.log.Error().Err(err).Msg("failed to collect psutil metrics")
 			return
 		}
-		push(metricCh, psutilMetrics)
+		push(metricCh, psutilMetrics, now)
 	}()
 
 	wg.Wait()
 	return metricCh
 }
 
-func push(ch chan<- model.Metric, metrics []model.Metric) {
+func push(ch chan<- model.Metric, metrics []model.Metric, now time.Time) {
 	for _, m := range metrics {
+		m.StampIfMissing(now)
 		ch <- m
 	}
 }
@@ -2,12 +2,14 @@ package agent
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
 )
 
 func TestRuntimeCollect(t *testing.T) {
@@ -43,3 +45,17 @@ func TestStore(t *testing.T) {
 		assert.GreaterOrEqual(t, len(stored), runtimeMetricCount+psutilMinimumCount)
 	})
 }
+
+func TestPush(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m, err := model.NewMetric().FromValues("Alloc", model.MetricTypeGauge, 1.0)
+	require.NoError(t, err)
+
+	ch := make(chan model.Metric, 1)
+	push(ch, []model.Metric{m}, now)
+	close(ch)
+
+	got := <-ch
+	require.NotNil(t, got.Timestamp)
+	assert.Equal(t, now, *got.Timestamp)
+}
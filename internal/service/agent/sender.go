@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
@@ -17,6 +18,7 @@ import (
 	"github.com/talx-hub/malerter/pkg/compressor"
 	"github.com/talx-hub/malerter/pkg/crypto"
 	"github.com/talx-hub/malerter/pkg/retry"
+	"github.com/talx-hub/malerter/pkg/signature"
 )
 
 type HTTPSender struct {
@@ -25,6 +27,8 @@ type HTTPSender struct {
 	encrypter *crypto.Encrypter
 	host      string
 	secret    string
+	tenant    string
+	tenantKey string
 	compress  bool
 }
 
@@ -114,6 +118,15 @@ func (s *HTTPSender) batch(batch []byte, sig string, isCompressed, isEncrypted b
 	if isEncrypted {
 		request.Header.Set("X-Encrypted", "true")
 	}
+	if s.tenant != model.DefaultTenant {
+		// подписывается тело в том виде, в каком оно уходит на сервер
+		signed := signature.Sign(s.tenantKey, time.Now(),
+			[]string{request.Method, request.URL.EscapedPath(), request.URL.RawQuery, s.tenant}, batch)
+		request.Header.Set(constants.KeyTenant, s.tenant)
+		request.Header.Set(constants.KeyTenantSignature, signed.Signature)
+		request.Header.Set(constants.KeyTenantTimestamp, signed.Timestamp)
+		request.Header.Set(constants.KeyTenantNonce, signed.Nonce)
+	}
 
 	wrappedDo := func(args ...any) (any, error) {
 		response, e := s.client.Do(request)
@@ -12,9 +12,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/talx-hub/malerter/internal/api/middlewares"
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/pkg/signature"
 )
 
 func TestToJSONs(t *testing.T) {
@@ -196,6 +198,22 @@ func TestSender_batch_Signature(t *testing.T) {
 	s.batch(data, sig, false, false)
 }
 
+func TestSender_batch_Tenant(t *testing.T) {
+	var tenant string
+	tenants := map[string]*signature.Verifier{"team-a": signature.NewVerifier("team-a-key", time.Minute)}
+	ts := httptest.NewServer(middlewares.CheckTenant(tenants)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenant = middlewares.TenantFromContext(r.Context())
+		})))
+	defer ts.Close()
+
+	s := newTestSender(ts.URL, "", false)
+	s.tenant = "team-a"
+	s.tenantKey = "team-a-key"
+	s.batch([]byte(`[]`), "", false, false)
+	assert.Equal(t, "team-a", tenant)
+}
+
 func TestSender_batch_HTTPError(t *testing.T) {
 	s := newTestSender("http://localhost:9999", "", false) // Unused port to simulate error
 	s.batch([]byte(`[{"bad":"json"}]`), "", false, false)
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/constants"
+)
+
+// errSnapshotsDisabled — снимок затёр бы резервную копию,
+// которую не удалось восстановить при запуске.
+var errSnapshotsDisabled = errors.New("snapshots are disabled after a failed restore")
+
+// Info — резервная копия в хранилище.
+type Info struct {
+	// Modified — время последнего изменения файлов копии.
+	Modified time.Time `json:"modified"`
+	Name     string    `json:"name"`
+	// Error — почему копию нельзя восстановить, пусто у годной копии.
+	Error string `json:"error,omitempty"`
+	// Size — суммарный размер снимка и журнала в байтах.
+	Size int64 `json:"size"`
+	// Snapshot — число записей снимка, Log — годных записей журнала.
+	Snapshot int `json:"snapshot"`
+	Log      int `json:"log"`
+}
+
+// Backup сохраняет накопленные записи и делает снимок, не дожидаясь
+// периода снимков, и возвращает получившуюся резервную копию.
+func (b *Manager) Backup(ctx context.Context) (Info, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.snapshots {
+		return Info{}, errSnapshotsDisabled
+	}
+	if err := b.backup(ctx); err != nil {
+		return Info{}, err
+	}
+	if err := b.snapshot(ctx); err != nil {
+		return Info{}, err
+	}
+	files, err := b.sink.List(ctx)
+	if err != nil {
+		return Info{}, fmt.Errorf("unable to list backups: %w", err)
+	}
+	return b.info(ctx, b.filename, files), nil
+}
+
+// List возвращает резервные копии от новых к старым.
+func (b *Manager) List(ctx context.Context) ([]Info, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names, err := b.backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := b.sink.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backups: %w", err)
+	}
+	infos := make([]Info, 0, len(names))
+	for _, name := range names {
+		if !slices.ContainsFunc(files, func(f File) bool { return belongs(f.Name, name) }) {
+			continue
+		}
+		infos = append(infos, b.info(ctx, name, files))
+	}
+	return infos, nil
+}
+
+// info описывает резервную копию name по файлам хранилища files.
+func (b *Manager) info(ctx context.Context, name string, files []File) Info {
+	info := Info{Name: name}
+	for _, f := range files {
+		if !belongs(f.Name, name) {
+			continue
+		}
+		info.Size += f.Size
+		if f.Modified.After(info.Modified) {
+			info.Modified = f.Modified
+		}
+	}
+
+	snapshot, _, err := readSnapshot(ctx, b.sink, name+snapshotSuffix, b.codec)
+	if err != nil && !errors.Is(err, errNoSnapshot) {
+		info.Error = err.Error()
+		return info
+	}
+	frames, _, err := readLog(ctx, b.sink, name, b.codec)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	log, _ := cut(frames, time.Time{})
+	info.Snapshot = len(snapshot.changes)
+	info.Log = len(log)
+	return info
+}
+
+// belongs сообщает, что файл хранилища — снимок или журнал копии name.
+func belongs(file, name string) bool {
+	return file == name || file == name+snapshotSuffix
+}
+
+// archived — файл резервной копии для архива.
+type archived struct {
+	modified time.Time
+	name     string
+	data     []byte
+}
+
+// archive — tar-архив со снимком и журналом резервной копии.
+type archive struct {
+	files []archived
+}
+
+// Download читает снимок и журнал резервной копии name и возвращает
+// их архив. Ошибки чтения возвращаются до того, как архив начнёт
+// писаться, а сам архив пишется в поток, не собираясь целиком.
+func (b *Manager) Download(ctx context.Context, name string) (io.WriterTo, error) {
+	files, err := b.read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &archive{files: files}, nil
+}
+
+// WriteTo пишет архив в w.
+func (a *archive) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	tw := tar.NewWriter(counter)
+	for _, f := range a.files {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Mode:     constants.PermissionFilePrivate,
+			Size:     int64(len(f.data)),
+			ModTime:  f.modified,
+		})
+		if err != nil {
+			return counter.n, fmt.Errorf("unable to write archive header: %w", err)
+		}
+		if _, err = tw.Write(f.data); err != nil {
+			return counter.n, fmt.Errorf("unable to write archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return counter.n, fmt.Errorf("unable to write archive: %w", err)
+	}
+	return counter.n, nil
+}
+
+// countingWriter считает записанные байты.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	//nolint:wrapcheck // errors of the destination are returned as they are
+	return n, err
+}
+
+// read читает снимок и журнал резервной копии name вместе,
+// чтобы они были согласованы.
+func (b *Manager) read(ctx context.Context, name string) ([]archived, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	names, err := b.backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(names, name) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	listed, err := b.sink.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backups: %w", err)
+	}
+	files := make([]archived, 0, 2)
+	for _, f := range listed {
+		if !belongs(f.Name, name) {
+			continue
+		}
+		var data []byte
+		if data, err = b.sink.Read(ctx, f.Name); err != nil {
+			return nil, err
+		}
+		files = append(files, archived{modified: f.Modified, name: f.Name, data: data})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return files, nil
+}
@@ -1,32 +1,136 @@
+// Package backup сохраняет метрики хранилища в файлы и восстанавливает их.
+//
+// Резервная копия состоит из снимка — полного состояния хранилища —
+// и журнала изменений, сделанных после него. Журнал пополняется
+// из буфера хранилища, а когда снимок устаревает или журнал разрастается,
+// записывается новый снимок и журнал очищается. Поэтому время
+// восстановления зависит от числа метрик, а не от времени работы сервера.
+//
+// Файлы пишутся в хранилище Sink: на диск или в S3-совместимое
+// хранилище объектов. В каталоге резервных копий и в хранилище объектов
+// каждый снимок начинает новое поколение, а старые поколения удаляются
+// по числу и возрасту. Восстановление берёт самое новое поколение
+// с годным снимком. Записи журнала, не прошедшие проверку,
+// откладываются в файл рядом с журналом.
+//
+// Кадры журнала и снимки помечены временем записи, поэтому хранилище
+// можно пересобрать на заданный момент: берётся самое новое поколение
+// со снимком не позже этого момента, а более поздние кадры отбрасываются.
+// Записи, пришедшие за интервал сохранения до момента, могут не попасть
+// в пересобранное состояние.
+//
+// При нулевом интервале сохранения копия ведётся синхронно: запись
+// вызывает Sync и ждёт, пока её изменения попадут в журнал и на диск.
+// Одновременные вызовы Sync ждут одной записи журнала.
+//
+// Администратор может сделать копию вне расписания, получить список копий,
+// восстановить выбранную копию или выгрузить её tar-архивом.
+//
+// Файлы резервной копии могут сжиматься и шифроваться; формат файла
+// указан в его заголовке, поэтому прежние копии восстанавливаются как есть.
 package backup
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/talx-hub/malerter/internal/config/server"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/db"
 	"github.com/talx-hub/malerter/pkg/queue"
 )
 
+const (
+	// snapshotRecords — число записей журнала, после которого
+	// снимок делается, не дожидаясь его периода.
+	snapshotRecords = 10000
+)
+
+var (
+	// ErrNoBackups — нет резервной копии, сделанной до момента восстановления.
+	ErrNoBackups = errors.New("no backups")
+	// errStopped — сохранение остановлено, Sync некому выполнить.
+	errStopped = errors.New("backup service is stopped")
+	// errAfterUntil — резервная копия сделана после момента восстановления.
+	errAfterUntil = errors.New("backup is newer than the restore point")
+)
+
+// Storage — хранилище, метрики которого копируются и восстанавливаются.
+// Replace заменяет все метрики хранилища атомарно: при ошибке
+// прежнее состояние сохраняется.
 type Storage interface {
-	Batch(context.Context, []model.Metric) error
-	Get(context.Context) ([]model.Metric, error)
+	Get(context.Context, model.ListOptions) ([]model.Metric, error)
+	Replace(context.Context, []model.Metric) error
+}
+
+// Report — итог восстановления.
+type Report struct {
+	// Until — момент восстановления, нулевой — последняя запись.
+	Until  time.Time `json:"until,omitempty"`
+	Backup string    `json:"backup"`
+	// Snapshot — число записей снимка, Log — применённых записей журнала.
+	Snapshot int `json:"snapshot"`
+	Log      int `json:"log"`
+	// Ignored — записи журнала, сделанные после момента восстановления.
+	Ignored     int `json:"ignored"`
+	Quarantined int `json:"quarantined"`
 }
 
 type Manager struct {
-	log            *logger.ZeroLogger
-	buffer         *queue.Queue[model.Metric]
-	storage        Storage
+	lastSnapshot time.Time
+	// restoreUntil — момент восстановления при запуске.
+	restoreUntil time.Time
+	log          *logger.ZeroLogger
+	buffer       *queue.Queue[model.Change]
+	storage      Storage
+	sink         Sink
+	producer     *producer
+	codec        codec
+	// overflow — политика переполнения счётчиков при пересборке.
+	overflow model.OverflowPolicy
+	// pending — записи, которые не удалось сохранить.
+	pending []model.Change
+	// filename — имя текущего журнала в sink.
 	filename       string
 	backupInterval time.Duration
-	needRestore    bool
+	snapshotPeriod time.Duration
+	maxAge         time.Duration
+	maxFiles       int
+	// records — число записей журнала после последнего снимка.
+	records     int
+	needRestore bool
+	// rotating — каждый снимок начинает новое поколение,
+	// иначе копия одна и журнал очищается после снимка.
+	rotating bool
+	// snapshots выключаются, если резервную копию не удалось восстановить:
+	// новый снимок затёр бы её.
+	snapshots bool
+	// unsnapshotted — снимок при запуске или после восстановления не удался.
+	// Ревизии хранилища начались заново, и записи прежнего журнала
+	// смешались бы с ними, поэтому журнал пополняется только после снимка.
+	unsnapshotted bool
+	// mu упорядочивает сохранение, снимки и восстановление.
+	mu sync.Mutex
+	// writes не пускает запись в хранилище, пока восстановление
+	// заменяет его состояние и очищает буфер.
+	writes sync.RWMutex
+	// waiters — вызовы Sync, ждущие следующей записи журнала,
+	// sync будит Run, stopped закрывается, когда Run завершён.
+	waiters []chan error
+	waitMu  sync.Mutex
+	sync    chan struct{}
+	stopped chan struct{}
 }
 
 func New(
 	config *server.Builder,
-	buffer *queue.Queue[model.Metric],
+	buffer *queue.Queue[model.Change],
 	storage Storage,
 	log *logger.ZeroLogger,
 ) *Manager {
@@ -46,105 +150,447 @@ func New(
 		return nil
 	}
 
+	sink, err := newSink(config)
+	if err != nil {
+		log.Error().Err(err).Msg("backup service: unable to create sink")
+		return nil
+	}
+	rotating := config.BackupDir != "" || config.BackupBucket != ""
+	filename := filepath.Base(config.FileStoragePath)
+	if rotating {
+		filename = newGeneration(time.Now())
+	}
+	var until time.Time
+	if config.RestoreUntil != "" {
+		if until, err = time.Parse(time.RFC3339, config.RestoreUntil); err != nil {
+			log.Error().Err(err).Msg("backup service: invalid restore point")
+			return nil
+		}
+	}
+	overflow := model.OverflowPolicy(config.CounterOverflow)
+	if !overflow.IsValid() {
+		overflow = model.OverflowSaturate
+	}
+	c, err := newCodec(config.BackupCompress, config.BackupKeyPath)
+	if err != nil {
+		log.Error().Err(err).Msg("backup service: unable to create codec")
+		return nil
+	}
+
 	return &Manager{
 		log:            log,
 		buffer:         buffer,
 		storage:        storage,
-		filename:       config.FileStoragePath,
+		sink:           sink,
+		producer:       newProducer(sink, filename, c),
+		codec:          c,
+		overflow:       overflow,
+		filename:       filename,
+		rotating:       rotating,
 		backupInterval: config.StoreInterval,
-		needRestore:    config.Restore,
+		snapshotPeriod: config.SnapshotPeriod,
+		maxAge:         config.BackupMaxAge,
+		maxFiles:       config.BackupMaxFiles,
+		needRestore:    config.Restore || !until.IsZero(),
+		restoreUntil:   until,
+		snapshots:      true,
+		sync:           make(chan struct{}, 1),
+		stopped:        make(chan struct{}),
 	}
 }
 
+// Run восстанавливает метрики, если это нужно, и начинает резервную копию
+// со снимка текущего состояния: без восстановления прежняя копия заменяется.
 func (b *Manager) Run(ctx context.Context) {
+	b.mu.Lock()
 	if b.needRestore {
-		b.restore(ctx)
+		if _, err := b.restore(ctx, b.restoreUntil); err != nil {
+			b.log.Error().Err(err).Msg("backup RESTORE failed, snapshots are disabled")
+			b.snapshots = false
+		}
+	}
+	if err := b.snapshot(ctx); err != nil {
+		b.log.Error().Err(err).Msg("backup snapshot failed, the log waits for a snapshot")
+		b.unsnapshotted = true
 	}
+	b.mu.Unlock()
+	defer close(b.stopped)
 
-	var ticker *time.Ticker
+	// без интервала канал тикера остаётся nil, и записи сохраняются
+	// только по вызовам Sync
+	var tick <-chan time.Time
 	if b.backupInterval != 0 {
-		ticker = time.NewTicker(b.backupInterval)
+		ticker := time.NewTicker(b.backupInterval)
+		defer ticker.Stop()
+		tick = ticker.C
 	}
 
 	b.log.Info().Msg("START backup SERVICE")
 	for {
-		// можно ли в default делать только b.backup()
-		// а отдельным case как-то проверять b.backupInterval != 0
-		// если условие выполняется, то блокируюсь до срабатывания ticker
-		// и затем fallthrough в default??
 		select {
 		case <-ctx.Done():
 			b.log.Info().Msg("SHUTDOWN backup SERVICE...")
-			b.backup()
+			b.commit(context.WithoutCancel(ctx), true)
 			return
+		case <-tick:
+			b.commit(ctx, false)
+		case <-b.sync:
+			b.commit(ctx, false)
+		}
+	}
+}
+
+// Sync возвращает управление, когда записи, попавшие в буфер до вызова,
+// сохранены в журнале. Записи всех ждущих вызовов сохраняются вместе.
+func (b *Manager) Sync(ctx context.Context) error {
+	done := make(chan error, 1)
+	b.waitMu.Lock()
+	b.waiters = append(b.waiters, done)
+	b.waitMu.Unlock()
+	select {
+	case b.sync <- struct{}{}:
+	default:
+		// Run ещё не забрал прежний сигнал и заберёт этот вызов с ним
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-b.stopped:
+		// последняя запись могла успеть разбудить этот вызов
+		select {
+		case err := <-done:
+			return err
 		default:
-			if b.backupInterval != 0 {
-				<-ticker.C
-			}
-			b.backup()
+			return errStopped
 		}
+	case <-ctx.Done():
+		return fmt.Errorf("backup sync is interrupted: %w", ctx.Err())
+	}
+}
+
+// commit сохраняет накопленные записи и будит вызовы Sync,
+// зарегистрированные до начала записи: их записи уже в буфере.
+func (b *Manager) commit(ctx context.Context, snapshot bool) {
+	b.waitMu.Lock()
+	waiters := b.waiters
+	b.waiters = nil
+	b.waitMu.Unlock()
+
+	err := b.save(ctx, snapshot)
+	for _, done := range waiters {
+		done <- err
 	}
 }
 
-func (b *Manager) restore(ctx context.Context) {
-	b.log.Info().Msg("start RESTORE metrics from backup...")
+// save сохраняет накопленные записи и делает снимок, если это нужно
+// или пора. Ошибка снимка не возвращается: записи уже в журнале.
+func (b *Manager) save(ctx context.Context, snapshot bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.backup(ctx)
+	if err != nil {
+		b.log.Error().Err(err).Msg("write metrics to backup failed")
+	}
+	if !snapshot && b.records < snapshotRecords &&
+		(b.snapshotPeriod == 0 || time.Since(b.lastSnapshot) < b.snapshotPeriod) {
+		return err
+	}
+	if snapErr := b.snapshot(ctx); snapErr != nil {
+		b.log.Error().Err(snapErr).Msg("backup snapshot failed")
+	}
+	return err
+}
+
+// Restore пересобирает хранилище из резервной копии name на момент until.
+// Пустое name — самая новая годная копия, нулевой until — момент последней
+// записи. Состояние собирается целиком и заменяет метрики хранилища
+// атомарно: при ошибке хранилище не меняется. Изменения, пришедшие
+// до замены, заменяются пересобранным состоянием, пришедшие после —
+// сохраняются. Запись, взявшая Writes, ждёт замены. Затем делается
+// снимок пересобранного состояния.
+func (b *Manager) Restore(ctx context.Context, name string, until time.Time) (Report, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// журнал должен содержать всё, что пришло до пересборки
+	if err := b.backup(ctx); err != nil {
+		return Report{}, err
+	}
+	metrics, report, err := b.load(ctx, name, until)
+	if err != nil {
+		return report, err
+	}
+
+	b.writes.Lock()
+	b.buffer.Close()
+	err = b.apply(ctx, metrics, report)
+	if err == nil {
+		// записи буфера относятся к заменённому состоянию
+		for b.buffer.Len() > 0 {
+			b.buffer.Pop()
+		}
+	}
+	b.buffer.Open()
+	b.writes.Unlock()
+	if err != nil {
+		return report, err
+	}
+	b.pending = nil
+	b.snapshots = true
+	if err = b.snapshot(ctx); err != nil {
+		b.unsnapshotted = true
+		return report, fmt.Errorf("storage is restored, but %w", err)
+	}
+	return report, nil
+}
+
+// Writes возвращает блокировку, которую запись в хранилище держит,
+// пока кладёт изменения в буфер: Restore ждёт начатые записи
+// и задерживает новые.
+func (b *Manager) Writes() sync.Locker {
+	return b.writes.RLocker()
+}
+
+// restore восстанавливает самую новую годную резервную копию
+// на момент until.
+func (b *Manager) restore(ctx context.Context, until time.Time) (Report, error) {
+	b.log.Info().Time("until", until).Msg("start RESTORE metrics from backup...")
 	b.buffer.Close()
 	defer b.buffer.Open()
 
-	r, err := newRestorer(b.filename)
+	metrics, report, err := b.load(ctx, "", until)
+	if errors.Is(err, ErrNoBackups) && until.IsZero() {
+		b.log.Info().Msg("no backups to RESTORE")
+		return report, nil
+	}
 	if err != nil {
-		b.log.Error().Err(err).Msg("unable to open backup Restorer")
-		return
+		return report, err
 	}
-	defer func() {
-		if err := r.close(); err != nil {
-			b.log.Error().Err(err).Msg("close backup failed")
-		}
-	}()
+	return report, b.apply(ctx, metrics, report)
+}
 
-	metrics, err := r.read()
+// load читает резервную копию name или, если name пусто, самую новую
+// годную копию, снимок которой сделан не позже until.
+func (b *Manager) load(ctx context.Context, name string, until time.Time) ([]model.Metric, Report, error) {
+	candidates, err := b.backups(ctx)
 	if err != nil {
-		b.log.Error().Err(err).Msg("read backup failed")
-		return
+		return nil, Report{}, err
+	}
+	if name != "" {
+		if !slices.Contains(candidates, name) {
+			return nil, Report{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		candidates = []string{name}
 	}
-	err = b.storage.Batch(ctx, metrics)
+	if len(candidates) == 0 {
+		return nil, Report{}, ErrNoBackups
+	}
+
+	newer := 0
+	for _, c := range candidates {
+		var metrics []model.Metric
+		var report Report
+		metrics, report, err = b.loadFrom(ctx, c, until)
+		if err == nil {
+			return metrics, report, nil
+		}
+		if errors.Is(err, errAfterUntil) {
+			newer++
+			b.log.Info().Str("backup", c).Msg("skipping backup made after the restore point")
+			continue
+		}
+		b.log.Warn().Err(err).Str("backup", c).Msg("skipping invalid backup")
+	}
+	if newer == len(candidates) {
+		return nil, Report{}, fmt.Errorf("%w made before %s", ErrNoBackups, until.Format(time.RFC3339))
+	}
+	return nil, Report{}, fmt.Errorf("no valid backup among %d, last error: %w", len(candidates), err)
+}
+
+// backups возвращает имена журналов резервных копий от новых к старым.
+func (b *Manager) backups(ctx context.Context) ([]string, error) {
+	if !b.rotating {
+		return []string{b.filename}, nil
+	}
+	gens, err := generations(ctx, b.sink)
 	if err != nil {
-		b.log.Error().Err(err).Msg("write backup batch failed")
-		return
+		return nil, err
 	}
-	b.log.Info().Msg("backup RESTORE successful!")
+	names := make([]string, 0, len(gens))
+	for _, g := range gens {
+		names = append(names, g.log)
+	}
+	return names, nil
 }
 
-func (b *Manager) backup() {
-	b.log.Info().Msg("start metrics backup...")
-	p, err := newProducer(b.filename)
+func (b *Manager) loadFrom(ctx context.Context, name string, until time.Time) ([]model.Metric, Report, error) {
+	report := Report{Backup: name, Until: until}
+	snapshot, bad, err := readSnapshot(ctx, b.sink, name+snapshotSuffix, b.codec)
+	if errors.Is(err, errNoSnapshot) {
+		err = nil
+	}
 	if err != nil {
-		b.log.Error().Err(err).Msg("unable to open backup Producer")
+		b.report(bad)
+		return nil, report, fmt.Errorf("read snapshot failed: %w", err)
+	}
+	if !until.IsZero() && snapshot.written.After(until) {
+		return nil, report, errAfterUntil
+	}
+	frames, bad, err := readLog(ctx, b.sink, name, b.codec)
+	if err != nil {
+		return nil, report, fmt.Errorf("read backup failed: %w", err)
+	}
+	b.report(bad)
+	b.quarantine(ctx, name, bad)
+
+	log, ignored := cut(frames, until)
+	states := snapshot.states()
+	log = since(states, ordered(log))
+	report.Snapshot = len(states)
+	report.Log = len(log)
+	report.Ignored = ignored
+	report.Quarantined = len(bad)
+	metrics, err := rebuild(states, log, b.overflow)
+	if err != nil {
+		return nil, report, err
+	}
+	return metrics, report, nil
+}
+
+// apply заменяет метрики хранилища восстановленными.
+func (b *Manager) apply(ctx context.Context, metrics []model.Metric, report Report) error {
+	if err := b.storage.Replace(ctx, metrics); err != nil {
+		return fmt.Errorf("unable to replace storage metrics: %w", err)
+	}
+	b.log.Info().
+		Str("backup", report.Backup).
+		Int("snapshot", report.Snapshot).
+		Int("log", report.Log).
+		Int("ignored", report.Ignored).
+		Int("quarantined", report.Quarantined).
+		Msg("backup RESTORE successful!")
+	return nil
+}
+
+// report сообщает о каждой записи, не прошедшей проверку.
+func (b *Manager) report(bad []quarantined) {
+	for _, q := range bad {
+		b.log.Warn().
+			Str("file", q.File).
+			Int("line", q.Line).
+			Str("reason", q.Reason).
+			Msg("backup record quarantined")
+	}
+}
+
+// quarantine откладывает записи журнала, не прошедшие проверку,
+// в отдельный файл: после снимка журнал очищается.
+func (b *Manager) quarantine(ctx context.Context, name string, bad []quarantined) {
+	if len(bad) == 0 {
 		return
 	}
-	defer func() {
-		if err := p.close(); err != nil {
-			b.log.Error().Err(err).Msg("close backup failed")
+	data := make([]byte, 0)
+	for _, q := range bad {
+		data = append(data, q.Data...)
+		if len(q.Data) == 0 || q.Data[len(q.Data)-1] != '\n' {
+			data = append(data, '\n')
 		}
-	}()
+	}
+	if err := b.sink.Append(ctx, name+quarantineSuffix, data); err != nil {
+		b.log.Error().Err(err).Msg("unable to write quarantine file")
+	}
+}
 
-	var metrics []model.Metric
+// snapshot записывает полное состояние хранилища и очищает журнал.
+// Всё, что было в журнале, к этому моменту уже вошло в снимок,
+// а изменения, попавшие в журнал позже, отличаются по ревизии.
+func (b *Manager) snapshot(ctx context.Context) error {
+	if !b.snapshots {
+		return nil
+	}
+	// реплика может отставать, а журнал после снимка будет очищен
+	metrics, err := b.storage.Get(db.WithPrimary(ctx), model.ListOptions{AllTenants: true})
+	if err != nil {
+		return fmt.Errorf("unable to read metrics for snapshot: %w", err)
+	}
+	taken := time.Now()
+	if b.rotating {
+		return b.rotate(ctx, metrics, taken)
+	}
+	if err = writeSnapshot(ctx, b.sink, b.filename+snapshotSuffix, metrics, taken, b.codec); err != nil {
+		return fmt.Errorf("write snapshot failed: %w", err)
+	}
+	if err = b.sink.Write(ctx, b.filename, nil); err != nil {
+		return fmt.Errorf("unable to compact backup log: %w", err)
+	}
+	b.producer = newProducer(b.sink, b.filename, b.codec)
+	b.snapshotDone(len(metrics))
+	return nil
+}
+
+// rotate начинает новое поколение со снимка и удаляет устаревшие.
+// Ошибка удаления не отменяет новое поколение и только пишется в лог.
+func (b *Manager) rotate(ctx context.Context, metrics []model.Metric, now time.Time) error {
+	next := newGeneration(now)
+	if err := writeSnapshot(ctx, b.sink, next+snapshotSuffix, metrics, now, b.codec); err != nil {
+		return fmt.Errorf("write snapshot failed: %w", err)
+	}
+	b.filename = next
+	b.producer = newProducer(b.sink, b.filename, b.codec)
+	b.snapshotDone(len(metrics))
+
+	removed, err := prune(ctx, b.sink, b.filename, b.maxFiles, b.maxAge, now)
+	for _, name := range removed {
+		b.log.Info().Str("backup", name).Msg("old backup removed")
+	}
+	if err != nil {
+		b.log.Error().Err(err).Msg("backup retention failed")
+	}
+	return nil
+}
+
+func (b *Manager) snapshotDone(metrics int) {
+	b.unsnapshotted = false
+	b.records = 0
+	b.lastSnapshot = time.Now()
+	b.log.Info().Str("backup", b.filename).Int("metrics", metrics).Msg("backup snapshot successful!")
+}
+
+func (b *Manager) backup(ctx context.Context) error {
+	b.log.Info().Msg("start metrics backup...")
+	changes := b.pending
+	b.pending = nil
 	for b.buffer.Len() > 0 {
-		metrics = append(metrics, b.buffer.Pop())
+		changes = append(changes, b.buffer.Pop())
 	}
-	if len(metrics) == 0 {
+	if len(changes) == 0 {
 		b.log.Info().Msg("no metrics to backup")
-		return
+		return nil
 	}
-
-	if err = p.write(metrics); err != nil {
-		b.log.Error().Err(err).Msg("write metrics to file failed")
-		return
+	if b.unsnapshotted {
+		if err := b.snapshot(ctx); err != nil {
+			b.pending = changes
+			return fmt.Errorf("backup log waits for a snapshot: %w", err)
+		}
 	}
 
-	if err = p.flush(); err != nil {
-		b.log.Error().Err(err).Msg("flush metrics to backup failed")
-		return
+	if !b.snapshots {
+		// ревизии в журнале сравниваются со снимком этого запуска,
+		// а без него такие записи должны применяться всегда;
+		// упорядочить записи по ревизии можно только до их сброса
+		changes = ordered(changes)
+		for i := range changes {
+			changes[i].Revision = 0
+		}
+	}
+	if err := b.producer.write(ctx, changes); err != nil {
+		// записи сохранятся при следующей попытке, порядок не нарушится
+		b.pending = changes
+		return err
 	}
+	b.records += len(changes)
 	b.log.Info().Msg("metrics backup successful!")
+	return nil
 }
@@ -1,20 +1,36 @@
 package backup
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/talx-hub/malerter/internal/api/middlewares"
 	"github.com/talx-hub/malerter/internal/config/server"
 	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/internal/repository/memory"
 	"github.com/talx-hub/malerter/pkg/queue"
+	"github.com/talx-hub/malerter/pkg/s3"
+	"github.com/talx-hub/malerter/pkg/s3/s3test"
 )
 
 const (
@@ -22,81 +38,860 @@ const (
 )
 
 func TestBackupRestore(t *testing.T) {
-	_ = os.Remove(backupFileName)
-
 	cfg := server.Builder{
-		FileStoragePath: backupFileName,
+		FileStoragePath: filepath.Join(t.TempDir(), backupFileName),
 		StoreInterval:   3600,
 	}
 
 	log, err := logger.New(constants.LogLevelDefault)
 	require.NoError(t, err)
 
-	tunnel := queue.New[model.Metric]()
+	tunnel := queue.New[model.Change]()
 	rep1 := memory.New(log, &tunnel)
 	m1, _ := model.NewMetric().FromValues("mainQuestion", model.MetricTypeCounter, int64(42))
 	m2, _ := model.NewMetric().FromValues("pi", model.MetricTypeGauge, 3.14)
-	_ = rep1.Add(context.TODO(), m1)
-	_ = rep1.Add(context.TODO(), m2)
-	ms1, _ := rep1.Get(context.TODO())
+	_, _ = rep1.Add(context.TODO(), m1)
+	_, _ = rep1.Add(context.TODO(), m2)
+	ms1, _ := rep1.Get(context.TODO(), model.ListOptions{})
 
 	bk1 := New(&cfg, &tunnel, rep1, log)
 	require.NotNil(t, bk1)
-	bk1.backup()
+	require.NoError(t, bk1.backup(context.Background()))
 
 	rep2 := memory.New(log, nil)
 	bk2 := New(&cfg, &tunnel, rep2, log)
 	require.NotNil(t, bk2)
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	bk2.restore(ctx)
+	_, err = bk2.restore(ctx, time.Time{})
+	require.NoError(t, err)
 
-	ms2, _ := rep2.Get(context.TODO())
+	ms2, _ := rep2.Get(context.TODO(), model.ListOptions{})
 	assert.ElementsMatch(t, ms1, ms2)
 }
 
-func TestRun(t *testing.T) {
-	const (
-		fromPrevTest  = 2
-		backupInLoop  = 2
-		numberOfLoops = 5
-	)
+func counter(name string, delta int64) model.Metric {
+	return model.Metric{Name: name, Type: model.MetricTypeCounter, Delta: &delta}
+}
+
+func updates(metrics ...model.Metric) []model.Change {
+	changes := make([]model.Change, 0, len(metrics))
+	for _, m := range metrics {
+		changes = append(changes, model.Change{Metric: m})
+	}
+	return changes
+}
+
+var hitsKey = model.MetricKey{Type: model.MetricTypeCounter, Name: "hits"}
+
+// restored восстанавливает резервную копию в новое хранилище.
+func restored(t *testing.T, cfg *server.Builder) *memory.Memory {
+	t.Helper()
+
+	rep := memory.New(logger.NewNopLogger(), nil)
+	tunnel := queue.New[model.Change]()
+	bk := New(cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	_, err := bk.restore(context.Background(), time.Time{})
+	require.NoError(t, err)
+	return rep
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName)}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	teamA := counter("hits", 5)
+	teamA.Tenant = "team-a"
+	for _, m := range []model.Metric{counter("hits", 1), counter("hits", 2), teamA} {
+		_, err := rep.Add(ctx, m)
+		require.NoError(t, err)
+	}
+	require.NoError(t, bk.backup(context.Background()))
+	// изменение попало в хранилище до снимка, а в журнал — после
+	stored, err := rep.Add(ctx, counter("hits", 3))
+	require.NoError(t, err)
+	late := tunnel.Pop()
+	require.NoError(t, bk.snapshot(ctx))
+	info, err := os.Stat(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	tunnel.Push(late)
+	_, err = rep.Add(ctx, counter("hits", 4))
+	require.NoError(t, err)
+	require.NoError(t, bk.backup(context.Background()))
+	assert.Equal(t, 2, bk.records)
+
+	rep2 := restored(t, &cfg)
+	found, err := rep2.Find(ctx, stored.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *found.Delta)
+	found, err = rep2.Find(ctx, teamA.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *found.Delta)
+}
+
+func TestRestoreFailed(t *testing.T) {
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName), Restore: true}
+	snapshot := cfg.FileStoragePath + snapshotSuffix
+	require.NoError(t, os.WriteFile(snapshot, []byte("{broken\n"), constants.PermissionFilePrivate))
+
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	bk.Run(ctx)
 
+	data, err := os.ReadFile(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, "{broken\n", string(data))
+}
+
+func TestRun(t *testing.T) {
 	cfg := server.Builder{
-		FileStoragePath: backupFileName,
-		StoreInterval:   3 * time.Second,
+		FileStoragePath: filepath.Join(t.TempDir(), backupFileName),
+		StoreInterval:   100 * time.Millisecond,
+		SnapshotPeriod:  250 * time.Millisecond,
 		Restore:         true,
 	}
 
 	log, err := logger.New(constants.LogLevelDefault)
 	require.NoError(t, err)
 
-	tunnel := queue.New[model.Metric]()
+	tunnel := queue.New[model.Change]()
 	rep := memory.New(log, &tunnel)
 	bk := New(&cfg, &tunnel, rep, log)
 	require.NotNil(t, bk)
 
-	timeout := numberOfLoops * cfg.StoreInterval
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-	go bk.Run(ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bk.Run(ctx)
+	}()
 
 	m1, _ := model.NewMetric().FromValues("zero", model.MetricTypeCounter, int64(0))
 	m2, _ := model.NewMetric().FromValues("e", model.MetricTypeGauge, 2.72)
-	_ = rep.Add(context.TODO(), m1)
-	time.Sleep(cfg.StoreInterval)
-	time.Sleep(cfg.StoreInterval)
-	_ = rep.Add(context.TODO(), m2)
+	for range 5 {
+		_, _ = rep.Add(context.TODO(), m1)
+		time.Sleep(cfg.StoreInterval)
+	}
+	_, _ = rep.Add(context.TODO(), m2)
+	cancel()
+	<-done
 
-	<-ctx.Done()
-	r, err := newRestorer(backupFileName)
+	want, err := rep.Get(context.TODO(), model.ListOptions{})
 	require.NoError(t, err)
-	defer func() {
-		err := r.close()
-		require.NoError(t, err)
+	got, err := restored(t, &cfg).Get(context.TODO(), model.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, got, len(want))
+	for i := range want {
+		assert.Equal(t, want[i].Key(), got[i].Key())
+		assert.Equal(t, want[i].Delta, got[i].Delta)
+		assert.Equal(t, want[i].Value, got[i].Value)
+	}
+}
+
+// Без интервала записи сохраняются по вызовам Sync, и одновременные
+// вызовы ждут одной записи журнала.
+func TestSync(t *testing.T) {
+	const writers = 8
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName)}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := rep.Add(context.Background(), counter("hits", 1)); err != nil {
+				errs <- err
+				return
+			}
+			errs <- bk.Sync(context.Background())
+		}()
+	}
+	// все вызовы ждут до запуска Run и сохраняются одной записью
+	require.Eventually(t, func() bool {
+		bk.waitMu.Lock()
+		defer bk.waitMu.Unlock()
+		return len(bk.waiters) == writers
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bk.Run(ctx)
 	}()
-	ms, err := r.read()
+	wg.Wait()
+	for range writers {
+		require.NoError(t, <-errs)
+	}
+
+	log, _, err := readLog(ctx, bk.sink, bk.filename, bk.codec)
+	require.NoError(t, err)
+	require.Len(t, log, 1)
+	assert.Len(t, log[0].changes, writers)
+	got, err := restored(t, &cfg).Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers), *got.Delta)
+
+	cancel()
+	<-done
+	assert.ErrorIs(t, bk.Sync(context.Background()), errStopped)
+}
+
+func TestDecode(t *testing.T) {
+	header, err := encodeHeader(codec{})
+	require.NoError(t, err)
+	good, err := encodeFrame(updates(counter("a", 1), counter("b", 2)), time.Now(), codec{})
+	require.NoError(t, err)
+	corrupt, err := encodeFrame(updates(counter("c", 3)), time.Now(), codec{})
+	require.NoError(t, err)
+	corrupt = bytes.Replace(corrupt, []byte(`"delta":3`), []byte(`"delta":4`), 1)
+	truncated, err := encodeFrame(updates(counter("d", 4), counter("e", 5)), time.Now(), codec{})
+	require.NoError(t, err)
+	truncated = truncated[:len(truncated)-20]
+
+	data := slices.Concat(header, good, corrupt, truncated)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	frames, bad, err := decode("log", lines, codec{})
+	require.NoError(t, err)
+	metrics, _ := cut(frames, time.Time{})
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "b", metrics[1].Name)
+	lineNumbers := make([]int, 0, len(bad))
+	for _, q := range bad {
+		lineNumbers = append(lineNumbers, q.Line)
+	}
+	assert.Equal(t, []int{6, 8, 9}, lineNumbers)
+	assert.Equal(t, "frame checksum mismatch", bad[0].Reason)
+
+	legacy := [][]byte{[]byte(`{"id":"a","type":"counter","delta":1}` + "\n"), []byte("{broken\n")}
+	frames, bad, err = decode("legacy", legacy, codec{})
+	require.NoError(t, err)
+	require.Len(t, frames, 1)
+	assert.True(t, frames[0].written.IsZero())
+	assert.Len(t, frames[0].changes, 1)
+	require.Len(t, bad, 1)
+	assert.Equal(t, 2, bad[0].Line)
+
+	_, _, err = decode("future", [][]byte{[]byte(`{"format":"malerter-backup","version":3}` + "\n")}, codec{})
+	assert.Error(t, err)
+}
+
+func TestRestoreQuarantine(t *testing.T) {
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName)}
+	p := newProducer(NewLocal(filepath.Dir(cfg.FileStoragePath)), backupFileName, codec{})
+	require.NoError(t, p.write(context.Background(), updates(counter("hits", 1))))
+	require.NoError(t, p.write(context.Background(), updates(counter("hits", 2))))
+	data, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cfg.FileStoragePath, data[:len(data)-5], constants.PermissionFilePrivate))
+
+	rep := restored(t, &cfg)
+	found, err := rep.Find(context.Background(), hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *found.Delta)
+	quarantine, err := os.ReadFile(cfg.FileStoragePath + quarantineSuffix)
+	require.NoError(t, err)
+	assert.Contains(t, string(quarantine), `"delta":2`)
+}
+
+func TestBackupDir(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{BackupDir: t.TempDir(), BackupMaxFiles: 2}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	for i := range 3 {
+		_, err := rep.Add(ctx, counter("hits", int64(i+1)))
+		require.NoError(t, err)
+		require.NoError(t, bk.backup(context.Background()))
+		require.NoError(t, bk.snapshot(ctx))
+	}
+	_, err := rep.Add(ctx, counter("hits", 10))
+	require.NoError(t, err)
+	require.NoError(t, bk.backup(context.Background()))
+
+	gens, err := generations(ctx, bk.sink)
+	require.NoError(t, err)
+	require.Len(t, gens, 2)
+	assert.Equal(t, bk.filename, gens[0].log)
+
+	find := func() int64 {
+		found, err := restored(t, &cfg).Find(ctx, hitsKey)
+		require.NoError(t, err)
+		return *found.Delta
+	}
+	assert.Equal(t, int64(16), find())
+
+	// повреждённый снимок: восстанавливается предыдущее поколение,
+	// его журнал доходит до следующего снимка
+	snapshot := filepath.Join(cfg.BackupDir, gens[0].log+snapshotSuffix)
+	data, err := os.ReadFile(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(snapshot, data[:len(data)-5], constants.PermissionFilePrivate))
+	assert.Equal(t, int64(6), find())
+}
+
+func TestCodec(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 4096)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "backup.key")
+	require.NoError(t, os.WriteFile(keyPath,
+		middlewares.MarshalPrivateKeyBase64(private), constants.PermissionFilePrivate))
+
+	tests := []struct {
+		name        string
+		compression string
+		keyPath     string
+	}{
+		{name: "gzip", compression: server.CompressionGzip},
+		{name: "zstd", compression: server.CompressionZstd},
+		{name: "encrypted", keyPath: keyPath},
+		{name: "zstd encrypted", compression: server.CompressionZstd, keyPath: keyPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cfg := server.Builder{
+				FileStoragePath: filepath.Join(t.TempDir(), backupFileName),
+				BackupCompress:  tt.compression,
+				BackupKeyPath:   tt.keyPath,
+			}
+			tunnel := queue.New[model.Change]()
+			rep := memory.New(logger.NewNopLogger(), &tunnel)
+			bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+			require.NotNil(t, bk)
+
+			_, err := rep.Add(ctx, counter("hits", 1))
+			require.NoError(t, err)
+			require.NoError(t, bk.backup(context.Background()))
+			require.NoError(t, bk.snapshot(ctx))
+			_, err = rep.Add(ctx, counter("hits", 2))
+			require.NoError(t, err)
+			require.NoError(t, bk.backup(context.Background()))
+
+			for _, name := range []string{cfg.FileStoragePath, cfg.FileStoragePath + snapshotSuffix} {
+				data, err := os.ReadFile(name)
+				require.NoError(t, err)
+				header, err := parseHeader(bytes.SplitAfter(data, []byte("\n"))[0])
+				require.NoError(t, err)
+				assert.Equal(t, tt.compression, header.Compression)
+				assert.Equal(t, tt.keyPath != "", header.Encrypted)
+				if header.Encrypted {
+					assert.NotContains(t, string(data), `"hits"`)
+				}
+			}
+			found, err := restored(t, &cfg).Find(ctx, hitsKey)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), *found.Delta)
+
+			if tt.keyPath != "" {
+				noKey := cfg
+				noKey.BackupKeyPath = ""
+				bk = New(&noKey, &tunnel, memory.New(logger.NewNopLogger(), nil), logger.NewNopLogger())
+				require.NotNil(t, bk)
+				_, err = bk.restore(ctx, time.Time{})
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+// Прежний журнал без сжатия дописывается в своём формате
+// и восстанавливается при включённом сжатии.
+func TestCodec_Plain(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName)}
+	p := newProducer(NewLocal(filepath.Dir(cfg.FileStoragePath)), backupFileName, codec{})
+	require.NoError(t, p.write(ctx, updates(counter("hits", 1))))
+
+	cfg.BackupCompress = server.CompressionGzip
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	tunnel.Push(model.Change{Metric: counter("hits", 2)})
+	require.NoError(t, bk.backup(context.Background()))
+
+	data, err := os.ReadFile(cfg.FileStoragePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"delta":2`)
+	found, err := restored(t, &cfg).Find(ctx, hitsKey)
 	require.NoError(t, err)
+	assert.Equal(t, int64(3), *found.Delta)
+}
+
+func TestS3(t *testing.T) {
+	ctx := context.Background()
+	s3cfg := s3.Config{Bucket: "backups", AccessKey: "access", SecretKey: "secret"}
+	fake := s3test.NewFake(s3cfg)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	cfg := server.Builder{
+		BackupBucket:    s3cfg.Bucket,
+		BackupEndpoint:  srv.URL,
+		BackupPrefix:    "malerter",
+		BackupAccessKey: s3cfg.AccessKey,
+		BackupSecretKey: s3cfg.SecretKey,
+		BackupMaxFiles:  2,
+	}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
 
-	assert.Equal(t, fromPrevTest+backupInLoop, len(ms))
+	for i := range 3 {
+		_, err := rep.Add(ctx, counter("hits", int64(i+1)))
+		require.NoError(t, err)
+		require.NoError(t, bk.backup(ctx))
+		require.NoError(t, bk.snapshot(ctx))
+	}
+	_, err := rep.Add(ctx, counter("hits", 10))
+	require.NoError(t, err)
+	require.NoError(t, bk.backup(ctx))
+
+	keys := fake.Keys()
+	require.Len(t, keys, 4)
+	for _, key := range keys {
+		assert.True(t, strings.HasPrefix(key, "malerter/"))
+	}
+	assert.Equal(t, partKey("malerter/"+bk.filename, 0), keys[3])
+
+	// сервер перезапущен на новом узле: локальных файлов нет
+	found, err := restored(t, &cfg).Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), *found.Delta)
+}
+
+// Каждый Append сохраняется отдельным объектом, не перезаписывая
+// прежние, а файл читается и перечисляется целиком.
+func TestS3_Append(t *testing.T) {
+	ctx := context.Background()
+	s3cfg := s3.Config{Bucket: "backups"}
+	fake := s3test.NewFake(s3cfg)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	s3cfg.Endpoint = srv.URL
+	client, err := s3.New(s3cfg)
+	require.NoError(t, err)
+	sink := NewS3(client, "malerter")
+
+	_, err = sink.Read(ctx, "a.bk")
+	require.ErrorIs(t, err, ErrNotFound)
+	for _, frame := range []string{"1\n", "2\n", "3\n"} {
+		require.NoError(t, sink.Append(ctx, "a.bk", []byte(frame)))
+	}
+	assert.Len(t, fake.Keys(), 3)
+
+	// после перезапуска нумерация частей продолжается
+	sink = NewS3(client, "malerter")
+	require.NoError(t, sink.Append(ctx, "a.bk", []byte("4\n")))
+	data, err := sink.Read(ctx, "a.bk")
+	require.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n", string(data))
+	files, err := sink.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "a.bk", files[0].Name)
+	assert.Equal(t, int64(len(data)), files[0].Size)
+
+	require.NoError(t, sink.Write(ctx, "a.bk", nil))
+	require.NoError(t, sink.Append(ctx, "a.bk", []byte("5\n")))
+	data, err = sink.Read(ctx, "a.bk")
+	require.NoError(t, err)
+	assert.Equal(t, "5\n", string(data))
+
+	require.NoError(t, sink.Remove(ctx, "a.bk"))
+	assert.Empty(t, fake.Keys())
+}
+
+// Записи, которые не удалось сохранить, сохраняются при следующей попытке.
+func TestBackupRetry(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(s3test.NewFake(s3.Config{Bucket: "backups"}))
+	cfg := server.Builder{BackupBucket: "backups", BackupEndpoint: srv.URL}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	require.NoError(t, bk.snapshot(ctx))
+	srv.Close()
+	_, err := rep.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	assert.Error(t, bk.backup(ctx))
+	assert.Len(t, bk.pending, 1)
+
+	bk.sink = NewLocal(t.TempDir())
+	bk.producer = newProducer(bk.sink, bk.filename, bk.codec)
+	require.NoError(t, bk.backup(ctx))
+	assert.Empty(t, bk.pending)
+	log, _, err := readLog(ctx, bk.sink, bk.filename, bk.codec)
+	require.NoError(t, err)
+	assert.Len(t, log, 1)
+}
+
+// Восстановление на момент отбрасывает записи журнала, сделанные позже,
+// и пропускает поколения, снимок которых сделан позже.
+func TestRestoreUntil(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{BackupDir: t.TempDir()}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	flood := counter("flood", 100)
+
+	before := time.Now()
+	require.NoError(t, bk.snapshot(ctx))
+	_, err := rep.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	require.NoError(t, bk.backup(ctx))
+	good := time.Now()
+	for _, m := range []model.Metric{counter("hits", 2), flood} {
+		_, err = rep.Add(ctx, m)
+		require.NoError(t, err)
+	}
+	require.NoError(t, bk.backup(ctx))
+
+	check := func(report Report) {
+		t.Helper()
+		found, err := rep.Find(ctx, hitsKey)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), *found.Delta)
+		_, err = rep.Find(ctx, flood.Key())
+		assert.Error(t, err)
+		assert.Equal(t, 1, report.Log)
+		assert.Equal(t, 2, report.Ignored)
+	}
+	report, err := bk.Restore(ctx, "", good)
+	require.NoError(t, err)
+	check(report)
+
+	// после пересборки сделан новый снимок, но он позже момента восстановления
+	gens, err := generations(ctx, bk.sink)
+	require.NoError(t, err)
+	require.Len(t, gens, 2)
+	report, err = bk.Restore(ctx, "", good)
+	require.NoError(t, err)
+	assert.Equal(t, gens[1].log, report.Backup)
+	check(report)
+
+	_, err = bk.Restore(ctx, "", before)
+	assert.ErrorIs(t, err, ErrNoBackups)
+	found, err := rep.Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *found.Delta)
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{BackupDir: t.TempDir()}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	_, err := rep.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	first, err := bk.Backup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, first.Snapshot)
+	assert.Positive(t, first.Size)
+	_, err = rep.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+	second, err := bk.Backup(ctx)
+	require.NoError(t, err)
+	_, err = rep.Add(ctx, counter("hits", 4))
+	require.NoError(t, err)
+	require.NoError(t, bk.backup(ctx))
+
+	infos, err := bk.List(ctx)
+	require.NoError(t, err)
+	// первые записи попали в журнал, начатый при запуске, ещё без снимка
+	require.Len(t, infos, 3)
+	assert.Equal(t, second.Name, infos[0].Name)
+	assert.Equal(t, 1, infos[0].Log)
+	assert.Empty(t, infos[0].Error)
+	assert.Equal(t, first.Name, infos[1].Name)
+	assert.Equal(t, 0, infos[2].Snapshot)
+	assert.Equal(t, 1, infos[2].Log)
+
+	var archive bytes.Buffer
+	downloaded, err := bk.Download(ctx, second.Name)
+	require.NoError(t, err)
+	_, err = downloaded.WriteTo(&archive)
+	require.NoError(t, err)
+	names := make([]string, 0, 2)
+	reader := tar.NewReader(&archive)
+	for {
+		h, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, h.Name)
+	}
+	assert.ElementsMatch(t, []string{second.Name, second.Name + snapshotSuffix}, names)
+	_, err = bk.Download(ctx, "../"+second.Name)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	report, err := bk.Restore(ctx, first.Name, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, first.Name, report.Backup)
+	// журнал копии доходит до следующего снимка
+	found, err := rep.Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *found.Delta)
+	_, err = bk.Restore(ctx, "missing.bk", time.Time{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// failingStorage — хранилище, в котором не удаётся заменить метрики.
+type failingStorage struct {
+	*memory.Memory
+}
+
+func (failingStorage) Replace(context.Context, []model.Metric) error {
+	return errors.New("replace failed")
+}
+
+func TestRestore_Atomic(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{BackupDir: t.TempDir()}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, failingStorage{rep}, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	_, err := rep.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	first, err := bk.Backup(ctx)
+	require.NoError(t, err)
+	_, err = rep.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+
+	_, err = bk.Restore(ctx, first.Name, time.Time{})
+	require.Error(t, err)
+	found, err := rep.Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), *found.Delta)
+}
+
+// racingStorage — хранилище, в которое во время замены метрик
+// начинается запись.
+type racingStorage struct {
+	*memory.Memory
+	write func()
+}
+
+func (s racingStorage) Replace(ctx context.Context, metrics []model.Metric) error {
+	s.write()
+	//nolint:wrapcheck // it's tests
+	return s.Memory.Replace(ctx, metrics)
+}
+
+func TestRestore_ConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{BackupDir: t.TempDir()}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	var bk *Manager
+	written := make(chan error, 1)
+	racing := racingStorage{Memory: rep, write: func() {
+		go func() {
+			writes := bk.Writes()
+			writes.Lock()
+			defer writes.Unlock()
+			_, err := rep.Add(ctx, counter("hits", 5))
+			written <- err
+		}()
+		// запись не проходит, пока состояние заменяется
+		select {
+		case <-written:
+			t.Error("write passed during restore")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}}
+	bk = New(&cfg, &tunnel, racing, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	_, err := rep.Add(ctx, counter("hits", 1))
+	require.NoError(t, err)
+	first, err := bk.Backup(ctx)
+	require.NoError(t, err)
+	_, err = rep.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+
+	_, err = bk.Restore(ctx, first.Name, time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, <-written)
+	// копия восстанавливает 3, запись прибавляется к ним
+	found, err := rep.Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), *found.Delta)
+
+	// запись после замены осталась в буфере и попадает в журнал
+	assert.Equal(t, 1, tunnel.Len())
+	require.NoError(t, bk.backup(ctx))
+	found, err = restored(t, &cfg).Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), *found.Delta)
+}
+
+func TestRebuild(t *testing.T) {
+	snapshot := counter("hits", 5)
+	snapshot.Revision = 2
+	hits, late := counter("hits", 3), counter("late", 1)
+	hits.Revision = 3
+
+	metrics, err := rebuild([]model.Metric{snapshot}, updates(hits, late, late), model.OverflowSaturate)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, int64(8), *metrics[0].Delta)
+	assert.Equal(t, int64(3), metrics[0].Revision)
+	// записи без ревизии получают следующую
+	assert.Equal(t, int64(2), *metrics[1].Delta)
+	assert.Equal(t, int64(2), metrics[1].Revision)
+}
+
+func TestOrdered(t *testing.T) {
+	cpu := func(value float64, revision int64) model.Change {
+		return model.Change{Metric: model.Metric{
+			Name: "cpu", Type: model.MetricTypeGauge, Value: &value, Revision: revision,
+		}}
+	}
+	cpuKey := model.MetricKey{Type: model.MetricTypeGauge, Name: "cpu"}
+	log := []model.Change{
+		cpu(2, 2), cpu(1, 1), cpu(0.5, 0),
+		model.NewDeletion(cpuKey, 3),
+		cpu(5, 2), cpu(4, 1),
+	}
+
+	got := ordered(log)
+	revisions := make([]int64, 0, len(got))
+	for _, ch := range got {
+		revisions = append(revisions, ch.Revision)
+	}
+	// записи без ревизии остаются на месте, удаление разделяет поколения
+	assert.Equal(t, []int64{1, 2, 0, 3, 1, 2}, revisions)
+
+	metrics, err := rebuild(nil, got, model.OverflowSaturate)
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.InDelta(t, 5.0, *metrics[0].Value, 0)
+}
+
+func TestRestore_DeleteReset(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName)}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+
+	value := 0.5
+	cpu := model.Metric{Name: "cpu", Type: model.MetricTypeGauge, Value: &value}
+	for _, m := range []model.Metric{counter("hits", 5), cpu, counter("temp", 1), counter("temp", 2)} {
+		_, err := rep.Add(ctx, m)
+		require.NoError(t, err)
+	}
+	require.NoError(t, bk.backup(ctx))
+	require.NoError(t, bk.snapshot(ctx))
+
+	require.NoError(t, rep.Delete(ctx, cpu.Key()))
+	require.NoError(t, rep.Reset(ctx, hitsKey))
+	_, err := rep.Add(ctx, counter("hits", 2))
+	require.NoError(t, err)
+	// удалённый ряд начинается заново с ревизии 1, меньшей, чем в снимке
+	temp := counter("temp", 7)
+	require.NoError(t, rep.Delete(ctx, temp.Key()))
+	_, err = rep.Add(ctx, temp)
+	require.NoError(t, err)
+	require.NoError(t, bk.backup(ctx))
+
+	rep2 := restored(t, &cfg)
+	var notFoundError *customerror.NotFoundError
+	_, err = rep2.Find(ctx, cpu.Key())
+	assert.ErrorAs(t, err, &notFoundError)
+	found, err := rep2.Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *found.Delta)
+	assert.Equal(t, int64(1), found.Resets)
+	found, err = rep2.Find(ctx, temp.Key())
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *found.Delta)
+}
+
+// flakySink — хранилище копий, в котором не удаётся заменить файл,
+// пока задан failing.
+type flakySink struct {
+	Sink
+	failing atomic.Bool
+}
+
+func (s *flakySink) Write(ctx context.Context, name string, data []byte) error {
+	if s.failing.Load() {
+		return errors.New("write failed")
+	}
+	//nolint:wrapcheck // it's tests
+	return s.Sink.Write(ctx, name, data)
+}
+
+// Пока снимок этого запуска не сделан, журнал прежнего запуска
+// не пополняется записями с новыми ревизиями.
+func TestRun_SnapshotFailed(t *testing.T) {
+	ctx := context.Background()
+	cfg := server.Builder{FileStoragePath: filepath.Join(t.TempDir(), backupFileName)}
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	for range 3 {
+		_, err := rep.Add(ctx, counter("hits", 1))
+		require.NoError(t, err)
+	}
+	bk := New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	require.NoError(t, bk.backup(ctx))
+	require.NoError(t, bk.snapshot(ctx))
+
+	// перезапуск: хранилище пусто, снимок не удаётся
+	tunnel = queue.New[model.Change]()
+	rep = memory.New(logger.NewNopLogger(), &tunnel)
+	bk = New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	sink := &flakySink{Sink: bk.sink}
+	sink.failing.Store(true)
+	bk.sink, bk.producer = sink, newProducer(sink, bk.filename, bk.codec)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	bk.Run(cancelled)
+	assert.True(t, bk.unsnapshotted)
+
+	_, err := rep.Add(ctx, counter("hits", 5))
+	require.NoError(t, err)
+	require.Error(t, bk.backup(ctx))
+	sink.failing.Store(false)
+	require.NoError(t, bk.backup(ctx))
+	assert.False(t, bk.unsnapshotted)
+
+	found, err := restored(t, &cfg).Find(ctx, hitsKey)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *found.Delta)
 }
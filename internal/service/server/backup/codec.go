@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/talx-hub/malerter/internal/config/server"
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/pkg/crypto"
+)
+
+// codec сжимает и шифрует записи кадров. Сжатые и зашифрованные записи
+// кадра хранятся одной строкой в base64. Сжатие и шифрование указываются
+// в заголовке файла, поэтому файл читается тем кодеком, которым записан.
+type codec struct {
+	encrypter   *crypto.Encrypter
+	decrypter   *crypto.Decrypter
+	compression string
+}
+
+// newCodec возвращает кодек для новых файлов. Файлы шифруются открытым
+// ключом, соответствующим закрытому ключу keyPath.
+func newCodec(compression, keyPath string) (codec, error) {
+	c := codec{compression: compression}
+	if keyPath == constants.EmptyPath {
+		return c, nil
+	}
+	decrypter, err := crypto.NewDecrypter(keyPath)
+	if err != nil {
+		return c, fmt.Errorf("unable to load backup key: %w", err)
+	}
+	c.decrypter = decrypter
+	c.encrypter = &crypto.Encrypter{PublicKey: &decrypter.PrivateKey.PublicKey}
+	return c, nil
+}
+
+func (c codec) header() fileHeader {
+	return fileHeader{
+		Format:      formatName,
+		Version:     formatVersion,
+		Compression: c.compression,
+		Encrypted:   c.decrypter != nil,
+	}
+}
+
+// forHeader возвращает кодек файла с заголовком h.
+func (c codec) forHeader(h fileHeader) (codec, error) {
+	file := codec{compression: h.Compression}
+	switch h.Compression {
+	case server.CompressionNone, server.CompressionGzip, server.CompressionZstd:
+	default:
+		return file, fmt.Errorf("unsupported backup compression %q", h.Compression)
+	}
+	if h.Encrypted {
+		if c.decrypter == nil {
+			return file, errors.New("backup is encrypted, no backup key provided")
+		}
+		file.encrypter, file.decrypter = c.encrypter, c.decrypter
+	}
+	return file, nil
+}
+
+// sealed сообщает, хранятся ли записи кадра одной строкой.
+func (c codec) sealed() bool {
+	return c.compression != server.CompressionNone || c.decrypter != nil
+}
+
+// seal сжимает и шифрует записи кадра в одну строку.
+func (c codec) seal(records []byte) ([]byte, error) {
+	data, err := c.compress(records)
+	if err != nil {
+		return nil, err
+	}
+	if c.encrypter != nil {
+		if data, err = c.encrypter.Encrypt(data); err != nil {
+			return nil, fmt.Errorf("unable to encrypt backup: %w", err)
+		}
+	}
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(data))+1)
+	base64.StdEncoding.Encode(line, data)
+	line[len(line)-1] = '\n'
+	return line, nil
+}
+
+// open расшифровывает и распаковывает строку, записанную seal.
+func (c codec) open(line []byte) ([]byte, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(data, line)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode frame: %w", err)
+	}
+	data = data[:n]
+	if c.decrypter != nil {
+		if data, err = c.decrypter.Decrypt(data); err != nil {
+			return nil, fmt.Errorf("unable to decrypt frame: %w", err)
+		}
+	}
+	return c.decompress(data)
+}
+
+func (c codec) compress(data []byte) ([]byte, error) {
+	switch c.compression {
+	case server.CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("unable to compress backup: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("unable to compress backup: %w", err)
+		}
+		return buf.Bytes(), nil
+	case server.CompressionZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create zstd encoder: %w", err)
+		}
+		defer func() {
+			_ = encoder.Close()
+		}()
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return data, nil
+	}
+}
+
+func (c codec) decompress(data []byte) ([]byte, error) {
+	switch c.compression {
+	case server.CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decompress frame: %w", err)
+		}
+		defer func() {
+			_ = r.Close()
+		}()
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("unable to decompress frame: %w", err)
+		}
+		return data, nil
+	case server.CompressionZstd:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create zstd decoder: %w", err)
+		}
+		defer decoder.Close()
+		if data, err = decoder.DecodeAll(data, nil); err != nil {
+			return nil, fmt.Errorf("unable to decompress frame: %w", err)
+		}
+		return data, nil
+	default:
+		return data, nil
+	}
+}
@@ -0,0 +1,263 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+// Файл резервной копии начинается с заголовка с версией формата,
+// за которым следуют кадры: заголовок кадра с временем записи, числом
+// записей и их контрольной суммой, затем сами записи, по метрике в строке.
+// Снимок — один кадр, журнал пополняется кадром при каждом сохранении.
+// Если файл сжат или зашифрован, записи кадра хранятся одной строкой.
+// Файлы без заголовка — прежний формат: только строки с метриками.
+const (
+	formatName = "malerter-backup"
+	// formatVersion — версия формата, которую пишет сервер.
+	formatVersion = 2
+	// legacyVersion — версия файлов без заголовка.
+	legacyVersion = 1
+)
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+type fileHeader struct {
+	Format      string `json:"format"`
+	Compression string `json:"compression,omitempty"`
+	Version     int    `json:"version"`
+	Encrypted   bool   `json:"encrypted,omitempty"`
+}
+
+type frameHeader struct {
+	Time     time.Time `json:"time"`
+	Checksum string    `json:"checksum"`
+	Records  int       `json:"records"`
+}
+
+// frame — записи кадра и время записи кадра. Записи попадают в кадр
+// до его записи; у файлов без времени кадров время нулевое.
+// Записи снимка — состояния метрик, записи журнала — изменения,
+// в том числе удаления и обнуления.
+type frame struct {
+	written time.Time
+	changes []model.Change
+}
+
+// states возвращает состояния метрик из записей снимка.
+func (f frame) states() []model.Metric {
+	metrics := make([]model.Metric, 0, len(f.changes))
+	for _, c := range f.changes {
+		metrics = append(metrics, c.Metric)
+	}
+	return metrics
+}
+
+// quarantined — запись, не прошедшая проверку при восстановлении.
+type quarantined struct {
+	File   string
+	Reason string
+	Data   []byte
+	Line   int
+}
+
+func encodeHeader(c codec) ([]byte, error) {
+	data, err := json.Marshal(c.header())
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode backup header: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// parseHeader возвращает заголовок файла по его первой строке.
+func parseHeader(line []byte) (fileHeader, error) {
+	var h fileHeader
+	if json.Unmarshal(line, &h) != nil || h.Format != formatName {
+		return fileHeader{Version: legacyVersion}, nil
+	}
+	if h.Version != formatVersion {
+		return h, fmt.Errorf("unsupported backup format version %d", h.Version)
+	}
+	return h, nil
+}
+
+// encodeFrame кодирует изменения кадром с контрольной суммой.
+func encodeFrame(changes []model.Change, written time.Time, c codec) ([]byte, error) {
+	var records bytes.Buffer
+	encoder := json.NewEncoder(&records)
+	for _, ch := range changes {
+		if err := encoder.Encode(&ch); err != nil {
+			return nil, fmt.Errorf("unable to backup metric: %w", err)
+		}
+	}
+	body := records.Bytes()
+	if c.sealed() {
+		var err error
+		if body, err = c.seal(body); err != nil {
+			return nil, err
+		}
+	}
+	header, err := json.Marshal(frameHeader{
+		Time:     written.UTC(),
+		Checksum: checksum(body),
+		Records:  len(changes),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode frame header: %w", err)
+	}
+	return append(append(header, '\n'), body...), nil
+}
+
+func checksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, checksumTable))
+}
+
+func parseFrameHeader(line []byte) (frameHeader, error) {
+	var h frameHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		return h, fmt.Errorf("invalid frame header: %w", err)
+	}
+	if h.Checksum == "" || h.Records < 0 {
+		return h, errors.New("invalid frame header")
+	}
+	return h, nil
+}
+
+// parseChange разбирает запись кадра; записи прежнего формата
+// без вида изменения — записи метрик.
+func parseChange(line []byte) (model.Change, error) {
+	change := model.Change{}
+	if err := json.Unmarshal(line, &change); err != nil {
+		return change, fmt.Errorf("unable to unmarshal metric: %w", err)
+	}
+	if err := change.CheckValid(); err != nil {
+		return change, fmt.Errorf("metric is invalid: %w", err)
+	}
+	return change, nil
+}
+
+// decode разбирает строки файла. Записи повреждённых и обрезанных кадров,
+// а также негодные строки прежнего формата возвращаются как quarantined.
+// Из сжатого или зашифрованного кадра откладывается вся его строка.
+func decode(file string, lines [][]byte, c codec) ([]frame, []quarantined, error) {
+	frames := make([]frame, 0)
+	bad := make([]quarantined, 0)
+	if len(lines) == 0 {
+		return frames, bad, nil
+	}
+	header, err := parseHeader(lines[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	quarantine := func(i int, reason string) {
+		bad = append(bad, quarantined{File: file, Line: i + 1, Reason: reason, Data: lines[i]})
+	}
+
+	if header.Version == legacyVersion {
+		changes := make([]model.Change, 0, len(lines))
+		for i, line := range lines {
+			ch, err := parseChange(line)
+			if err != nil {
+				quarantine(i, err.Error())
+				continue
+			}
+			changes = append(changes, ch)
+		}
+		return append(frames, frame{changes: changes}), bad, nil
+	}
+	if c, err = c.forHeader(header); err != nil {
+		return nil, nil, err
+	}
+
+	for i := 1; i < len(lines); {
+		h, err := parseFrameHeader(lines[i])
+		if err != nil {
+			quarantine(i, err.Error())
+			i++
+			continue
+		}
+		size := h.Records
+		if c.sealed() {
+			size = 1
+		}
+		start, end := i+1, i+1+size
+		i = end
+		var frameErr error
+		switch {
+		case end > len(lines):
+			end = len(lines)
+			frameErr = fmt.Errorf("frame is truncated: %d of %d lines", end-start, size)
+		case checksum(bytes.Join(lines[start:end], nil)) != h.Checksum:
+			frameErr = errors.New("frame checksum mismatch")
+		}
+		if frameErr != nil {
+			for j := start; j < end; j++ {
+				quarantine(j, frameErr.Error())
+			}
+			continue
+		}
+
+		f := frame{written: h.Time}
+		if c.sealed() {
+			if f.changes, err = unseal(lines[start], h.Records, c); err != nil {
+				quarantine(start, err.Error())
+				continue
+			}
+		} else {
+			f.changes = make([]model.Change, 0, h.Records)
+			for j := start; j < end; j++ {
+				ch, err := parseChange(lines[j])
+				if err != nil {
+					quarantine(j, err.Error())
+					continue
+				}
+				f.changes = append(f.changes, ch)
+			}
+		}
+		frames = append(frames, f)
+	}
+	return frames, bad, nil
+}
+
+// cut возвращает записи кадров, записанных не позже until, и число
+// отброшенных записей. Нулевой until не ограничивает, кадры без времени
+// записи не отбрасываются.
+func cut(frames []frame, until time.Time) ([]model.Change, int) {
+	changes := make([]model.Change, 0)
+	ignored := 0
+	for _, f := range frames {
+		if !until.IsZero() && f.written.After(until) {
+			ignored += len(f.changes)
+			continue
+		}
+		changes = append(changes, f.changes...)
+	}
+	return changes, ignored
+}
+
+// unseal возвращает записи сжатого или зашифрованного кадра.
+// Кадр годен, только если годны все его записи.
+func unseal(line []byte, records int, c codec) ([]model.Change, error) {
+	data, err := c.open(line)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(data)
+	if len(lines) != records {
+		return nil, fmt.Errorf("frame holds %d of %d records", len(lines), records)
+	}
+	changes := make([]model.Change, 0, records)
+	for i, line := range lines {
+		ch, err := parseChange(line)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i+1, err)
+		}
+		changes = append(changes, ch)
+	}
+	return changes, nil
+}
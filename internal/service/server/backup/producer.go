@@ -1,68 +1,60 @@
 package backup
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"time"
 
-	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/model"
 )
 
+// producer дописывает кадры в журнал. Журнал дописывается в том
+// формате, в котором начат; новый журнал начинается с заголовка.
 type producer struct {
-	writer *bufio.Writer
-	file   *os.File
+	sink  Sink
+	name  string
+	codec codec
+	// started — формат журнала уже известен.
+	started bool
 }
 
-func newProducer(filename string) (*producer, error) {
-	file, err := os.OpenFile(
-		filename,
-		os.O_WRONLY|os.O_CREATE|os.O_APPEND,
-		constants.PermissionFilePrivate)
-	if err != nil {
-		return nil,
-			fmt.Errorf("unable to open backup file %s: %w", filename, err)
-	}
-
-	return &producer{
-		file:   file,
-		writer: bufio.NewWriter(file),
-	}, nil
-}
-
-func (p *producer) writeMetric(metric model.Metric) error {
-	jsonEncoder := json.NewEncoder(p.writer)
-	if err := jsonEncoder.Encode(&metric); err != nil {
-		return fmt.Errorf("unable to backup metric: %w", err)
-	}
-
-	return nil
+func newProducer(sink Sink, name string, c codec) *producer {
+	return &producer{sink: sink, name: name, codec: c}
 }
 
-func (p *producer) write(metrics []model.Metric) error {
-	errCount := 0
-	for _, m := range metrics {
-		if err := p.writeMetric(m); err != nil {
-			errCount++
+// write дописывает изменения в журнал одним кадром.
+func (p *producer) write(ctx context.Context, changes []model.Change) error {
+	var header []byte
+	if !p.started {
+		data, err := p.sink.Read(ctx, p.name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("unable to read backup header: %w", err)
+		}
+		if len(data) == 0 {
+			if header, err = encodeHeader(p.codec); err != nil {
+				return err
+			}
+		} else {
+			first, _, _ := bytes.Cut(data, []byte("\n"))
+			h, err := parseHeader(first)
+			if err == nil {
+				p.codec, err = p.codec.forHeader(h)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to continue backup %s: %w", p.name, err)
+			}
 		}
 	}
-	if errCount == 0 {
-		return nil
-	}
-	return fmt.Errorf("failed to write %d metrics", errCount)
-}
 
-func (p *producer) flush() error {
-	if err := p.writer.Flush(); err != nil {
-		return fmt.Errorf("unable to flush backup: %w", err)
+	data, err := encodeFrame(changes, time.Now(), p.codec)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func (p *producer) close() error {
-	if err := p.file.Close(); err != nil {
-		return fmt.Errorf("unable to close backup file: %w", err)
+	if err = p.sink.Append(ctx, p.name, append(header, data...)); err != nil {
+		return fmt.Errorf("unable to backup metrics: %w", err)
 	}
+	p.started = true
 	return nil
 }
@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// logSuffix — суффикс журнала поколения в каталоге резервных копий.
+	logSuffix = ".bk"
+	// quarantineSuffix — суффикс файла с записями, не прошедшими проверку.
+	quarantineSuffix = ".quarantine"
+	// generationLayout — формат имени поколения: имена упорядочены по времени.
+	generationLayout = "20060102T150405.000000000Z"
+)
+
+// generation — снимок и журнал изменений после него.
+// При ротации каждый снимок начинает новое поколение.
+type generation struct {
+	modified time.Time
+	// log — имя журнала, имя снимка получается добавлением snapshotSuffix.
+	log string
+}
+
+func newGeneration(now time.Time) string {
+	return now.UTC().Format(generationLayout) + logSuffix
+}
+
+// generations возвращает поколения хранилища от новых к старым.
+func generations(ctx context.Context, sink Sink) ([]generation, error) {
+	files, err := sink.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backups: %w", err)
+	}
+	found := make(map[string]time.Time)
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name, snapshotSuffix)
+		if !strings.HasSuffix(name, logSuffix) {
+			continue
+		}
+		if f.Modified.After(found[name]) {
+			found[name] = f.Modified
+		}
+	}
+
+	gens := make([]generation, 0, len(found))
+	for log, modified := range found {
+		gens = append(gens, generation{log: log, modified: modified})
+	}
+	slices.SortFunc(gens, func(a, b generation) int {
+		if c := b.modified.Compare(a.modified); c != 0 {
+			return c
+		}
+		return strings.Compare(b.log, a.log)
+	})
+	return gens, nil
+}
+
+// prune удаляет поколения сверх maxFiles и старше maxAge;
+// нулевое ограничение не действует. Текущее поколение не удаляется.
+func prune(
+	ctx context.Context, sink Sink, current string, maxFiles int, maxAge time.Duration, now time.Time,
+) ([]string, error) {
+	gens, err := generations(ctx, sink)
+	if err != nil {
+		return nil, err
+	}
+	removed := make([]string, 0)
+	kept := 1
+	for _, g := range gens {
+		if g.log == current {
+			continue
+		}
+		if (maxFiles == 0 || kept < maxFiles) && (maxAge == 0 || now.Sub(g.modified) <= maxAge) {
+			kept++
+			continue
+		}
+		for _, name := range []string{g.log, g.log + snapshotSuffix, g.log + quarantineSuffix} {
+			if err = sink.Remove(ctx, name); err != nil {
+				return removed, fmt.Errorf("unable to remove backup %s: %w", name, err)
+			}
+		}
+		removed = append(removed, g.log)
+	}
+	return removed, nil
+}
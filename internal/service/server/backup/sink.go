@@ -0,0 +1,334 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/config/server"
+	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/pkg/atomicfile"
+	"github.com/talx-hub/malerter/pkg/s3"
+)
+
+const (
+	permissionDir = 0o700
+	// partDigits — длина номера части файла в S3.
+	partDigits = 20
+)
+
+// ErrNotFound — файла нет в хранилище резервных копий.
+var ErrNotFound = errors.New("backup file not found")
+
+// Sink — хранилище файлов резервной копии. Файлы адресуются именами
+// без каталогов; журнал пишет только один сервер.
+type Sink interface {
+	// Read возвращает содержимое файла или ErrNotFound.
+	Read(ctx context.Context, name string) ([]byte, error)
+	// Write заменяет файл целиком.
+	Write(ctx context.Context, name string, data []byte) error
+	// Append дописывает данные в конец файла, создавая его,
+	// и возвращает управление, когда они сохранены надёжно.
+	Append(ctx context.Context, name string, data []byte) error
+	// List возвращает файлы хранилища.
+	List(ctx context.Context) ([]File, error)
+	// Remove удаляет файл; удаление отсутствующего файла не ошибка.
+	Remove(ctx context.Context, name string) error
+}
+
+// File — файл хранилища резервных копий.
+type File struct {
+	Modified time.Time
+	Name     string
+	Size     int64
+}
+
+// newSink возвращает хранилище резервных копий по конфигурации:
+// бакет S3, каталог резервных копий или каталог файла резервной копии.
+func newSink(config *server.Builder) (Sink, error) {
+	if config.BackupBucket != "" {
+		client, err := s3.New(s3.Config{
+			Endpoint:  config.BackupEndpoint,
+			Bucket:    config.BackupBucket,
+			Region:    config.BackupRegion,
+			AccessKey: config.BackupAccessKey,
+			SecretKey: config.BackupSecretKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to create s3 client: %w", err)
+		}
+		return NewS3(client, config.BackupPrefix), nil
+	}
+	if config.BackupDir != "" {
+		if err := os.MkdirAll(config.BackupDir, permissionDir); err != nil {
+			return nil, fmt.Errorf("unable to create backup dir: %w", err)
+		}
+		return NewLocal(config.BackupDir), nil
+	}
+	return NewLocal(filepath.Dir(config.FileStoragePath)), nil
+}
+
+// Local хранит резервные копии в каталоге на диске.
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+func (l *Local) path(name string) string {
+	return filepath.Join(l.dir, name)
+}
+
+func (l *Local) Read(_ context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(l.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read backup file %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// Write подменяет файл атомарно: после сбоя остаётся прежний файл или новый.
+func (l *Local) Write(_ context.Context, name string, data []byte) error {
+	if err := atomicfile.Write(l.path(name), data, constants.PermissionFilePrivate); err != nil {
+		return fmt.Errorf("unable to write backup file %s: %w", name, err)
+	}
+	return nil
+}
+
+// Append возвращает управление, когда данные сброшены на диск,
+// а новый файл — и запись о нём в каталоге.
+func (l *Local) Append(_ context.Context, name string, data []byte) error {
+	_, err := os.Stat(l.path(name))
+	created := errors.Is(err, os.ErrNotExist)
+	file, err := os.OpenFile(l.path(name),
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND, constants.PermissionFilePrivate)
+	if err != nil {
+		return fmt.Errorf("unable to open backup file %s: %w", name, err)
+	}
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to write backup file %s: %w", name, err)
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("unable to sync backup file %s: %w", name, err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("unable to close backup file %s: %w", name, err)
+	}
+	if created {
+		if err = atomicfile.SyncDir(l.dir); err != nil {
+			return fmt.Errorf("unable to sync backup dir: %w", err)
+		}
+	}
+	return nil
+}
+
+func (l *Local) List(_ context.Context) ([]File, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read backup dir %s: %w", l.dir, err)
+	}
+	files := make([]File, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, File{Name: e.Name(), Modified: info.ModTime(), Size: info.Size()})
+	}
+	return files, nil
+}
+
+func (l *Local) Remove(_ context.Context, name string) error {
+	if err := os.Remove(l.path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove backup file %s: %w", name, err)
+	}
+	return nil
+}
+
+// S3 хранит резервные копии объектами S3-совместимого хранилища
+// с общим префиксом. Хранилище объектов не умеет дописывать, поэтому
+// каждый Append сохраняется отдельной частью name/<номер>, а файл
+// читается как объект name, за которым следуют части по порядку.
+type S3 struct {
+	client *s3.Client
+	// next — номер следующей части файла; журнал пишет один сервер.
+	next   map[string]int64
+	prefix string
+	mu     sync.Mutex
+}
+
+// NewS3 возвращает хранилище в бакете клиента; непустой префикс
+// дополняется разделителем.
+func NewS3(client *s3.Client, prefix string) *S3 {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3{client: client, prefix: prefix, next: make(map[string]int64)}
+}
+
+func (s *S3) Read(ctx context.Context, name string) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.prefix+name)
+	found := err == nil
+	if err != nil && !errors.Is(err, s3.ErrNotFound) {
+		return nil, fmt.Errorf("unable to read backup object %s: %w", name, err)
+	}
+	parts, err := s.parts(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range parts {
+		part, err := s.client.Get(ctx, p.Key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read backup object %s: %w", p.Key, err)
+		}
+		data = append(data, part...)
+	}
+	if !found && len(parts) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return data, nil
+}
+
+// Write удаляет части файла и заменяет объект: если запись прервётся,
+// журнал, очищаемый после снимка, не вернётся к прежнему состоянию.
+func (s *S3) Write(ctx context.Context, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.removeParts(ctx, name); err != nil {
+		return err
+	}
+	if err := s.client.Put(ctx, s.prefix+name, data); err != nil {
+		return fmt.Errorf("unable to write backup object %s: %w", name, err)
+	}
+	return nil
+}
+
+// Append сохраняет данные следующей частью файла.
+func (s *S3) Append(ctx context.Context, name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next, ok := s.next[name]
+	if !ok {
+		parts, err := s.parts(ctx, name)
+		if err != nil {
+			return err
+		}
+		if n := len(parts); n > 0 {
+			last, _ := partNumber(s.prefix+name, parts[n-1].Key)
+			next = last + 1
+		}
+	}
+	if err := s.client.Put(ctx, partKey(s.prefix+name, next), data); err != nil {
+		return fmt.Errorf("unable to append backup object %s: %w", name, err)
+	}
+	s.next[name] = next + 1
+	return nil
+}
+
+// List возвращает файлы вместе с размером и временем изменения их частей.
+func (s *S3) List(ctx context.Context) ([]File, error) {
+	objects, err := s.client.List(ctx, s.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backup objects: %w", err)
+	}
+	files := make([]File, 0, len(objects))
+	index := make(map[string]int, len(objects))
+	for _, o := range objects {
+		name, _, isPart := strings.Cut(strings.TrimPrefix(o.Key, s.prefix), "/")
+		if name == "" {
+			continue
+		}
+		if _, ok := partNumber(s.prefix+name, o.Key); isPart && !ok {
+			continue
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(files)
+			index[name] = i
+			files = append(files, File{Name: name})
+		}
+		files[i].Size += o.Size
+		if o.LastModified.After(files[i].Modified) {
+			files[i].Modified = o.LastModified
+		}
+	}
+	return files, nil
+}
+
+func (s *S3) Remove(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.removeParts(ctx, name); err != nil {
+		return err
+	}
+	if err := s.client.Delete(ctx, s.prefix+name); err != nil {
+		return fmt.Errorf("unable to remove backup object %s: %w", name, err)
+	}
+	return nil
+}
+
+// parts возвращает части файла по возрастанию номера.
+func (s *S3) parts(ctx context.Context, name string) ([]s3.Object, error) {
+	base := s.prefix + name
+	objects, err := s.client.List(ctx, base+"/")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list backup object %s: %w", name, err)
+	}
+	parts := make([]s3.Object, 0, len(objects))
+	for _, o := range objects {
+		if _, ok := partNumber(base, o.Key); ok {
+			parts = append(parts, o)
+		}
+	}
+	// номера дополнены нулями, поэтому порядок ключей — порядок частей
+	slices.SortFunc(parts, func(a, b s3.Object) int { return strings.Compare(a.Key, b.Key) })
+	return parts, nil
+}
+
+// Вызывается под s.mu.
+func (s *S3) removeParts(ctx context.Context, name string) error {
+	parts, err := s.parts(ctx, name)
+	if err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if err = s.client.Delete(ctx, p.Key); err != nil {
+			return fmt.Errorf("unable to remove backup object %s: %w", p.Key, err)
+		}
+	}
+	s.next[name] = 0
+	return nil
+}
+
+func partKey(base string, n int64) string {
+	return fmt.Sprintf("%s/%0*d", base, partDigits, n)
+}
+
+func partNumber(base, key string) (int64, bool) {
+	suffix, ok := strings.CutPrefix(key, base+"/")
+	if !ok || len(suffix) != partDigits {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(suffix, 10, 64)
+	return n, err == nil && n >= 0
+}
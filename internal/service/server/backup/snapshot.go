@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+// snapshotSuffix — суффикс файла снимка рядом с журналом резервной копии.
+const snapshotSuffix = ".snapshot"
+
+// errNoSnapshot — снимка нет: резервная копия могла быть сделана
+// до появления снимков.
+var errNoSnapshot = errors.New("no snapshot")
+
+// writeSnapshot сохраняет полное состояние метрик на момент taken,
+// целиком заменяя прежний снимок.
+func writeSnapshot(
+	ctx context.Context, sink Sink, name string, metrics []model.Metric, taken time.Time, c codec,
+) error {
+	header, err := encodeHeader(c)
+	if err != nil {
+		return err
+	}
+	changes := make([]model.Change, 0, len(metrics))
+	for _, m := range metrics {
+		changes = append(changes, model.Change{Metric: m})
+	}
+	data, err := encodeFrame(changes, taken, c)
+	if err != nil {
+		return err
+	}
+	if err = sink.Write(ctx, name, append(header, data...)); err != nil {
+		return fmt.Errorf("unable to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// readSnapshot читает снимок. Снимок годен, только если все его записи
+// прошли проверку, иначе возвращаются записи, не прошедшие её.
+func readSnapshot(ctx context.Context, sink Sink, name string, c codec) (frame, []quarantined, error) {
+	data, err := sink.Read(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return frame{}, nil, errNoSnapshot
+	}
+	if err != nil {
+		return frame{}, nil, fmt.Errorf("unable to read snapshot %s: %w", name, err)
+	}
+
+	lines := splitLines(data)
+	frames, bad, err := decode(name, lines, c)
+	if err != nil {
+		return frame{}, nil, err
+	}
+	if len(bad) != 0 {
+		return frame{}, bad, fmt.Errorf("snapshot %s is corrupt: %d records failed the check", name, len(bad))
+	}
+	// у годного снимка за заголовком всегда следует один кадр, даже пустой
+	if len(frames) != 1 {
+		return frame{}, nil, fmt.Errorf("snapshot %s is truncated", name)
+	}
+	for _, ch := range frames[0].changes {
+		if ch.Kind != model.ChangeUpdate {
+			return frame{}, nil, fmt.Errorf("snapshot %s holds a %s record", name, ch.Kind)
+		}
+	}
+	return frames[0], nil, nil
+}
+
+// seriesGeneration — ряд между удалениями: ряд, созданный заново
+// после удаления, снова начинается с ревизии 1.
+type seriesGeneration struct {
+	key model.MetricKey
+	n   int
+}
+
+// ordered упорядочивает записи каждого ряда по ревизии. Хранилище передаёт
+// изменения в буфер после снятия блокировки, поэтому записи одного ряда
+// могут попасть в журнал не в том порядке, в котором применялись.
+// Удаление попадает в буфер после всех предшествующих записей ряда,
+// поэтому записи переставляются только в пределах поколения ряда.
+// Записи без ревизии остаются на своих местах.
+func ordered(log []model.Change) []model.Change {
+	positions := make(map[seriesGeneration][]int)
+	generations := make(map[model.MetricKey]int)
+	for i, ch := range log {
+		key := ch.Key()
+		if ch.Revision != 0 {
+			g := seriesGeneration{key: key, n: generations[key]}
+			positions[g] = append(positions[g], i)
+		}
+		if ch.Kind == model.ChangeDelete {
+			generations[key]++
+		}
+	}
+
+	result := slices.Clone(log)
+	for _, idx := range positions {
+		records := make([]model.Change, 0, len(idx))
+		for _, i := range idx {
+			records = append(records, log[i])
+		}
+		slices.SortStableFunc(records, func(a, b model.Change) int {
+			return cmp.Compare(a.Revision, b.Revision)
+		})
+		for j, i := range idx {
+			result[i] = records[j]
+		}
+	}
+	return result
+}
+
+// since отбирает записи журнала, сделанные после снимка.
+// Запись могла попасть в журнал уже после того, как её результат
+// вошёл в снимок; такие записи узнаются по ревизии ряда.
+// Удалённый ряд появляется заново с ревизии 1, поэтому после удаления
+// ревизии ряда сравниваются уже не со снимком.
+// Записи без ревизии применяются всегда.
+func since(snapshot []model.Metric, log []model.Change) []model.Change {
+	revisions := make(map[model.MetricKey]int64, len(snapshot))
+	for _, m := range snapshot {
+		revisions[m.Key()] = m.Revision
+	}
+	newer := make([]model.Change, 0, len(log))
+	for _, ch := range log {
+		key := ch.Key()
+		if ch.Revision != 0 && ch.Revision <= revisions[key] {
+			continue
+		}
+		if ch.Kind == model.ChangeDelete {
+			delete(revisions, key)
+		}
+		newer = append(newer, ch)
+	}
+	return newer
+}
+
+// rebuild возвращает состояние хранилища, собранное из снимка snapshot
+// и записей журнала log. Записи сливаются с состоянием ряда так же,
+// как при записи в хранилище, с политикой переполнения policy;
+// ряд получает ревизию записи, а для записей без ревизии — следующую.
+// Удаление и обнуление отсутствующего ряда ничего не меняют.
+func rebuild(snapshot []model.Metric, log []model.Change, policy model.OverflowPolicy) ([]model.Metric, error) {
+	state := make(map[model.MetricKey]model.Metric, len(snapshot))
+	for _, m := range snapshot {
+		state[m.Key()] = m
+	}
+	for _, ch := range log {
+		key := ch.Key()
+		current, found := state[key]
+		switch {
+		case ch.Kind == model.ChangeDelete:
+			delete(state, key)
+			continue
+		case !found && ch.Kind == model.ChangeReset:
+			continue
+		case !found:
+			current = ch.Baseline()
+			current.Revision = max(ch.Revision, 1)
+			state[key] = current
+			continue
+		case ch.Kind == model.ChangeReset:
+			if err := current.Reset(*ch.Timestamp); err != nil {
+				return nil, fmt.Errorf("unable to apply backup reset of %s: %w", key, err)
+			}
+		default:
+			if err := current.Update(ch.Metric, policy); err != nil {
+				return nil, fmt.Errorf("unable to apply backup record %s: %w", ch.String(), err)
+			}
+		}
+		current.Revision = max(ch.Revision, current.Revision+1)
+		state[key] = current
+	}
+
+	metrics := make([]model.Metric, 0, len(state))
+	for _, m := range state {
+		metrics = append(metrics, m)
+	}
+	slices.SortFunc(metrics, func(a, b model.Metric) int {
+		return model.CompareKeys(a.Key(), b.Key())
+	})
+	return metrics, nil
+}
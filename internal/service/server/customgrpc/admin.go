@@ -0,0 +1,180 @@
+package customgrpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/talx-hub/malerter/internal/api/handlers"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/service/server/backup"
+	"github.com/talx-hub/malerter/pkg/signature"
+	pb "github.com/talx-hub/malerter/proto"
+)
+
+// chunkSize — размер части архива резервной копии в потоке.
+const chunkSize = 64 * 1024
+
+// Метаданные подписи вызова администратора.
+const (
+	mdAdminSignature = "admin-signature"
+	mdAdminTimestamp = "admin-timestamp"
+	mdAdminNonce     = "admin-nonce"
+)
+
+// adminServer управляет резервными копиями по gRPC.
+// Вызовы, как и HTTP-запросы администратора, подписываются ключом
+// администратора: подпись покрывает имя метода, момент подписи,
+// одноразовое значение и сообщение (см. signature.Request).
+type adminServer struct {
+	pb.UnimplementedAdminServer
+	backups handlers.Backups
+	admin   *signature.Verifier
+	log     *logger.ZeroLogger
+}
+
+func newAdminServer(backups handlers.Backups, admin *signature.Verifier, log *logger.ZeroLogger) *adminServer {
+	return &adminServer{backups: backups, admin: admin, log: log}
+}
+
+func (s *adminServer) Backup(ctx context.Context, r *pb.BackupRequest) (*pb.BackupInfo, error) {
+	if err := s.check(ctx, pb.Admin_Backup_FullMethodName, r); err != nil {
+		return nil, err
+	}
+	info, err := s.backups.Backup(ctx)
+	if err != nil {
+		return nil, s.backupError(err)
+	}
+	return toBackupInfo(info), nil
+}
+
+func (s *adminServer) ListBackups(
+	ctx context.Context, r *pb.ListBackupsRequest,
+) (*pb.ListBackupsResponse, error) {
+	if err := s.check(ctx, pb.Admin_ListBackups_FullMethodName, r); err != nil {
+		return nil, err
+	}
+	infos, err := s.backups.List(ctx)
+	if err != nil {
+		return nil, s.backupError(err)
+	}
+	resp := &pb.ListBackupsResponse{Backups: make([]*pb.BackupInfo, 0, len(infos))}
+	for _, info := range infos {
+		resp.Backups = append(resp.Backups, toBackupInfo(info))
+	}
+	return resp, nil
+}
+
+func (s *adminServer) Restore(ctx context.Context, r *pb.RestoreRequest) (*pb.RestoreResponse, error) {
+	if err := s.check(ctx, pb.Admin_Restore_FullMethodName, r); err != nil {
+		return nil, err
+	}
+	var until time.Time
+	if r.GetUntil() != nil {
+		until = r.GetUntil().AsTime()
+	}
+	report, err := s.backups.Restore(ctx, r.GetBackup(), until)
+	if err != nil {
+		return nil, s.backupError(err)
+	}
+	resp := &pb.RestoreResponse{
+		Backup:             report.Backup,
+		SnapshotRecords:    int64(report.Snapshot),
+		LogRecords:         int64(report.Log),
+		IgnoredRecords:     int64(report.Ignored),
+		QuarantinedRecords: int64(report.Quarantined),
+	}
+	if !report.Until.IsZero() {
+		resp.Until = timestamppb.New(report.Until)
+	}
+	return resp, nil
+}
+
+// Download отправляет tar-архив резервной копии частями по chunkSize.
+func (s *adminServer) Download(r *pb.DownloadRequest, stream grpc.ServerStreamingServer[pb.Chunk]) error {
+	if err := s.check(stream.Context(), pb.Admin_Download_FullMethodName, r); err != nil {
+		return err
+	}
+	archive, err := s.backups.Download(stream.Context(), r.GetBackup())
+	if err != nil {
+		return s.backupError(err)
+	}
+	chunks := bufio.NewWriterSize(&chunkWriter{stream: stream}, chunkSize)
+	if _, err = archive.WriteTo(chunks); err != nil {
+		return status.Errorf(codes.Internal, "download failed: %v", err)
+	}
+	if err = chunks.Flush(); err != nil {
+		return status.Errorf(codes.Internal, "download failed: %v", err)
+	}
+	return nil
+}
+
+// chunkWriter отправляет в поток каждую запись отдельной частью.
+type chunkWriter struct {
+	stream grpc.ServerStreamingServer[pb.Chunk]
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	// сообщение нельзя менять после Send, а буфер p будет переиспользован
+	if err := c.stream.Send(&pb.Chunk{Data: slices.Clone(p)}); err != nil {
+		//nolint:wrapcheck // status errors must stay as they are
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// check отклоняет вызовы без подписи администратора и без резервных копий.
+func (s *adminServer) check(ctx context.Context, method string, req proto.Message) error {
+	if s.admin == nil {
+		return status.Error(codes.PermissionDenied, "admin API requires an admin key")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	data, err := pb.SigningBytes(req)
+	if err != nil {
+		return status.Errorf(codes.Internal, "verify failed: %v", err)
+	}
+	err = s.admin.Verify(first(mdAdminSignature), first(mdAdminTimestamp), first(mdAdminNonce),
+		[]string{method}, data)
+	if err != nil {
+		s.log.Warn().Err(err).Str("method", method).Msg("admin signature verification failed")
+		return status.Errorf(codes.Unauthenticated, "admin signature: %v", err)
+	}
+	if s.backups == nil {
+		return status.Error(codes.Unavailable, "backups are disabled")
+	}
+	return nil
+}
+
+func (s *adminServer) backupError(err error) error {
+	s.log.Error().Err(err).Msg("backup request failed")
+	if errors.Is(err, backup.ErrNotFound) || errors.Is(err, backup.ErrNoBackups) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toBackupInfo(info backup.Info) *pb.BackupInfo {
+	return &pb.BackupInfo{
+		Name:            info.Name,
+		Modified:        timestamppb.New(info.Modified),
+		Size:            info.Size,
+		SnapshotRecords: int64(info.Snapshot),
+		LogRecords:      int64(info.Log),
+		Error:           info.Error,
+	}
+}
@@ -0,0 +1,162 @@
+package customgrpc
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/talx-hub/malerter/internal/api/handlers"
+	"github.com/talx-hub/malerter/internal/config/server"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+	"github.com/talx-hub/malerter/internal/repository/memory"
+	"github.com/talx-hub/malerter/internal/service/server/backup"
+	"github.com/talx-hub/malerter/pkg/queue"
+	"github.com/talx-hub/malerter/pkg/signature"
+	pb "github.com/talx-hub/malerter/proto"
+)
+
+const (
+	adminSecret = "admin-secret"
+	agentSecret = "agent-secret"
+)
+
+// startAdmin запускает сервер резервных копий с проверкой подписи
+// агентов и возвращает его клиента.
+func startAdmin(t *testing.T, backups handlers.Backups) pb.AdminClient {
+	t.Helper()
+
+	log := logger.NewNopLogger()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(NewVerifySignatureInterceptor(agentSecret, log)))
+	pb.RegisterAdminServer(srv, newAdminServer(backups, signature.NewVerifier(adminSecret, time.Minute), log))
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return pb.NewAdminClient(conn)
+}
+
+var nonces atomic.Int64
+
+// signed подписывает вызов method администратора ключом secret.
+func signed(t *testing.T, method string, req proto.Message, secret string) context.Context {
+	t.Helper()
+
+	data, err := pb.SigningBytes(req)
+	require.NoError(t, err)
+	ts := signature.Timestamp(time.Now())
+	nonce := fmt.Sprintf("n%d", nonces.Add(1))
+	sig := signature.Hash(signature.Request(ts, nonce, []string{method}, data), secret)
+	return metadata.AppendToOutgoingContext(context.Background(),
+		mdAdminSignature, sig, mdAdminTimestamp, ts, mdAdminNonce, nonce)
+}
+
+func TestAdminServer(t *testing.T) {
+	tunnel := queue.New[model.Change]()
+	rep := memory.New(logger.NewNopLogger(), &tunnel)
+	cfg := server.Builder{BackupDir: t.TempDir()}
+	bk := backup.New(&cfg, &tunnel, rep, logger.NewNopLogger())
+	require.NotNil(t, bk)
+	client := startAdmin(t, bk)
+
+	hits := int64(1)
+	_, err := rep.Add(context.Background(), model.Metric{Name: "hits", Type: model.MetricTypeCounter, Delta: &hits})
+	require.NoError(t, err)
+	ctx := signed(t, pb.Admin_Backup_FullMethodName, &pb.BackupRequest{}, adminSecret)
+	info, err := client.Backup(ctx, &pb.BackupRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), info.GetSnapshotRecords())
+
+	// перехваченный вызов нельзя повторить
+	_, err = client.Backup(ctx, &pb.BackupRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// ключ агента не подходит
+	_, err = client.Backup(signed(t, pb.Admin_Backup_FullMethodName, &pb.BackupRequest{}, agentSecret),
+		&pb.BackupRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	// подпись одного метода не годится для другого
+	list := &pb.ListBackupsRequest{}
+	_, err = client.ListBackups(signed(t, pb.Admin_Backup_FullMethodName, list, adminSecret), list)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	backups, err := client.ListBackups(signed(t, pb.Admin_ListBackups_FullMethodName, list, adminSecret), list)
+	require.NoError(t, err)
+	require.NotEmpty(t, backups.GetBackups())
+	assert.Equal(t, info.GetName(), backups.GetBackups()[0].GetName())
+
+	download := &pb.DownloadRequest{Backup: info.GetName()}
+	stream, err := client.Download(signed(t, pb.Admin_Download_FullMethodName, download, adminSecret), download)
+	require.NoError(t, err)
+	var archive bytes.Buffer
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		archive.Write(chunk.GetData())
+	}
+	h, err := tar.NewReader(&archive).Next()
+	require.NoError(t, err)
+	assert.Contains(t, h.Name, info.GetName())
+
+	stream, err = client.Download(signed(t, pb.Admin_Download_FullMethodName, download, "wrong"), download)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	restore := &pb.RestoreRequest{Backup: "missing.bk"}
+	_, err = client.Restore(signed(t, pb.Admin_Restore_FullMethodName, restore, adminSecret), restore)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	restore.Backup = info.GetName()
+	report, err := client.Restore(signed(t, pb.Admin_Restore_FullMethodName, restore, adminSecret), restore)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.GetSnapshotRecords())
+}
+
+func TestAdminServer_RequiresAdminKey(t *testing.T) {
+	req := &pb.ListBackupsRequest{}
+	s := newAdminServer(nil, nil, logger.NewNopLogger())
+	_, err := s.ListBackups(signed(t, pb.Admin_ListBackups_FullMethodName, req, adminSecret), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	s = newAdminServer(nil, signature.NewVerifier(adminSecret, time.Minute), logger.NewNopLogger())
+	_, err = s.ListBackups(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = s.ListBackups(incoming(signed(t, pb.Admin_ListBackups_FullMethodName, req, adminSecret)), req)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// incoming переносит исходящие метаданные во входящие, как их видит сервер.
+func incoming(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewIncomingContext(context.Background(), md)
+}
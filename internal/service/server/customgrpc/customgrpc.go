@@ -5,15 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/talx-hub/malerter/internal/api/handlers"
 	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/internal/repository/db"
@@ -22,11 +26,22 @@ import (
 	pb "github.com/talx-hub/malerter/proto"
 )
 
+// Метаданные арендатора и подписи его вызова.
+const (
+	mdTenant          = "x-tenant"
+	mdTenantSignature = "x-tenant-signature"
+	mdTenantTimestamp = "x-tenant-timestamp"
+	mdTenantNonce     = "x-tenant-nonce"
+)
+
 type Server struct {
 	pb.UnimplementedMetricsServer
 	storage    handlers.Storage
+	backups    handlers.Backups
 	log        *logger.ZeroLogger
 	decrypter  *crypto.Decrypter
+	admin      *signature.Verifier
+	tenants    map[string]*signature.Verifier
 	grpcServer *grpc.Server
 	subnet     *net.IPNet
 	address    string
@@ -39,10 +54,16 @@ func New(
 	decrypter *crypto.Decrypter,
 	address, secret string,
 	subnet *net.IPNet,
+	backups handlers.Backups,
+	admin *signature.Verifier,
+	tenants map[string]*signature.Verifier,
 ) *Server {
 	return &Server{
+		admin:     admin,
+		tenants:   tenants,
 		address:   address,
 		storage:   storage,
+		backups:   backups,
 		log:       log,
 		decrypter: decrypter,
 		secret:    secret,
@@ -52,13 +73,22 @@ func New(
 
 func (s *Server) Batch(ctx context.Context, r *pb.BatchRequest,
 ) (*pb.BatchResponse, error) {
-	metrics := s.parseMetrics(r)
+	tenant, err := s.tenantFromMetadata(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	metrics := s.parseMetrics(r, tenant)
 
-	if err := s.storeMetrics(ctx, metrics); err != nil {
-		return nil, status.Errorf(codes.Internal, "storage error: %v", err)
+	merged, err := s.storeMetrics(ctx, metrics)
+	if err != nil {
+		return nil, err
 	}
 
-	return &pb.BatchResponse{}, nil
+	resp := &pb.BatchResponse{Metrics: make([]*pb.Metric, 0, len(merged))}
+	for _, m := range merged {
+		resp.Metrics = append(resp.Metrics, toGRPC(m))
+	}
+	return resp, nil
 }
 
 func (s *Server) Start() error {
@@ -73,8 +103,12 @@ func (s *Server) Start() error {
 			NewCheckNetworkInterceptor(s.subnet, s.log),
 			NewVerifySignatureInterceptor(s.secret, s.log),
 			NewDecryptingInterceptor(s.decrypter, s.log),
+		),
+		grpc.ChainStreamInterceptor(
+			NewCheckNetworkStreamInterceptor(s.subnet, s.log),
 		))
 	pb.RegisterMetricsServer(s.grpcServer, s)
+	pb.RegisterAdminServer(s.grpcServer, newAdminServer(s.backups, s.admin, s.log))
 
 	errCh := make(chan error)
 	defer close(errCh)
@@ -103,56 +137,183 @@ func (s *Server) Stop(ctx context.Context) error {
 	}
 }
 
-func (s *Server) parseMetrics(r *pb.BatchRequest) []model.Metric {
+// tenantFromMetadata возвращает арендатора из метаданных x-tenant.
+// Вызов арендатора должен быть подписан его ключом, подпись покрывает
+// метод, арендатора и сообщение (см. signature.Request).
+// Без метаданных метрики относятся к арендатору по умолчанию.
+func (s *Server) tenantFromMetadata(ctx context.Context, r *pb.BatchRequest) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	tenant := first(mdTenant)
+	if tenant == model.DefaultTenant {
+		return model.DefaultTenant, nil
+	}
+	if err := model.CheckTenant(tenant); err != nil {
+		return "", status.Errorf(codes.InvalidArgument, "invalid tenant: %v", err)
+	}
+	verifier, ok := s.tenants[tenant]
+	if !ok {
+		return "", status.Errorf(codes.PermissionDenied, "unknown tenant <%s>", tenant)
+	}
+	data, err := pb.SigningBytes(r)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "verify failed: %v", err)
+	}
+	err = verifier.Verify(first(mdTenantSignature), first(mdTenantTimestamp), first(mdTenantNonce),
+		[]string{pb.Metrics_Batch_FullMethodName, tenant}, data)
+	if err != nil {
+		s.log.Warn().Err(err).Str("tenant", tenant).Msg("tenant signature verification failed")
+		return "", status.Errorf(codes.Unauthenticated, "tenant signature: %v", err)
+	}
+	return tenant, nil
+}
+
+func (s *Server) parseMetrics(r *pb.BatchRequest, tenant string) []model.Metric {
 	protoMetrics := r.GetMetricList().GetMetrics()
 	metrics := make([]model.Metric, len(protoMetrics))
+	now := time.Now()
 	var j = 0
 	for _, protoMetric := range protoMetrics {
-		m, err := fromGRPC(protoMetric)
+		m, err := fromGRPC(protoMetric, tenant)
 		if err != nil {
 			s.log.Error().Err(err).Msg("failed to parse metric")
 			continue
 		}
+		m.StampIfMissing(now)
 		metrics[j] = m
 		j++
 	}
 	return metrics[:j]
 }
 
-func (s *Server) storeMetrics(ctx context.Context, batch []model.Metric) error {
+func (s *Server) storeMetrics(ctx context.Context, batch []model.Metric) ([]model.Metric, error) {
 	ctxTO, cancel := context.WithTimeout(ctx, constants.TimeoutStorage)
 	defer cancel()
 	wrappedBatch := func(args ...any) (any, error) {
-		return nil, s.storage.Batch(ctxTO, batch)
+		return s.storage.Batch(ctxTO, batch)
 	}
-	if _, err := db.WithConnectionCheck(wrappedBatch); err != nil {
+	stored, err := db.WithConnectionCheck(wrappedBatch)
+	if err != nil {
 		errMsg := "failed to store batch in repo"
 		s.log.Error().Err(err).Msg(errMsg)
-		return status.Errorf(codes.Internal, "%s: %v", errMsg, err)
+		return nil, status.Errorf(codeFromError(err), "%s: %v", errMsg, err)
+	}
+	merged, ok := stored.([]model.Metric)
+	if !ok {
+		return nil, status.Error(codes.Internal, "failed to convert 'batch' result")
+	}
+	return merged, nil
+}
+
+// codeFromError возвращает код ответа на ошибку хранилища
+// по тем же правилам, что и HTTP-обработчики: ошибки клиента
+// не выдаются за внутренние, чтобы клиент не повторял запрос.
+func codeFromError(err error) codes.Code {
+	var notFoundError *customerror.NotFoundError
+	var invalidArgumentError *customerror.InvalidArgumentError
+	var unavailableError *customerror.UnavailableError
+	var conflictError *customerror.ConflictError
+	switch {
+	case errors.As(err, &notFoundError):
+		return codes.NotFound
+	case errors.As(err, &invalidArgumentError):
+		return codes.InvalidArgument
+	case errors.As(err, &unavailableError):
+		return codes.Unavailable
+	case errors.As(err, &conflictError):
+		return codes.FailedPrecondition
+	default:
+		return codes.Internal
 	}
-	return nil
 }
 
-func fromGRPC(pbMetric *pb.Metric) (model.Metric, error) {
+func fromGRPC(pbMetric *pb.Metric, tenant string) (model.Metric, error) {
+	var m model.Metric
 	switch pbMetric.GetType() {
 	case pb.Metric_Gauge:
-		return model.Metric{
+		m = model.Metric{
 			Delta: nil,
 			Value: &pbMetric.Value,
 			Type:  model.MetricTypeGauge,
 			Name:  pbMetric.GetName(),
-		}, nil
+		}
 	case pb.Metric_Counter:
-		return model.Metric{
-			Delta: &pbMetric.Delta,
-			Value: nil,
-			Type:  model.MetricTypeCounter,
-			Name:  pbMetric.GetName(),
-		}, nil
+		m = model.Metric{
+			Delta:      &pbMetric.Delta,
+			Value:      nil,
+			Type:       model.MetricTypeCounter,
+			Name:       pbMetric.GetName(),
+			Cumulative: pbMetric.GetCumulative(),
+		}
+	case pb.Metric_Histogram:
+		pbHist := pbMetric.GetHistogram()
+		if pbHist == nil {
+			return model.Metric{}, errors.New(
+				"histogram metric has no histogram payload")
+		}
+		m = model.Metric{
+			Histogram: &model.Histogram{
+				Bounds: pbHist.GetBounds(),
+				Counts: pbHist.GetCounts(),
+				Sum:    pbHist.GetSum(),
+				Count:  pbHist.GetCount(),
+			},
+			Type: model.MetricTypeHistogram,
+			Name: pbMetric.GetName(),
+		}
 	default:
 		return model.Metric{}, errors.New(
 			"metric has unspecified type")
 	}
+
+	m.Labels = pbMetric.GetLabels()
+	m.Tenant = tenant
+	if pbMetric.GetTimestamp() != nil {
+		ts := pbMetric.GetTimestamp().AsTime()
+		m.Timestamp = &ts
+	}
+	if err := m.CheckValid(); err != nil {
+		return model.Metric{}, fmt.Errorf("invalid metric: %w", err)
+	}
+	return m, nil
+}
+
+func toGRPC(m model.Metric) *pb.Metric {
+	pbMetric := &pb.Metric{
+		Name:   m.Name,
+		Labels: m.Labels,
+	}
+	if m.Timestamp != nil {
+		pbMetric.Timestamp = timestamppb.New(*m.Timestamp)
+	}
+	switch m.Type {
+	case model.MetricTypeGauge:
+		pbMetric.Type = pb.Metric_Gauge
+		if m.Value != nil {
+			pbMetric.Value = *m.Value
+		}
+	case model.MetricTypeCounter:
+		pbMetric.Type = pb.Metric_Counter
+		if m.Delta != nil {
+			pbMetric.Delta = *m.Delta
+		}
+	case model.MetricTypeHistogram:
+		pbMetric.Type = pb.Metric_Histogram
+		if m.Histogram != nil {
+			pbMetric.Histogram = &pb.Histogram{
+				Bounds: m.Histogram.Bounds,
+				Counts: m.Histogram.Counts,
+				Sum:    m.Histogram.Sum,
+				Count:  m.Histogram.Count,
+			}
+		}
+	}
+	return pbMetric
 }
 
 func NewDecryptingInterceptor(decrypter *crypto.Decrypter, log *logger.ZeroLogger,
@@ -167,13 +328,10 @@ func NewDecryptingInterceptor(decrypter *crypto.Decrypter, log *logger.ZeroLogge
 			return handler(ctx, req)
 		}
 
+		// шифруются только метрики агента
 		batchReq, ok := req.(*pb.BatchRequest)
 		if !ok {
-			errMsg := "request does not implement *pb.BathRequest: got " +
-				fmt.Sprintf("%T", req)
-			log.Error().Msg(errMsg)
-			return nil, status.Errorf(
-				codes.InvalidArgument, "wrong message format: %s", errMsg)
+			return handler(ctx, req)
 		}
 
 		encrypted := batchReq.GetEncryptedPayload()
@@ -213,48 +371,60 @@ func NewVerifySignatureInterceptor(secret string, log *logger.ZeroLogger,
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		if secret == constants.NoSecret {
+		// вызовы администратора проверяет adminServer своим ключом
+		if secret == constants.NoSecret || isAdmin(info.FullMethod) {
 			return handler(ctx, req)
 		}
-
-		batchReq, ok := req.(*pb.BatchRequest)
-		if !ok {
-			errMsg := "request does not implement *pb.BathRequest: got " +
-				fmt.Sprintf("%T", req)
-			log.Error().Msg(errMsg)
-			return nil, status.Errorf(
-				codes.InvalidArgument, "wrong message format: %s", errMsg)
+		if err := verifySignature(ctx, req, secret, log); err != nil {
+			return nil, err
 		}
+		return handler(ctx, req)
+	}
+}
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Errorf(
-				codes.Unauthenticated, "missing metadata")
-		}
+// isAdmin сообщает, что метод принадлежит сервису администратора.
+func isAdmin(method string) bool {
+	return strings.HasPrefix(method, "/"+pb.Admin_ServiceDesc.ServiceName+"/")
+}
 
-		signatures := md.Get("signature")
-		if len(signatures) == 0 {
-			return nil, status.Errorf(
-				codes.Unauthenticated, "missing signature")
-		}
+// verifySignature сверяет подпись из метаданных с подписью сообщения.
+func verifySignature(ctx context.Context, req interface{}, secret string, log *logger.ZeroLogger) error {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		errMsg := "request does not implement proto.Message: got " +
+			fmt.Sprintf("%T", req)
+		log.Error().Msg(errMsg)
+		return status.Errorf(
+			codes.InvalidArgument, "wrong message format: %s", errMsg)
+	}
 
-		data, err := proto.Marshal(batchReq)
-		if err != nil {
-			log.Error().Err(err).Msg(
-				"failed to marshal request for verification")
-			return nil, status.Errorf(
-				codes.Internal, "verify failed: %v", err)
-		}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Errorf(
+			codes.Unauthenticated, "missing metadata")
+	}
 
-		hash := signature.Hash(data, secret)
-		if hash != signatures[0] {
-			log.Warn().Msg("signature verification failed")
-			return nil, status.Errorf(
-				codes.PermissionDenied, "invalid signature")
-		}
+	signatures := md.Get("signature")
+	if len(signatures) == 0 {
+		return status.Errorf(
+			codes.Unauthenticated, "missing signature")
+	}
 
-		return handler(ctx, req)
+	data, err := pb.SigningBytes(msg)
+	if err != nil {
+		log.Error().Err(err).Msg(
+			"failed to marshal request for verification")
+		return status.Errorf(
+			codes.Internal, "verify failed: %v", err)
 	}
+
+	hash := signature.Hash(data, secret)
+	if hash != signatures[0] {
+		log.Warn().Msg("signature verification failed")
+		return status.Errorf(
+			codes.PermissionDenied, "invalid signature")
+	}
+	return nil
 }
 
 func NewCheckNetworkInterceptor(ipNet *net.IPNet, log *logger.ZeroLogger,
@@ -265,34 +435,58 @@ func NewCheckNetworkInterceptor(ipNet *net.IPNet, log *logger.ZeroLogger,
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (resp interface{}, err error) {
-		if ipNet == nil {
-			return handler(ctx, req)
+		if err = checkNetwork(ctx, ipNet, log); err != nil {
+			return nil, err
 		}
+		return handler(ctx, req)
+	}
+}
 
-		const forbiddenMsg = "forbidden"
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			log.Error().Msg("metadata not found in context")
-			return nil, status.Error(codes.PermissionDenied, forbiddenMsg)
+// NewCheckNetworkStreamInterceptor — NewCheckNetworkInterceptor
+// для потоковых вызовов.
+func NewCheckNetworkStreamInterceptor(ipNet *net.IPNet, log *logger.ZeroLogger,
+) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := checkNetwork(ss.Context(), ipNet, log); err != nil {
+			return err
 		}
+		return handler(srv, ss)
+	}
+}
 
-		realIPs := md.Get("x-real-ip")
-		if len(realIPs) == 0 {
-			log.Error().Msg("x-real-ip header not present")
-			return nil, status.Error(codes.PermissionDenied, forbiddenMsg)
-		}
+// checkNetwork проверяет, что адрес из x-real-ip входит в доверенную подсеть.
+func checkNetwork(ctx context.Context, ipNet *net.IPNet, log *logger.ZeroLogger) error {
+	if ipNet == nil {
+		return nil
+	}
 
-		ip := net.ParseIP(realIPs[0])
-		if ip == nil {
-			log.Error().Str("x-real-ip", realIPs[0]).Msg("unable to parse x-real-ip")
-			return nil, status.Error(codes.PermissionDenied, forbiddenMsg)
-		}
+	const forbiddenMsg = "forbidden"
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		log.Error().Msg("metadata not found in context")
+		return status.Error(codes.PermissionDenied, forbiddenMsg)
+	}
 
-		if !ipNet.Contains(ip) {
-			log.Error().Str("ip", ip.String()).Msg("agent IP not in allowed subnet")
-			return nil, status.Error(codes.PermissionDenied, forbiddenMsg)
-		}
+	realIPs := md.Get("x-real-ip")
+	if len(realIPs) == 0 {
+		log.Error().Msg("x-real-ip header not present")
+		return status.Error(codes.PermissionDenied, forbiddenMsg)
+	}
 
-		return handler(ctx, req)
+	ip := net.ParseIP(realIPs[0])
+	if ip == nil {
+		log.Error().Str("x-real-ip", realIPs[0]).Msg("unable to parse x-real-ip")
+		return status.Error(codes.PermissionDenied, forbiddenMsg)
 	}
+
+	if !ipNet.Contains(ip) {
+		log.Error().Str("ip", ip.String()).Msg("agent IP not in allowed subnet")
+		return status.Error(codes.PermissionDenied, forbiddenMsg)
+	}
+	return nil
 }
@@ -2,6 +2,8 @@ package customgrpc
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"testing"
 	"time"
@@ -13,10 +15,11 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/talx-hub/malerter/internal/constants"
+	"github.com/talx-hub/malerter/internal/customerror"
 	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/internal/repository/memory"
 	"github.com/talx-hub/malerter/pkg/signature"
 	pb "github.com/talx-hub/malerter/proto"
@@ -43,6 +46,7 @@ func TestServer_Batch(t *testing.T) {
 	tests := []struct {
 		metrics     []*pb.Metric
 		wantMetrics int
+		wantStored  int
 		wantCode    codes.Code
 	}{
 		{
@@ -51,8 +55,18 @@ func TestServer_Batch(t *testing.T) {
 				{Name: "m2", Type: pb.Metric_Gauge, Value: 2.72},
 				{Name: "m3", Type: pb.Metric_Counter, Value: 42},
 				{Name: "m3", Type: pb.Metric_Counter, Value: 42},
+				{Name: "h1", Type: pb.Metric_Histogram, Histogram: &pb.Histogram{
+					Bounds: []float64{1}, Counts: []int64{1, 1}, Sum: 2.5, Count: 2}},
+				{Name: "h2", Type: pb.Metric_Histogram, Histogram: &pb.Histogram{
+					Bounds: []float64{1}, Counts: []int64{1}, Sum: 2.5, Count: 1}},
+				{Name: "h3", Type: pb.Metric_Histogram},
+				{Name: "m1", Type: pb.Metric_Gauge, Value: 1,
+					Labels: map[string]string{"host": "a"}},
+				{Name: "m1", Type: pb.Metric_Gauge, Value: 1,
+					Labels: map[string]string{"host name": "a"}},
 			},
-			wantMetrics: 3,
+			wantMetrics: 5,
+			wantStored:  6,
 			wantCode:    codes.OK},
 	}
 
@@ -63,6 +77,9 @@ func TestServer_Batch(t *testing.T) {
 		nil,
 		addr,
 		constants.NoSecret,
+		nil,
+		nil,
+		nil,
 		nil)
 	defer func() {
 		ctxTO, cancel := context.WithTimeout(
@@ -78,7 +95,7 @@ func TestServer_Batch(t *testing.T) {
 	time.Sleep(1 * time.Second)
 
 	for _, tt := range tests {
-		_, err := client.Batch(context.Background(), &pb.BatchRequest{
+		resp, err := client.Batch(context.Background(), &pb.BatchRequest{
 			Payload: &pb.BatchRequest_MetricList{
 				MetricList: &pb.MetricList{
 					Metrics: tt.metrics,
@@ -86,17 +103,104 @@ func TestServer_Batch(t *testing.T) {
 			},
 		})
 		require.NoError(t, err)
-		result, err := storage.Get(context.Background())
+		require.Len(t, resp.GetMetrics(), tt.wantStored)
+		assert.Equal(t, int64(2), resp.GetMetrics()[4].GetHistogram().GetCount())
+		result, err := storage.Get(context.Background(), model.ListOptions{})
 		require.NoError(t, err)
 		assert.Equal(t, tt.wantMetrics, len(result))
 	}
 }
 
+func TestServer_Batch_Tenant(t *testing.T) {
+	storage := memory.New(logger.NewNopLogger(), nil)
+	tenants := map[string]*signature.Verifier{"team-a": signature.NewVerifier("team-a-key", time.Minute)}
+	srv := New(storage, logger.NewNopLogger(), nil, addr, constants.NoSecret, nil, nil, nil, tenants)
+	req := &pb.BatchRequest{
+		Payload: &pb.BatchRequest_MetricList{
+			MetricList: &pb.MetricList{
+				Metrics: []*pb.Metric{{
+					Name: "Alloc", Type: pb.Metric_Gauge, Value: 1,
+					Labels: map[string]string{"host": "a", "dc": "b", "rack": "c", "zone": "d"},
+				}},
+			},
+		},
+	}
+
+	data, err := pb.SigningBytes(req)
+	require.NoError(t, err)
+	signedBy := func(tenant, key string) context.Context {
+		signed := signature.Sign(key, time.Now(), []string{pb.Metrics_Batch_FullMethodName, tenant}, data)
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			mdTenant, tenant, mdTenantSignature, signed.Signature,
+			mdTenantTimestamp, signed.Timestamp, mdTenantNonce, signed.Nonce))
+	}
+
+	// арендатор без подписи его ключом не принимается
+	_, err = srv.Batch(metadata.NewIncomingContext(context.Background(), metadata.Pairs(mdTenant, "team-a")), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = srv.Batch(signedBy("team-a", "wrong-key"), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = srv.Batch(signedBy("team-b", "team-a-key"), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// подпись метрик с метками проверяется независимо от порядка обхода map
+	for range 50 {
+		_, err = srv.Batch(signedBy("team-a", "team-a-key"), req)
+		require.NoError(t, err)
+	}
+	result, err := storage.Get(context.Background(), model.ListOptions{Tenant: "team-a"})
+	require.NoError(t, err)
+	assert.Len(t, result, 1)
+	result, err = storage.Get(context.Background(), model.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, result)
+
+	_, err = srv.Batch(signedBy("team/a", "team-a-key"), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// Ошибки клиента не возвращаются как Internal, чтобы клиент не повторял запрос.
+func TestServer_Batch_InvalidArgument(t *testing.T) {
+	srv := New(memory.New(logger.NewNopLogger(), nil),
+		logger.NewNopLogger(), nil, addr, constants.NoSecret, nil, nil, nil, nil)
+	batch := func(bound float64) *pb.BatchRequest {
+		return &pb.BatchRequest{
+			Payload: &pb.BatchRequest_MetricList{
+				MetricList: &pb.MetricList{
+					Metrics: []*pb.Metric{{Name: "h1", Type: pb.Metric_Histogram, Histogram: &pb.Histogram{
+						Bounds: []float64{bound}, Counts: []int64{1, 0}, Sum: 0.5, Count: 1}}},
+				},
+			},
+		}
+	}
+
+	_, err := srv.Batch(context.Background(), batch(1))
+	require.NoError(t, err)
+	_, err = srv.Batch(context.Background(), batch(2))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func Test_codeFromError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{&customerror.NotFoundError{}, codes.NotFound},
+		{&customerror.InvalidArgumentError{}, codes.InvalidArgument},
+		{&customerror.UnavailableError{}, codes.Unavailable},
+		{fmt.Errorf("wrapped: %w", &customerror.ConflictError{}), codes.FailedPrecondition},
+		{errors.New("unexpected"), codes.Internal},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, codeFromError(tt.err), tt.err.Error())
+	}
+}
+
 func TestNewVerifySignatureInterceptor_ValidSignature(t *testing.T) {
 	log := logger.NewNopLogger()
 	secret := "key"
 	req := &pb.BatchRequest{Payload: &pb.BatchRequest_MetricList{}}
-	data, _ := proto.Marshal(req)
+	data, _ := pb.SigningBytes(req)
 	sig := signature.Hash(data, secret)
 
 	md := metadata.Pairs("signature", sig)
@@ -120,6 +224,33 @@ func TestNewVerifySignatureInterceptor_ValidSignature(t *testing.T) {
 	assert.Equal(t, "ok", resp)
 }
 
+func TestNewVerifySignatureInterceptor_Labels(t *testing.T) {
+	secret := "key"
+	req := &pb.BatchRequest{
+		Payload: &pb.BatchRequest_MetricList{
+			MetricList: &pb.MetricList{
+				Metrics: []*pb.Metric{{
+					Name: "Alloc", Type: pb.Metric_Gauge, Value: 1,
+					Labels: map[string]string{"host": "a", "dc": "b", "rack": "c", "zone": "d"},
+				}},
+			},
+		},
+	}
+	data, err := pb.SigningBytes(req)
+	require.NoError(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("signature", signature.Hash(data, secret)))
+
+	interceptor := NewVerifySignatureInterceptor(secret, logger.NewNopLogger())
+	for range 100 {
+		_, err = interceptor(ctx, req, &grpc.UnaryServerInfo{},
+			func(ctx context.Context, r interface{}) (interface{}, error) {
+				return "ok", nil
+			})
+		require.NoError(t, err)
+	}
+}
+
 func TestNewVerifySignatureInterceptor_InvalidSignature(t *testing.T) {
 	log := logger.NewNopLogger()
 	secret := "key"
@@ -9,8 +9,10 @@ import (
 
 	"github.com/talx-hub/malerter/internal/api/handlers"
 	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
 	"github.com/talx-hub/malerter/internal/service/server/router"
 	"github.com/talx-hub/malerter/pkg/crypto"
+	"github.com/talx-hub/malerter/pkg/signature"
 )
 
 type CustomHTTP struct {
@@ -23,9 +25,17 @@ func New(
 	decrypter *crypto.Decrypter,
 	address, secret string,
 	subnet *net.IPNet,
+	retention model.Retention,
+	backups handlers.Backups,
+	admin *signature.Verifier,
+	tenants map[string]*signature.Verifier,
 ) *CustomHTTP {
-	chiRouter := router.New(log, subnet, secret, decrypter)
-	chiRouter.SetRouter(handlers.NewHTTPHandler(storage, log))
+	handler := handlers.NewHTTPHandler(storage, log)
+	handler.SetRetention(retention)
+	handler.SetBackups(backups)
+
+	chiRouter := router.New(log, subnet, secret, decrypter, admin, tenants)
+	chiRouter.SetRouter(handler)
 
 	return &CustomHTTP{
 		Server: http.Server{
@@ -16,21 +16,53 @@ type mockStorage struct {
 	mock.Mock
 }
 
-func (m *mockStorage) Add(ctx context.Context, metric model.Metric) error {
+func (m *mockStorage) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
 	args := m.Called(ctx, metric)
 	//nolint:wrapcheck // it's tests
-	return args.Error(0)
+	return metric, args.Error(0)
 }
 
-func (m *mockStorage) Batch(_ context.Context, _ []model.Metric) error {
-	return nil
+func (m *mockStorage) Batch(_ context.Context, batch []model.Metric) ([]model.Metric, error) {
+	return batch, nil
+}
+
+func (m *mockStorage) CompareAndSet(
+	_ context.Context, metric model.Metric, _ model.Condition,
+) (model.Metric, error) {
+	return metric, nil
 }
 
-func (m *mockStorage) Find(_ context.Context, _ string) (model.Metric, error) {
+func (m *mockStorage) Find(_ context.Context, _ model.MetricKey) (model.Metric, error) {
 	return model.Metric{}, nil
 }
 
-func (m *mockStorage) Get(_ context.Context) ([]model.Metric, error) {
+func (m *mockStorage) FindByLabels(
+	_ context.Context, _ string, _ model.MetricType, _ string, _ model.Labels,
+) ([]model.Metric, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Delete(_ context.Context, _ model.MetricKey) error {
+	return nil
+}
+
+func (m *mockStorage) Reset(_ context.Context, _ model.MetricKey) error {
+	return nil
+}
+
+func (m *mockStorage) History(
+	_ context.Context, _ model.MetricKey, _, _ time.Time,
+) ([]model.Sample, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Rollups(
+	_ context.Context, _ model.MetricKey, _ time.Duration, _, _ time.Time,
+) ([]model.Rollup, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Get(_ context.Context, _ model.ListOptions) ([]model.Metric, error) {
 	return nil, nil
 }
 
@@ -42,7 +74,7 @@ func TestNewCustomHTTP(t *testing.T) {
 	log := logger.NewNopLogger()
 	storage := new(mockStorage)
 
-	srv := New(storage, log, nil, ":9999", "secret", nil)
+	srv := New(storage, log, nil, ":9999", "secret", nil, model.Retention{}, nil, nil, nil)
 
 	assert.Equal(t, ":9999", srv.Addr)
 	assert.NotNil(t, srv.Handler)
@@ -52,7 +84,7 @@ func TestStop_OK(t *testing.T) {
 	log := logger.NewNopLogger()
 	storage := new(mockStorage)
 
-	srv := New(storage, log, nil, ":0", "", nil)
+	srv := New(storage, log, nil, ":0", "", nil, model.Retention{}, nil, nil, nil)
 
 	go func() {
 		_ = srv.Start()
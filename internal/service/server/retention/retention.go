@@ -0,0 +1,76 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/talx-hub/malerter/internal/config/server"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+type Storage interface {
+	Compact(ctx context.Context, policy model.Retention, now time.Time) error
+}
+
+// Manager периодически сжимает историю метрик в агрегаты
+// и удаляет данные с истёкшим сроком хранения.
+type Manager struct {
+	log      *logger.ZeroLogger
+	storage  Storage
+	policy   model.Retention
+	interval time.Duration
+}
+
+func New(
+	config *server.Builder,
+	storage Storage,
+	log *logger.ZeroLogger,
+) *Manager {
+	if log == nil {
+		return nil
+	}
+	if config == nil {
+		log.Error().Msg("retention service: config is nil")
+		return nil
+	}
+	if storage == nil {
+		log.Error().Msg("retention service: storage is nil")
+		return nil
+	}
+	if config.CompactInterval <= 0 {
+		log.Warn().Msg("retention service: compaction is disabled")
+		return nil
+	}
+
+	return &Manager{
+		log:      log,
+		storage:  storage,
+		policy:   config.Retention(),
+		interval: config.CompactInterval,
+	}
+}
+
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.log.Info().Msg("START retention SERVICE")
+	for {
+		select {
+		case <-ctx.Done():
+			m.log.Info().Msg("SHUTDOWN retention SERVICE...")
+			return
+		case <-ticker.C:
+			m.compact(ctx)
+		}
+	}
+}
+
+func (m *Manager) compact(ctx context.Context) {
+	if err := m.storage.Compact(ctx, m.policy, time.Now()); err != nil {
+		m.log.Error().Err(err).Msg("history compaction failed")
+		return
+	}
+	m.log.Debug().Msg("history compaction successful")
+}
@@ -0,0 +1,63 @@
+package retention
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/internal/config/server"
+	"github.com/talx-hub/malerter/internal/logger"
+	"github.com/talx-hub/malerter/internal/model"
+)
+
+type countingStorage struct {
+	calls atomic.Int32
+}
+
+func (s *countingStorage) Compact(_ context.Context, _ model.Retention, _ time.Time) error {
+	s.calls.Add(1)
+	return nil
+}
+
+func TestNew(t *testing.T) {
+	log := logger.NewNopLogger()
+	cfg := &server.Builder{CompactInterval: time.Minute, RetentionRaw: time.Hour}
+	storage := &countingStorage{}
+
+	assert.Nil(t, New(cfg, storage, nil))
+	assert.Nil(t, New(nil, storage, log))
+	assert.Nil(t, New(cfg, nil, log))
+	assert.Nil(t, New(&server.Builder{}, storage, log), "zero interval disables compaction")
+
+	m := New(cfg, storage, log)
+	require.NotNil(t, m)
+	assert.Equal(t, time.Hour, m.policy.Raw)
+	assert.Len(t, m.policy.Tiers, 2)
+}
+
+func TestRun(t *testing.T) {
+	storage := &countingStorage{}
+	m := New(
+		&server.Builder{CompactInterval: 10 * time.Millisecond},
+		storage,
+		logger.NewNopLogger(),
+	)
+	require.NotNil(t, m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return storage.calls.Load() >= 2
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+}
@@ -12,10 +12,13 @@ import (
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/pkg/crypto"
+	"github.com/talx-hub/malerter/pkg/signature"
 )
 
 type Router struct {
 	decrypter *crypto.Decrypter
+	admin     *signature.Verifier
+	tenants   map[string]*signature.Verifier
 	log       *logger.ZeroLogger
 	router    *chi.Mux
 	IPNet     *net.IPNet
@@ -27,9 +30,13 @@ func New(
 	ipNet *net.IPNet,
 	secret string,
 	decrypter *crypto.Decrypter,
+	admin *signature.Verifier,
+	tenants map[string]*signature.Verifier,
 ) *Router {
 	return &Router{
 		decrypter: decrypter,
+		admin:     admin,
+		tenants:   tenants,
 		log:       log,
 		router:    chi.NewRouter(),
 		IPNet:     ipNet,
@@ -44,11 +51,22 @@ type Handler interface {
 	DumpMetric(w http.ResponseWriter, r *http.Request)
 	DumpMetricJSON(w http.ResponseWriter, r *http.Request)
 	DumpMetricList(w http.ResponseWriter, r *http.Request)
+	DeleteMetric(w http.ResponseWriter, r *http.Request)
+	ResetMetric(w http.ResponseWriter, r *http.Request)
 	Ping(w http.ResponseWriter, r *http.Request)
+	QueryRange(w http.ResponseWriter, r *http.Request)
+	ListMetrics(w http.ResponseWriter, r *http.Request)
+	TriggerBackup(w http.ResponseWriter, r *http.Request)
+	ListBackups(w http.ResponseWriter, r *http.Request)
+	DownloadBackup(w http.ResponseWriter, r *http.Request)
+	RestoreBackup(w http.ResponseWriter, r *http.Request)
 }
 
 func (r *Router) SetRouter(h Handler) {
 	r.router.Use(middlewares.Logging(r.log))
+	r.router.Use(middlewares.CheckTenant(r.tenants))
+	// POST /value/ только читает метрику
+	r.router.Use(middlewares.ReadPrimary(constants.ReadYourWritesWindow, "/value/"))
 
 	r.router.Route("/", func(c chi.Router) {
 		c.
@@ -64,6 +82,17 @@ func (r *Router) SetRouter(h Handler) {
 				With(middlewares.Compress(r.log)).
 				Post("/", h.GetMetricJSON)
 			c.Get("/{type}/{name}", h.GetMetric)
+			c.
+				With(middlewares.CheckNetwork(r.IPNet, r.log)).
+				With(middlewares.CheckSignature(r.secret)).
+				Delete("/{type}/{name}", h.DeleteMetric)
+		})
+
+		c.Route("/reset", func(c chi.Router) {
+			c.
+				With(middlewares.CheckNetwork(r.IPNet, r.log)).
+				With(middlewares.CheckSignature(r.secret)).
+				Post("/{type}/{name}", h.ResetMetric)
 		})
 
 		c.Route("/update", func(c chi.Router) {
@@ -93,6 +122,26 @@ func (r *Router) SetRouter(h Handler) {
 				Post("/", h.DumpMetricList)
 		})
 
+		c.Route("/api/v1", func(c chi.Router) {
+			c.
+				With(middlewares.WriteSignature(r.secret)).
+				With(middlewares.Compress(r.log)).
+				Get("/query_range", h.QueryRange)
+			c.
+				With(middlewares.WriteSignature(r.secret)).
+				With(middlewares.Compress(r.log)).
+				Get("/metrics", h.ListMetrics)
+		})
+
+		c.Route("/admin", func(c chi.Router) {
+			admin := c.
+				With(middlewares.CheckNetwork(r.IPNet, r.log)).
+				With(middlewares.CheckAdminSignature(r.admin))
+			admin.Post("/backup", h.TriggerBackup)
+			admin.Get("/backups", h.ListBackups)
+			admin.Get("/backups/{name}", h.DownloadBackup)
+			admin.Post("/restore", h.RestoreBackup)
+		})
 		c.Route("/debug/pprof", func(c chi.Router) {
 			c.HandleFunc("/", pprof.Index)
 			c.HandleFunc("/cmdline", pprof.Cmdline)
@@ -1,14 +1,18 @@
 package router_test
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/talx-hub/malerter/internal/api/middlewares"
 	"github.com/talx-hub/malerter/internal/constants"
 	"github.com/talx-hub/malerter/internal/logger"
 	"github.com/talx-hub/malerter/internal/service/server/router"
@@ -16,14 +20,17 @@ import (
 )
 
 const testSecret = "test-secret"
+const testAdminKey = "test-admin-key"
 const testTrustedSubnet = "127.0.0.0/24"
+const testTenantKey = "test-tenant-key"
 
 type stubHandler struct {
 	name string
 }
 
-func (s stubHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+func (s stubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Handler", s.name)
+	w.Header().Set("X-Handler-Tenant", middlewares.TenantFromContext(r.Context()))
 	w.WriteHeader(http.StatusTeapot)
 }
 
@@ -47,7 +54,36 @@ func (testHandler) DumpMetricJSON(w http.ResponseWriter, r *http.Request) {
 func (testHandler) DumpMetricList(w http.ResponseWriter, r *http.Request) {
 	stubHandler{"DumpMetricList"}.ServeHTTP(w, r)
 }
+func (testHandler) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"DeleteMetric"}.ServeHTTP(w, r)
+}
+func (testHandler) ResetMetric(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"ResetMetric"}.ServeHTTP(w, r)
+}
 func (testHandler) Ping(w http.ResponseWriter, r *http.Request) { stubHandler{"Ping"}.ServeHTTP(w, r) }
+func (testHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"QueryRange"}.ServeHTTP(w, r)
+}
+
+func (testHandler) ListMetrics(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"ListMetrics"}.ServeHTTP(w, r)
+}
+
+func (testHandler) TriggerBackup(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"TriggerBackup"}.ServeHTTP(w, r)
+}
+
+func (testHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"ListBackups"}.ServeHTTP(w, r)
+}
+
+func (testHandler) DownloadBackup(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"DownloadBackup"}.ServeHTTP(w, r)
+}
+
+func (testHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	stubHandler{"RestoreBackup"}.ServeHTTP(w, r)
+}
 
 func newTestServer(t *testing.T) *httptest.Server {
 	t.Helper()
@@ -55,11 +91,59 @@ func newTestServer(t *testing.T) *httptest.Server {
 	_, subnet, err := net.ParseCIDR(testTrustedSubnet)
 	require.NoError(t, err)
 
-	r := router.New(logger.NewNopLogger(), subnet, testSecret, nil)
+	admin := signature.NewVerifier(testAdminKey, time.Minute)
+	tenants := map[string]*signature.Verifier{"team-a": signature.NewVerifier(testTenantKey, time.Minute)}
+	r := router.New(logger.NewNopLogger(), subnet, testSecret, nil, admin, tenants)
 	r.SetRouter(testHandler{})
 	return httptest.NewServer(r.GetRouter())
 }
 
+// signAdmin подписывает запрос без тела ключом администратора.
+func signAdmin(req *http.Request, nonce string) {
+	ts := signature.Timestamp(time.Now())
+	data := signature.Request(ts, nonce, []string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery}, nil)
+	req.Header.Set(constants.KeyAdminSignature, signature.Hash(data, testAdminKey))
+	req.Header.Set(constants.KeyAdminTimestamp, ts)
+	req.Header.Set(constants.KeyAdminNonce, nonce)
+}
+
+func TestRouter_Tenant(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	get := func(tenant, key string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/value/gauge/ram", http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set(constants.KeyTenant, tenant)
+		if key != "" {
+			signed := signature.Sign(key, time.Now(),
+				[]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, tenant}, nil)
+			req.Header.Set(constants.KeyTenantSignature, signed.Signature)
+			req.Header.Set(constants.KeyTenantTimestamp, signed.Timestamp)
+			req.Header.Set(constants.KeyTenantNonce, signed.Nonce)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	resp := get("team-a", testTenantKey)
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	assert.Equal(t, "team-a", resp.Header.Get("X-Handler-Tenant"))
+
+	// заголовку без подписи ключом арендатора не доверяют
+	assert.Equal(t, http.StatusUnauthorized, get("team-a", "").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, get("team-a", testSecret).StatusCode)
+	assert.Equal(t, http.StatusForbidden, get("team-b", testTenantKey).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, get("team/a", testTenantKey).StatusCode)
+
+	resp = get("", "")
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("X-Handler-Tenant"))
+}
+
 func TestRouter_HappyRoutes(t *testing.T) {
 	srv := newTestServer(t)
 	defer srv.Close()
@@ -74,6 +158,8 @@ func TestRouter_HappyRoutes(t *testing.T) {
 	}{
 		{"GET /", http.MethodGet, "/", false, http.StatusTeapot, "GetAll"},
 		{"GET /ping", http.MethodGet, "/ping", false, http.StatusTeapot, "Ping"},
+		{"GET /api/v1/query_range", http.MethodGet, "/api/v1/query_range", false, http.StatusTeapot, "QueryRange"},
+		{"GET /api/v1/metrics", http.MethodGet, "/api/v1/metrics", false, http.StatusTeapot, "ListMetrics"},
 		{"POST /value", http.MethodPost, "/value", false, http.StatusTeapot, "GetMetricJSON"},
 		{"GET /value/gauge/ram", http.MethodGet, "/value/gauge/ram", false, http.StatusTeapot, "GetMetric"},
 		{"POST /update", http.MethodPost, "/update", false, http.StatusTeapot, "DumpMetricJSON"},
@@ -81,14 +167,28 @@ func TestRouter_HappyRoutes(t *testing.T) {
 		{"POST /update/gauge/ram/123", http.MethodPost, "/update/gauge/ram/123", false, http.StatusTeapot, "DumpMetric"},
 		{"POST /updates", http.MethodPost, "/updates", false, http.StatusTeapot, "DumpMetricList"},
 		{"POST /updates", http.MethodPost, "/updates", true, http.StatusForbidden, ""},
+		{"DELETE /value/gauge/ram", http.MethodDelete, "/value/gauge/ram", false, http.StatusTeapot, "DeleteMetric"},
+		{"DELETE /value/gauge/ram", http.MethodDelete, "/value/gauge/ram", true, http.StatusForbidden, ""},
+		{"POST /reset/counter/hits", http.MethodPost, "/reset/counter/hits", false, http.StatusTeapot, "ResetMetric"},
+		{"POST /reset/counter/hits", http.MethodPost, "/reset/counter/hits", true, http.StatusForbidden, ""},
+		{"POST /admin/restore", http.MethodPost, "/admin/restore", false, http.StatusTeapot, "RestoreBackup"},
+		{"POST /admin/restore", http.MethodPost, "/admin/restore", true, http.StatusForbidden, ""},
+		{"POST /admin/backup", http.MethodPost, "/admin/backup", false, http.StatusTeapot, "TriggerBackup"},
+		{"GET /admin/backups", http.MethodGet, "/admin/backups", false, http.StatusTeapot, "ListBackups"},
+		{"GET /admin/backups", http.MethodGet, "/admin/backups", true, http.StatusForbidden, ""},
+		{"GET /admin/backups/a.bk", http.MethodGet, "/admin/backups/a.bk", false, http.StatusTeapot, "DownloadBackup"},
 	}
 
-	for _, tt := range tests {
+	for i, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req, err := http.NewRequest(tt.method, srv.URL+tt.path, http.NoBody)
 			require.NoError(t, err)
 
-			if tt.method == http.MethodPost {
+			if strings.HasPrefix(tt.path, "/admin") {
+				signAdmin(req, fmt.Sprintf("nonce%d", i))
+			}
+			if tt.method == http.MethodPost || tt.method == http.MethodDelete ||
+				strings.HasPrefix(tt.path, "/admin") {
 				req.Header.Set("Content-Type", "application/json")
 				sig := signature.Hash([]byte(""), testSecret)
 				req.Header.Set(constants.KeyHashSHA256, sig)
@@ -110,7 +210,7 @@ func TestRouter_HappyRoutes(t *testing.T) {
 }
 
 func TestRouter_not_check_network(t *testing.T) {
-	r := router.New(logger.NewNopLogger(), nil, testSecret, nil)
+	r := router.New(logger.NewNopLogger(), nil, testSecret, nil, nil, nil)
 	r.SetRouter(testHandler{})
 	srv := httptest.NewServer(r.GetRouter())
 	defer srv.Close()
@@ -192,3 +292,33 @@ func TestRouter_WrongRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestRouter_AdminRequiresAdminKey(t *testing.T) {
+	r := router.New(logger.NewNopLogger(), nil, testSecret, nil, nil, nil)
+	r.SetRouter(testHandler{})
+	srv := httptest.NewServer(r.GetRouter())
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/admin/backup", http.NoBody)
+	require.NoError(t, err)
+	signAdmin(req, "nonce")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// Подпись агента не годится для запросов администратора.
+func TestRouter_AdminRejectsAgentSignature(t *testing.T) {
+	srv := newTestServer(t)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/admin/backups", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set(constants.KeyHashSHA256, signature.Hash([]byte(""), testSecret))
+	req.Header.Set("X-Real-IP", "127.0.0.2")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
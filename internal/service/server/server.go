@@ -12,6 +12,7 @@ import (
 	"github.com/talx-hub/malerter/internal/service/server/customgrpc"
 	"github.com/talx-hub/malerter/internal/service/server/customhttp"
 	"github.com/talx-hub/malerter/pkg/crypto"
+	"github.com/talx-hub/malerter/pkg/signature"
 )
 
 type Server interface {
@@ -22,6 +23,7 @@ type Server interface {
 func Init(
 	cfg *server.Builder,
 	storage handlers.Storage,
+	backups handlers.Backups,
 	log *logger.ZeroLogger,
 ) Server {
 	decrypter, err := initDecrypter(cfg)
@@ -36,13 +38,27 @@ func Init(
 		return nil
 	}
 
+	// запросы администратора подписываются отдельным ключом:
+	// ключ агентов есть у каждого агента
+	var admin *signature.Verifier
+	if cfg.AdminKey != constants.NoSecret {
+		admin = signature.NewVerifier(cfg.AdminKey, constants.AdminSignatureWindow)
+	}
+
+	// арендатор подтверждается подписью своим ключом
+	tenants := make(map[string]*signature.Verifier)
+	for tenant, key := range cfg.Tenants() {
+		tenants[tenant] = signature.NewVerifier(key, constants.TenantSignatureWindow)
+	}
+
 	if cfg.UseGRPC {
 		return customgrpc.New(
-			storage, log, decrypter, cfg.RootAddress, cfg.Secret, agentSubnet)
+			storage, log, decrypter, cfg.RootAddress, cfg.Secret, agentSubnet, backups, admin, tenants)
 	}
 
 	return customhttp.New(
-		storage, log, decrypter, cfg.RootAddress, cfg.Secret, agentSubnet)
+		storage, log, decrypter, cfg.RootAddress, cfg.Secret, agentSubnet,
+		cfg.Retention(), backups, admin, tenants)
 }
 
 func parseTrustedSubnet(cfg *server.Builder) (*net.IPNet, error) {
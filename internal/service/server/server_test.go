@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -18,21 +19,53 @@ type mockStorage struct {
 	mock.Mock
 }
 
-func (m *mockStorage) Add(ctx context.Context, metric model.Metric) error {
+func (m *mockStorage) Add(ctx context.Context, metric model.Metric) (model.Metric, error) {
 	args := m.Called(ctx, metric)
 	//nolint:wrapcheck // it's tests
-	return args.Error(0)
+	return metric, args.Error(0)
 }
 
-func (m *mockStorage) Batch(_ context.Context, _ []model.Metric) error {
-	return nil
+func (m *mockStorage) Batch(_ context.Context, batch []model.Metric) ([]model.Metric, error) {
+	return batch, nil
+}
+
+func (m *mockStorage) CompareAndSet(
+	_ context.Context, metric model.Metric, _ model.Condition,
+) (model.Metric, error) {
+	return metric, nil
 }
 
-func (m *mockStorage) Find(_ context.Context, _ string) (model.Metric, error) {
+func (m *mockStorage) Find(_ context.Context, _ model.MetricKey) (model.Metric, error) {
 	return model.Metric{}, nil
 }
 
-func (m *mockStorage) Get(_ context.Context) ([]model.Metric, error) {
+func (m *mockStorage) FindByLabels(
+	_ context.Context, _ string, _ model.MetricType, _ string, _ model.Labels,
+) ([]model.Metric, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Delete(_ context.Context, _ model.MetricKey) error {
+	return nil
+}
+
+func (m *mockStorage) Reset(_ context.Context, _ model.MetricKey) error {
+	return nil
+}
+
+func (m *mockStorage) History(
+	_ context.Context, _ model.MetricKey, _, _ time.Time,
+) ([]model.Sample, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Rollups(
+	_ context.Context, _ model.MetricKey, _ time.Duration, _, _ time.Time,
+) ([]model.Rollup, error) {
+	return nil, nil
+}
+
+func (m *mockStorage) Get(_ context.Context, _ model.ListOptions) ([]model.Metric, error) {
 	return nil, nil
 }
 
@@ -89,7 +122,7 @@ func Test_Init_returnsHTTP(t *testing.T) {
 	log, _ := logger.New("debug")
 	storage := new(mockStorage)
 
-	s := Init(cfg, storage, log)
+	s := Init(cfg, storage, nil, log)
 	assert.NotNil(t, s)
 	assert.Implements(t, (*Server)(nil), s)
 }
@@ -106,7 +139,7 @@ func Test_Init_returnsGRPC(t *testing.T) {
 	log, _ := logger.New("debug")
 	storage := new(mockStorage)
 
-	s := Init(cfg, storage, log)
+	s := Init(cfg, storage, nil, log)
 	assert.NotNil(t, s)
 	assert.Implements(t, (*Server)(nil), s)
 }
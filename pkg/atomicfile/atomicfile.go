@@ -0,0 +1,60 @@
+// Package atomicfile записывает файлы так, что после сбоя на диске
+// остаётся либо прежнее содержимое файла, либо новое целиком.
+package atomicfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// tmpSuffix — суффикс временного файла, который затем подменяет целевой.
+const tmpSuffix = ".tmp"
+
+// Write записывает data во временный файл рядом с name, дожидается
+// его сброса на диск и переименовывает в name.
+func Write(name string, data []byte, perm os.FileMode) error {
+	tmp := name + tmpSuffix
+	if err := writeSync(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", name, err)
+	}
+	return SyncDir(filepath.Dir(name))
+}
+
+func writeSync(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to sync %s: %w", name, err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", name, err)
+	}
+	return nil
+}
+
+// SyncDir сбрасывает на диск запись каталога, чтобы переименование
+// файла пережило сбой питания.
+func SyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open dir %s: %w", dir, err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dir %s: %w", dir, err)
+	}
+	return nil
+}
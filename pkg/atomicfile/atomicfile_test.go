@@ -0,0 +1,38 @@
+package atomicfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/talx-hub/malerter/pkg/atomicfile"
+)
+
+func TestWrite(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "state.json")
+
+	if err := atomicfile.Write(name, []byte("old"), 0o600); err != nil {
+		t.Fatalf("first write failed: %v", err)
+	}
+	if err := atomicfile.Write(name, []byte("new"), 0o600); err != nil {
+		t.Fatalf("second write failed: %v", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "new" {
+		t.Errorf("expected new content, got %q", data)
+	}
+	if _, err = os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be renamed, stat error: %v", err)
+	}
+}
+
+func TestWrite_MissingDir(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "missing", "state.json")
+	if err := atomicfile.Write(name, []byte("data"), 0o600); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
@@ -0,0 +1,71 @@
+// Package ring предоставляет универсальный (generic) кольцевой буфер
+// фиксированной ёмкости.
+//
+// При заполнении буфера новые элементы вытесняют самые старые.
+// Буфер не потокобезопасен: синхронизация — ответственность вызывающего.
+package ring
+
+// Ring хранит не более Cap последних добавленных элементов.
+type Ring[T any] struct {
+	data []T // Хранилище элементов
+	head int // Индекс самого старого элемента
+	size int // Текущее количество элементов
+}
+
+// New создаёт пустой буфер ёмкостью capacity.
+//
+// Ёмкость меньше единицы приводится к единице.
+func New[T any](capacity int) *Ring[T] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Ring[T]{
+		data: make([]T, capacity),
+	}
+}
+
+// Push добавляет элемент, вытесняя самый старый при заполненном буфере.
+func (r *Ring[T]) Push(e T) {
+	if r.size < len(r.data) {
+		r.data[(r.head+r.size)%len(r.data)] = e
+		r.size++
+		return
+	}
+	r.data[r.head] = e
+	r.head = (r.head + 1) % len(r.data)
+}
+
+// Len возвращает текущее количество элементов.
+func (r *Ring[T]) Len() int {
+	return r.size
+}
+
+// Cap возвращает ёмкость буфера.
+func (r *Ring[T]) Cap() int {
+	return len(r.data)
+}
+
+// Retain оставляет только элементы, для которых keep возвращает true,
+// сохраняя их порядок.
+func (r *Ring[T]) Retain(keep func(T) bool) {
+	items := r.Items()
+	var zero T
+	for i := range r.data {
+		r.data[i] = zero
+	}
+	r.head, r.size = 0, 0
+	for _, e := range items {
+		if keep(e) {
+			r.Push(e)
+		}
+	}
+}
+
+// Items возвращает копию элементов от самого старого к самому новому.
+func (r *Ring[T]) Items() []T {
+	items := make([]T, r.size)
+	for i := range items {
+		items[i] = r.data[(r.head+i)%len(r.data)]
+	}
+	return items
+}
@@ -0,0 +1,60 @@
+package ring_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/talx-hub/malerter/pkg/ring"
+)
+
+func TestRingPushAndItems(t *testing.T) {
+	r := ring.New[int](3)
+
+	if r.Len() != 0 || r.Cap() != 3 {
+		t.Fatalf("expected empty ring of capacity 3, got len %d cap %d", r.Len(), r.Cap())
+	}
+
+	r.Push(1)
+	r.Push(2)
+	if got := r.Items(); !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", got)
+	}
+
+	r.Push(3)
+	r.Push(4)
+	r.Push(5)
+	if r.Len() != 3 {
+		t.Errorf("expected length 3 after overflow, got %d", r.Len())
+	}
+	if got := r.Items(); !slices.Equal(got, []int{3, 4, 5}) {
+		t.Errorf("expected [3 4 5], got %v", got)
+	}
+}
+
+func TestRingMinimalCapacity(t *testing.T) {
+	r := ring.New[string](0)
+	r.Push("a")
+	r.Push("b")
+
+	if got := r.Items(); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("expected [b], got %v", got)
+	}
+}
+
+func TestRingRetain(t *testing.T) {
+	r := ring.New[int](3)
+	for i := 1; i <= 5; i++ {
+		r.Push(i)
+	}
+
+	r.Retain(func(e int) bool { return e%2 == 1 })
+	if got := r.Items(); !slices.Equal(got, []int{3, 5}) {
+		t.Errorf("expected [3 5], got %v", got)
+	}
+
+	r.Push(6)
+	r.Push(7)
+	if got := r.Items(); !slices.Equal(got, []int{5, 6, 7}) {
+		t.Errorf("expected [5 6 7], got %v", got)
+	}
+}
@@ -0,0 +1,196 @@
+// Package s3 — минимальный клиент S3-совместимого хранилища объектов:
+// запись, чтение, удаление и список объектов бакета.
+// Бакет адресуется в пути запроса, как принято у S3-совместимых серверов.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// RegionDefault — регион, если он не задан.
+	RegionDefault = "us-east-1"
+	// requestTimeout ограничивает запрос к хранилищу.
+	requestTimeout = 30 * time.Second
+)
+
+// ErrNotFound — объекта нет в бакете.
+var ErrNotFound = errors.New("object not found")
+
+type Config struct {
+	// Endpoint — адрес хранилища, например https://s3.amazonaws.com.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+type Client struct {
+	endpoint  *url.URL
+	http      *http.Client
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+}
+
+// Object — объект из списка бакета.
+type Object struct {
+	LastModified time.Time `xml:"LastModified"`
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+}
+
+type listResult struct {
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = RegionDefault
+	}
+	return &Client{
+		endpoint:  endpoint,
+		http:      &http.Client{Timeout: requestTimeout},
+		bucket:    cfg.Bucket,
+		region:    region,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+	}, nil
+}
+
+// Put записывает объект целиком.
+func (c *Client) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	return closeBody(resp)
+}
+
+// Get возвращает содержимое объекта или ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read s3 object %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Delete удаляет объект; удаление отсутствующего объекта не ошибка.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return closeBody(resp)
+}
+
+// List возвращает все объекты с префиксом prefix.
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := make([]Object, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode s3 list: %w", err)
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *Client) do(
+	ctx context.Context, method, key string, query url.Values, payload []byte,
+) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = query.Encode()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create s3 request: %w", err)
+	}
+	sign(req, payload, c.region, c.accessKey, c.secretKey, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s failed: %w", method, key, err)
+	}
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return resp, nil
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	var e errorResponse
+	_ = xml.NewDecoder(resp.Body).Decode(&e)
+	return nil, fmt.Errorf("s3 %s %s failed: %s %s: %s", method, key, resp.Status, e.Code, e.Message)
+}
+
+func closeBody(resp *http.Response) error {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		return fmt.Errorf("unable to close s3 response: %w", err)
+	}
+	return nil
+}
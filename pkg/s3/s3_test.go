@@ -0,0 +1,73 @@
+package s3_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/talx-hub/malerter/pkg/s3"
+	"github.com/talx-hub/malerter/pkg/s3/s3test"
+)
+
+func newTestClient(t *testing.T) (*s3.Client, *s3test.Fake) {
+	t.Helper()
+	cfg := s3.Config{Bucket: "backups", AccessKey: "access", SecretKey: "secret"}
+	fake := s3test.NewFake(cfg)
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	cfg.Endpoint = srv.URL
+	client, err := s3.New(cfg)
+	require.NoError(t, err)
+	return client, fake
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newTestClient(t)
+
+	_, err := client.Get(ctx, "malerter/a.bk")
+	assert.ErrorIs(t, err, s3.ErrNotFound)
+
+	require.NoError(t, client.Put(ctx, "malerter/a.bk", []byte("frame\n")))
+	data, err := client.Get(ctx, "malerter/a.bk")
+	require.NoError(t, err)
+	assert.Equal(t, "frame\n", string(data))
+
+	require.NoError(t, client.Delete(ctx, "malerter/a.bk"))
+	require.NoError(t, client.Delete(ctx, "malerter/a.bk"))
+	assert.Empty(t, fake.Keys())
+}
+
+func TestClient_List(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newTestClient(t)
+	fake.MaxKeys = 2
+
+	for _, key := range []string{"p/1", "p/2", "p/3", "q/1", "p/sub dir/4"} {
+		require.NoError(t, client.Put(ctx, key, []byte(key)))
+	}
+	objects, err := client.List(ctx, "p/")
+	require.NoError(t, err)
+	keys := make([]string, 0, len(objects))
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+		assert.False(t, o.LastModified.IsZero())
+	}
+	assert.Equal(t, []string{"p/1", "p/2", "p/3", "p/sub dir/4"}, keys)
+}
+
+func TestClient_Signature(t *testing.T) {
+	cfg := s3.Config{Bucket: "backups", AccessKey: "access", SecretKey: "secret"}
+	srv := httptest.NewServer(s3test.NewFake(cfg))
+	defer srv.Close()
+	cfg.Endpoint = srv.URL
+	cfg.SecretKey = "wrong"
+	client, err := s3.New(cfg)
+	require.NoError(t, err)
+
+	err = client.Put(context.Background(), "a", []byte("data"))
+	assert.ErrorContains(t, err, "SignatureDoesNotMatch")
+}
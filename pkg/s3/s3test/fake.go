@@ -0,0 +1,150 @@
+// Package s3test — S3-совместимый сервер в памяти для тестов клиентов s3.
+package s3test
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/talx-hub/malerter/pkg/s3"
+)
+
+// fakeMaxKeys — число объектов на странице списка по умолчанию.
+const fakeMaxKeys = 1000
+
+// Fake — S3-совместимый сервер в памяти для тестов. Проверяет подпись
+// запросов и поддерживает запись, чтение, удаление и список объектов.
+type Fake struct {
+	objects   map[string]fakeObject
+	bucket    string
+	accessKey string
+	secretKey string
+	region    string
+	// MaxKeys — размер страницы списка.
+	MaxKeys int
+	mu      sync.Mutex
+}
+
+type fakeObject struct {
+	modified time.Time
+	data     []byte
+}
+
+type fakeList struct {
+	XMLName               xml.Name    `xml:"ListBucketResult"`
+	Name                  string      `xml:"Name"`
+	Prefix                string      `xml:"Prefix"`
+	NextContinuationToken string      `xml:"NextContinuationToken,omitempty"`
+	Contents              []s3.Object `xml:"Contents"`
+	KeyCount              int         `xml:"KeyCount"`
+	IsTruncated           bool        `xml:"IsTruncated"`
+}
+
+type fakeError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func NewFake(cfg s3.Config) *Fake {
+	region := cfg.Region
+	if region == "" {
+		region = s3.RegionDefault
+	}
+	return &Fake{
+		objects:   make(map[string]fakeObject),
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		region:    region,
+		MaxKeys:   fakeMaxKeys,
+	}
+}
+
+// Keys возвращает ключи всех объектов по возрастанию.
+func (f *Fake) Keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.objects))
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !s3.Verify(r, payload, f.region, f.accessKey, f.secretKey) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", "signature does not match")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: payload, modified: time.Now().UTC()}
+	case r.Method == http.MethodGet:
+		o, ok := f.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		_, _ = w.Write(o.data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (f *Fake) list(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	keys := make([]string, 0)
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	start, _ := strconv.Atoi(r.URL.Query().Get("continuation-token"))
+	start = min(start, len(keys))
+	end := min(start+f.MaxKeys, len(keys))
+
+	result := fakeList{Name: f.bucket, Prefix: prefix, Contents: make([]s3.Object, 0, end-start)}
+	for _, k := range keys[start:end] {
+		o := f.objects[k]
+		result.Contents = append(result.Contents, s3.Object{Key: k, LastModified: o.modified, Size: int64(len(o.data))})
+	}
+	result.KeyCount = len(result.Contents)
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(fakeError{Code: code, Message: message})
+}
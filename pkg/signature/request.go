@@ -0,0 +1,144 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxNonce — наибольшая длина одноразового значения запроса.
+const maxNonce = 128
+
+var (
+	// ErrStale — запрос подписан слишком давно или в будущем.
+	ErrStale = errors.New("request is stale")
+	// ErrReplayed — запрос с таким одноразовым значением уже был.
+	ErrReplayed = errors.New("request is replayed")
+	// ErrMismatch — подпись не совпадает с запросом.
+	ErrMismatch = errors.New("wrong signature")
+)
+
+// Request возвращает данные, которые подписываются для запроса:
+// момент подписи, одноразовое значение, поля запроса (метод, путь и т.п.)
+// через перевод строки и тело последним.
+//
+// Пример для HTTP:
+//
+//	data := signature.Request(ts, nonce, []string{"POST", "/admin/restore", "backup=a.bk"}, body)
+//	sig := signature.Hash(data, adminKey)
+func Request(timestamp, nonce string, fields []string, body []byte) []byte {
+	var b strings.Builder
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.WriteString(nonce)
+	for _, f := range fields {
+		b.WriteByte('\n')
+		b.WriteString(f)
+	}
+	b.WriteByte('\n')
+	b.Write(body)
+	return []byte(b.String())
+}
+
+// Timestamp возвращает момент подписи в формате Request: секунды Unix.
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// Signed — подпись запроса с моментом подписи и одноразовым значением,
+// которые передаются вместе с ней.
+type Signed struct {
+	Signature string
+	Timestamp string
+	Nonce     string
+}
+
+// Sign подписывает запрос по Request ключом key в момент now
+// со случайным одноразовым значением.
+func Sign(key string, now time.Time, fields []string, body []byte) Signed {
+	ts := Timestamp(now)
+	nonce := rand.Text()
+	return Signed{
+		Signature: Hash(Request(ts, nonce, fields, body), key),
+		Timestamp: ts,
+		Nonce:     nonce,
+	}
+}
+
+// Verifier проверяет подписи запросов, сделанные по Request.
+// Запрос подписан давнее окна window — устарел; одноразовое значение
+// помнится, пока запрос не устарел, поэтому перехваченный запрос
+// нельзя повторить.
+type Verifier struct {
+	now    func() time.Time
+	seen   map[string]time.Time
+	key    string
+	window time.Duration
+	mu     sync.Mutex
+}
+
+func NewVerifier(key string, window time.Duration) *Verifier {
+	return &Verifier{
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+		key:    key,
+		window: window,
+	}
+}
+
+// Verify проверяет подпись sig запроса с полями fields и телом body.
+func (v *Verifier) Verify(sig, timestamp, nonce string, fields []string, body []byte) error {
+	if err := checkNonce(nonce); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if strings.ContainsRune(f, '\n') {
+			return errors.New("request field contains a line break")
+		}
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp: %w", err)
+	}
+	signed := time.Unix(sec, 0)
+	now := v.now()
+	if signed.Before(now.Add(-v.window)) || signed.After(now.Add(v.window)) {
+		return ErrStale
+	}
+	want := Hash(Request(timestamp, nonce, fields, body), v.key)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return ErrMismatch
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for n, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, n)
+		}
+	}
+	if _, ok := v.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	v.seen[nonce] = signed.Add(v.window)
+	return nil
+}
+
+// checkNonce допускает буквы, цифры, '-' и '_': одноразовое значение
+// не должно сливаться с соседними полями подписанных данных.
+func checkNonce(nonce string) error {
+	if nonce == "" || len(nonce) > maxNonce {
+		return errors.New("request nonce must have 1 to 128 characters")
+	}
+	for _, r := range nonce {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_') {
+			return errors.New("request nonce must contain only letters, digits, '-' and '_'")
+		}
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package signature_test
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/talx-hub/malerter/pkg/signature"
 )
@@ -19,3 +21,53 @@ func TestHash(t *testing.T) {
 		t.Errorf("Hash() = %q; want %q", got, want)
 	}
 }
+
+func TestVerifier(t *testing.T) {
+	const key = "adminkey"
+	v := signature.NewVerifier(key, time.Minute)
+	fields := []string{"POST", "/admin/restore", "backup=a.bk"}
+	sign := func(ts, nonce string, fields []string) string {
+		return signature.Hash(signature.Request(ts, nonce, fields, nil), key)
+	}
+	now := signature.Timestamp(time.Now())
+
+	if err := v.Verify(sign(now, "n1", fields), now, "n1", fields, nil); err != nil {
+		t.Fatalf("Verify() = %v; want nil", err)
+	}
+	if err := v.Verify(sign(now, "n1", fields), now, "n1", fields, nil); !errors.Is(err, signature.ErrReplayed) {
+		t.Errorf("Verify() of a replayed request = %v; want %v", err, signature.ErrReplayed)
+	}
+	restore := []string{"POST", "/admin/restore", "backup=b.bk"}
+	if err := v.Verify(sign(now, "n2", fields), now, "n2", restore, nil); !errors.Is(err, signature.ErrMismatch) {
+		t.Errorf("Verify() of a changed query = %v; want %v", err, signature.ErrMismatch)
+	}
+	old := signature.Timestamp(time.Now().Add(-time.Hour))
+	if err := v.Verify(sign(old, "n3", fields), old, "n3", fields, nil); !errors.Is(err, signature.ErrStale) {
+		t.Errorf("Verify() of a stale request = %v; want %v", err, signature.ErrStale)
+	}
+	if err := v.Verify(sign(now, "n\n4", fields), now, "n\n4", fields, nil); err == nil {
+		t.Error("Verify() accepted a nonce with a line break")
+	}
+	wrong := signature.Hash(signature.Request(now, "n5", fields, nil), "agentkey")
+	if err := v.Verify(wrong, now, "n5", fields, nil); !errors.Is(err, signature.ErrMismatch) {
+		t.Errorf("Verify() with another key = %v; want %v", err, signature.ErrMismatch)
+	}
+}
+
+func TestSign(t *testing.T) {
+	const key = "tenantkey"
+	v := signature.NewVerifier(key, time.Minute)
+	fields := []string{"GET", "/value/gauge/Alloc", "", "team-a"}
+
+	first := signature.Sign(key, time.Now(), fields, nil)
+	if err := v.Verify(first.Signature, first.Timestamp, first.Nonce, fields, nil); err != nil {
+		t.Fatalf("Verify() of a signed request = %v; want nil", err)
+	}
+	second := signature.Sign(key, time.Now(), fields, nil)
+	if second.Nonce == first.Nonce {
+		t.Error("Sign() repeated the nonce")
+	}
+	if err := v.Verify(second.Signature, second.Timestamp, second.Nonce, fields, nil); err != nil {
+		t.Errorf("Verify() of the second request = %v; want nil", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -28,6 +29,7 @@ const (
 	Metric_Unspecified Metric_Type = 0
 	Metric_Gauge       Metric_Type = 1
 	Metric_Counter     Metric_Type = 2
+	Metric_Histogram   Metric_Type = 3
 )
 
 // Enum value maps for Metric_Type.
@@ -36,11 +38,13 @@ var (
 		0: "Unspecified",
 		1: "Gauge",
 		2: "Counter",
+		3: "Histogram",
 	}
 	Metric_Type_value = map[string]int32{
 		"Unspecified": 0,
 		"Gauge":       1,
 		"Counter":     2,
+		"Histogram":   3,
 	}
 )
 
@@ -72,11 +76,16 @@ func (Metric_Type) EnumDescriptor() ([]byte, []int) {
 }
 
 type Metric struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	Type          Metric_Type            `protobuf:"varint,2,opt,name=type,proto3,enum=metrics.Metric_Type" json:"type,omitempty"`
-	Value         float64                `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"`
-	Delta         int64                  `protobuf:"varint,4,opt,name=delta,proto3" json:"delta,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Name      string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Type      Metric_Type            `protobuf:"varint,2,opt,name=type,proto3,enum=metrics.Metric_Type" json:"type,omitempty"`
+	Value     float64                `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"`
+	Delta     int64                  `protobuf:"varint,4,opt,name=delta,proto3" json:"delta,omitempty"`
+	Histogram *Histogram             `protobuf:"bytes,5,opt,name=histogram,proto3" json:"histogram,omitempty"`
+	Labels    map[string]string      `protobuf:"bytes,6,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Timestamp *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// delta содержит итог с момента запуска агента, а не приращение
+	Cumulative    bool `protobuf:"varint,8,opt,name=cumulative,proto3" json:"cumulative,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -139,6 +148,102 @@ func (x *Metric) GetDelta() int64 {
 	return 0
 }
 
+func (x *Metric) GetHistogram() *Histogram {
+	if x != nil {
+		return x.Histogram
+	}
+	return nil
+}
+
+func (x *Metric) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Metric) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Metric) GetCumulative() bool {
+	if x != nil {
+		return x.Cumulative
+	}
+	return false
+}
+
+type Histogram struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Bounds        []float64              `protobuf:"fixed64,1,rep,packed,name=bounds,proto3" json:"bounds,omitempty"`
+	Counts        []int64                `protobuf:"varint,2,rep,packed,name=counts,proto3" json:"counts,omitempty"`
+	Sum           float64                `protobuf:"fixed64,3,opt,name=sum,proto3" json:"sum,omitempty"`
+	Count         int64                  `protobuf:"varint,4,opt,name=count,proto3" json:"count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Histogram) Reset() {
+	*x = Histogram{}
+	mi := &file_proto_metrics_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Histogram) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Histogram) ProtoMessage() {}
+
+func (x *Histogram) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_metrics_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Histogram.ProtoReflect.Descriptor instead.
+func (*Histogram) Descriptor() ([]byte, []int) {
+	return file_proto_metrics_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Histogram) GetBounds() []float64 {
+	if x != nil {
+		return x.Bounds
+	}
+	return nil
+}
+
+func (x *Histogram) GetCounts() []int64 {
+	if x != nil {
+		return x.Counts
+	}
+	return nil
+}
+
+func (x *Histogram) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+func (x *Histogram) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
 type MetricList struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
@@ -148,7 +253,7 @@ type MetricList struct {
 
 func (x *MetricList) Reset() {
 	*x = MetricList{}
-	mi := &file_proto_metrics_proto_msgTypes[1]
+	mi := &file_proto_metrics_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -160,7 +265,7 @@ func (x *MetricList) String() string {
 func (*MetricList) ProtoMessage() {}
 
 func (x *MetricList) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_metrics_proto_msgTypes[1]
+	mi := &file_proto_metrics_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -173,7 +278,7 @@ func (x *MetricList) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use MetricList.ProtoReflect.Descriptor instead.
 func (*MetricList) Descriptor() ([]byte, []int) {
-	return file_proto_metrics_proto_rawDescGZIP(), []int{1}
+	return file_proto_metrics_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *MetricList) GetMetrics() []*Metric {
@@ -196,7 +301,7 @@ type BatchRequest struct {
 
 func (x *BatchRequest) Reset() {
 	*x = BatchRequest{}
-	mi := &file_proto_metrics_proto_msgTypes[2]
+	mi := &file_proto_metrics_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -208,7 +313,7 @@ func (x *BatchRequest) String() string {
 func (*BatchRequest) ProtoMessage() {}
 
 func (x *BatchRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_metrics_proto_msgTypes[2]
+	mi := &file_proto_metrics_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -221,7 +326,7 @@ func (x *BatchRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BatchRequest.ProtoReflect.Descriptor instead.
 func (*BatchRequest) Descriptor() ([]byte, []int) {
-	return file_proto_metrics_proto_rawDescGZIP(), []int{2}
+	return file_proto_metrics_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *BatchRequest) GetPayload() isBatchRequest_Payload {
@@ -266,15 +371,17 @@ func (*BatchRequest_MetricList) isBatchRequest_Payload() {}
 func (*BatchRequest_EncryptedPayload) isBatchRequest_Payload() {}
 
 type BatchResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Error         string                 `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Error string                 `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"`
+	// значения сохранённых метрик после слияния
+	Metrics       []*Metric `protobuf:"bytes,2,rep,name=metrics,proto3" json:"metrics,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *BatchResponse) Reset() {
 	*x = BatchResponse{}
-	mi := &file_proto_metrics_proto_msgTypes[3]
+	mi := &file_proto_metrics_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -286,7 +393,7 @@ func (x *BatchResponse) String() string {
 func (*BatchResponse) ProtoMessage() {}
 
 func (x *BatchResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_metrics_proto_msgTypes[3]
+	mi := &file_proto_metrics_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -299,7 +406,7 @@ func (x *BatchResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BatchResponse.ProtoReflect.Descriptor instead.
 func (*BatchResponse) Descriptor() ([]byte, []int) {
-	return file_proto_metrics_proto_rawDescGZIP(), []int{3}
+	return file_proto_metrics_proto_rawDescGZIP(), []int{4}
 }
 
 func (x *BatchResponse) GetError() string {
@@ -309,20 +416,42 @@ func (x *BatchResponse) GetError() string {
 	return ""
 }
 
+func (x *BatchResponse) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
 var File_proto_metrics_proto protoreflect.FileDescriptor
 
 const file_proto_metrics_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/metrics.proto\x12\ametrics\"\xa3\x01\n" +
+	"\x13proto/metrics.proto\x12\ametrics\x1a\x1fgoogle/protobuf/timestamp.proto\"\xae\x03\n" +
 	"\x06Metric\x12\x12\n" +
 	"\x04name\x18\x01 \x01(\tR\x04name\x12(\n" +
 	"\x04type\x18\x02 \x01(\x0e2\x14.metrics.Metric.TypeR\x04type\x12\x14\n" +
 	"\x05value\x18\x03 \x01(\x01R\x05value\x12\x14\n" +
-	"\x05delta\x18\x04 \x01(\x03R\x05delta\"/\n" +
+	"\x05delta\x18\x04 \x01(\x03R\x05delta\x120\n" +
+	"\thistogram\x18\x05 \x01(\v2\x12.metrics.HistogramR\thistogram\x123\n" +
+	"\x06labels\x18\x06 \x03(\v2\x1b.metrics.Metric.LabelsEntryR\x06labels\x128\n" +
+	"\ttimestamp\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x1e\n" +
+	"\n" +
+	"cumulative\x18\b \x01(\bR\n" +
+	"cumulative\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\">\n" +
 	"\x04Type\x12\x0f\n" +
 	"\vUnspecified\x10\x00\x12\t\n" +
 	"\x05Gauge\x10\x01\x12\v\n" +
-	"\aCounter\x10\x02\"7\n" +
+	"\aCounter\x10\x02\x12\r\n" +
+	"\tHistogram\x10\x03\"c\n" +
+	"\tHistogram\x12\x16\n" +
+	"\x06bounds\x18\x01 \x03(\x01R\x06bounds\x12\x16\n" +
+	"\x06counts\x18\x02 \x03(\x03R\x06counts\x12\x10\n" +
+	"\x03sum\x18\x03 \x01(\x01R\x03sum\x12\x14\n" +
+	"\x05count\x18\x04 \x01(\x03R\x05count\"7\n" +
 	"\n" +
 	"MetricList\x12)\n" +
 	"\ametrics\x18\x01 \x03(\v2\x0f.metrics.MetricR\ametrics\"\x80\x01\n" +
@@ -330,9 +459,10 @@ const file_proto_metrics_proto_rawDesc = "" +
 	"\vmetric_list\x18\x01 \x01(\v2\x13.metrics.MetricListH\x00R\n" +
 	"metricList\x12-\n" +
 	"\x11encrypted_payload\x18\x02 \x01(\fH\x00R\x10encryptedPayloadB\t\n" +
-	"\apayload\"%\n" +
+	"\apayload\"P\n" +
 	"\rBatchResponse\x12\x14\n" +
-	"\x05error\x18\x01 \x01(\tR\x05error2A\n" +
+	"\x05error\x18\x01 \x01(\tR\x05error\x12)\n" +
+	"\ametrics\x18\x02 \x03(\v2\x0f.metrics.MetricR\ametrics2A\n" +
 	"\aMetrics\x126\n" +
 	"\x05Batch\x12\x15.metrics.BatchRequest\x1a\x16.metrics.BatchResponseB$Z\"github.com/talx-hub/malerter/protob\x06proto3"
 
@@ -349,25 +479,32 @@ func file_proto_metrics_proto_rawDescGZIP() []byte {
 }
 
 var file_proto_metrics_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_proto_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_proto_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_proto_metrics_proto_goTypes = []any{
-	(Metric_Type)(0),      // 0: metrics.Metric.Type
-	(*Metric)(nil),        // 1: metrics.Metric
-	(*MetricList)(nil),    // 2: metrics.MetricList
-	(*BatchRequest)(nil),  // 3: metrics.BatchRequest
-	(*BatchResponse)(nil), // 4: metrics.BatchResponse
+	(Metric_Type)(0),              // 0: metrics.Metric.Type
+	(*Metric)(nil),                // 1: metrics.Metric
+	(*Histogram)(nil),             // 2: metrics.Histogram
+	(*MetricList)(nil),            // 3: metrics.MetricList
+	(*BatchRequest)(nil),          // 4: metrics.BatchRequest
+	(*BatchResponse)(nil),         // 5: metrics.BatchResponse
+	nil,                           // 6: metrics.Metric.LabelsEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
 }
 var file_proto_metrics_proto_depIdxs = []int32{
 	0, // 0: metrics.Metric.type:type_name -> metrics.Metric.Type
-	1, // 1: metrics.MetricList.metrics:type_name -> metrics.Metric
-	2, // 2: metrics.BatchRequest.metric_list:type_name -> metrics.MetricList
-	3, // 3: metrics.Metrics.Batch:input_type -> metrics.BatchRequest
-	4, // 4: metrics.Metrics.Batch:output_type -> metrics.BatchResponse
-	4, // [4:5] is the sub-list for method output_type
-	3, // [3:4] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+	2, // 1: metrics.Metric.histogram:type_name -> metrics.Histogram
+	6, // 2: metrics.Metric.labels:type_name -> metrics.Metric.LabelsEntry
+	7, // 3: metrics.Metric.timestamp:type_name -> google.protobuf.Timestamp
+	1, // 4: metrics.MetricList.metrics:type_name -> metrics.Metric
+	3, // 5: metrics.BatchRequest.metric_list:type_name -> metrics.MetricList
+	1, // 6: metrics.BatchResponse.metrics:type_name -> metrics.Metric
+	4, // 7: metrics.Metrics.Batch:input_type -> metrics.BatchRequest
+	5, // 8: metrics.Metrics.Batch:output_type -> metrics.BatchResponse
+	8, // [8:9] is the sub-list for method output_type
+	7, // [7:8] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_proto_metrics_proto_init() }
@@ -375,7 +512,7 @@ func file_proto_metrics_proto_init() {
 	if File_proto_metrics_proto != nil {
 		return
 	}
-	file_proto_metrics_proto_msgTypes[2].OneofWrappers = []any{
+	file_proto_metrics_proto_msgTypes[3].OneofWrappers = []any{
 		(*BatchRequest_MetricList)(nil),
 		(*BatchRequest_EncryptedPayload)(nil),
 	}
@@ -385,7 +522,7 @@ func file_proto_metrics_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_metrics_proto_rawDesc), len(file_proto_metrics_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   4,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
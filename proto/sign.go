@@ -0,0 +1,14 @@
+package proto
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+// SigningBytes возвращает представление сообщения, по которому
+// вычисляются и проверяются подписи. Обычная сериализация map-полей
+// (например, меток метрики) не детерминирована, поэтому подписывающая
+// и проверяющая стороны должны сериализовать сообщение этой функцией.
+func SigningBytes(m proto.Message) ([]byte, error) {
+	//nolint:wrapcheck // callers wrap the error
+	return proto.MarshalOptions{Deterministic: true}.Marshal(m)
+}